@@ -3,6 +3,7 @@ package apperrors
 import (
 	"fmt"
 	"strings"
+	"time"
 )
 
 type UsernameAlreadyExistsError struct {
@@ -40,9 +41,8 @@ type MalFormedTokenError struct {
 type InvalidTokenError struct {
 }
 
-type RoleNotMatchingError struct {
-	Role         string
-	RequiredRole string
+type PermissionDeniedError struct {
+	Permission string
 }
 
 type DeleteBorrowedBookError struct {
@@ -57,6 +57,143 @@ type BookWithSameIDError struct {
 	BookID string
 }
 
+type UserNotFoundError struct {
+	Username string
+}
+
+type InvalidQueryError struct {
+	ErrorMessages []string
+}
+
+type CopyNotFoundError struct {
+	Barcode string
+}
+
+type CopyNotAvailableError struct {
+	Barcode string
+	Status  string
+}
+
+type CopyOnLoanError struct {
+	Barcode string
+}
+
+type BarcodeAlreadyExistsError struct {
+	Barcode string
+}
+
+type LoanNotFoundError struct {
+	BookID   string
+	Username string
+}
+
+type BalanceExceededError struct {
+	Username string
+	Balance  int
+	Limit    int
+}
+
+type RenewalLimitReachedError struct {
+	BookTitle string
+	Limit     int
+}
+
+type LoanOverdueError struct {
+	BookTitle string
+	DueAt     time.Time
+}
+
+type CopyOnHoldError struct {
+	Barcode string
+}
+
+type HoldNotNeededError struct {
+	BookTitle string
+}
+
+type HoldAlreadyPlacedError struct {
+	BookTitle string
+}
+
+type HoldNotFoundError struct {
+	BookID   string
+	Username string
+}
+
+type HoldsPendingError struct {
+	BookTitle string
+}
+
+type CardNotFoundError struct {
+	CardNumber string
+}
+
+type CardNumberAlreadyExistsError struct {
+	CardNumber string
+}
+
+type CalendarValidationError struct {
+	ErrorMessages []string
+}
+
+type JobRunNotFoundError struct {
+	ID string
+}
+
+type SessionNotFoundError struct {
+	ID string
+}
+
+type InvalidRefreshTokenError struct {
+}
+
+type CopyWithdrawnError struct {
+	Barcode string
+}
+
+type ReplacementFeeError struct {
+	Barcode string
+}
+
+type LoanLimitReachedError struct {
+	BookTitle string
+	Limit     int
+}
+
+type UserValidationError struct {
+	ErrorMessages []string
+}
+
+type ServiceUnavailableError struct {
+	Reason string
+}
+
+type UserDisabledError struct {
+	Username string
+}
+
+type UserHasBooksError struct {
+	Username string
+	Books    int
+}
+
+type UserHasHoldsError struct {
+	Username string
+	Holds    int
+}
+
+type IncorrectPasswordError struct{}
+
+type PaymentValidationError struct {
+	ErrorMessages []string
+}
+
+// RequestBodyError reports a request body that is not the JSON the endpoint
+// expects.
+type RequestBodyError struct {
+	Err error
+}
+
 func (e *UsernameAlreadyExistsError) Error() string {
 	return fmt.Sprintf("%s already exists", e.Username)
 }
@@ -93,8 +230,8 @@ func (e *InvalidTokenError) Error() string {
 	return "Invalid token"
 }
 
-func (e *RoleNotMatchingError) Error() string {
-	return fmt.Sprintf("your role %s is not matching %s", e.Role, e.RequiredRole)
+func (e *PermissionDeniedError) Error() string {
+	return fmt.Sprintf("you do not have the %s permission", e.Permission)
 }
 
 func (e *DeleteBorrowedBookError) Error() string {
@@ -112,3 +249,131 @@ func (e *BookValidationError) Error() string {
 func (e *BookWithSameIDError) Error() string {
 	return fmt.Sprintf("book with id %s exists", e.BookID)
 }
+
+func (e *UserNotFoundError) Error() string {
+	return fmt.Sprintf("No User With Username %s", e.Username)
+}
+
+func (e *LoanNotFoundError) Error() string {
+	return fmt.Sprintf("%s has no open loan of book %s", e.Username, e.BookID)
+}
+
+func (e *BalanceExceededError) Error() string {
+	return fmt.Sprintf("%s owes %d in fines, more than the %d allowed to borrow", e.Username, e.Balance, e.Limit)
+}
+
+func (e *RenewalLimitReachedError) Error() string {
+	return fmt.Sprintf("You can't renew %s again, it has been renewed the maximum of %d times", e.BookTitle, e.Limit)
+}
+
+func (e *LoanOverdueError) Error() string {
+	return fmt.Sprintf("You can't renew %s because it was due on %s; return it instead", e.BookTitle, e.DueAt.Format(time.DateOnly))
+}
+
+func (e *CopyOnHoldError) Error() string {
+	return fmt.Sprintf("copy %s is held for a patron", e.Barcode)
+}
+
+func (e *HoldNotNeededError) Error() string {
+	return fmt.Sprintf("You can't place a hold on %s because copies are available, borrow it instead", e.BookTitle)
+}
+
+func (e *HoldAlreadyPlacedError) Error() string {
+	return fmt.Sprintf("You already have a hold on %s", e.BookTitle)
+}
+
+func (e *HoldNotFoundError) Error() string {
+	return fmt.Sprintf("%s has no hold on book %s", e.Username, e.BookID)
+}
+
+func (e *HoldsPendingError) Error() string {
+	return fmt.Sprintf("You can't renew %s because other patrons are waiting for it", e.BookTitle)
+}
+
+func (e *CardNotFoundError) Error() string {
+	return fmt.Sprintf("No User With Card Number %s", e.CardNumber)
+}
+
+func (e *CardNumberAlreadyExistsError) Error() string {
+	return fmt.Sprintf("card number %s is already issued", e.CardNumber)
+}
+
+func (e *CalendarValidationError) Error() string {
+	return strings.Join(e.ErrorMessages, ",")
+}
+
+func (e *JobRunNotFoundError) Error() string {
+	return fmt.Sprintf("No Job Run With ID %s", e.ID)
+}
+
+func (e *SessionNotFoundError) Error() string {
+	return fmt.Sprintf("No Session With ID %s", e.ID)
+}
+
+func (e *InvalidRefreshTokenError) Error() string {
+	return "Invalid or expired refresh token"
+}
+
+func (e *CopyWithdrawnError) Error() string {
+	return fmt.Sprintf("copy %s has already been withdrawn", e.Barcode)
+}
+
+func (e *ReplacementFeeError) Error() string {
+	return fmt.Sprintf("copy %s is not on loan, so there is no patron to charge a replacement fee", e.Barcode)
+}
+
+func (e *LoanLimitReachedError) Error() string {
+	return fmt.Sprintf("You can't borrow %s because you already have %d books, the most you may borrow at once", e.BookTitle, e.Limit)
+}
+
+func (e *UserValidationError) Error() string {
+	return strings.Join(e.ErrorMessages, ",")
+}
+
+func (e *UserDisabledError) Error() string {
+	return fmt.Sprintf("the account of %s is disabled", e.Username)
+}
+
+func (e *UserHasBooksError) Error() string {
+	return fmt.Sprintf("%s still has %d books on loan", e.Username, e.Books)
+}
+
+func (e *UserHasHoldsError) Error() string {
+	return fmt.Sprintf("%s still has %d active holds", e.Username, e.Holds)
+}
+
+func (e *IncorrectPasswordError) Error() string {
+	return "current password is incorrect"
+}
+
+func (e *ServiceUnavailableError) Error() string {
+	return fmt.Sprintf("service unavailable: %s", e.Reason)
+}
+
+func (e *InvalidQueryError) Error() string {
+	return strings.Join(e.ErrorMessages, ",")
+}
+
+func (e *CopyNotFoundError) Error() string {
+	return fmt.Sprintf("No Copy With Barcode %s", e.Barcode)
+}
+
+func (e *CopyNotAvailableError) Error() string {
+	return fmt.Sprintf("copy %s is %s and cannot be borrowed", e.Barcode, e.Status)
+}
+
+func (e *CopyOnLoanError) Error() string {
+	return fmt.Sprintf("copy %s is on loan", e.Barcode)
+}
+
+func (e *BarcodeAlreadyExistsError) Error() string {
+	return fmt.Sprintf("copy with barcode %s exists", e.Barcode)
+}
+
+func (e *RequestBodyError) Error() string {
+	return fmt.Sprintf("malformed request body: %v", e.Err)
+}
+
+func (e *PaymentValidationError) Error() string {
+	return strings.Join(e.ErrorMessages, ",")
+}
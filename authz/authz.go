@@ -0,0 +1,194 @@
+// Package authz decides what users may do. Routes require named permissions,
+// roles bundle permissions, and a user holds any number of roles and may do
+// whatever one of them permits.
+package authz
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Permission names an action that routes can require.
+type Permission string
+
+const (
+	// BooksRead allows browsing and searching the catalogue.
+	BooksRead Permission = "books:read"
+	// BooksWrite allows adding, editing and removing books and copies.
+	BooksWrite Permission = "books:write"
+	// LoansBorrow allows borrowing, returning, renewing and holding books
+	// for oneself.
+	LoansBorrow Permission = "loans:borrow"
+	// LoansRead allows reading the loans and balances of any patron.
+	LoansRead Permission = "loans:read"
+	// LoansCheckoutForOthers allows desk checkouts and returns for patrons.
+	LoansCheckoutForOthers Permission = "loans:checkout-for-others"
+	// LoansWriteOff allows declaring copies lost or damaged.
+	LoansWriteOff Permission = "loans:write-off"
+	// FinesSettle allows recording payments of fines and fees and waiving
+	// them.
+	FinesSettle Permission = "fines:settle"
+	// HoldsRead allows reading the hold queue of any book.
+	HoldsRead Permission = "holds:read"
+	// UsersRead allows reading user accounts.
+	UsersRead Permission = "users:read"
+	// UsersWrite allows issuing cards, setting loan limits and ending the
+	// sessions of users.
+	UsersWrite Permission = "users:write"
+	// UsersManage allows creating, disabling and deleting accounts and
+	// changing their roles, and so granting any permission.
+	UsersManage Permission = "users:manage"
+	// CalendarWrite allows changing the opening hours and closed days.
+	CalendarWrite Permission = "calendar:write"
+	// JobsRead allows reading the background jobs and their runs.
+	JobsRead Permission = "jobs:read"
+)
+
+// Permissions lists every permission.
+var Permissions = []Permission{
+	BooksRead, BooksWrite, LoansBorrow, LoansRead, LoansCheckoutForOthers, LoansWriteOff, FinesSettle, HoldsRead,
+	UsersRead, UsersWrite, UsersManage, CalendarWrite, JobsRead,
+}
+
+// Built-in roles.
+const (
+	// UserRole is given to patrons who register themselves.
+	UserRole = "user"
+	// LibrarianRole runs the desk and the catalogue.
+	LibrarianRole = "librarian"
+	// AdminRole may do everything.
+	AdminRole = "admin"
+)
+
+var patronPermissions = []Permission{BooksRead, LoansBorrow}
+
+var builtinRoles = map[string][]Permission{
+	UserRole: patronPermissions,
+	LibrarianRole: append([]Permission{
+		BooksWrite, LoansRead, LoansCheckoutForOthers, LoansWriteOff, FinesSettle, HoldsRead, UsersRead, UsersWrite,
+	}, patronPermissions...),
+	AdminRole: Permissions,
+}
+
+// Role is a role and the permissions it grants.
+type Role struct {
+	Name        string       `json:"name"`
+	Permissions []Permission `json:"permissions"`
+	BuiltIn     bool         `json:"built_in"`
+}
+
+// Policy knows the roles and what they permit.
+type Policy struct {
+	roles map[string]map[Permission]bool
+}
+
+// NewPolicy returns a Policy with the built-in roles and the custom roles,
+// given as role names mapped to permission names. Custom roles may not
+// redefine a built-in role or name an unknown permission.
+func NewPolicy(custom map[string][]string) (*Policy, error) {
+	policy := &Policy{roles: make(map[string]map[Permission]bool, len(builtinRoles)+len(custom))}
+	for name, permissions := range builtinRoles {
+		policy.roles[name] = set(permissions)
+	}
+
+	var problems []string
+	for _, name := range sortedKeys(custom) {
+		if _, ok := builtinRoles[name]; ok {
+			problems = append(problems, fmt.Sprintf("role %q is built in", name))
+			continue
+		}
+		if err := ValidRoleName(name); err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		permissions := make([]Permission, 0, len(custom[name]))
+		for _, permission := range custom[name] {
+			if !known(Permission(permission)) {
+				problems = append(problems, fmt.Sprintf("role %q: unknown permission %q", name, permission))
+				continue
+			}
+			permissions = append(permissions, Permission(permission))
+		}
+		policy.roles[name] = set(permissions)
+	}
+	if len(problems) > 0 {
+		return nil, errors.New(strings.Join(problems, "; "))
+	}
+	return policy, nil
+}
+
+// ValidRoleName reports whether name can be used as a role: lower case
+// letters, digits and dashes.
+func ValidRoleName(name string) error {
+	if name == "" {
+		return errors.New("role name is empty")
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return fmt.Errorf("role %q may only contain lower case letters, digits and dashes", name)
+		}
+	}
+	return nil
+}
+
+// Allows reports whether any of roles grants permission. Unknown roles
+// grant nothing.
+func (p *Policy) Allows(roles []string, permission Permission) bool {
+	for _, role := range roles {
+		if p.roles[role][permission] {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRole reports whether the policy defines the role name.
+func (p *Policy) HasRole(name string) bool {
+	_, ok := p.roles[name]
+	return ok
+}
+
+// Roles returns every role, by name, with its permissions in the order of
+// Permissions.
+func (p *Policy) Roles() []Role {
+	roles := make([]Role, 0, len(p.roles))
+	for _, name := range sortedKeys(p.roles) {
+		role := Role{Name: name, Permissions: []Permission{}}
+		_, role.BuiltIn = builtinRoles[name]
+		for _, permission := range Permissions {
+			if p.roles[name][permission] {
+				role.Permissions = append(role.Permissions, permission)
+			}
+		}
+		roles = append(roles, role)
+	}
+	return roles
+}
+
+func known(permission Permission) bool {
+	for _, p := range Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func set(permissions []Permission) map[Permission]bool {
+	s := make(map[Permission]bool, len(permissions))
+	for _, permission := range permissions {
+		s[permission] = true
+	}
+	return s
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,98 @@
+package authz
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNewPolicy(t *testing.T) {
+	policy, err := NewPolicy(map[string][]string{
+		"volunteer": {string(BooksRead), string(LoansCheckoutForOthers)},
+		"auditor":   {},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		roles      []string
+		permission Permission
+		want       bool
+	}{
+		{[]string{"volunteer"}, LoansCheckoutForOthers, true},
+		{[]string{"volunteer"}, LoansBorrow, false},
+		{[]string{"auditor"}, BooksRead, false},
+		{[]string{"auditor", UserRole}, BooksRead, true},
+		{[]string{UserRole}, BooksWrite, false},
+		{[]string{LibrarianRole}, FinesSettle, true},
+		{[]string{LibrarianRole}, UsersManage, false},
+		{[]string{"unknown"}, BooksRead, false},
+		{nil, BooksRead, false},
+	}
+	for _, test := range tests {
+		if got := policy.Allows(test.roles, test.permission); got != test.want {
+			t.Errorf("Allows(%q, %s) = %v, want %v", test.roles, test.permission, got, test.want)
+		}
+	}
+	for _, permission := range Permissions {
+		if !policy.Allows([]string{AdminRole}, permission) {
+			t.Errorf("admin lacks %s", permission)
+		}
+	}
+	if !policy.HasRole("auditor") || policy.HasRole("unknown") {
+		t.Error("HasRole does not match the configured roles")
+	}
+}
+
+func TestNewPolicyRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		custom map[string][]string
+		want   string
+	}{
+		{"unknown permission", map[string][]string{"volunteer": {"books:read", "books:burn"}}, `unknown permission "books:burn"`},
+		{"built-in role redefined", map[string][]string{LibrarianRole: {string(BooksRead)}}, `role "librarian" is built in`},
+		{"admin narrowed", map[string][]string{AdminRole: {}}, `role "admin" is built in`},
+		{"bad role name", map[string][]string{"Night Shift": {string(BooksRead)}}, "lower case letters"},
+		{"empty role name", map[string][]string{"": {string(BooksRead)}}, "role name is empty"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewPolicy(test.custom)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("NewPolicy = %v, want an error containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestBuiltinRolesAreUnchanged(t *testing.T) {
+	policy, err := NewPolicy(map[string][]string{"volunteer": {string(BooksRead)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles := policy.Roles()
+	for i := range roles {
+		if roles[i].Name == UserRole {
+			roles[i].Permissions[0] = UsersManage
+		}
+	}
+	if policy.Allows([]string{UserRole}, UsersManage) {
+		t.Error("changing the listed roles changed the policy")
+	}
+
+	var names []string
+	for _, role := range policy.Roles() {
+		names = append(names, role.Name)
+		_, builtIn := builtinRoles[role.Name]
+		if role.BuiltIn != builtIn {
+			t.Errorf("role %s is listed with BuiltIn %v", role.Name, role.BuiltIn)
+		}
+		if role.Name == UserRole && !slices.Equal(role.Permissions, patronPermissions) {
+			t.Errorf("user role grants %v, want %v", role.Permissions, patronPermissions)
+		}
+	}
+	if want := []string{AdminRole, LibrarianRole, UserRole, "volunteer"}; !slices.Equal(names, want) {
+		t.Errorf("roles are %q, want %q", names, want)
+	}
+}
@@ -0,0 +1,152 @@
+// Package calendar decides which days the library is open, so that due dates
+// can avoid closed days and fines are not charged for them. Days are
+// calendar dates in the library's time zone.
+package calendar
+
+import (
+	"fmt"
+	"library_management_system/apperrors"
+	"library_management_system/models"
+	"slices"
+	"strings"
+	"time"
+
+	// Embedded zone data lets time zones load on hosts without a zoneinfo
+	// database, such as minimal containers.
+	_ "time/tzdata"
+)
+
+const dateLayout = time.DateOnly
+
+// maxRoll bounds the search for an open day. A valid calendar opens on at
+// least one weekday, so it only matters with years of holidays in a row.
+const maxRoll = 366
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// Calendar is a validated models.Calendar ready for lookups.
+type Calendar struct {
+	location *time.Location
+	open     [7]bool
+	// closes is the closing time of each weekday, in minutes after
+	// midnight.
+	closes   [7]int
+	holidays map[string]bool
+}
+
+// Default is the calendar used until an administrator saves one: open all
+// day, every day, in UTC.
+func Default() models.Calendar {
+	calendar := models.Calendar{TimeZone: "UTC", Holidays: []models.Holiday{}}
+	for _, day := range []string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"} {
+		calendar.OpeningHours = append(calendar.OpeningHours, models.OpeningHours{Weekday: day, Opens: "00:00", Closes: "24:00"})
+	}
+	return calendar
+}
+
+// New checks c and returns it as a Calendar, or
+// *apperrors.CalendarValidationError listing every problem found.
+func New(c models.Calendar) (*Calendar, error) {
+	var errorMessages []string
+	calendar := &Calendar{holidays: make(map[string]bool)}
+
+	location, err := time.LoadLocation(c.TimeZone)
+	if c.TimeZone == "" {
+		errorMessages = append(errorMessages, "time zone is empty")
+	} else if err != nil {
+		errorMessages = append(errorMessages, fmt.Sprintf("unknown time zone %s", c.TimeZone))
+	}
+	calendar.location = location
+
+	for _, hours := range c.OpeningHours {
+		weekday, ok := weekdays[strings.ToLower(hours.Weekday)]
+		if !ok {
+			errorMessages = append(errorMessages, fmt.Sprintf("unknown weekday %s", hours.Weekday))
+			continue
+		}
+		if calendar.open[weekday] {
+			errorMessages = append(errorMessages, fmt.Sprintf("opening hours for %s are given twice", hours.Weekday))
+		}
+		calendar.open[weekday] = true
+		opens, opensOK := minutes(hours.Opens)
+		closes, closesOK := minutes(hours.Closes)
+		switch {
+		case !opensOK || !closesOK:
+			errorMessages = append(errorMessages, fmt.Sprintf("opening hours for %s must be HH:MM times", hours.Weekday))
+		case opens >= closes:
+			errorMessages = append(errorMessages, fmt.Sprintf("library must open before it closes on %s", hours.Weekday))
+		}
+		calendar.closes[weekday] = closes
+	}
+	if !slices.Contains(calendar.open[:], true) {
+		errorMessages = append(errorMessages, "library must open on at least one weekday")
+	}
+
+	for _, holiday := range c.Holidays {
+		if _, err := time.Parse(dateLayout, holiday.Date); err != nil {
+			errorMessages = append(errorMessages, fmt.Sprintf("holiday date %s is not YYYY-MM-DD", holiday.Date))
+			continue
+		}
+		if calendar.holidays[holiday.Date] {
+			errorMessages = append(errorMessages, fmt.Sprintf("holiday %s is given twice", holiday.Date))
+		}
+		calendar.holidays[holiday.Date] = true
+	}
+
+	if len(errorMessages) > 0 {
+		return nil, &apperrors.CalendarValidationError{ErrorMessages: errorMessages}
+	}
+	return calendar, nil
+}
+
+// Open reports whether the library opens on the day t falls on.
+func (c *Calendar) Open(t time.Time) bool {
+	local := t.In(c.location)
+	return c.open[local.Weekday()] && !c.holidays[local.Format(dateLayout)]
+}
+
+// NextOpenDay returns the time something due at t can be brought back: t
+// itself if the library opens that day, and otherwise the same local time on
+// the first day after it that the library opens, either way brought forward
+// to closing time if it falls after the library closes.
+func (c *Calendar) NextOpenDay(t time.Time) time.Time {
+	local := t.In(c.location)
+	for i := 0; i < maxRoll && !c.Open(local); i++ {
+		local = local.AddDate(0, 0, 1)
+	}
+	if c.Open(local) {
+		year, month, day := local.Date()
+		closing := time.Date(year, month, day, 0, c.closes[local.Weekday()], 0, 0, c.location)
+		if local.After(closing) {
+			local = closing
+		}
+	}
+	return local.In(t.Location())
+}
+
+// minutes parses an "HH:MM" time of day, allowing "24:00" for midnight at
+// the end of the day.
+func minutes(clock string) (int, bool) {
+	var hours, mins int
+	if len(clock) != 5 {
+		return 0, false
+	}
+	if _, err := fmt.Sscanf(clock, "%02d:%02d", &hours, &mins); err != nil {
+		return 0, false
+	}
+	if hours == 24 && mins == 0 {
+		return 24 * 60, true
+	}
+	if hours > 23 || mins > 59 || hours < 0 || mins < 0 {
+		return 0, false
+	}
+	return hours*60 + mins, true
+}
@@ -0,0 +1,64 @@
+package calendar
+
+import (
+	"library_management_system/models"
+	"testing"
+	"time"
+)
+
+// newTestCalendar returns a Berlin library open weekdays 09:00 to 18:00 and
+// Saturdays 10:00 to 14:00, closed on Sundays and on Good Friday 2024.
+func newTestCalendar(t *testing.T) *Calendar {
+	c := models.Calendar{TimeZone: "Europe/Berlin", Holidays: []models.Holiday{{Date: "2024-03-29"}}}
+	for _, day := range []string{"monday", "tuesday", "wednesday", "thursday", "friday"} {
+		c.OpeningHours = append(c.OpeningHours, models.OpeningHours{Weekday: day, Opens: "09:00", Closes: "18:00"})
+	}
+	c.OpeningHours = append(c.OpeningHours, models.OpeningHours{Weekday: "Saturday", Opens: "10:00", Closes: "14:00"})
+	calendar, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return calendar
+}
+
+func TestNextOpenDay(t *testing.T) {
+	calendar := newTestCalendar(t)
+	berlin := calendar.location
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2024, time.March, day, hour, min, 0, 0, berlin)
+	}
+	tests := []struct {
+		name string
+		due  time.Time
+		want time.Time
+	}{
+		{"open day", at(25, 12, 0), at(25, 12, 0)},
+		{"at closing time", at(25, 18, 0), at(25, 18, 0)},
+		{"after closing time", at(25, 20, 30), at(25, 18, 0)},
+		{"Sunday", at(24, 12, 0), at(25, 12, 0)},
+		{"Sunday evening", at(24, 21, 0), at(25, 18, 0)},
+		{"holiday to a short Saturday", at(29, 16, 0), at(30, 14, 0)},
+		{"holiday morning", at(29, 11, 0), at(30, 11, 0)},
+		{"across the change to summer time", at(31, 12, 0), time.Date(2024, time.April, 1, 12, 0, 0, 0, berlin)},
+		{"in another zone", at(24, 12, 0).UTC(), at(25, 12, 0).UTC()},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := calendar.NextOpenDay(test.due)
+			if !got.Equal(test.want) || got.Location() != test.want.Location() {
+				t.Errorf("NextOpenDay(%v) = %v, want %v", test.due, got, test.want)
+			}
+		})
+	}
+}
+
+func TestDefaultNeverMovesDueDates(t *testing.T) {
+	calendar, err := New(Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	due := time.Date(2024, time.March, 24, 23, 59, 0, 0, time.UTC)
+	if got := calendar.NextOpenDay(due); !got.Equal(due) {
+		t.Errorf("NextOpenDay(%v) = %v", due, got)
+	}
+}
@@ -0,0 +1,619 @@
+package appconfig
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"library_management_system/authz"
+	"library_management_system/config/dbconfig"
+	"library_management_system/config/jsonconfig"
+	"library_management_system/scheduler"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ConfigFileEnv names the environment variable holding the config file path
+// when -config is not given.
+const ConfigFileEnv = "LMS_CONFIG"
+
+// Config is the runtime configuration of the service.
+type Config struct {
+	Server      ServerConfig      `json:"server"`
+	Storage     StorageConfig     `json:"storage"`
+	Auth        AuthConfig        `json:"auth"`
+	Circulation CirculationConfig `json:"circulation"`
+	Scheduler   SchedulerConfig   `json:"scheduler"`
+}
+
+type ServerConfig struct {
+	Addr              string   `json:"addr"`
+	ReadHeaderTimeout Duration `json:"read_header_timeout"`
+	ReadTimeout       Duration `json:"read_timeout"`
+	WriteTimeout      Duration `json:"write_timeout"`
+	IdleTimeout       Duration `json:"idle_timeout"`
+	ShutdownTimeout   Duration `json:"shutdown_timeout"`
+}
+
+type StorageConfig struct {
+	Backend                 string `json:"backend"`
+	MongoURI                string `json:"mongo_uri"`
+	DatabaseName            string `json:"database_name"`
+	BooksCollection         string `json:"books_collection"`
+	UsersCollection         string `json:"users_collection"`
+	LoansCollection         string `json:"loans_collection"`
+	HoldsCollection         string `json:"holds_collection"`
+	PaymentsCollection      string `json:"payments_collection"`
+	CalendarCollection      string `json:"calendar_collection"`
+	JobLeasesCollection     string `json:"job_leases_collection"`
+	JobRunsCollection       string `json:"job_runs_collection"`
+	SessionsCollection      string `json:"sessions_collection"`
+	RevokedTokensCollection string `json:"revoked_tokens_collection"`
+	SigningKeysCollection   string `json:"signing_keys_collection"`
+	BoltPath                string `json:"bolt_path"`
+	SQLDriver               string `json:"sql_driver"`
+	SQLDSN                  string `json:"sql_dsn"`
+}
+
+type AuthConfig struct {
+	// SigningAlgorithm is the algorithm of new signing keys, RS256 or EdDSA.
+	SigningAlgorithm string `json:"signing_algorithm"`
+	// KeyRotationInterval is how long a signing key signs tokens before it is
+	// replaced by a new one.
+	KeyRotationInterval Duration `json:"key_rotation_interval"`
+	// KeyRotationWindow is how long a replaced key still verifies tokens. It
+	// must outlast the access tokens the key signed, which it goes on signing
+	// until its replacement has been published for jsonconfig.JWKSMaxAge.
+	KeyRotationWindow Duration `json:"key_rotation_window"`
+	// KeyEncryptionKey encrypts the signing keys stored in the database. It
+	// is 32 random bytes in standard base64, such as the output of
+	// "openssl rand -base64 32", and should not be stored with the database.
+	KeyEncryptionKey string   `json:"key_encryption_key"`
+	TokenTTL         Duration `json:"token_ttl"`
+	// RefreshTokenTTL is how long a login session lasts. Its refresh token
+	// changes on every use but the session does not outlive it.
+	RefreshTokenTTL Duration `json:"refresh_token_ttl"`
+	BcryptCost      int      `json:"bcrypt_cost"`
+	// Roles defines roles besides the built-in user, librarian and admin,
+	// each as the list of permissions it grants.
+	Roles Roles `json:"roles"`
+}
+
+// keyEncryptionKeySize is the length of the key encryption key, an AES-256
+// key.
+const keyEncryptionKeySize = 32
+
+// DecodeKeyEncryptionKey returns the bytes of KeyEncryptionKey.
+func (c AuthConfig) DecodeKeyEncryptionKey() ([]byte, error) {
+	if strings.TrimSpace(c.KeyEncryptionKey) == "" {
+		return nil, errors.New("is empty")
+	}
+	key, err := base64.StdEncoding.DecodeString(c.KeyEncryptionKey)
+	if err != nil {
+		return nil, errors.New("is not base64")
+	}
+	if len(key) != keyEncryptionKeySize {
+		return nil, fmt.Errorf("must be %d bytes, not %d", keyEncryptionKeySize, len(key))
+	}
+	return key, nil
+}
+
+// CirculationConfig holds the lending rules. Fines and balances are in minor
+// currency units.
+type CirculationConfig struct {
+	LoanPeriod  Duration `json:"loan_period"`
+	MaxRenewals int      `json:"max_renewals"`
+	// HoldExpiry is how long a hold waits in the queue before it lapses.
+	HoldExpiry Duration `json:"hold_expiry"`
+	// PickupWindow is how long a copy is set aside for a ready hold.
+	PickupWindow   Duration `json:"pickup_window"`
+	FineDailyRate  int      `json:"fine_daily_rate"`
+	FineGraceDays  int      `json:"fine_grace_days"`
+	FineMaxPerItem int      `json:"fine_max_per_item"`
+	// MaxBalance is the most a user may owe and still borrow.
+	MaxBalance int `json:"max_balance"`
+	// LoanLimits caps the concurrent loans of users by role; users with
+	// several roles get the highest of their limits. Users none of whose
+	// roles has an entry get DefaultLoanLimit. Administrators can override
+	// the limit of a single user.
+	LoanLimits       LoanLimits `json:"loan_limits"`
+	DefaultLoanLimit int        `json:"default_loan_limit"`
+	// DueSoonWindow is how long before the due date borrowers are reminded.
+	DueSoonWindow Duration `json:"due_soon_window"`
+}
+
+// SchedulerConfig controls the background jobs. Schedules are cron
+// expressions in UTC.
+type SchedulerConfig struct {
+	Enabled bool `json:"enabled"`
+	// InstanceID names this server in job leases and runs; when empty the
+	// host name and process ID are used.
+	InstanceID string `json:"instance_id"`
+	// Tick is how often the scheduler looks for due jobs.
+	Tick Duration `json:"tick"`
+	// LeaseTTL is the longest a job run may take before it is cancelled and
+	// another server may run the job.
+	LeaseTTL           Duration `json:"lease_ttl"`
+	OverdueSchedule    string   `json:"overdue_schedule"`
+	HoldExpirySchedule string   `json:"hold_expiry_schedule"`
+	DueSoonSchedule    string   `json:"due_soon_schedule"`
+	// SessionPurgeSchedule is when expired sessions and revoked tokens are
+	// deleted.
+	SessionPurgeSchedule string `json:"session_purge_schedule"`
+	// KeyRotationSchedule is when signing keys are checked for rotation. A
+	// key is only replaced once the rotation interval has passed.
+	KeyRotationSchedule string `json:"key_rotation_schedule"`
+}
+
+// LoanLimits maps a role to the number of books its users may have on loan
+// at once. On the command line and in the environment it is written as
+// comma separated role=limit pairs, such as "user=5,admin=20".
+type LoanLimits map[string]int
+
+func (l LoanLimits) String() string {
+	pairs := make([]string, 0, len(l))
+	for role, limit := range l {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", role, limit))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (l *LoanLimits) Set(s string) error {
+	limits := LoanLimits{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		role, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("%q is not a role=limit pair", pair)
+		}
+		limit, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		limits[strings.TrimSpace(role)] = limit
+	}
+	*l = limits
+	return nil
+}
+
+// Roles maps a role to the permissions it grants. On the command line and in
+// the environment it is written as comma separated role=permissions pairs,
+// with the permissions joined by "+", such as
+// "cataloguer=books:read+books:write,desk=loans:checkout-for-others".
+type Roles map[string][]string
+
+func (r Roles) String() string {
+	pairs := make([]string, 0, len(r))
+	for role, permissions := range r {
+		pairs = append(pairs, role+"="+strings.Join(permissions, "+"))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (r *Roles) Set(s string) error {
+	roles := Roles{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		role, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("%q is not a role=permissions pair", pair)
+		}
+		permissions := []string{}
+		for _, permission := range strings.Split(value, "+") {
+			if permission = strings.TrimSpace(permission); permission != "" {
+				permissions = append(permissions, permission)
+			}
+		}
+		roles[strings.TrimSpace(role)] = permissions
+	}
+	*r = roles
+	return nil
+}
+
+// Duration is a time.Duration that reads and writes strings such as "1h30m"
+// in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *Duration) Set(s string) error {
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Default returns the configuration used when nothing overrides it.
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Addr:              ":8000",
+			ReadHeaderTimeout: Duration(5 * time.Second),
+			ReadTimeout:       Duration(15 * time.Second),
+			WriteTimeout:      Duration(15 * time.Second),
+			IdleTimeout:       Duration(time.Minute),
+			ShutdownTimeout:   Duration(30 * time.Second),
+		},
+		Storage: StorageConfig{
+			Backend:                 dbconfig.MongoBackend,
+			MongoURI:                dbconfig.MongoURI,
+			DatabaseName:            dbconfig.DatabaseName,
+			BooksCollection:         dbconfig.BooksCollection,
+			UsersCollection:         dbconfig.UsersCollection,
+			LoansCollection:         dbconfig.LoansCollection,
+			HoldsCollection:         dbconfig.HoldsCollection,
+			PaymentsCollection:      dbconfig.PaymentsCollection,
+			CalendarCollection:      dbconfig.CalendarCollection,
+			JobLeasesCollection:     dbconfig.JobLeasesCollection,
+			JobRunsCollection:       dbconfig.JobRunsCollection,
+			SessionsCollection:      dbconfig.SessionsCollection,
+			RevokedTokensCollection: dbconfig.RevokedTokensCollection,
+			SigningKeysCollection:   dbconfig.SigningKeysCollection,
+			BoltPath:                dbconfig.BoltPath,
+			SQLDriver:               dbconfig.SQLDriver,
+			SQLDSN:                  dbconfig.SQLDSN,
+		},
+		Auth: AuthConfig{
+			SigningAlgorithm:    "RS256",
+			KeyRotationInterval: Duration(30 * 24 * time.Hour),
+			KeyRotationWindow:   Duration(24 * time.Hour),
+			TokenTTL:            Duration(15 * time.Minute),
+			RefreshTokenTTL:     Duration(30 * 24 * time.Hour),
+			BcryptCost:          bcrypt.DefaultCost,
+		},
+		Circulation: CirculationConfig{
+			LoanPeriod:     Duration(14 * 24 * time.Hour),
+			MaxRenewals:    2,
+			HoldExpiry:     Duration(90 * 24 * time.Hour),
+			PickupWindow:   Duration(3 * 24 * time.Hour),
+			FineDailyRate:  25,
+			FineGraceDays:  1,
+			FineMaxPerItem: 1000,
+			MaxBalance:     500,
+			LoanLimits: LoanLimits{
+				authz.UserRole:      5,
+				authz.LibrarianRole: 20,
+				authz.AdminRole:     20,
+			},
+			DefaultLoanLimit: 5,
+			DueSoonWindow:    Duration(48 * time.Hour),
+		},
+		Scheduler: SchedulerConfig{
+			Enabled:              true,
+			Tick:                 Duration(30 * time.Second),
+			LeaseTTL:             Duration(10 * time.Minute),
+			OverdueSchedule:      "*/15 * * * *",
+			HoldExpirySchedule:   "*/15 * * * *",
+			DueSoonSchedule:      "0 8 * * *",
+			SessionPurgeSchedule: "0 3 * * *",
+			KeyRotationSchedule:  "0 * * * *",
+		},
+	}
+}
+
+// setting binds one configuration value to its environment variable and
+// command-line flag.
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	value flag.Value
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"LISTEN_ADDR", "addr", "address the HTTP server listens on", (*stringValue)(&c.Server.Addr)},
+		{"READ_HEADER_TIMEOUT", "read-header-timeout", "time allowed to read request headers", &c.Server.ReadHeaderTimeout},
+		{"READ_TIMEOUT", "read-timeout", "time allowed to read a whole request", &c.Server.ReadTimeout},
+		{"WRITE_TIMEOUT", "write-timeout", "time allowed to write a response", &c.Server.WriteTimeout},
+		{"IDLE_TIMEOUT", "idle-timeout", "how long idle keep-alive connections stay open", &c.Server.IdleTimeout},
+		{"SHUTDOWN_TIMEOUT", "shutdown-timeout", "how long to drain in-flight requests on shutdown", &c.Server.ShutdownTimeout},
+		{"STORAGE_BACKEND", "storage-backend", "storage backend: mongo, bolt, sql or memory, which forgets everything on exit", (*stringValue)(&c.Storage.Backend)},
+		{"MONGO_URI", "mongo-uri", "MongoDB connection URI of a replica set or sharded cluster", (*stringValue)(&c.Storage.MongoURI)},
+		{"MONGO_DATABASE", "mongo-database", "MongoDB database name", (*stringValue)(&c.Storage.DatabaseName)},
+		{"MONGO_BOOKS_COLLECTION", "mongo-books-collection", "MongoDB collection holding books", (*stringValue)(&c.Storage.BooksCollection)},
+		{"MONGO_USERS_COLLECTION", "mongo-users-collection", "MongoDB collection holding users", (*stringValue)(&c.Storage.UsersCollection)},
+		{"MONGO_LOANS_COLLECTION", "mongo-loans-collection", "MongoDB collection holding loans", (*stringValue)(&c.Storage.LoansCollection)},
+		{"MONGO_HOLDS_COLLECTION", "mongo-holds-collection", "MongoDB collection holding holds", (*stringValue)(&c.Storage.HoldsCollection)},
+		{"MONGO_PAYMENTS_COLLECTION", "mongo-payments-collection", "MongoDB collection holding fine payments and waivers", (*stringValue)(&c.Storage.PaymentsCollection)},
+		{"MONGO_CALENDAR_COLLECTION", "mongo-calendar-collection", "MongoDB collection holding the calendar", (*stringValue)(&c.Storage.CalendarCollection)},
+		{"MONGO_JOB_LEASES_COLLECTION", "mongo-job-leases-collection", "MongoDB collection holding job leases", (*stringValue)(&c.Storage.JobLeasesCollection)},
+		{"MONGO_JOB_RUNS_COLLECTION", "mongo-job-runs-collection", "MongoDB collection holding the job run history", (*stringValue)(&c.Storage.JobRunsCollection)},
+		{"MONGO_SESSIONS_COLLECTION", "mongo-sessions-collection", "MongoDB collection holding login sessions", (*stringValue)(&c.Storage.SessionsCollection)},
+		{"MONGO_REVOKED_TOKENS_COLLECTION", "mongo-revoked-tokens-collection", "MongoDB collection holding revoked access tokens", (*stringValue)(&c.Storage.RevokedTokensCollection)},
+		{"MONGO_SIGNING_KEYS_COLLECTION", "mongo-signing-keys-collection", "MongoDB collection holding token signing keys", (*stringValue)(&c.Storage.SigningKeysCollection)},
+		{"BOLT_PATH", "bolt-path", "database file for the bolt backend", (*stringValue)(&c.Storage.BoltPath)},
+		{"SQL_DRIVER", "sql-driver", "database/sql driver for the sql backend", (*stringValue)(&c.Storage.SQLDriver)},
+		{"SQL_DSN", "sql-dsn", "data source name for the sql backend", (*stringValue)(&c.Storage.SQLDSN)},
+		{"JWT_ALGORITHM", "jwt-algorithm", "algorithm of new token signing keys: RS256 or EdDSA", (*stringValue)(&c.Auth.SigningAlgorithm)},
+		{"KEY_ROTATION_INTERVAL", "key-rotation-interval", "how long a signing key is used before it is replaced", &c.Auth.KeyRotationInterval},
+		{"KEY_ROTATION_WINDOW", "key-rotation-window", "how long a replaced signing key still verifies tokens", &c.Auth.KeyRotationWindow},
+		{"KEY_ENCRYPTION_KEY", "key-encryption-key", "base64 encoded 32 byte key encrypting the stored signing keys", (*stringValue)(&c.Auth.KeyEncryptionKey)},
+		{"TOKEN_TTL", "token-ttl", "lifetime of access tokens", &c.Auth.TokenTTL},
+		{"REFRESH_TOKEN_TTL", "refresh-token-ttl", "lifetime of login sessions and their refresh tokens", &c.Auth.RefreshTokenTTL},
+		{"BCRYPT_COST", "bcrypt-cost", "bcrypt cost used to hash passwords", (*intValue)(&c.Auth.BcryptCost)},
+		{"ROLES", "roles", "custom roles, as role=permission+permission pairs", &c.Auth.Roles},
+		{"LOAN_PERIOD", "loan-period", "how long a copy is lent before it is due", &c.Circulation.LoanPeriod},
+		{"MAX_RENEWALS", "max-renewals", "how many times a loan can be renewed", (*intValue)(&c.Circulation.MaxRenewals)},
+		{"HOLD_EXPIRY", "hold-expiry", "how long a hold waits in the queue before it lapses", &c.Circulation.HoldExpiry},
+		{"PICKUP_WINDOW", "pickup-window", "how long a returned copy is kept for the next holder", &c.Circulation.PickupWindow},
+		{"FINE_DAILY_RATE", "fine-daily-rate", "fine per overdue day, in minor currency units", (*intValue)(&c.Circulation.FineDailyRate)},
+		{"FINE_GRACE_DAYS", "fine-grace-days", "overdue days that are not fined", (*intValue)(&c.Circulation.FineGraceDays)},
+		{"FINE_MAX_PER_ITEM", "fine-max-per-item", "largest fine for one loan, 0 for no cap", (*intValue)(&c.Circulation.FineMaxPerItem)},
+		{"MAX_BALANCE", "max-balance", "largest fine balance that still allows borrowing", (*intValue)(&c.Circulation.MaxBalance)},
+		{"LOAN_LIMITS", "loan-limits", "concurrent loan limits by role, as role=limit pairs", &c.Circulation.LoanLimits},
+		{"DEFAULT_LOAN_LIMIT", "default-loan-limit", "concurrent loan limit of roles without one", (*intValue)(&c.Circulation.DefaultLoanLimit)},
+		{"DUE_SOON_WINDOW", "due-soon-window", "how long before the due date borrowers are reminded", &c.Circulation.DueSoonWindow},
+		{"SCHEDULER_ENABLED", "scheduler-enabled", "run background jobs in this server", (*boolValue)(&c.Scheduler.Enabled)},
+		{"SCHEDULER_INSTANCE_ID", "scheduler-instance-id", "name of this server in job leases and runs", (*stringValue)(&c.Scheduler.InstanceID)},
+		{"SCHEDULER_TICK", "scheduler-tick", "how often to look for due jobs", &c.Scheduler.Tick},
+		{"SCHEDULER_LEASE_TTL", "scheduler-lease-ttl", "longest a job run may take", &c.Scheduler.LeaseTTL},
+		{"OVERDUE_SCHEDULE", "overdue-schedule", "cron schedule for marking loans overdue", (*stringValue)(&c.Scheduler.OverdueSchedule)},
+		{"HOLD_EXPIRY_SCHEDULE", "hold-expiry-schedule", "cron schedule for expiring holds", (*stringValue)(&c.Scheduler.HoldExpirySchedule)},
+		{"DUE_SOON_SCHEDULE", "due-soon-schedule", "cron schedule for due soon reminders", (*stringValue)(&c.Scheduler.DueSoonSchedule)},
+		{"SESSION_PURGE_SCHEDULE", "session-purge-schedule", "cron schedule for deleting expired sessions", (*stringValue)(&c.Scheduler.SessionPurgeSchedule)},
+		{"KEY_ROTATION_SCHEDULE", "key-rotation-schedule", "cron schedule for rotating token signing keys", (*stringValue)(&c.Scheduler.KeyRotationSchedule)},
+	}
+}
+
+// Load builds the configuration from, in increasing order of precedence, the
+// defaults, the JSON file named by -config or LMS_CONFIG, environment
+// variables and command-line flags. It returns the validated configuration and
+// the arguments left after the flags.
+func Load(args []string) (*Config, []string, error) {
+	// Flags are parsed into a scratch config first so that they can be
+	// applied last, after the file and the environment.
+	var flagged Config
+	fs := flag.NewFlagSet("library_management_system", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv(ConfigFileEnv), "path to a JSON config file")
+	flaggedSettings := flagged.settings()
+	for _, s := range flaggedSettings {
+		fs.Var(s.value, s.flag, s.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	config := Default()
+	if *configFile != "" {
+		if err := config.loadFile(*configFile); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	settings := config.settings()
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok {
+			if err := s.value.Set(value); err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		for i, s := range flaggedSettings {
+			if s.flag == f.Name && err == nil {
+				err = settings[i].value.Set(s.value.String())
+			}
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return &config, fs.Args(), nil
+}
+
+func (c *Config) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports every problem with the configuration at once.
+func (c *Config) Validate() error {
+	var problems []string
+	require := func(value, name string) {
+		if strings.TrimSpace(value) == "" {
+			problems = append(problems, name+" is empty")
+		}
+	}
+
+	require(c.Server.Addr, "server.addr")
+	positive := func(value Duration, name string) {
+		if value <= 0 {
+			problems = append(problems, name+" must be positive")
+		}
+	}
+	positive(c.Server.ReadHeaderTimeout, "server.read_header_timeout")
+	positive(c.Server.ReadTimeout, "server.read_timeout")
+	positive(c.Server.WriteTimeout, "server.write_timeout")
+	positive(c.Server.IdleTimeout, "server.idle_timeout")
+	positive(c.Server.ShutdownTimeout, "server.shutdown_timeout")
+	if c.Auth.SigningAlgorithm != "RS256" && c.Auth.SigningAlgorithm != "EdDSA" {
+		problems = append(problems, fmt.Sprintf("auth.signing_algorithm %q is not one of RS256, EdDSA", c.Auth.SigningAlgorithm))
+	}
+	positive(c.Auth.KeyRotationInterval, "auth.key_rotation_interval")
+	if _, err := c.Auth.DecodeKeyEncryptionKey(); err != nil {
+		problems = append(problems, "auth.key_encryption_key: "+err.Error())
+	}
+	positive(c.Auth.TokenTTL, "auth.token_ttl")
+	if time.Duration(c.Auth.KeyRotationWindow) < time.Duration(c.Auth.TokenTTL)+jsonconfig.JWKSMaxAge {
+		problems = append(problems, fmt.Sprintf("auth.key_rotation_window must be at least auth.token_ttl plus %v, the time a new key is published before it signs", jsonconfig.JWKSMaxAge))
+	}
+	positive(c.Auth.RefreshTokenTTL, "auth.refresh_token_ttl")
+	if c.Auth.BcryptCost < bcrypt.MinCost || c.Auth.BcryptCost > bcrypt.MaxCost {
+		problems = append(problems, fmt.Sprintf("auth.bcrypt_cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost))
+	}
+	if _, err := authz.NewPolicy(c.Auth.Roles); err != nil {
+		problems = append(problems, "auth.roles: "+err.Error())
+	}
+	positive(c.Circulation.LoanPeriod, "circulation.loan_period")
+	nonNegative := func(value int, name string) {
+		if value < 0 {
+			problems = append(problems, name+" must not be negative")
+		}
+	}
+	nonNegative(c.Circulation.MaxRenewals, "circulation.max_renewals")
+	positive(c.Circulation.HoldExpiry, "circulation.hold_expiry")
+	positive(c.Circulation.PickupWindow, "circulation.pickup_window")
+	nonNegative(c.Circulation.FineDailyRate, "circulation.fine_daily_rate")
+	nonNegative(c.Circulation.FineGraceDays, "circulation.fine_grace_days")
+	nonNegative(c.Circulation.FineMaxPerItem, "circulation.fine_max_per_item")
+	nonNegative(c.Circulation.MaxBalance, "circulation.max_balance")
+	nonNegative(c.Circulation.DefaultLoanLimit, "circulation.default_loan_limit")
+	positive(c.Circulation.DueSoonWindow, "circulation.due_soon_window")
+	roles := make([]string, 0, len(c.Circulation.LoanLimits))
+	for role := range c.Circulation.LoanLimits {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	for _, role := range roles {
+		nonNegative(c.Circulation.LoanLimits[role], "circulation.loan_limits."+role)
+	}
+
+	positive(c.Scheduler.Tick, "scheduler.tick")
+	positive(c.Scheduler.LeaseTTL, "scheduler.lease_ttl")
+	schedule := func(spec, name string) {
+		if _, err := scheduler.ParseSchedule(spec); err != nil {
+			problems = append(problems, name+": "+err.Error())
+		}
+	}
+	schedule(c.Scheduler.OverdueSchedule, "scheduler.overdue_schedule")
+	schedule(c.Scheduler.HoldExpirySchedule, "scheduler.hold_expiry_schedule")
+	schedule(c.Scheduler.DueSoonSchedule, "scheduler.due_soon_schedule")
+	schedule(c.Scheduler.SessionPurgeSchedule, "scheduler.session_purge_schedule")
+	schedule(c.Scheduler.KeyRotationSchedule, "scheduler.key_rotation_schedule")
+
+	switch c.Storage.Backend {
+	case dbconfig.MongoBackend:
+		require(c.Storage.MongoURI, "storage.mongo_uri")
+		require(c.Storage.DatabaseName, "storage.database_name")
+		require(c.Storage.BooksCollection, "storage.books_collection")
+		require(c.Storage.UsersCollection, "storage.users_collection")
+		require(c.Storage.LoansCollection, "storage.loans_collection")
+		require(c.Storage.HoldsCollection, "storage.holds_collection")
+		require(c.Storage.PaymentsCollection, "storage.payments_collection")
+		require(c.Storage.CalendarCollection, "storage.calendar_collection")
+		require(c.Storage.JobLeasesCollection, "storage.job_leases_collection")
+		require(c.Storage.JobRunsCollection, "storage.job_runs_collection")
+		require(c.Storage.SessionsCollection, "storage.sessions_collection")
+		require(c.Storage.RevokedTokensCollection, "storage.revoked_tokens_collection")
+		require(c.Storage.SigningKeysCollection, "storage.signing_keys_collection")
+	case dbconfig.BoltBackend:
+		require(c.Storage.BoltPath, "storage.bolt_path")
+	case dbconfig.SQLBackend:
+		require(c.Storage.SQLDriver, "storage.sql_driver")
+		require(c.Storage.SQLDSN, "storage.sql_dsn")
+	case dbconfig.MemoryBackend:
+	default:
+		problems = append(problems, fmt.Sprintf("storage.backend %q is not one of %s, %s, %s, %s",
+			c.Storage.Backend, dbconfig.MongoBackend, dbconfig.BoltBackend, dbconfig.SQLBackend, dbconfig.MemoryBackend))
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+type stringValue string
+
+func (s *stringValue) Set(value string) error {
+	*s = stringValue(value)
+	return nil
+}
+
+func (s *stringValue) String() string {
+	if s == nil {
+		return ""
+	}
+	return string(*s)
+}
+
+type intValue int
+
+func (i *intValue) Set(value string) error {
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("%q is not a number", value)
+	}
+	*i = intValue(parsed)
+	return nil
+}
+
+func (i *intValue) String() string {
+	if i == nil {
+		return "0"
+	}
+	return fmt.Sprint(int(*i))
+}
+
+type boolValue bool
+
+func (b *boolValue) Set(value string) error {
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("%q is not true or false", value)
+	}
+	*b = boolValue(parsed)
+	return nil
+}
+
+func (b *boolValue) String() string {
+	if b == nil {
+		return "false"
+	}
+	return strconv.FormatBool(bool(*b))
+}
+
+// IsBoolFlag lets boolean flags be given without a value.
+func (b *boolValue) IsBoolFlag() bool {
+	return true
+}
@@ -1,17 +1,102 @@
 package dbconfig
 
 const (
-	MongoURI        = "mongodb://localhost:27017"
-	UsersCollection = "users"
-	Username        = "username"
-	Role            = "role"
-	BooksCollection = "books"
-	BorrowedBookIDs = "borrowed_book_ids"
-	Title           = "title"
-	Author          = "author"
-	Amount          = "amount"
-	OwnedBy         = "owned_by"
-	DatabaseName    = "mydb"
-	ID              = "_id"
-	SetOperator     = "$set"
+	MongoBackend            = "mongo"
+	BoltBackend             = "bolt"
+	BoltPath                = "library.db"
+	SQLBackend              = "sql"
+	SQLDriver               = "sqlite3"
+	SQLDSN                  = "library.sqlite"
+	MemoryBackend           = "memory"
+	MongoURI                = "mongodb://localhost:27017"
+	UsersCollection         = "users"
+	LoansCollection         = "loans"
+	HoldsCollection         = "holds"
+	PaymentsCollection      = "payments"
+	CalendarCollection      = "calendar"
+	JobLeasesCollection     = "job_leases"
+	JobRunsCollection       = "job_runs"
+	SessionsCollection      = "sessions"
+	RevokedTokensCollection = "revoked_tokens"
+	SigningKeysCollection   = "signing_keys"
+	HeldFor                 = "held_for"
+	PlacedAt                = "placed_at"
+	ExpiresAt               = "expires_at"
+	At                      = "at"
+	InOperator              = "$in"
+	NotInOperator           = "$nin"
+	BookID                  = "book_id"
+	BorrowedAt              = "borrowed_at"
+	ReturnedAt              = "returned_at"
+	Fine                    = "fine"
+	DueAt                   = "due_at"
+	Renewals                = "renewals"
+	CheckedInBy             = "checked_in_by"
+	Outcome                 = "outcome"
+	ReplacementFee          = "replacement_fee"
+	OverdueAt               = "overdue_at"
+	RemindedAt              = "reminded_at"
+	Job                     = "job"
+	Owner                   = "owner"
+	Slot                    = "slot"
+	StartedAt               = "started_at"
+	FinishedAt              = "finished_at"
+	Result                  = "result"
+	Error                   = "error"
+	RefreshTokenHash        = "refresh_token_hash"
+	AccessTokenID           = "access_token_id"
+	AccessTokenExpiresAt    = "access_token_expires_at"
+	CreatedAt               = "created_at"
+	RevokedAt               = "revoked_at"
+	Withdrawal              = "withdrawal"
+	CardNumber              = "card_number"
+	Username                = "username"
+	Role                    = "role"
+	Roles                   = "roles"
+	Disabled                = "disabled"
+	Password                = "password"
+	Email                   = "email"
+	Phone                   = "phone"
+	LoanLimit               = "loan_limit"
+	BooksCollection         = "books"
+	BorrowedBookIDs         = "borrowed_book_ids"
+	Title                   = "title"
+	Author                  = "author"
+	Amount                  = "amount"
+	Copies                  = "copies"
+	Barcode                 = "barcode"
+	Status                  = "status"
+	Location                = "location"
+	Condition               = "condition"
+	BorrowedBy              = "borrowed_by"
+	SearchTerms             = "search_terms"
+	OwnedBy                 = "owned_by"
+	MigrationsCollection    = "migrations"
+	DatabaseName            = "mydb"
+	ID                      = "_id"
+	SetOperator             = "$set"
+	RegexOperator           = "$regex"
+	LessThanOrEqualOperator = "$lte"
+	LessThanOperator        = "$lt"
+	AndOperator             = "$and"
+	UnsetOperator           = "$unset"
+	PushOperator            = "$push"
+	ElemMatchOperator       = "$elemMatch"
+	EqualOperator           = "$eq"
+	MapOperator             = "$map"
+	FilterOperator          = "$filter"
+	CondOperator            = "$cond"
+	SizeOperator            = "$size"
+	MergeObjectsOperator    = "$mergeObjects"
+	ExistsOperator          = "$exists"
+	IncOperator             = "$inc"
+	PullOperator            = "$pull"
+	NotEqualOperator        = "$ne"
+	GreaterThanOperator     = "$gt"
+	IfNullOperator          = "$ifNull"
+	ConcatArraysOperator    = "$concatArrays"
+	SubtractOperator        = "$subtract"
+	LiteralOperator         = "$literal"
+	ArrayElemAtOperator     = "$arrayElemAt"
+	AdminDatabaseName       = "admin"
 )
@@ -1,15 +1,27 @@
 package jsonconfig
 
+import "time"
+
 const (
 	UsernameContextKey  = "username"
-	RoleContextKey      = "role"
+	RolesContextKey     = "roles"
+	TokenIDContextKey   = "token_id"
+	SessionIDContextKey = "session_id"
 	UsernameClaimKey    = UsernameContextKey
-	RoleClaimKey        = RoleContextKey
+	RolesClaimKey       = RolesContextKey
 	ExpirationClaimKey  = "exp"
+	IssuedAtClaimKey    = "iat"
+	TokenIDClaimKey     = "jti"
+	SessionIDClaimKey   = "sid"
 	ContentType         = "Content-Type"
 	ApplicationJson     = "application/json"
 	AuthorizationHeader = "Authorization"
 	Bearer              = "Bearer "
 	ErrorJsonKey        = "error"
-	TokenKey            = "token"
+	StatusJsonKey       = "status"
+	CacheControlHeader  = "Cache-Control"
+	// JWKSMaxAge is how long clients may cache the key set; they should
+	// fetch it again when a token names a key they do not know. New keys
+	// sign nothing until they have been published for this long.
+	JWKSMaxAge = 5 * time.Minute
 )
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"library_management_system/authz"
+	"library_management_system/config/appconfig"
+	"library_management_system/db"
+	"library_management_system/services/sessionservice"
+	"library_management_system/services/userservice"
+	"os"
+	"strings"
+)
+
+// runCreateUser implements the "create-user [-roles admin] <username>"
+// command, which creates an account straight in the configured storage. It
+// is how the first administrator is made. The password is read from the
+// first line of standard input so that it stays out of the shell history.
+func runCreateUser(config *appconfig.Config, args []string) error {
+	fs := flag.NewFlagSet("create-user", flag.ContinueOnError)
+	roles := fs.String("roles", authz.AdminRole, "comma separated roles of the new user")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: create-user [-roles admin] <username>, with the password on standard input")
+	}
+
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	password = strings.TrimRight(password, "\r\n")
+
+	policy, err := authz.NewPolicy(config.Auth.Roles)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	repos, err := db.Open(ctx, config.Storage)
+	if err != nil {
+		return err
+	}
+	defer repos.Close(ctx)
+
+	sessions := sessionservice.NewService(repos.Sessions, repos.Users, repos.Transactor, config.Auth)
+	users := userservice.NewService(repos.Users, repos.Holds, sessions, repos.Transactor, policy, config.Auth)
+	user, err := users.RegisterUser(fs.Arg(0), password, strings.Split(*roles, ","), ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created %s with roles %s and library card %s\n", user.Username, strings.Join(user.Roles, ", "), user.CardNumber)
+	return nil
+}
@@ -2,44 +2,169 @@ package db
 
 import (
 	"context"
+	"fmt"
+	"library_management_system/config/appconfig"
 	"library_management_system/config/dbconfig"
-	"log"
+	"library_management_system/db/migrations"
+	"library_management_system/repository"
+	"library_management_system/repository/boltrepo"
+	"library_management_system/repository/memrepo"
+	"library_management_system/repository/mongorepo"
+	"library_management_system/repository/sqlrepo"
 
-	"go.mongodb.org/mongo-driver/bson"
+	_ "github.com/mattn/go-sqlite3"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var Client *mongo.Client
-var BooksCollection *mongo.Collection
-var UsersCollection *mongo.Collection
+// Repositories bundles the repositories of the selected backend with the
+// transactor that spans them.
+type Repositories struct {
+	Books      repository.BookRepository
+	Users      repository.UserRepository
+	Loans      repository.LoanRepository
+	Holds      repository.HoldRepository
+	Payments   repository.PaymentRepository
+	Calendar   repository.CalendarRepository
+	Jobs       repository.JobRepository
+	Sessions   repository.SessionRepository
+	Keys       repository.SigningKeyRepository
+	Transactor repository.Transactor
+	// Ping checks that the backend is reachable and usable.
+	Ping  func(ctx context.Context) error
+	Close func(ctx context.Context) error
+}
 
-// InitDB initializes the MongoDB client and collections.
-func InitDB() {
-	clientOptions := options.Client().ApplyURI(dbconfig.MongoURI)
-	var err error
-	dbContext := context.Background()
-	Client, err = mongo.Connect(dbContext, clientOptions)
-	if err != nil {
-		log.Fatal(err)
+// Open connects to the backend named in config and returns its repositories.
+func Open(ctx context.Context, config appconfig.StorageConfig) (*Repositories, error) {
+	switch config.Backend {
+	case dbconfig.MongoBackend:
+		client, err := ConnectMongo(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+		repos, err := openMongo(ctx, client, config)
+		if err != nil {
+			client.Disconnect(ctx)
+			return nil, err
+		}
+		return repos, nil
+	case dbconfig.BoltBackend:
+		store, err := boltrepo.Open(config.BoltPath)
+		if err != nil {
+			return nil, err
+		}
+		return &Repositories{
+			Books:      store.Books(),
+			Users:      store.Users(),
+			Loans:      store.Loans(),
+			Holds:      store.Holds(),
+			Payments:   store.Payments(),
+			Calendar:   store.Calendar(),
+			Jobs:       store.Jobs(),
+			Sessions:   store.Sessions(),
+			Keys:       store.SigningKeys(),
+			Transactor: store,
+			Ping:       store.Ping,
+			Close:      func(context.Context) error { return store.Close() },
+		}, nil
+	case dbconfig.SQLBackend:
+		store, err := sqlrepo.Open(ctx, config.SQLDriver, config.SQLDSN)
+		if err != nil {
+			return nil, err
+		}
+		return &Repositories{
+			Books:      store.Books(),
+			Users:      store.Users(),
+			Loans:      store.Loans(),
+			Holds:      store.Holds(),
+			Payments:   store.Payments(),
+			Calendar:   store.Calendar(),
+			Jobs:       store.Jobs(),
+			Sessions:   store.Sessions(),
+			Keys:       store.SigningKeys(),
+			Transactor: store,
+			Ping:       store.Ping,
+			Close:      func(context.Context) error { return store.Close() },
+		}, nil
+	case dbconfig.MemoryBackend:
+		store := memrepo.NewStore()
+		return &Repositories{
+			Books:      store.Books(),
+			Users:      store.Users(),
+			Loans:      store.Loans(),
+			Holds:      store.Holds(),
+			Payments:   store.Payments(),
+			Calendar:   store.Calendar(),
+			Jobs:       store.Jobs(),
+			Sessions:   store.Sessions(),
+			Keys:       store.SigningKeys(),
+			Transactor: store,
+			Ping:       func(context.Context) error { return nil },
+			Close:      func(context.Context) error { return nil },
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", config.Backend)
 	}
+}
 
-	// Check the connection
-	err = Client.Ping(dbContext, nil)
+// ConnectMongo connects to the MongoDB server named in config and checks
+// that it answers. Indexes are managed by the migrations package.
+func ConnectMongo(ctx context.Context, config appconfig.StorageConfig) (*mongo.Client, error) {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(config.MongoURI))
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+	return client, nil
+}
 
-	// Initialize collections
-	BooksCollection = Client.Database(dbconfig.DatabaseName).Collection(dbconfig.BooksCollection)
-	UsersCollection = Client.Database(dbconfig.DatabaseName).Collection(dbconfig.UsersCollection)
-
-	// Create username index for UsersCollection
-	_, err = UsersCollection.Indexes().CreateOne(dbContext, mongo.IndexModel{
-		Keys:    bson.D{{Key: dbconfig.Username, Value: 1}}, // Index on 'username'
-		Options: options.Index().SetUnique(true),            // Ensure unique values
-	})
+// openMongo checks that every migration has been applied to the configured
+// database, and returns repositories over its collections. It does not apply
+// migrations itself: run "migrate up" first. Closing the repositories
+// disconnects client.
+func openMongo(ctx context.Context, client *mongo.Client, config appconfig.StorageConfig) (*Repositories, error) {
+	err := migrations.NewMigrator(MigrationTarget(client, config), nil).EnsureCurrent(ctx)
 	if err != nil {
-		log.Fatalf("Failed to create index: %v", err)
+		return nil, err
+	}
+	transactor, err := mongorepo.NewTransactor(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	collection := client.Database(config.DatabaseName).Collection
+	return &Repositories{
+		Books:      mongorepo.NewBookRepository(collection(config.BooksCollection)),
+		Users:      mongorepo.NewUserRepository(collection(config.UsersCollection)),
+		Loans:      mongorepo.NewLoanRepository(collection(config.LoansCollection)),
+		Holds:      mongorepo.NewHoldRepository(collection(config.HoldsCollection)),
+		Payments:   mongorepo.NewPaymentRepository(collection(config.PaymentsCollection)),
+		Calendar:   mongorepo.NewCalendarRepository(collection(config.CalendarCollection)),
+		Jobs:       mongorepo.NewJobRepository(collection(config.JobLeasesCollection), collection(config.JobRunsCollection)),
+		Sessions:   mongorepo.NewSessionRepository(collection(config.SessionsCollection), collection(config.RevokedTokensCollection)),
+		Keys:       mongorepo.NewSigningKeyRepository(collection(config.SigningKeysCollection)),
+		Transactor: transactor,
+		Ping:       func(ctx context.Context) error { return client.Ping(ctx, nil) },
+		Close:      client.Disconnect,
+	}, nil
+}
+
+// MigrationTarget describes the configured Mongo database and collections to
+// the migrations package.
+func MigrationTarget(client *mongo.Client, config appconfig.StorageConfig) migrations.Target {
+	database := client.Database(config.DatabaseName)
+	return migrations.Target{
+		Database:      database,
+		Books:         database.Collection(config.BooksCollection),
+		Users:         database.Collection(config.UsersCollection),
+		Loans:         database.Collection(config.LoansCollection),
+		Holds:         database.Collection(config.HoldsCollection),
+		Payments:      database.Collection(config.PaymentsCollection),
+		JobRuns:       database.Collection(config.JobRunsCollection),
+		Sessions:      database.Collection(config.SessionsCollection),
+		RevokedTokens: database.Collection(config.RevokedTokensCollection),
 	}
 }
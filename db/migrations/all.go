@@ -0,0 +1,341 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"library_management_system/config/dbconfig"
+	"library_management_system/models"
+	"library_management_system/repository"
+	"library_management_system/search"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	usernameIndexName    = "username_1"
+	searchTermsIndexName = "search_terms_1"
+	barcodeIndexName     = "copies.barcode_1"
+	cardNumberIndexName  = "card_number_1"
+)
+
+// All is the ordered list of migrations shipped with this build. Append new
+// migrations with the next version number; never renumber or edit one that
+// has been released. Like every Migration, each one must be safe to run
+// again.
+var All = []Migration{
+	{
+		Version:     1,
+		Description: "unique index on users.username",
+		Up: func(ctx context.Context, target Target) error {
+			_, err := target.Users.Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: dbconfig.Username, Value: 1}},
+				Options: options.Index().SetUnique(true).SetName(usernameIndexName),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, target Target) error {
+			return dropIndexes(ctx, target.Users, usernameIndexName)
+		},
+	},
+	{
+		Version:     2,
+		Description: "backfill empty owned_by and borrowed_book_ids arrays",
+		Up: func(ctx context.Context, target Target) error {
+			_, err := target.Books.UpdateMany(ctx,
+				bson.M{dbconfig.OwnedBy: nil},
+				bson.M{dbconfig.SetOperator: bson.M{dbconfig.OwnedBy: bson.A{}}})
+			if err != nil {
+				return err
+			}
+			_, err = target.Users.UpdateMany(ctx,
+				bson.M{dbconfig.BorrowedBookIDs: nil},
+				bson.M{dbconfig.SetOperator: bson.M{dbconfig.BorrowedBookIDs: bson.A{}}})
+			return err
+		},
+	},
+	{
+		Version:     3,
+		Description: "indexes for listing books by author, title and availability",
+		Up: func(ctx context.Context, target Target) error {
+			_, err := target.Books.Indexes().CreateMany(ctx, bookListingIndexes)
+			return err
+		},
+		Down: func(ctx context.Context, target Target) error {
+			return dropIndexes(ctx, target.Books, indexNames(bookListingIndexes)...)
+		},
+	},
+	{
+		Version:     4,
+		Description: "search terms on books with a multikey index",
+		Up: func(ctx context.Context, target Target) error {
+			cursor, err := target.Books.Find(ctx, bson.D{{}})
+			if err != nil {
+				return err
+			}
+			defer cursor.Close(ctx)
+			for cursor.Next(ctx) {
+				var book models.Book
+				if err := cursor.Decode(&book); err != nil {
+					return err
+				}
+				_, err := target.Books.UpdateByID(ctx, book.ID, bson.M{dbconfig.SetOperator: bson.M{
+					dbconfig.SearchTerms: search.BookTerms(book),
+				}})
+				if err != nil {
+					return err
+				}
+			}
+			if err := cursor.Err(); err != nil {
+				return err
+			}
+			_, err = target.Books.Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: dbconfig.SearchTerms, Value: 1}},
+				Options: options.Index().SetName(searchTermsIndexName),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, target Target) error {
+			if err := dropIndexes(ctx, target.Books, searchTermsIndexName); err != nil {
+				return err
+			}
+			_, err := target.Books.UpdateMany(ctx, bson.D{{}}, bson.M{dbconfig.UnsetOperator: bson.M{dbconfig.SearchTerms: ""}})
+			return err
+		},
+	},
+	{
+		Version:     5,
+		Description: "individual copies with unique barcodes on books",
+		Up: func(ctx context.Context, target Target) error {
+			cursor, err := target.Books.Find(ctx, bson.M{dbconfig.Copies: nil})
+			if err != nil {
+				return err
+			}
+			defer cursor.Close(ctx)
+			for cursor.Next(ctx) {
+				var book models.Book
+				if err := cursor.Decode(&book); err != nil {
+					return err
+				}
+				copies := repository.LegacyCopies(book)
+				_, err := target.Books.UpdateByID(ctx, book.ID, bson.M{dbconfig.SetOperator: bson.M{
+					dbconfig.Copies: copies,
+					dbconfig.Amount: repository.AvailableCopies(copies),
+				}})
+				if err != nil {
+					return err
+				}
+			}
+			if err := cursor.Err(); err != nil {
+				return err
+			}
+			barcode := dbconfig.Copies + "." + dbconfig.Barcode
+			_, err = target.Books.Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{{Key: barcode, Value: 1}},
+				Options: options.Index().SetUnique(true).SetName(barcodeIndexName).
+					SetPartialFilterExpression(bson.M{barcode: bson.M{dbconfig.ExistsOperator: true}}),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, target Target) error {
+			return dropIndexes(ctx, target.Books, barcodeIndexName)
+		},
+	},
+	{
+		Version:     6,
+		Description: "indexes for loan history by book and by user",
+		Up: func(ctx context.Context, target Target) error {
+			_, err := target.Loans.Indexes().CreateMany(ctx, loanHistoryIndexes)
+			return err
+		},
+		Down: func(ctx context.Context, target Target) error {
+			return dropIndexes(ctx, target.Loans, indexNames(loanHistoryIndexes)...)
+		},
+	},
+	{
+		Version:     7,
+		Description: "indexes for the hold queue and hold expiry",
+		Up: func(ctx context.Context, target Target) error {
+			_, err := target.Holds.Indexes().CreateMany(ctx, holdQueueIndexes)
+			return err
+		},
+		Down: func(ctx context.Context, target Target) error {
+			return dropIndexes(ctx, target.Holds, indexNames(holdQueueIndexes)...)
+		},
+	},
+	{
+		Version:     8,
+		Description: "unique library card numbers on users",
+		Up: func(ctx context.Context, target Target) error {
+			_, err := target.Users.Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{{Key: dbconfig.CardNumber, Value: 1}},
+				Options: options.Index().SetUnique(true).SetName(cardNumberIndexName).
+					SetPartialFilterExpression(bson.M{dbconfig.CardNumber: bson.M{dbconfig.ExistsOperator: true}}),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, target Target) error {
+			return dropIndexes(ctx, target.Users, cardNumberIndexName)
+		},
+	},
+	{
+		Version:     9,
+		Description: "indexes for finding due loans and for the job run history",
+		Up: func(ctx context.Context, target Target) error {
+			if _, err := target.Loans.Indexes().CreateOne(ctx, loanDueIndex); err != nil {
+				return err
+			}
+			_, err := target.JobRuns.Indexes().CreateOne(ctx, jobRunHistoryIndex)
+			return err
+		},
+		Down: func(ctx context.Context, target Target) error {
+			if err := dropIndexes(ctx, target.Loans, *loanDueIndex.Options.Name); err != nil {
+				return err
+			}
+			return dropIndexes(ctx, target.JobRuns, *jobRunHistoryIndex.Options.Name)
+		},
+	},
+	{
+		Version:     10,
+		Description: "sessions by user and expiry of sessions and revoked tokens",
+		Up: func(ctx context.Context, target Target) error {
+			if _, err := target.Sessions.Indexes().CreateMany(ctx, sessionIndexes); err != nil {
+				return err
+			}
+			_, err := target.RevokedTokens.Indexes().CreateOne(ctx, expiryIndex)
+			return err
+		},
+		Down: func(ctx context.Context, target Target) error {
+			if err := dropIndexes(ctx, target.Sessions, indexNames(sessionIndexes)...); err != nil {
+				return err
+			}
+			return dropIndexes(ctx, target.RevokedTokens, *expiryIndex.Options.Name)
+		},
+	},
+	{
+		Version:     11,
+		Description: "users hold a list of roles instead of a single role",
+		Up: func(ctx context.Context, target Target) error {
+			_, err := target.Users.UpdateMany(ctx,
+				bson.M{dbconfig.Role: bson.M{dbconfig.ExistsOperator: true}},
+				mongo.Pipeline{
+					{{Key: dbconfig.SetOperator, Value: bson.M{dbconfig.Roles: bson.A{"$" + dbconfig.Role}}}},
+					{{Key: dbconfig.UnsetOperator, Value: dbconfig.Role}},
+				})
+			return err
+		},
+		// Down keeps the first role of users with several.
+		Down: func(ctx context.Context, target Target) error {
+			_, err := target.Users.UpdateMany(ctx,
+				bson.M{dbconfig.Roles: bson.M{dbconfig.ExistsOperator: true}},
+				mongo.Pipeline{
+					{{Key: dbconfig.SetOperator, Value: bson.M{
+						dbconfig.Role: bson.M{dbconfig.ArrayElemAtOperator: bson.A{"$" + dbconfig.Roles, 0}},
+					}}},
+					{{Key: dbconfig.UnsetOperator, Value: dbconfig.Roles}},
+				})
+			return err
+		},
+	},
+	{
+		Version:     12,
+		Description: "index for the holds of a user",
+		Up: func(ctx context.Context, target Target) error {
+			_, err := target.Holds.Indexes().CreateOne(ctx, userHoldsIndex)
+			return err
+		},
+		Down: func(ctx context.Context, target Target) error {
+			return dropIndexes(ctx, target.Holds, *userHoldsIndex.Options.Name)
+		},
+	},
+	{
+		Version:     13,
+		Description: "index for the payments of a user",
+		Up: func(ctx context.Context, target Target) error {
+			_, err := target.Payments.Indexes().CreateOne(ctx, userPaymentsIndex)
+			return err
+		},
+		Down: func(ctx context.Context, target Target) error {
+			return dropIndexes(ctx, target.Payments, *userPaymentsIndex.Options.Name)
+		},
+	},
+}
+
+// Error codes of MongoDB that dropIndexes treats as already done.
+const (
+	namespaceNotFound = 26
+	indexNotFound     = 27
+)
+
+// dropIndexes drops the named indexes of collection, skipping those that are
+// already gone, so that a Down interrupted before its record was removed can
+// be run again.
+func dropIndexes(ctx context.Context, collection *mongo.Collection, names ...string) error {
+	for _, name := range names {
+		_, err := collection.Indexes().DropOne(ctx, name)
+		var commandErr mongo.CommandError
+		if errors.As(err, &commandErr) && (commandErr.Code == namespaceNotFound || commandErr.Code == indexNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func indexNames(indexes []mongo.IndexModel) []string {
+	names := make([]string, len(indexes))
+	for i, index := range indexes {
+		names[i] = *index.Options.Name
+	}
+	return names
+}
+
+var bookListingIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: dbconfig.Author, Value: 1}, {Key: dbconfig.ID, Value: 1}}, Options: options.Index().SetName("author_1__id_1")},
+	{Keys: bson.D{{Key: dbconfig.Title, Value: 1}, {Key: dbconfig.ID, Value: 1}}, Options: options.Index().SetName("title_1__id_1")},
+	{Keys: bson.D{{Key: dbconfig.Amount, Value: 1}, {Key: dbconfig.ID, Value: 1}}, Options: options.Index().SetName("amount_1__id_1")},
+}
+
+var loanHistoryIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: dbconfig.BookID, Value: 1}, {Key: dbconfig.BorrowedAt, Value: -1}}, Options: options.Index().SetName("book_id_1_borrowed_at_-1")},
+	{Keys: bson.D{{Key: dbconfig.Username, Value: 1}, {Key: dbconfig.BorrowedAt, Value: -1}}, Options: options.Index().SetName("username_1_borrowed_at_-1")},
+}
+
+var holdQueueIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: dbconfig.BookID, Value: 1}, {Key: dbconfig.Status, Value: 1}, {Key: dbconfig.PlacedAt, Value: 1}}, Options: options.Index().SetName("book_id_1_status_1_placed_at_1")},
+	{Keys: bson.D{{Key: dbconfig.Status, Value: 1}, {Key: dbconfig.ExpiresAt, Value: 1}}, Options: options.Index().SetName("status_1_expires_at_1")},
+}
+
+var userHoldsIndex = mongo.IndexModel{
+	Keys:    bson.D{{Key: dbconfig.Username, Value: 1}, {Key: dbconfig.Status, Value: 1}},
+	Options: options.Index().SetName("username_1_status_1"),
+}
+
+var userPaymentsIndex = mongo.IndexModel{
+	Keys:    bson.D{{Key: dbconfig.Username, Value: 1}, {Key: dbconfig.At, Value: 1}},
+	Options: options.Index().SetName("username_1_at_1"),
+}
+
+var loanDueIndex = mongo.IndexModel{
+	Keys:    bson.D{{Key: dbconfig.ReturnedAt, Value: 1}, {Key: dbconfig.DueAt, Value: 1}},
+	Options: options.Index().SetName("returned_at_1_due_at_1"),
+}
+
+var jobRunHistoryIndex = mongo.IndexModel{
+	Keys:    bson.D{{Key: dbconfig.Job, Value: 1}, {Key: dbconfig.StartedAt, Value: -1}},
+	Options: options.Index().SetName("job_1_started_at_-1"),
+}
+
+// expiryIndex has MongoDB delete documents once their expires_at has passed.
+var expiryIndex = mongo.IndexModel{
+	Keys:    bson.D{{Key: dbconfig.ExpiresAt, Value: 1}},
+	Options: options.Index().SetName("expires_at_1").SetExpireAfterSeconds(0),
+}
+
+var sessionIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: dbconfig.Username, Value: 1}, {Key: dbconfig.CreatedAt, Value: 1}}, Options: options.Index().SetName("username_1_created_at_1")},
+	expiryIndex,
+}
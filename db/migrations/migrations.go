@@ -0,0 +1,192 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"library_management_system/config/dbconfig"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Target is the database a Migrator works on, with the collections resolved
+// from configuration.
+type Target struct {
+	Database      *mongo.Database
+	Books         *mongo.Collection
+	Users         *mongo.Collection
+	Loans         *mongo.Collection
+	Holds         *mongo.Collection
+	Payments      *mongo.Collection
+	JobRuns       *mongo.Collection
+	Sessions      *mongo.Collection
+	RevokedTokens *mongo.Collection
+}
+
+// Migration is one versioned change to the Mongo schema or data. Down may be
+// nil for changes that cannot be reverted, such as data backfills.
+//
+// Up and Down must be idempotent. A migration and the record of it are
+// separate writes, not one transaction, since MongoDB cannot build indexes
+// on existing collections inside a transaction; a migration interrupted
+// before it was recorded runs again in full the next time.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, target Target) error
+	Down        func(ctx context.Context, target Target) error
+}
+
+// Status describes whether a migration has been applied.
+type Status struct {
+	Version     int
+	Description string
+	Applied     bool
+	AppliedAt   time.Time
+}
+
+type record struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// Migrator applies migrations in version order and records each applied
+// version in the migrations collection.
+type Migrator struct {
+	target     Target
+	migrations []Migration
+}
+
+// NewMigrator returns a Migrator for target. If migrations is nil the
+// registered list in All is used.
+func NewMigrator(target Target, migrations []Migration) *Migrator {
+	if migrations == nil {
+		migrations = All
+	}
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	return &Migrator{target: target, migrations: sorted}
+}
+
+// Up applies every pending migration in order, recording each once it has
+// run, and returns the versions it ran. It stops at the first failure, which
+// leaves that migration pending, partly applied, for the next Up.
+func (m *Migrator) Up(ctx context.Context) ([]int, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []int
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		if err := migration.Up(ctx, m.target); err != nil {
+			return ran, fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Description, err)
+		}
+		_, err := m.collection().InsertOne(ctx, record{
+			Version:     migration.Version,
+			Description: migration.Description,
+			AppliedAt:   time.Now().UTC(),
+		})
+		if err != nil {
+			return ran, err
+		}
+		ran = append(ran, migration.Version)
+	}
+	return ran, nil
+}
+
+// Down reverts the most recently applied migration and returns its version.
+// It returns 0 when nothing has been applied.
+func (m *Migrator) Down(ctx context.Context) (int, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if migration.Down == nil {
+			return 0, fmt.Errorf("migration %d (%s) cannot be reverted", migration.Version, migration.Description)
+		}
+		if err := migration.Down(ctx, m.target); err != nil {
+			return 0, fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Description, err)
+		}
+		_, err := m.collection().DeleteOne(ctx, bson.M{dbconfig.ID: migration.Version})
+		if err != nil {
+			return 0, err
+		}
+		return migration.Version, nil
+	}
+	return 0, nil
+}
+
+// Status lists every known migration and whether it has been applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		record, ok := applied[migration.Version]
+		statuses = append(statuses, Status{
+			Version:     migration.Version,
+			Description: migration.Description,
+			Applied:     ok,
+			AppliedAt:   record.AppliedAt,
+		})
+	}
+	return statuses, nil
+}
+
+// EnsureCurrent returns an error naming the pending migrations if the database
+// is behind this build.
+func (m *Migrator) EnsureCurrent(ctx context.Context) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	var pending []int
+	for _, status := range statuses {
+		if !status.Applied {
+			pending = append(pending, status.Version)
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema is behind, pending migrations %v; run \"migrate up\"", pending)
+	}
+	return nil
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int]record, error) {
+	cursor, err := m.collection().Find(ctx, bson.D{{}}, options.Find().SetSort(bson.M{dbconfig.ID: 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	applied := make(map[int]record)
+	for cursor.Next(ctx) {
+		var r record
+		if err := cursor.Decode(&r); err != nil {
+			return nil, err
+		}
+		applied[r.Version] = r
+	}
+	return applied, cursor.Err()
+}
+
+func (m *Migrator) collection() *mongo.Collection {
+	return m.target.Database.Collection(dbconfig.MigrationsCollection)
+}
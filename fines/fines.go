@@ -0,0 +1,96 @@
+// Package fines computes what patrons owe for late returns. Amounts are whole
+// minor currency units (for example cents) so that sums stay exact.
+package fines
+
+import (
+	"library_management_system/models"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+// Policy is the fine schedule applied to overdue loans.
+type Policy struct {
+	// DailyRate is charged for every day a copy is kept past its due date,
+	// once the grace days are used up.
+	DailyRate int
+	// GraceDays are overdue days that are never charged.
+	GraceDays int
+	// MaxPerItem caps the fine for a single loan; zero means no cap.
+	MaxPerItem int
+	// Calendar, if set, tells which days the library opens. Overdue days
+	// starting on a day it is closed are not charged.
+	Calendar Calendar
+}
+
+// Calendar is the part of calendar.Calendar a Policy uses.
+type Calendar interface {
+	Open(t time.Time) bool
+}
+
+// OverdueDays counts the started days between dueAt and at.
+func OverdueDays(dueAt, at time.Time) int {
+	if !at.After(dueAt) {
+		return 0
+	}
+	overdue := at.Sub(dueAt)
+	days := int(overdue / day)
+	if overdue%day != 0 {
+		days++
+	}
+	return days
+}
+
+// ChargeableDays counts the overdue days between dueAt and at that start on a
+// day the library opens.
+func (p Policy) ChargeableDays(dueAt, at time.Time) int {
+	overdue := OverdueDays(dueAt, at)
+	if p.Calendar == nil {
+		return overdue
+	}
+	days := 0
+	for i := 0; i < overdue; i++ {
+		if p.Calendar.Open(dueAt.Add(time.Duration(i) * day)) {
+			days++
+		}
+	}
+	return days
+}
+
+// Fine is the amount owed for a copy due at dueAt and returned, or still held,
+// at at.
+func (p Policy) Fine(dueAt, at time.Time) int {
+	charged := p.ChargeableDays(dueAt, at) - p.GraceDays
+	if charged <= 0 {
+		return 0
+	}
+	fine := charged * p.DailyRate
+	if p.MaxPerItem > 0 && fine > p.MaxPerItem {
+		fine = p.MaxPerItem
+	}
+	return fine
+}
+
+// Balance totals what username owes at at: the fines fixed when loans were
+// closed, with any replacement fees for lost or damaged copies, plus the
+// fines still accruing on loans that are open and overdue, less what was
+// paid or waived.
+func (p Policy) Balance(username string, loans []models.Loan, payments []models.Payment, at time.Time) models.Balance {
+	balance := models.Balance{Username: username}
+	for _, loan := range loans {
+		if loan.ReturnedAt != nil {
+			balance.Charged += loan.Fine + loan.ReplacementFee
+		} else {
+			balance.Accruing += p.Fine(loan.DueAt, at)
+		}
+	}
+	for _, payment := range payments {
+		if payment.Kind == models.PaymentWaived {
+			balance.Waived += payment.Amount
+		} else {
+			balance.Paid += payment.Amount
+		}
+	}
+	balance.Total = balance.Charged + balance.Accruing - balance.Paid - balance.Waived
+	return balance
+}
@@ -0,0 +1,75 @@
+package fines
+
+import (
+	"library_management_system/calendar"
+	"library_management_system/models"
+	"testing"
+	"time"
+)
+
+var due = time.Date(2024, time.March, 4, 17, 0, 0, 0, time.UTC)
+
+func TestFine(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		at     time.Time
+		want   int
+	}{
+		{"not yet due", Policy{DailyRate: 10}, due.Add(-time.Hour), 0},
+		{"a started day counts", Policy{DailyRate: 10}, due.Add(time.Minute), 10},
+		{"every day charged", Policy{DailyRate: 10}, due.Add(3 * day), 30},
+		{"within the grace days", Policy{DailyRate: 10, GraceDays: 2}, due.Add(2 * day), 0},
+		{"past the grace days", Policy{DailyRate: 10, GraceDays: 2}, due.Add(5 * day), 30},
+		{"under the cap", Policy{DailyRate: 10, MaxPerItem: 50}, due.Add(4 * day), 40},
+		{"capped", Policy{DailyRate: 10, MaxPerItem: 50}, due.Add(30 * day), 50},
+		{"grace days before the cap", Policy{DailyRate: 10, GraceDays: 3, MaxPerItem: 50}, due.Add(7 * day), 40},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.policy.Fine(due, test.at); got != test.want {
+				t.Errorf("Fine = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFineSkipsClosedDays(t *testing.T) {
+	c := calendar.Default()
+	c.OpeningHours = c.OpeningHours[:5]
+	c.Holidays = []models.Holiday{{Date: "2024-03-06"}}
+	open, err := calendar.New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := Policy{DailyRate: 10, GraceDays: 1, Calendar: open}
+	// Due on a Monday and brought back on the Tuesday after: of the eight
+	// overdue days, the Wednesday holiday and the weekend are not charged,
+	// and one of the other five is a grace day.
+	if got := policy.Fine(due, due.Add(8*day)); got != 40 {
+		t.Errorf("Fine = %d, want 40", got)
+	}
+	if days := policy.ChargeableDays(due, due.Add(8*day)); days != 5 {
+		t.Errorf("ChargeableDays = %d, want 5", days)
+	}
+}
+
+func TestBalance(t *testing.T) {
+	policy := Policy{DailyRate: 10, MaxPerItem: 100}
+	returned := due.Add(2 * day)
+	loans := []models.Loan{
+		{DueAt: due, ReturnedAt: &returned, Fine: 20},
+		{DueAt: due, ReturnedAt: &returned, ReplacementFee: 500},
+		{DueAt: due},
+	}
+	payments := []models.Payment{
+		{Kind: models.PaymentPaid, Amount: 300},
+		{Kind: models.PaymentWaived, Amount: 120},
+		{Kind: models.PaymentPaid, Amount: 20},
+	}
+	got := policy.Balance("bob", loans, payments, due.Add(5*day))
+	want := models.Balance{Username: "bob", Charged: 520, Accruing: 50, Paid: 320, Waived: 120, Total: 130}
+	if got != want {
+		t.Errorf("Balance = %+v, want %+v", got, want)
+	}
+}
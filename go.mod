@@ -5,8 +5,11 @@ go 1.22.2
 require (
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/gorilla/mux v1.8.1
+	github.com/mattn/go-sqlite3 v1.14.33
+	go.etcd.io/bbolt v1.3.10
 	go.mongodb.org/mongo-driver v1.16.0
 	golang.org/x/crypto v0.25.0
+	golang.org/x/text v0.16.0
 )
 
 require (
@@ -18,5 +21,5 @@ require (
 	github.com/xdg-go/stringprep v1.0.4 // indirect
 	github.com/youmark/pkcs8 v0.0.0-20181117223130-1be2e3e5546d // indirect
 	golang.org/x/sync v0.7.0 // indirect
-	golang.org/x/text v0.16.0 // indirect
+	golang.org/x/sys v0.22.0 // indirect
 )
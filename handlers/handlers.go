@@ -2,13 +2,19 @@ package handlers
 
 import (
 	"encoding/json"
+	"io"
 	"library_management_system/apperrors"
+	"library_management_system/authz"
 	"library_management_system/config/jsonconfig"
 	"library_management_system/models"
+	"library_management_system/scheduler"
 	"library_management_system/services/bookservice"
+	"library_management_system/services/calendarservice"
+	"library_management_system/services/keyservice"
+	"library_management_system/services/sessionservice"
 	"library_management_system/services/userservice"
 	"net/http"
-	"os"
+	"strconv"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -16,23 +22,64 @@ import (
 )
 
 const IDPathVariable = "id"
-const UserRole = "user"
 const UsernamePathVariable = "username"
+const BarcodePathVariable = "barcode"
+
+const (
+	AuthorQueryParam      = "author"
+	TitlePrefixQueryParam = "title_prefix"
+	AvailableQueryParam   = "available"
+	SortQueryParam        = "sort"
+	OrderQueryParam       = "order"
+	LimitQueryParam       = "limit"
+	PageTokenQueryParam   = "page_token"
+	SearchTextQueryParam  = "q"
+	BarcodeQueryParam     = "barcode"
+	StatusQueryParam      = "status"
+	JobQueryParam         = "job"
+)
+
+// Handler serves the HTTP API on top of the book, user, calendar, session
+// and key services and the job scheduler, letting users do what the roles
+// policy permits.
+type Handler struct {
+	books     *bookservice.Service
+	users     *userservice.Service
+	calendars *calendarservice.Service
+	sessions  *sessionservice.Service
+	keys      *keyservice.Service
+	jobs      *scheduler.Scheduler
+	policy    *authz.Policy
+}
+
+// NewHandler returns a Handler that delegates to the given services and
+// scheduler and checks permissions against policy.
+func NewHandler(books *bookservice.Service, users *userservice.Service, calendars *calendarservice.Service,
+	sessions *sessionservice.Service, keys *keyservice.Service, jobs *scheduler.Scheduler, policy *authz.Policy) *Handler {
+	return &Handler{
+		books:     books,
+		users:     users,
+		calendars: calendars,
+		sessions:  sessions,
+		keys:      keys,
+		jobs:      jobs,
+		policy:    policy,
+	}
+}
 
-var jwtKey = []byte(os.Getenv("JWT_KEY")) // Store this securely
 type Credentials struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
 
-func RegisterUser(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) RegisterUser(w http.ResponseWriter, r *http.Request) {
 	var creds Credentials
 	err := json.NewDecoder(r.Body).Decode(&creds)
 	if err != nil {
 		panic(&apperrors.CredentialsDecodingError{})
 	}
 
-	user, err := userservice.RegisterUser(creds.Username, creds.Password, UserRole, r.Context())
+	user, err := h.users.RegisterUser(creds.Username, creds.Password, []string{authz.UserRole}, r.Context())
 	if err != nil {
 		panic(err)
 	}
@@ -41,38 +88,95 @@ func RegisterUser(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(user)
 }
 
-func GenerateJWT(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) GenerateJWT(w http.ResponseWriter, r *http.Request) {
 	var creds Credentials
 	err := json.NewDecoder(r.Body).Decode(&creds)
 	if err != nil {
 		panic(&apperrors.CredentialsDecodingError{})
 	}
 
-	user, err := userservice.AuthenticateUser(creds.Username, creds.Password, r.Context())
+	user, err := h.users.AuthenticateUser(creds.Username, creds.Password, r.Context())
+	if _, disabled := err.(*apperrors.UserDisabledError); disabled {
+		panic(err)
+	}
 	if err != nil {
 		panic(&apperrors.UnauthenticatedUserError{})
 	}
-	// Create JWT token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		jsonconfig.UsernameClaimKey:   user.Username,
-		jsonconfig.RoleClaimKey:       user.Role,
-		jsonconfig.ExpirationClaimKey: time.Now().Add(time.Hour * 1).Unix(),
-	})
 
-	tokenString, err := token.SignedString(jwtKey)
+	grant, err := h.sessions.StartSession(user.Username, r.Context())
+	if err != nil {
+		panic(err)
+	}
+	h.writeTokens(w, r, grant)
+}
+
+// RefreshRequest is the body of a token refresh.
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (h *Handler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var request RefreshRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		panic(&apperrors.InvalidRefreshTokenError{})
+	}
+
+	grant, err := h.sessions.Refresh(request.RefreshToken, r.Context())
+	if err != nil {
+		panic(err)
+	}
+	h.writeTokens(w, r, grant)
+}
+
+// TokenResponse is the body of a successful login or refresh. ExpiresIn is
+// the lifetime of the access token in seconds; the refresh token replaces
+// any earlier one of the same session.
+type TokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// writeTokens signs the access token of grant and sends it with the refresh
+// token.
+func (h *Handler) writeTokens(w http.ResponseWriter, r *http.Request, grant *sessionservice.Grant) {
+	now := time.Now()
+	tokenString, err := h.keys.Sign(jwt.MapClaims{
+		jsonconfig.UsernameClaimKey:   grant.User.Username,
+		jsonconfig.RolesClaimKey:      grant.User.Roles,
+		jsonconfig.IssuedAtClaimKey:   now.Unix(),
+		jsonconfig.ExpirationClaimKey: grant.AccessTokenExpiresAt.Unix(),
+		jsonconfig.TokenIDClaimKey:    grant.AccessTokenID,
+		jsonconfig.SessionIDClaimKey:  grant.Session.ID,
+	}, r.Context())
 	if err != nil {
 		panic(err)
 	}
 
 	w.Header().Set(jsonconfig.ContentType, jsonconfig.ApplicationJson)
-	json.NewEncoder(w).Encode(map[string]string{jsonconfig.TokenKey: tokenString})
+	json.NewEncoder(w).Encode(TokenResponse{
+		Token:        tokenString,
+		RefreshToken: grant.RefreshToken,
+		ExpiresIn:    int64(grant.AccessTokenExpiresAt.Sub(now).Round(time.Second).Seconds()),
+	})
+}
+
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.Context().Value(jsonconfig.SessionIDContextKey).(string)
+
+	err := h.sessions.EndSession(sessionID, r.Context())
+	if err != nil {
+		panic(err)
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func GetBookByID(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) GetBookByID(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars[IDPathVariable]
 
-	book, err := bookservice.GetBookByID(id, r.Context())
+	book, err := h.books.GetBookByID(id, r.Context())
 	if err != nil {
 		panic(&apperrors.BookNotFoundError{BookID: id})
 	}
@@ -80,21 +184,70 @@ func GetBookByID(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(book)
 }
 
-func GetBooks(w http.ResponseWriter, r *http.Request) {
-	books, err := bookservice.GetAllBooks(r.Context())
+func (h *Handler) GetBooks(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := models.BookQuery{
+		Author:      params.Get(AuthorQueryParam),
+		TitlePrefix: params.Get(TitlePrefixQueryParam),
+		SortBy:      params.Get(SortQueryParam),
+		Order:       params.Get(OrderQueryParam),
+		PageToken:   params.Get(PageTokenQueryParam),
+	}
+
+	var errorMessages []string
+	if limit := params.Get(LimitQueryParam); limit != "" {
+		var err error
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			errorMessages = append(errorMessages, "limit is not a number")
+		}
+	}
+	if available := params.Get(AvailableQueryParam); available != "" {
+		value, err := strconv.ParseBool(available)
+		if err != nil {
+			errorMessages = append(errorMessages, "available is not true or false")
+		}
+		query.Available = &value
+	}
+	if len(errorMessages) > 0 {
+		panic(&apperrors.InvalidQueryError{ErrorMessages: errorMessages})
+	}
+
+	page, err := h.books.ListBooks(query, r.Context())
+	if err != nil {
+		panic(err)
+	}
+
+	json.NewEncoder(w).Encode(page)
+}
+
+func (h *Handler) SearchBooks(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := models.BookQuery{PageToken: params.Get(PageTokenQueryParam)}
+	if limit := params.Get(LimitQueryParam); limit != "" {
+		var err error
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			panic(&apperrors.InvalidQueryError{ErrorMessages: []string{"limit is not a number"}})
+		}
+	}
+
+	page, err := h.books.SearchBooks(params.Get(SearchTextQueryParam), query, r.Context())
 	if err != nil {
 		panic(err)
 	}
 
-	json.NewEncoder(w).Encode(books)
+	json.NewEncoder(w).Encode(page)
 }
 
-func BorrowBook(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) BorrowBook(w http.ResponseWriter, r *http.Request) {
 	username := r.Context().Value(jsonconfig.UsernameContextKey).(string)
 	vars := mux.Vars(r)
 	id := vars[IDPathVariable]
 
-	success, err := bookservice.BorrowBook(id, username, r.Context())
+	barcode := r.URL.Query().Get(BarcodeQueryParam)
+
+	success, err := h.books.BorrowBook(id, username, barcode, r.Context())
 
 	if err != nil {
 		panic(err)
@@ -102,12 +255,12 @@ func BorrowBook(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(success)
 }
 
-func ReleaseBook(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) ReleaseBook(w http.ResponseWriter, r *http.Request) {
 	username := r.Context().Value(jsonconfig.UsernameContextKey).(string)
 	vars := mux.Vars(r)
 	id := vars[IDPathVariable]
 
-	success, err := bookservice.ReleaseBook(id, username, r.Context())
+	success, err := h.books.ReleaseBook(id, username, r.Context())
 
 	if err != nil {
 		panic(err)
@@ -115,14 +268,64 @@ func ReleaseBook(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(success)
 }
 
-func AddBook(w http.ResponseWriter, r *http.Request) {
-	var book models.Book
-	err := json.NewDecoder(r.Body).Decode(&book)
+func (h *Handler) RenewBook(w http.ResponseWriter, r *http.Request) {
+	username := r.Context().Value(jsonconfig.UsernameContextKey).(string)
+	vars := mux.Vars(r)
+	id := vars[IDPathVariable]
+
+	loan, err := h.books.RenewBook(id, username, r.Context())
+
+	if err != nil {
+		panic(err)
+	}
+	json.NewEncoder(w).Encode(loan)
+}
+
+func (h *Handler) PlaceHold(w http.ResponseWriter, r *http.Request) {
+	username := r.Context().Value(jsonconfig.UsernameContextKey).(string)
+	vars := mux.Vars(r)
+	id := vars[IDPathVariable]
+
+	hold, err := h.books.PlaceHold(id, username, r.Context())
+
+	if err != nil {
+		panic(err)
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(hold)
+}
+
+func (h *Handler) GetHold(w http.ResponseWriter, r *http.Request) {
+	username := r.Context().Value(jsonconfig.UsernameContextKey).(string)
+	vars := mux.Vars(r)
+	id := vars[IDPathVariable]
+
+	hold, err := h.books.GetHold(id, username, r.Context())
+
+	if err != nil {
+		panic(err)
+	}
+	json.NewEncoder(w).Encode(hold)
+}
+
+func (h *Handler) CancelHold(w http.ResponseWriter, r *http.Request) {
+	username := r.Context().Value(jsonconfig.UsernameContextKey).(string)
+	vars := mux.Vars(r)
+	id := vars[IDPathVariable]
+
+	success, err := h.books.CancelHold(id, username, r.Context())
+
 	if err != nil {
 		panic(err)
 	}
+	json.NewEncoder(w).Encode(success)
+}
+
+func (h *Handler) AddBook(w http.ResponseWriter, r *http.Request) {
+	var book models.Book
+	decodeBody(r, &book)
 
-	success, err := bookservice.AddBook(book, r.Context())
+	success, err := h.books.AddBook(book, r.Context())
 
 	if err != nil {
 		panic(err)
@@ -130,11 +333,11 @@ func AddBook(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(success)
 }
 
-func DeleteBook(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) DeleteBook(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars[IDPathVariable]
 
-	success, err := bookservice.DeleteBook(id, r.Context())
+	success, err := h.books.DeleteBook(id, r.Context())
 
 	if err != nil {
 		panic(err)
@@ -143,16 +346,235 @@ func DeleteBook(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(success)
 }
 
-func UpdateBook(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) UpdateBook(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars[IDPathVariable]
 	var book models.Book
-	err := json.NewDecoder(r.Body).Decode(&book)
+	decodeBody(r, &book)
+
+	success, err := h.books.UpdateBook(id, book, r.Context())
+
 	if err != nil {
 		panic(err)
 	}
+	w.WriteHeader(http.StatusNoContent)
+	json.NewEncoder(w).Encode(success)
+}
 
-	success, err := bookservice.UpdateBook(id, book, r.Context())
+func (h *Handler) GetBookLoans(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars[IDPathVariable]
+
+	loans, err := h.books.GetBookLoans(id, r.Context())
+
+	if err != nil {
+		panic(err)
+	}
+	json.NewEncoder(w).Encode(loans)
+}
+
+func (h *Handler) GetHolds(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars[IDPathVariable]
+
+	holds, err := h.books.GetHolds(id, r.Context())
+
+	if err != nil {
+		panic(err)
+	}
+	json.NewEncoder(w).Encode(holds)
+}
+
+func (h *Handler) GetBalance(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	username := vars[UsernamePathVariable]
+
+	balance, err := h.books.GetBalance(username, r.Context())
+
+	if err != nil {
+		panic(err)
+	}
+	json.NewEncoder(w).Encode(balance)
+}
+
+// PaymentRequest is the body of a payment or waiver of fines and fees.
+type PaymentRequest struct {
+	Kind   models.PaymentKind `json:"kind"`
+	Amount int                `json:"amount"`
+	Note   string             `json:"note"`
+}
+
+func (h *Handler) SettleBalance(w http.ResponseWriter, r *http.Request) {
+	staff := r.Context().Value(jsonconfig.UsernameContextKey).(string)
+	vars := mux.Vars(r)
+	username := vars[UsernamePathVariable]
+	var request PaymentRequest
+	decodeBody(r, &request)
+
+	balance, err := h.books.SettleBalance(username, request.Kind, request.Amount, request.Note, staff, r.Context())
+
+	if err != nil {
+		panic(err)
+	}
+	json.NewEncoder(w).Encode(balance)
+}
+
+func (h *Handler) GetPayments(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	username := vars[UsernamePathVariable]
+
+	payments, err := h.books.GetPayments(username, r.Context())
+
+	if err != nil {
+		panic(err)
+	}
+	json.NewEncoder(w).Encode(payments)
+}
+
+func (h *Handler) GetUserLoans(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	username := vars[UsernamePathVariable]
+
+	page, err := h.books.GetUserLoans(username, loanQuery(r), r.Context())
+
+	if err != nil {
+		panic(err)
+	}
+	json.NewEncoder(w).Encode(page)
+}
+
+func (h *Handler) GetMyLoans(w http.ResponseWriter, r *http.Request) {
+	username := r.Context().Value(jsonconfig.UsernameContextKey).(string)
+
+	page, err := h.books.GetUserLoans(username, loanQuery(r), r.Context())
+
+	if err != nil {
+		panic(err)
+	}
+	json.NewEncoder(w).Encode(page)
+}
+
+// loanQuery reads the status filter and paging parameters of a loan listing.
+func loanQuery(r *http.Request) models.LoanQuery {
+	params := r.URL.Query()
+	query := models.LoanQuery{
+		Status:    params.Get(StatusQueryParam),
+		PageToken: params.Get(PageTokenQueryParam),
+	}
+	if limit := params.Get(LimitQueryParam); limit != "" {
+		var err error
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			panic(&apperrors.InvalidQueryError{ErrorMessages: []string{"limit is not a number"}})
+		}
+	}
+	return query
+}
+
+func (h *Handler) GetMyBalance(w http.ResponseWriter, r *http.Request) {
+	username := r.Context().Value(jsonconfig.UsernameContextKey).(string)
+
+	balance, err := h.books.GetBalance(username, r.Context())
+
+	if err != nil {
+		panic(err)
+	}
+	json.NewEncoder(w).Encode(balance)
+}
+
+func (h *Handler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	username := r.Context().Value(jsonconfig.UsernameContextKey).(string)
+
+	profile, err := h.books.GetProfile(username, r.Context())
+
+	if err != nil {
+		panic(err)
+	}
+	json.NewEncoder(w).Encode(profile)
+}
+
+// ContactRequest is the body of a contact details update. Omitted fields are
+// left as they are and empty ones are removed.
+type ContactRequest struct {
+	Email *string `json:"email"`
+	Phone *string `json:"phone"`
+}
+
+func (h *Handler) UpdateContact(w http.ResponseWriter, r *http.Request) {
+	username := r.Context().Value(jsonconfig.UsernameContextKey).(string)
+	var request ContactRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		panic(&apperrors.UserValidationError{ErrorMessages: []string{"body must be a JSON object with email and phone"}})
+	}
+
+	user, err := h.users.UpdateContact(username, request.Email, request.Phone, r.Context())
+	if err != nil {
+		panic(err)
+	}
+	json.NewEncoder(w).Encode(user)
+}
+
+// PasswordRequest is the body of a password change.
+type PasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// ChangePassword changes the password of the authenticated user and ends all
+// of their sessions, signing out anyone else using the old password. The
+// caller gets the tokens of a new session.
+func (h *Handler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	username := r.Context().Value(jsonconfig.UsernameContextKey).(string)
+	var request PasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		panic(&apperrors.CredentialsDecodingError{})
+	}
+
+	grant, err := h.users.ChangePassword(username, request.CurrentPassword, request.NewPassword, r.Context())
+	if err != nil {
+		panic(err)
+	}
+	h.writeTokens(w, r, grant)
+}
+
+func (h *Handler) AddCopy(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars[IDPathVariable]
+	var copy models.Copy
+	decodeBody(r, &copy)
+
+	success, err := h.books.AddCopy(id, copy, r.Context())
+
+	if err != nil {
+		panic(err)
+	}
+	json.NewEncoder(w).Encode(success)
+}
+
+func (h *Handler) UpdateCopy(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars[IDPathVariable]
+	barcode := vars[BarcodePathVariable]
+	var copy models.Copy
+	decodeBody(r, &copy)
+
+	success, err := h.books.UpdateCopy(id, barcode, copy, r.Context())
+
+	if err != nil {
+		panic(err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	json.NewEncoder(w).Encode(success)
+}
+
+func (h *Handler) RemoveCopy(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars[IDPathVariable]
+	barcode := vars[BarcodePathVariable]
+
+	success, err := h.books.RemoveCopy(id, barcode, r.Context())
 
 	if err != nil {
 		panic(err)
@@ -161,8 +583,40 @@ func UpdateBook(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(success)
 }
 
-func GetUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := userservice.GetAllUsers(r.Context())
+// WriteOffRequest is the body of a lost or damaged declaration. A
+// replacement fee can only be charged for a copy that is on loan.
+type WriteOffRequest struct {
+	ReplacementFee int    `json:"replacement_fee"`
+	Note           string `json:"note"`
+}
+
+func (h *Handler) DeclareLost(w http.ResponseWriter, r *http.Request) {
+	h.writeOff(w, r, models.LoanLost)
+}
+
+func (h *Handler) DeclareDamaged(w http.ResponseWriter, r *http.Request) {
+	h.writeOff(w, r, models.LoanDamaged)
+}
+
+func (h *Handler) writeOff(w http.ResponseWriter, r *http.Request, outcome models.LoanOutcome) {
+	staff := r.Context().Value(jsonconfig.UsernameContextKey).(string)
+	vars := mux.Vars(r)
+	id := vars[IDPathVariable]
+	barcode := vars[BarcodePathVariable]
+	// Both fields are optional, so the body may be left out altogether.
+	var request WriteOffRequest
+	decodeOptionalBody(r, &request)
+
+	writeOff, err := h.books.WriteOffCopy(id, barcode, outcome, request.ReplacementFee, request.Note, staff, r.Context())
+
+	if err != nil {
+		panic(err)
+	}
+	json.NewEncoder(w).Encode(writeOff)
+}
+
+func (h *Handler) GetUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := h.users.GetAllUsers(r.Context())
 	if err != nil {
 		panic(err)
 	}
@@ -170,13 +624,256 @@ func GetUsers(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(users)
 }
 
-func GetUserByUsername(w http.ResponseWriter, r *http.Request) {
+// DeskRequest is the body of a desk checkout or return. The patron is named
+// by exactly one of Username and CardNumber.
+type DeskRequest struct {
+	Username   string `json:"username"`
+	CardNumber string `json:"card_number"`
+	Barcode    string `json:"barcode"`
+}
+
+func (h *Handler) CheckOutForPatron(w http.ResponseWriter, r *http.Request) {
+	staff := r.Context().Value(jsonconfig.UsernameContextKey).(string)
+	vars := mux.Vars(r)
+	id := vars[IDPathVariable]
+	var request DeskRequest
+	decodeBody(r, &request)
+
+	loan, err := h.books.CheckOutForPatron(id, request.Username, request.CardNumber, request.Barcode, staff, r.Context())
+
+	if err != nil {
+		panic(err)
+	}
+	json.NewEncoder(w).Encode(loan)
+}
+
+func (h *Handler) CheckInForPatron(w http.ResponseWriter, r *http.Request) {
+	staff := r.Context().Value(jsonconfig.UsernameContextKey).(string)
+	vars := mux.Vars(r)
+	id := vars[IDPathVariable]
+	var request DeskRequest
+	decodeBody(r, &request)
+
+	loan, err := h.books.CheckInForPatron(id, request.Username, request.CardNumber, staff, r.Context())
+
+	if err != nil {
+		panic(err)
+	}
+	json.NewEncoder(w).Encode(loan)
+}
+
+func (h *Handler) IssueCard(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	username := vars[UsernamePathVariable]
+
+	user, err := h.users.IssueCard(username, r.Context())
+	if err != nil {
+		panic(err)
+	}
+	json.NewEncoder(w).Encode(user)
+}
+
+// LoanLimitRequest is the body of a loan limit override; a null loan_limit
+// removes the override.
+type LoanLimitRequest struct {
+	LoanLimit *int `json:"loan_limit"`
+}
+
+func (h *Handler) SetLoanLimit(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	username := vars[UsernamePathVariable]
+	var request LoanLimitRequest
+	decodeBody(r, &request)
+
+	user, err := h.users.SetLoanLimit(username, request.LoanLimit, r.Context())
+	if err != nil {
+		panic(err)
+	}
+	json.NewEncoder(w).Encode(user)
+}
+
+// NewUserRequest is the body of an account created by an administrator.
+type NewUserRequest struct {
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	Roles    []string `json:"roles"`
+}
+
+func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var request NewUserRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		panic(&apperrors.CredentialsDecodingError{})
+	}
+
+	user, err := h.users.RegisterUser(request.Username, request.Password, request.Roles, r.Context())
+	if err != nil {
+		panic(err)
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
+
+// RolesRequest is the body of a role change; it replaces every role of the
+// user.
+type RolesRequest struct {
+	Roles []string `json:"roles"`
+}
+
+// SetRoles replaces the roles of a user and ends their sessions, so that the
+// new roles apply from their next login.
+func (h *Handler) SetRoles(w http.ResponseWriter, r *http.Request) {
+	actor := r.Context().Value(jsonconfig.UsernameContextKey).(string)
 	vars := mux.Vars(r)
 	username := vars[UsernamePathVariable]
-	user, err := userservice.FindUser(r.Context(), username)
+	var request RolesRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		panic(&apperrors.UserValidationError{ErrorMessages: []string{"body must be a JSON object with a roles list"}})
+	}
+
+	user, err := h.users.SetRoles(username, request.Roles, actor, r.Context())
+	if err != nil {
+		panic(err)
+	}
+	json.NewEncoder(w).Encode(user)
+}
+
+// DisableUser disables an account and ends its sessions.
+func (h *Handler) DisableUser(w http.ResponseWriter, r *http.Request) {
+	actor := r.Context().Value(jsonconfig.UsernameContextKey).(string)
+	vars := mux.Vars(r)
+	username := vars[UsernamePathVariable]
+
+	user, err := h.users.DisableUser(username, actor, r.Context())
 	if err != nil {
 		panic(err)
 	}
+	json.NewEncoder(w).Encode(user)
+}
+
+func (h *Handler) EnableUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	username := vars[UsernamePathVariable]
 
+	user, err := h.users.EnableUser(username, r.Context())
+	if err != nil {
+		panic(err)
+	}
 	json.NewEncoder(w).Encode(user)
 }
+
+// DeleteUser deletes an account and ends its sessions.
+func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	actor := r.Context().Value(jsonconfig.UsernameContextKey).(string)
+	vars := mux.Vars(r)
+	username := vars[UsernamePathVariable]
+
+	err := h.users.DeleteUser(username, actor, r.Context())
+	if err != nil {
+		panic(err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) GetUserByUsername(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	username := vars[UsernamePathVariable]
+	user, err := h.users.FindUser(r.Context(), username)
+	if err != nil {
+		panic(err)
+	}
+
+	json.NewEncoder(w).Encode(user)
+}
+
+func (h *Handler) GetCalendar(w http.ResponseWriter, r *http.Request) {
+	calendar, err := h.calendars.GetCalendar(r.Context())
+	if err != nil {
+		panic(err)
+	}
+	json.NewEncoder(w).Encode(calendar)
+}
+
+func (h *Handler) SetCalendar(w http.ResponseWriter, r *http.Request) {
+	var calendar models.Calendar
+	decodeBody(r, &calendar)
+
+	saved, err := h.calendars.SetCalendar(calendar, r.Context())
+
+	if err != nil {
+		panic(err)
+	}
+	json.NewEncoder(w).Encode(saved)
+}
+
+func (h *Handler) GetJobs(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(h.jobs.Jobs())
+}
+
+func (h *Handler) GetJobRuns(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := models.JobRunQuery{Job: params.Get(JobQueryParam)}
+	if limit := params.Get(LimitQueryParam); limit != "" {
+		var err error
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			panic(&apperrors.InvalidQueryError{ErrorMessages: []string{"limit is not a number"}})
+		}
+	}
+
+	runs, err := h.jobs.Runs(r.Context(), query)
+	if err != nil {
+		panic(err)
+	}
+	json.NewEncoder(w).Encode(runs)
+}
+
+// SessionsRevokedResponse reports how many sessions were ended.
+type SessionsRevokedResponse struct {
+	Revoked int `json:"revoked"`
+}
+
+func (h *Handler) RevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	username := vars[UsernamePathVariable]
+
+	revoked, err := h.sessions.RevokeUserSessions(username, r.Context())
+	if err != nil {
+		panic(err)
+	}
+	json.NewEncoder(w).Encode(SessionsRevokedResponse{Revoked: revoked})
+}
+
+// GetJWKS publishes the public keys that access tokens are signed with, so
+// that other services can verify them.
+func (h *Handler) GetJWKS(w http.ResponseWriter, r *http.Request) {
+	set, err := h.keys.JWKS(r.Context())
+	if err != nil {
+		panic(err)
+	}
+	w.Header().Set(jsonconfig.ContentType, jsonconfig.ApplicationJson)
+	w.Header().Set(jsonconfig.CacheControlHeader, "public, max-age="+strconv.Itoa(int(jsonconfig.JWKSMaxAge/time.Second)))
+	json.NewEncoder(w).Encode(set)
+}
+
+func (h *Handler) GetRoles(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(h.policy.Roles())
+}
+
+// decodeBody decodes the JSON body of r into v. A malformed or missing body
+// is a *apperrors.RequestBodyError, so the client gets a 400.
+func decodeBody(r *http.Request, v interface{}) {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		panic(&apperrors.RequestBodyError{Err: err})
+	}
+}
+
+// decodeOptionalBody is decodeBody for requests whose fields are all
+// optional, where an empty body leaves v as it is.
+func decodeOptionalBody(r *http.Request, v interface{}) {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && err != io.EOF {
+		panic(&apperrors.RequestBodyError{Err: err})
+	}
+}
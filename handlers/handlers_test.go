@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"library_management_system/config/appconfig"
+	"library_management_system/internal/testbackends"
+	"library_management_system/notify"
+	"library_management_system/services/bookservice"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestInvalidPageTokenIsBadRequest(t *testing.T) {
+	repos := testbackends.Open(t)["memory"]
+	books := bookservice.NewService(repos.Books, repos.Users, repos.Loans, repos.Holds, repos.Payments, repos.Calendar,
+		repos.Transactor, notify.LogNotifier{}, appconfig.Default().Circulation)
+	h := NewHandler(books, nil, nil, nil, nil, nil, nil)
+	handler := ErrorHandler(http.HandlerFunc(h.GetBooks))
+
+	for _, token := range []string{"!!!", "MjA=", "LTIw"} {
+		r := httptest.NewRequest(http.MethodGet, "/books?"+url.Values{PageTokenQueryParam: {token}}.Encode(), nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("page token %q: status %d, want %d", token, w.Code, http.StatusBadRequest)
+		}
+	}
+}
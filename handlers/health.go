@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"library_management_system/apperrors"
+	"library_management_system/config/jsonconfig"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const readinessTimeout = 2 * time.Second
+
+// HealthHandler serves the liveness and readiness probes.
+type HealthHandler struct {
+	ping     func(ctx context.Context) error
+	draining atomic.Bool
+}
+
+// NewHealthHandler returns a HealthHandler whose readiness probe calls ping.
+func NewHealthHandler(ping func(ctx context.Context) error) *HealthHandler {
+	return &HealthHandler{ping: ping}
+}
+
+// SetDraining makes the readiness probe fail so that load balancers stop
+// routing new requests while in-flight ones finish.
+func (h *HealthHandler) SetDraining() {
+	h.draining.Store(true)
+}
+
+// Liveness reports that the process is up and serving HTTP.
+func (h *HealthHandler) Liveness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(jsonconfig.ContentType, jsonconfig.ApplicationJson)
+	json.NewEncoder(w).Encode(map[string]string{jsonconfig.StatusJsonKey: "ok"})
+}
+
+// Readiness reports whether the service can take traffic: it is not shutting
+// down and the storage backend answers a ping.
+func (h *HealthHandler) Readiness(w http.ResponseWriter, r *http.Request) {
+	if h.draining.Load() {
+		panic(&apperrors.ServiceUnavailableError{Reason: "shutting down"})
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+	if err := h.ping(ctx); err != nil {
+		panic(&apperrors.ServiceUnavailableError{Reason: err.Error()})
+	}
+
+	w.Header().Set(jsonconfig.ContentType, jsonconfig.ApplicationJson)
+	json.NewEncoder(w).Encode(map[string]string{jsonconfig.StatusJsonKey: "ready"})
+}
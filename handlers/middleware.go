@@ -5,50 +5,79 @@ import (
 	"encoding/json"
 	"errors"
 	"library_management_system/apperrors"
+	"library_management_system/authz"
 	"library_management_system/config/jsonconfig"
 	"net/http"
 	"strings"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/mux"
 )
 
-func AuthMiddleware(next http.Handler) http.Handler {
+func (h *Handler) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		tokenString := r.Header.Get(jsonconfig.AuthorizationHeader)
 		tokenString = strings.TrimPrefix(tokenString, jsonconfig.Bearer)
 		if tokenString == "" {
 			panic(&apperrors.MalFormedTokenError{})
 		}
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, http.ErrNoLocation
-			}
-			return jwtKey, nil
-		})
+		claims, err := h.keys.Parse(tokenString, r.Context())
+		if err != nil {
+			panic(err)
+		}
 
-		if err != nil || !token.Valid {
+		// Tokens without an ID or session cannot be revoked, so they are
+		// not accepted either.
+		tokenID, _ := claims[jsonconfig.TokenIDClaimKey].(string)
+		sessionID, _ := claims[jsonconfig.SessionIDClaimKey].(string)
+		if tokenID == "" || sessionID == "" {
+			panic(&apperrors.InvalidTokenError{})
+		}
+		revoked, err := h.sessions.TokenRevoked(tokenID, r.Context())
+		if err != nil {
+			panic(err)
+		}
+		if revoked {
 			panic(&apperrors.InvalidTokenError{})
 		}
 
-		ctx := context.WithValue(r.Context(), jsonconfig.UsernameContextKey, token.Claims.(jwt.MapClaims)[jsonconfig.UsernameClaimKey])
-		ctx = context.WithValue(ctx, jsonconfig.RoleContextKey, token.Claims.(jwt.MapClaims)[jsonconfig.RoleClaimKey])
+		roles := []string{}
+		if claimed, ok := claims[jsonconfig.RolesClaimKey].([]interface{}); ok {
+			for _, role := range claimed {
+				if role, ok := role.(string); ok {
+					roles = append(roles, role)
+				}
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), jsonconfig.UsernameContextKey, claims[jsonconfig.UsernameClaimKey])
+		ctx = context.WithValue(ctx, jsonconfig.RolesContextKey, roles)
+		ctx = context.WithValue(ctx, jsonconfig.TokenIDContextKey, tokenID)
+		ctx = context.WithValue(ctx, jsonconfig.SessionIDContextKey, sessionID)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-func RoleMiddleware(requiredRole string) mux.MiddlewareFunc {
+// PermissionMiddleware only lets through users one of whose roles grants
+// permission. It must run after AuthMiddleware, which puts the roles of the
+// token into the request context.
+func (h *Handler) PermissionMiddleware(permission authz.Permission) mux.MiddlewareFunc {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			role, ok := r.Context().Value(jsonconfig.RoleContextKey).(string)
-			if !ok || role != requiredRole {
-				panic(&apperrors.RoleNotMatchingError{Role: role, RequiredRole: requiredRole})
+			roles, _ := r.Context().Value(jsonconfig.RolesContextKey).([]string)
+			if !h.policy.Allows(roles, permission) {
+				panic(&apperrors.PermissionDeniedError{Permission: string(permission)})
 			}
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
+// Require wraps next in a PermissionMiddleware, for routes that need a
+// permission of their own.
+func (h *Handler) Require(permission authz.Permission, next http.HandlerFunc) http.Handler {
+	return h.PermissionMiddleware(permission)(next)
+}
+
 func ErrorHandler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
@@ -78,15 +107,49 @@ func handleError(err error, w http.ResponseWriter) {
 		*apperrors.BookValidationError,
 		*apperrors.DeleteBorrowedBookError,
 		*apperrors.BookWithSameIDError,
-		*apperrors.CredentialsDecodingError:
+		*apperrors.UserNotFoundError,
+		*apperrors.InvalidQueryError,
+		*apperrors.CopyNotFoundError,
+		*apperrors.CopyNotAvailableError,
+		*apperrors.CopyOnLoanError,
+		*apperrors.BarcodeAlreadyExistsError,
+		*apperrors.LoanNotFoundError,
+		*apperrors.BalanceExceededError,
+		*apperrors.RenewalLimitReachedError,
+		*apperrors.LoanOverdueError,
+		*apperrors.CopyOnHoldError,
+		*apperrors.HoldNotNeededError,
+		*apperrors.HoldAlreadyPlacedError,
+		*apperrors.HoldNotFoundError,
+		*apperrors.HoldsPendingError,
+		*apperrors.LoanLimitReachedError,
+		*apperrors.UserValidationError,
+		*apperrors.CardNotFoundError,
+		*apperrors.CardNumberAlreadyExistsError,
+		*apperrors.CopyWithdrawnError,
+		*apperrors.ReplacementFeeError,
+		*apperrors.CalendarValidationError,
+		*apperrors.UserHasBooksError,
+		*apperrors.UserHasHoldsError,
+		*apperrors.CredentialsDecodingError,
+		*apperrors.RequestBodyError,
+		*apperrors.PaymentValidationError:
 		w.WriteHeader(http.StatusBadRequest)
 	case *apperrors.UnauthorizedUserError,
 		*apperrors.UnauthenticatedUserError,
 		*apperrors.MalFormedTokenError,
-		*apperrors.InvalidTokenError:
+		*apperrors.InvalidTokenError,
+		*apperrors.InvalidRefreshTokenError,
+		*apperrors.UserDisabledError,
+		*apperrors.SessionNotFoundError:
 		w.WriteHeader(http.StatusUnauthorized)
-	case *apperrors.RoleNotMatchingError:
+	// A wrong current password is not a 401, which clients take to mean
+	// that their token needs refreshing.
+	case *apperrors.PermissionDeniedError,
+		*apperrors.IncorrectPasswordError:
 		w.WriteHeader(http.StatusForbidden)
+	case *apperrors.ServiceUnavailableError:
+		w.WriteHeader(http.StatusServiceUnavailable)
 	default:
 		w.WriteHeader(http.StatusInternalServerError)
 	}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"library_management_system/authz"
+	"library_management_system/config/jsonconfig"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestPermissionChecks(t *testing.T) {
+	policy, err := authz.NewPolicy(map[string][]string{"volunteer": {string(authz.LoansCheckoutForOthers)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(nil, nil, nil, nil, nil, nil, policy)
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+
+	// The same check mounted both ways main.go mounts it.
+	router := mux.NewRouter()
+	router.Handle("/books", h.Require(authz.BooksWrite, ok))
+	desk := router.PathPrefix("/desk").Subrouter()
+	desk.Use(h.PermissionMiddleware(authz.LoansCheckoutForOthers))
+	desk.HandleFunc("/checkout", ok)
+	handler := ErrorHandler(router)
+
+	tests := []struct {
+		path  string
+		roles []string
+		want  int
+	}{
+		{"/books", []string{authz.LibrarianRole}, http.StatusNoContent},
+		{"/books", []string{authz.UserRole}, http.StatusForbidden},
+		{"/books", []string{"volunteer"}, http.StatusForbidden},
+		{"/books", []string{"unknown"}, http.StatusForbidden},
+		{"/books", nil, http.StatusForbidden},
+		{"/desk/checkout", []string{"volunteer"}, http.StatusNoContent},
+		{"/desk/checkout", []string{authz.UserRole, authz.AdminRole}, http.StatusNoContent},
+		{"/desk/checkout", []string{authz.UserRole}, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, test.path, nil)
+		if test.roles != nil {
+			r = r.WithContext(context.WithValue(r.Context(), jsonconfig.RolesContextKey, test.roles))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s as %q: status %d, want %d", test.path, test.roles, w.Code, test.want)
+			continue
+		}
+		if w.Code == http.StatusForbidden {
+			var body map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body[jsonconfig.ErrorJsonKey] == "" {
+				t.Errorf("%s as %q: error body %v, %v", test.path, test.roles, body, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Package testbackends opens empty stores of the storage backends for tests
+// that must behave the same on all of them.
+package testbackends
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"library_management_system/config/appconfig"
+	"library_management_system/config/dbconfig"
+	"library_management_system/db"
+	"library_management_system/db/migrations"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// MongoURIVariable names the environment variable that points the tests at
+// a MongoDB replica set. Without it the mongo backend is left out.
+const MongoURIVariable = "MONGO_URI"
+
+// Open opens an empty store of every backend that runs without a server,
+// memory, bolt and sql on SQLite, and of mongo when MONGO_URI is set. The
+// stores are closed, and the Mongo database dropped, when the test ends.
+func Open(t testing.TB) map[string]*db.Repositories {
+	t.Helper()
+	dir := t.TempDir()
+	configs := []appconfig.StorageConfig{
+		{Backend: dbconfig.MemoryBackend},
+		{Backend: dbconfig.BoltBackend, BoltPath: filepath.Join(dir, "library.db")},
+		{Backend: dbconfig.SQLBackend, SQLDriver: dbconfig.SQLDriver, SQLDSN: filepath.Join(dir, "library.sqlite")},
+	}
+	if uri := os.Getenv(MongoURIVariable); uri != "" {
+		configs = append(configs, newMongoDatabase(t, uri))
+	}
+	backends := make(map[string]*db.Repositories)
+	for _, config := range configs {
+		repos, err := db.Open(context.Background(), config)
+		if err != nil {
+			t.Fatalf("opening %s: %v", config.Backend, err)
+		}
+		t.Cleanup(func() { repos.Close(context.Background()) })
+		backends[config.Backend] = repos
+	}
+	return backends
+}
+
+// newMongoDatabase migrates a database with a random name on the server at
+// uri and returns the configuration that opens it.
+func newMongoDatabase(t testing.TB, uri string) appconfig.StorageConfig {
+	t.Helper()
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		t.Fatal(err)
+	}
+	config := appconfig.Default().Storage
+	config.MongoURI = uri
+	config.DatabaseName = "library_test_" + hex.EncodeToString(suffix)
+
+	ctx := context.Background()
+	client, err := db.ConnectMongo(ctx, config)
+	if err != nil {
+		t.Fatalf("connecting to %s: %v", MongoURIVariable, err)
+	}
+	t.Cleanup(func() {
+		client.Database(config.DatabaseName).Drop(ctx)
+		client.Disconnect(ctx)
+	})
+	if _, err := migrations.NewMigrator(db.MigrationTarget(client, config), nil).Up(ctx); err != nil {
+		t.Fatalf("migrating %s: %v", config.DatabaseName, err)
+	}
+	return config
+}
@@ -1,42 +1,266 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"library_management_system/authz"
+	"library_management_system/config/appconfig"
 	"library_management_system/db"
 	"library_management_system/handlers"
+	"library_management_system/notify"
+	"library_management_system/repository"
+	"library_management_system/scheduler"
+	"library_management_system/services/bookservice"
+	"library_management_system/services/calendarservice"
+	"library_management_system/services/keyservice"
+	"library_management_system/services/sessionservice"
+	"library_management_system/services/userservice"
+	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
 func main() {
-	db.InitDB()
+	config, args, err := appconfig.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := runMigrate(config.Storage, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(args) > 0 && args[0] == "create-user" {
+		if err := runCreateUser(config, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	repos, err := db.Open(context.Background(), config.Storage)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	policy, err := authz.NewPolicy(config.Auth.Roles)
+	if err != nil {
+		log.Fatal(err)
+	}
+	books := bookservice.NewService(repos.Books, repos.Users, repos.Loans, repos.Holds, repos.Payments, repos.Calendar,
+		repos.Transactor, notify.LogNotifier{}, config.Circulation)
+	sessions := sessionservice.NewService(repos.Sessions, repos.Users, repos.Transactor, config.Auth)
+	keys, err := keyservice.NewService(repos.Keys, config.Auth)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Make sure there is a key to sign with before the first login.
+	if _, _, err := keys.Rotate(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	jobs, err := newScheduler(repos.Jobs, books, sessions, keys, config.Scheduler)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	h := handlers.NewHandler(
+		books,
+		userservice.NewService(repos.Users, repos.Holds, sessions, repos.Transactor, policy, config.Auth),
+		calendarservice.NewService(repos.Calendar),
+		sessions,
+		keys,
+		jobs,
+		policy,
+	)
+
+	health := handlers.NewHealthHandler(repos.Ping)
 
 	router := mux.NewRouter()
 	router.Use(handlers.ErrorHandler)
 
+	// Probes
+	router.HandleFunc("/healthz", health.Liveness).Methods("GET")
+	router.HandleFunc("/readyz", health.Readiness).Methods("GET")
+
 	// Public routes
-	router.HandleFunc("/register", handlers.RegisterUser).Methods("POST")
-	router.HandleFunc("/login", handlers.GenerateJWT).Methods("POST")
+	router.HandleFunc("/register", h.RegisterUser).Methods("POST")
+	router.HandleFunc("/login", h.GenerateJWT).Methods("POST")
+	router.HandleFunc("/token/refresh", h.RefreshToken).Methods("POST")
+	router.HandleFunc("/calendar", h.GetCalendar).Methods("GET")
+	router.HandleFunc("/.well-known/jwks.json", h.GetJWKS).Methods("GET")
 
 	// Create a subrouter for all /books/* routes
 	booksRouter := router.PathPrefix("/books").Subrouter()
-	booksRouter.Use(handlers.AuthMiddleware)
-
-	booksRouter.HandleFunc("", handlers.GetBooks).Methods("GET")
-	booksRouter.HandleFunc("/{id}", handlers.GetBookByID).Methods("GET")
-	booksRouter.HandleFunc("/{id}/borrow", handlers.BorrowBook).Methods("PATCH")
-	booksRouter.HandleFunc("/{id}/release", handlers.ReleaseBook).Methods("PATCH")
-
-	adminBooksRouter := booksRouter.PathPrefix("").Subrouter()
-	adminBooksRouter.Use(handlers.RoleMiddleware("admin"))
-	adminBooksRouter.HandleFunc("", handlers.AddBook).Methods("POST")
-	adminBooksRouter.HandleFunc("/{id}", handlers.DeleteBook).Methods("DELETE")
-	adminBooksRouter.HandleFunc("/{id}", handlers.UpdateBook).Methods("PUT")
-
-	adminUsersRouter := router.PathPrefix("/users").Subrouter()
-	adminUsersRouter.Use(handlers.AuthMiddleware)
-	adminUsersRouter.Use(handlers.RoleMiddleware("admin"))
-	adminUsersRouter.HandleFunc("", handlers.GetUsers).Methods("GET")
-	adminUsersRouter.HandleFunc("/{username}", handlers.GetUserByUsername).Methods("GET")
-	http.ListenAndServe(":8000", router)
+	booksRouter.Use(h.AuthMiddleware)
+
+	// Every route checks the permission it needs; see package authz for the
+	// roles that grant them.
+	booksRouter.Handle("", h.Require(authz.BooksRead, h.GetBooks)).Methods("GET")
+	booksRouter.Handle("/search", h.Require(authz.BooksRead, h.SearchBooks)).Methods("GET")
+	booksRouter.Handle("/{id}", h.Require(authz.BooksRead, h.GetBookByID)).Methods("GET")
+	booksRouter.Handle("/{id}/borrow", h.Require(authz.LoansBorrow, h.BorrowBook)).Methods("PATCH")
+	booksRouter.Handle("/{id}/release", h.Require(authz.LoansBorrow, h.ReleaseBook)).Methods("PATCH")
+	booksRouter.Handle("/{id}/renew", h.Require(authz.LoansBorrow, h.RenewBook)).Methods("PATCH")
+	booksRouter.Handle("/{id}/hold", h.Require(authz.LoansBorrow, h.PlaceHold)).Methods("POST")
+	booksRouter.Handle("/{id}/hold", h.Require(authz.LoansBorrow, h.GetHold)).Methods("GET")
+	booksRouter.Handle("/{id}/hold", h.Require(authz.LoansBorrow, h.CancelHold)).Methods("DELETE")
+	booksRouter.Handle("", h.Require(authz.BooksWrite, h.AddBook)).Methods("POST")
+	booksRouter.Handle("/{id}", h.Require(authz.BooksWrite, h.DeleteBook)).Methods("DELETE")
+	booksRouter.Handle("/{id}", h.Require(authz.BooksWrite, h.UpdateBook)).Methods("PUT")
+	booksRouter.Handle("/{id}/loans", h.Require(authz.LoansRead, h.GetBookLoans)).Methods("GET")
+	booksRouter.Handle("/{id}/holds", h.Require(authz.HoldsRead, h.GetHolds)).Methods("GET")
+	booksRouter.Handle("/{id}/checkout", h.Require(authz.LoansCheckoutForOthers, h.CheckOutForPatron)).Methods("POST")
+	booksRouter.Handle("/{id}/checkin", h.Require(authz.LoansCheckoutForOthers, h.CheckInForPatron)).Methods("POST")
+	booksRouter.Handle("/{id}/copies", h.Require(authz.BooksWrite, h.AddCopy)).Methods("POST")
+	booksRouter.Handle("/{id}/copies/{barcode}", h.Require(authz.BooksWrite, h.UpdateCopy)).Methods("PUT")
+	booksRouter.Handle("/{id}/copies/{barcode}", h.Require(authz.BooksWrite, h.RemoveCopy)).Methods("DELETE")
+	booksRouter.Handle("/{id}/copies/{barcode}/lost", h.Require(authz.LoansWriteOff, h.DeclareLost)).Methods("POST")
+	booksRouter.Handle("/{id}/copies/{barcode}/damaged", h.Require(authz.LoansWriteOff, h.DeclareDamaged)).Methods("POST")
+
+	logoutRouter := router.PathPrefix("/logout").Subrouter()
+	logoutRouter.Use(h.AuthMiddleware)
+	logoutRouter.HandleFunc("", h.Logout).Methods("POST")
+
+	// Routes acting on the authenticated user
+	meRouter := router.PathPrefix("/me").Subrouter()
+	meRouter.Use(h.AuthMiddleware)
+	meRouter.HandleFunc("/balance", h.GetMyBalance).Methods("GET")
+	meRouter.HandleFunc("/loans", h.GetMyLoans).Methods("GET")
+	meRouter.HandleFunc("", h.GetProfile).Methods("GET")
+	meRouter.HandleFunc("", h.UpdateContact).Methods("PATCH")
+	meRouter.HandleFunc("/password", h.ChangePassword).Methods("PUT")
+
+	calendarRouter := router.PathPrefix("/calendar").Subrouter()
+	calendarRouter.Use(h.AuthMiddleware)
+	calendarRouter.Use(h.PermissionMiddleware(authz.CalendarWrite))
+	calendarRouter.HandleFunc("", h.SetCalendar).Methods("PUT")
+
+	jobsRouter := router.PathPrefix("/jobs").Subrouter()
+	jobsRouter.Use(h.AuthMiddleware)
+	jobsRouter.Use(h.PermissionMiddleware(authz.JobsRead))
+	jobsRouter.HandleFunc("", h.GetJobs).Methods("GET")
+	jobsRouter.HandleFunc("/runs", h.GetJobRuns).Methods("GET")
+
+	rolesRouter := router.PathPrefix("/roles").Subrouter()
+	rolesRouter.Use(h.AuthMiddleware)
+	rolesRouter.Use(h.PermissionMiddleware(authz.UsersRead))
+	rolesRouter.HandleFunc("", h.GetRoles).Methods("GET")
+
+	usersRouter := router.PathPrefix("/users").Subrouter()
+	usersRouter.Use(h.AuthMiddleware)
+	usersRouter.Handle("", h.Require(authz.UsersRead, h.GetUsers)).Methods("GET")
+	usersRouter.Handle("/{username}", h.Require(authz.UsersRead, h.GetUserByUsername)).Methods("GET")
+	usersRouter.Handle("/{username}/balance", h.Require(authz.LoansRead, h.GetBalance)).Methods("GET")
+	usersRouter.Handle("/{username}/loans", h.Require(authz.LoansRead, h.GetUserLoans)).Methods("GET")
+	usersRouter.Handle("/{username}/payments", h.Require(authz.LoansRead, h.GetPayments)).Methods("GET")
+	usersRouter.Handle("/{username}/payments", h.Require(authz.FinesSettle, h.SettleBalance)).Methods("POST")
+	usersRouter.Handle("/{username}/loan-limit", h.Require(authz.UsersWrite, h.SetLoanLimit)).Methods("PUT")
+	usersRouter.Handle("/{username}/card", h.Require(authz.UsersWrite, h.IssueCard)).Methods("POST")
+	usersRouter.Handle("/{username}/sessions", h.Require(authz.UsersWrite, h.RevokeUserSessions)).Methods("DELETE")
+	usersRouter.Handle("", h.Require(authz.UsersManage, h.CreateUser)).Methods("POST")
+	usersRouter.Handle("/{username}", h.Require(authz.UsersManage, h.DeleteUser)).Methods("DELETE")
+	usersRouter.Handle("/{username}/roles", h.Require(authz.UsersManage, h.SetRoles)).Methods("PUT")
+	usersRouter.Handle("/{username}/disable", h.Require(authz.UsersManage, h.DisableUser)).Methods("POST")
+	usersRouter.Handle("/{username}/enable", h.Require(authz.UsersManage, h.EnableUser)).Methods("POST")
+
+	server := &http.Server{
+		Addr:              config.Server.Addr,
+		Handler:           router,
+		ReadHeaderTimeout: time.Duration(config.Server.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(config.Server.ReadTimeout),
+		WriteTimeout:      time.Duration(config.Server.WriteTimeout),
+		IdleTimeout:       time.Duration(config.Server.IdleTimeout),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	log.Printf("listening on %s", config.Server.Addr)
+
+	jobsDone := make(chan struct{})
+	if config.Scheduler.Enabled {
+		go func() {
+			defer close(jobsDone)
+			jobs.Run(ctx)
+		}()
+	} else {
+		close(jobsDone)
+	}
+
+	<-ctx.Done()
+	stop()
+	log.Println("shutting down, draining in-flight requests")
+	health.SetDraining()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Server.ShutdownTimeout))
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP shutdown: %v", err)
+	}
+	select {
+	case <-jobsDone:
+	case <-shutdownCtx.Done():
+		log.Println("shutdown timed out waiting for background jobs")
+	}
+	if err := repos.Close(shutdownCtx); err != nil {
+		log.Printf("closing storage: %v", err)
+	}
+}
+
+// newScheduler returns a scheduler with the circulation and housekeeping
+// jobs added. The jobs
+// are added even when the scheduler is disabled so that their history can
+// still be listed.
+func newScheduler(repo repository.JobRepository, books *bookservice.Service, sessions *sessionservice.Service,
+	keys *keyservice.Service, config appconfig.SchedulerConfig) (*scheduler.Scheduler, error) {
+	jobs := scheduler.New(repo, config.InstanceID, time.Duration(config.Tick), time.Duration(config.LeaseTTL))
+	err := jobs.Add("mark-overdue", config.OverdueSchedule, func(ctx context.Context) (string, error) {
+		marked, err := books.MarkOverdueLoans(ctx)
+		return fmt.Sprintf("%d loans marked overdue", marked), err
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = jobs.Add("expire-holds", config.HoldExpirySchedule, func(ctx context.Context) (string, error) {
+		expired, err := books.ExpireHolds(ctx)
+		return fmt.Sprintf("%d holds expired", expired), err
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = jobs.Add("due-soon-reminders", config.DueSoonSchedule, func(ctx context.Context) (string, error) {
+		sent, err := books.SendDueSoonReminders(ctx)
+		return fmt.Sprintf("%d reminders sent", sent), err
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = jobs.Add("purge-sessions", config.SessionPurgeSchedule, func(ctx context.Context) (string, error) {
+		purged, err := sessions.PurgeExpired(ctx)
+		return fmt.Sprintf("%d expired records deleted", purged), err
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = jobs.Add("rotate-signing-keys", config.KeyRotationSchedule, func(ctx context.Context) (string, error) {
+		created, deleted, err := keys.Rotate(ctx)
+		if created == "" {
+			return fmt.Sprintf("no rotation due, %d keys retired", deleted), err
+		}
+		return fmt.Sprintf("key %s created, %d keys retired", created, deleted), err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"library_management_system/config/appconfig"
+	"library_management_system/config/dbconfig"
+	"library_management_system/db"
+	"library_management_system/db/migrations"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// runMigrate implements the "migrate up|down|status" command against the
+// configured Mongo database.
+func runMigrate(config appconfig.StorageConfig, args []string) error {
+	if config.Backend != dbconfig.MongoBackend {
+		return fmt.Errorf("migrations only apply to the %s backend, not %s", dbconfig.MongoBackend, config.Backend)
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("usage: migrate up|down|status")
+	}
+
+	ctx := context.Background()
+	client, err := db.ConnectMongo(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(ctx)
+	migrator := migrations.NewMigrator(db.MigrationTarget(client, config), nil)
+
+	switch args[0] {
+	case "up":
+		ran, err := migrator.Up(ctx)
+		for _, version := range ran {
+			fmt.Printf("applied migration %d\n", version)
+		}
+		if err != nil {
+			return err
+		}
+		if len(ran) == 0 {
+			fmt.Println("schema is up to date")
+		}
+	case "down":
+		version, err := migrator.Down(ctx)
+		if err != nil {
+			return err
+		}
+		if version == 0 {
+			fmt.Println("no migrations to revert")
+		} else {
+			fmt.Printf("reverted migration %d\n", version)
+		}
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tDESCRIPTION\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Description, appliedAt)
+		}
+		w.Flush()
+	default:
+		return fmt.Errorf("unknown migrate command %q; use up, down or status", args[0])
+	}
+	return nil
+}
@@ -1,17 +1,399 @@
 package models
 
+import "time"
+
 type Book struct {
-	ID      string   `json:"id" bson:"_id"`
-	Title   string   `json:"title"`
-	Author  string   `json:"author"`
+	ID     string `json:"id" bson:"_id"`
+	Title  string `json:"title"`
+	Author string `json:"author"`
+	// Amount is the number of copies currently available. It is maintained
+	// by the repositories from the copy statuses and cannot be set directly
+	// once a book exists.
 	Amount  int      `json:"amount"`
 	OwnedBy []string `json:"owned_by" bson:"owned_by"`
+	Copies  []Copy   `json:"copies" bson:"copies"`
+}
+
+type CopyStatus string
+
+const (
+	CopyAvailable CopyStatus = "available"
+	CopyOnLoan    CopyStatus = "on_loan"
+	CopyMissing   CopyStatus = "missing"
+	CopyInRepair  CopyStatus = "in_repair"
+	// CopyOnHold is a copy set aside for the patron named in HeldFor.
+	CopyOnHold CopyStatus = "on_hold"
+	// CopyWithdrawn copies were written off as lost or damaged. They stay on
+	// the book so the Withdrawal record is kept.
+	CopyWithdrawn CopyStatus = "withdrawn"
+)
+
+// Copy is one physical item of a book, identified by its barcode.
+type Copy struct {
+	Barcode    string     `json:"barcode"`
+	Status     CopyStatus `json:"status"`
+	Location   string     `json:"location"`
+	Condition  string     `json:"condition"`
+	BorrowedBy string     `json:"borrowed_by,omitempty" bson:"borrowed_by"`
+	HeldFor    string     `json:"held_for,omitempty" bson:"held_for"`
+	// Withdrawal records why and by whom the copy was last written off.
+	Withdrawal *Withdrawal `json:"withdrawal,omitempty" bson:"withdrawal,omitempty"`
+}
+
+// Withdrawal is the audit record of a copy taken out of inventory.
+type Withdrawal struct {
+	Reason LoanOutcome `json:"reason"`
+	Note   string      `json:"note,omitempty" bson:"note,omitempty"`
+	At     time.Time   `json:"at"`
+	By     string      `json:"by"`
+}
+
+// Loan records one copy of a book lent to one user. It is open until
+// ReturnedAt is set.
+type Loan struct {
+	ID         string     `json:"id" bson:"_id"`
+	BookID     string     `json:"book_id" bson:"book_id"`
+	Barcode    string     `json:"barcode"`
+	Username   string     `json:"username"`
+	BorrowedAt time.Time  `json:"borrowed_at" bson:"borrowed_at"`
+	DueAt      time.Time  `json:"due_at" bson:"due_at"`
+	ReturnedAt *time.Time `json:"returned_at,omitempty" bson:"returned_at"`
+	// Fine is what the late return cost, fixed when the loan is closed.
+	Fine int `json:"fine"`
+	// Renewals counts how many times DueAt has been pushed back.
+	Renewals int `json:"renewals"`
+	// CheckedOutBy and CheckedInBy name the staff member who lent or took
+	// back the copy at the desk. They are empty when patrons did it
+	// themselves.
+	CheckedOutBy string `json:"checked_out_by,omitempty" bson:"checked_out_by,omitempty"`
+	CheckedInBy  string `json:"checked_in_by,omitempty" bson:"checked_in_by,omitempty"`
+	// Outcome says how the loan was closed. Loans closed before outcomes
+	// were recorded leave it empty and were returned.
+	Outcome LoanOutcome `json:"outcome,omitempty" bson:"outcome,omitempty"`
+	// ReplacementFee is charged when the copy is declared lost or damaged.
+	ReplacementFee int `json:"replacement_fee,omitempty" bson:"replacement_fee,omitempty"`
+	// OverdueAt is when the loan was found overdue and the patron told, and
+	// RemindedAt when the patron was reminded that it is due soon.
+	OverdueAt  *time.Time `json:"overdue_at,omitempty" bson:"overdue_at,omitempty"`
+	RemindedAt *time.Time `json:"reminded_at,omitempty" bson:"reminded_at,omitempty"`
+	// BookTitle is filled in when loans are listed for a user. It is not
+	// stored and is empty for books that have since been deleted.
+	BookTitle string `json:"book_title,omitempty" bson:"-"`
+}
+
+// WriteOff is the result of declaring a copy lost or damaged: the withdrawn
+// copy and, if it was on loan, the loan that was closed.
+type WriteOff struct {
+	BookID string `json:"book_id"`
+	Copy   Copy   `json:"copy"`
+	Loan   *Loan  `json:"loan,omitempty"`
+}
+
+type LoanOutcome string
+
+const (
+	LoanReturned LoanOutcome = "returned"
+	LoanLost     LoanOutcome = "lost"
+	LoanDamaged  LoanOutcome = "damaged"
+)
+
+// LoanClosing describes how an open loan ends.
+type LoanClosing struct {
+	At             time.Time
+	Outcome        LoanOutcome
+	Fine           int
+	ReplacementFee int
+	// CheckedInBy names the staff member closing the loan, if any.
+	CheckedInBy string
+}
+
+// Balance is what a user owes in fines, in minor currency units.
+type Balance struct {
+	Username string `json:"username"`
+	// Charged is the sum of fines and replacement fees on closed loans.
+	Charged int `json:"charged"`
+	// Accruing is the sum of fines on open overdue loans so far.
+	Accruing int `json:"accruing"`
+	// Paid and Waived are the sums of the payments of each kind recorded
+	// against the charges.
+	Paid   int `json:"paid"`
+	Waived int `json:"waived"`
+	Total  int `json:"total"`
+}
+
+type PaymentKind string
+
+const (
+	// PaymentPaid is money the patron handed over.
+	PaymentPaid PaymentKind = "payment"
+	// PaymentWaived is an amount staff let the patron off.
+	PaymentWaived PaymentKind = "waiver"
+)
+
+// Payment settles part of what a user owes in fines and replacement fees.
+type Payment struct {
+	ID       string      `json:"id" bson:"_id"`
+	Username string      `json:"username"`
+	Kind     PaymentKind `json:"kind"`
+	// Amount is in minor currency units, like the fines it settles.
+	Amount int       `json:"amount"`
+	Note   string    `json:"note,omitempty" bson:"note,omitempty"`
+	At     time.Time `json:"at"`
+	// By names the staff member who recorded the payment.
+	By string `json:"by"`
+}
+
+type HoldStatus string
+
+const (
+	// HoldWaiting holds are queued for the next copy to come back.
+	HoldWaiting HoldStatus = "waiting"
+	// HoldReady holds have a copy set aside until ExpiresAt.
+	HoldReady     HoldStatus = "ready"
+	HoldFulfilled HoldStatus = "fulfilled"
+	HoldCancelled HoldStatus = "cancelled"
+	HoldExpired   HoldStatus = "expired"
+)
+
+// Hold is a patron's place in the queue for a book. Holds are served in the
+// order they were placed.
+type Hold struct {
+	ID       string     `json:"id" bson:"_id"`
+	BookID   string     `json:"book_id" bson:"book_id"`
+	Username string     `json:"username"`
+	Status   HoldStatus `json:"status"`
+	// Barcode is the copy set aside once the hold is ready.
+	Barcode  string     `json:"barcode,omitempty"`
+	PlacedAt time.Time  `json:"placed_at" bson:"placed_at"`
+	ReadyAt  *time.Time `json:"ready_at,omitempty" bson:"ready_at"`
+	// ExpiresAt ends the wait for a waiting hold and the pickup window for a
+	// ready one.
+	ExpiresAt time.Time  `json:"expires_at" bson:"expires_at"`
+	ClosedAt  *time.Time `json:"closed_at,omitempty" bson:"closed_at"`
+	// Position is the place in the queue of a waiting hold, counted from 1.
+	// It is computed when holds are read and not stored.
+	Position int `json:"position,omitempty" bson:"-"`
+}
+
+// Active reports whether the hold is still waiting or ready.
+func (h Hold) Active() bool {
+	return h.Status == HoldWaiting || h.Status == HoldReady
 }
 
 type User struct {
-	ID              string   `json:"id" bson:"-"`
-	Username        string   `json:"username"`
-	Password        string   `json:"-"`
-	Role            string   `json:"role"`
+	ID       string `json:"id" bson:"-"`
+	Username string `json:"username"`
+	Password string `json:"-"`
+	// Roles grant the user permissions; see package authz.
+	Roles []string `json:"roles"`
+	// CardNumber is printed on the user's library card so that desk staff
+	// can look the user up.
+	CardNumber      string   `json:"card_number,omitempty" bson:"card_number,omitempty"`
 	BorrowedBookIDs []string `json:"borrowed_book_ids" bson:"borrowed_book_ids"`
+	// LoanLimit overrides the concurrent loan limit of the user's roles when
+	// set by an administrator.
+	LoanLimit *int `json:"loan_limit,omitempty" bson:"loan_limit,omitempty"`
+	// Disabled accounts cannot log in or refresh their sessions.
+	Disabled bool `json:"disabled" bson:"disabled,omitempty"`
+	// Email and Phone are contact details the user keeps up to date
+	// themselves; either may be empty.
+	Email string `json:"email,omitempty" bson:"email,omitempty"`
+	Phone string `json:"phone,omitempty" bson:"phone,omitempty"`
+}
+
+// Sort keys accepted in BookQuery.SortBy.
+const (
+	SortByTitle     = "title"
+	SortByAuthor    = "author"
+	SortByAvailable = "available"
+)
+
+// Sort orders accepted in BookQuery.Order.
+const (
+	OrderAscending  = "asc"
+	OrderDescending = "desc"
+)
+
+// BookQuery filters, orders and pages a book listing. Offset is resolved by
+// the book service from PageToken before the query reaches a repository.
+type BookQuery struct {
+	Author      string
+	TitlePrefix string
+	Available   *bool
+	SortBy      string
+	Order       string
+	Limit       int
+	PageToken   string
+	Offset      int
+}
+
+// Loan statuses accepted in LoanQuery.Status.
+const (
+	LoanStatusOpen     = "open"
+	LoanStatusOverdue  = "overdue"
+	LoanStatusReturned = "returned"
+)
+
+// LoanQuery filters and pages a user's loans. Offset is resolved by the book
+// service from PageToken.
+type LoanQuery struct {
+	Status    string
+	Limit     int
+	PageToken string
+	Offset    int
+}
+
+// Profile is what a user sees of their own account: the account and the
+// books they have on loan now, most recently borrowed first.
+type Profile struct {
+	User
+	Loans []Loan `json:"loans"`
+}
+
+// LoanPage is one page of a user's loans, most recent first.
+type LoanPage struct {
+	Loans         []Loan `json:"loans"`
+	Total         int64  `json:"total"`
+	Limit         int    `json:"limit"`
+	Offset        int    `json:"offset"`
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+// BookPage is one page of a book listing.
+type BookPage struct {
+	Books         []Book `json:"books"`
+	Total         int64  `json:"total"`
+	Limit         int    `json:"limit"`
+	Offset        int    `json:"offset"`
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+// Calendar is when the library is open. Dates and times are local to
+// TimeZone. A weekday without OpeningHours is a weekly closure.
+type Calendar struct {
+	TimeZone     string         `json:"time_zone" bson:"time_zone"`
+	OpeningHours []OpeningHours `json:"opening_hours" bson:"opening_hours"`
+	Holidays     []Holiday      `json:"holidays"`
+}
+
+// OpeningHours are the hours of one weekday, such as "monday", as "15:04"
+// times. Closes may be "24:00" for a library open until midnight. Copies
+// due after closing time are due at closing time instead.
+type OpeningHours struct {
+	Weekday string `json:"weekday"`
+	Opens   string `json:"opens"`
+	Closes  string `json:"closes"`
+}
+
+// Holiday is a date, as "2006-01-02", on which the library is closed.
+type Holiday struct {
+	Date string `json:"date"`
+	Name string `json:"name,omitempty" bson:"name,omitempty"`
+}
+
+// Job is a task the scheduler runs on a cron schedule.
+type Job struct {
+	Name     string `json:"name"`
+	Schedule string `json:"schedule"`
+	// NextRunAt is when this server will next try to run the job.
+	NextRunAt time.Time `json:"next_run_at"`
+}
+
+type JobRunStatus string
+
+const (
+	JobRunning   JobRunStatus = "running"
+	JobSucceeded JobRunStatus = "succeeded"
+	JobFailed    JobRunStatus = "failed"
+)
+
+// JobRun is one run of a scheduled job, by the server instance named in
+// Owner.
+type JobRun struct {
+	ID          string       `json:"id" bson:"_id"`
+	Job         string       `json:"job"`
+	Owner       string       `json:"owner"`
+	Status      JobRunStatus `json:"status"`
+	ScheduledAt time.Time    `json:"scheduled_at" bson:"scheduled_at"`
+	StartedAt   time.Time    `json:"started_at" bson:"started_at"`
+	FinishedAt  *time.Time   `json:"finished_at,omitempty" bson:"finished_at"`
+	// Result summarises what a successful run did, and Error why a run
+	// failed.
+	Result string `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// JobLease is the claim of one server instance on a run of a job. Slot is
+// the scheduled time of the last run claimed, and the lease is held until
+// ExpiresAt or until the run ends.
+type JobLease struct {
+	Job       string    `json:"job" bson:"_id"`
+	Owner     string    `json:"owner"`
+	Slot      time.Time `json:"slot"`
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+}
+
+// JobRunQuery filters and limits the job run history.
+type JobRunQuery struct {
+	Job   string
+	Limit int
+}
+
+// Session is a login of one user. The user keeps it alive by exchanging its
+// refresh token for a new access token and a new refresh token, until the
+// session expires or is revoked. Only a hash of the refresh token is stored.
+type Session struct {
+	ID               string `json:"id" bson:"_id"`
+	Username         string `json:"username"`
+	RefreshTokenHash string `json:"-" bson:"refresh_token_hash"`
+	// AccessTokenID is the ID of the latest access token issued for the
+	// session, which is revoked with the session.
+	AccessTokenID        string     `json:"-" bson:"access_token_id"`
+	AccessTokenExpiresAt time.Time  `json:"-" bson:"access_token_expires_at"`
+	CreatedAt            time.Time  `json:"created_at" bson:"created_at"`
+	ExpiresAt            time.Time  `json:"expires_at" bson:"expires_at"`
+	RevokedAt            *time.Time `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+}
+
+// SessionRotation is what changes in a session when its refresh token is
+// used.
+type SessionRotation struct {
+	RefreshTokenHash     string
+	AccessTokenID        string
+	AccessTokenExpiresAt time.Time
+}
+
+// RevokedToken is an access token that must be refused until it expires.
+type RevokedToken struct {
+	ID        string    `json:"id" bson:"_id"`
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+}
+
+// SigningKey is a key pair that signs access tokens. The newest key signs;
+// older ones still verify the tokens they signed until the rotation window
+// after the next key was created has passed. PrivateKey is PKCS #8 DER.
+type SigningKey struct {
+	ID         string    `json:"id" bson:"_id"`
+	Algorithm  string    `json:"algorithm"`
+	PrivateKey []byte    `json:"-" bson:"private_key"`
+	CreatedAt  time.Time `json:"created_at" bson:"created_at"`
+}
+
+// JWK is the public half of a signing key as a JSON Web Key (RFC 7517). RSA
+// keys carry N and E, Ed25519 keys Curve and X, all base64url encoded.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKSet is the JSON Web Key Set of the keys that access tokens may be
+// signed with.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
 }
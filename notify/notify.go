@@ -0,0 +1,28 @@
+// Package notify delivers messages to patrons. The library has no mail or
+// SMS gateway yet, so the only Notifier writes notices to the server log.
+package notify
+
+import (
+	"context"
+	"log"
+)
+
+// Notice is a message for one patron.
+type Notice struct {
+	Username string
+	Subject  string
+	Body     string
+}
+
+// Notifier delivers notices.
+type Notifier interface {
+	Notify(ctx context.Context, notice Notice) error
+}
+
+// LogNotifier writes notices to the standard logger.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, notice Notice) error {
+	log.Printf("notice to %s: %s: %s", notice.Username, notice.Subject, notice.Body)
+	return nil
+}
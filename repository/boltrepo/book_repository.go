@@ -0,0 +1,248 @@
+package boltrepo
+
+import (
+	"context"
+	"library_management_system/apperrors"
+	"library_management_system/models"
+	"library_management_system/repository"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BookRepository stores books in the books bucket, keyed by book ID.
+type BookRepository struct {
+	store *Store
+}
+
+func (r *BookRepository) FindByID(ctx context.Context, id string) (*models.Book, error) {
+	var book *models.Book
+	err := r.store.view(ctx, func(tx *bolt.Tx) error {
+		var err error
+		book, err = getBook(tx, id)
+		return err
+	})
+	return book, err
+}
+
+func (r *BookRepository) Find(ctx context.Context, query models.BookQuery) ([]models.Book, int64, error) {
+	books, err := r.all(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	page, total := repository.FilterBooks(books, query)
+	return page, total, nil
+}
+
+// SearchCandidates returns every book; an embedded single-box catalog is small
+// enough to rank in full.
+func (r *BookRepository) SearchCandidates(ctx context.Context, terms, prefixes []string) ([]models.Book, error) {
+	return r.all(ctx)
+}
+
+func (r *BookRepository) all(ctx context.Context) ([]models.Book, error) {
+	var books []models.Book
+	err := r.store.view(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket(booksBucket).ForEach(func(k, v []byte) error {
+			var book models.Book
+			if err := decode(v, &book); err != nil {
+				return err
+			}
+			books = append(books, book)
+			return nil
+		})
+	})
+	return books, err
+}
+
+func (r *BookRepository) FindByBarcode(ctx context.Context, barcode string) (*models.Book, error) {
+	var book *models.Book
+	err := r.store.view(ctx, func(tx *bolt.Tx) error {
+		id := tx.Bucket(barcodesBucket).Get([]byte(barcode))
+		if id == nil {
+			return &apperrors.CopyNotFoundError{Barcode: barcode}
+		}
+		var err error
+		book, err = getBook(tx, string(id))
+		return err
+	})
+	return book, err
+}
+
+func (r *BookRepository) Insert(ctx context.Context, book models.Book) error {
+	return r.store.update(ctx, func(tx *bolt.Tx) error {
+		if tx.Bucket(booksBucket).Get([]byte(book.ID)) != nil {
+			return &apperrors.BookWithSameIDError{BookID: book.ID}
+		}
+		for _, c := range book.Copies {
+			if err := claimBarcode(tx, c.Barcode, book.ID); err != nil {
+				return err
+			}
+		}
+		return putBook(tx, &book)
+	})
+}
+
+func (r *BookRepository) Update(ctx context.Context, book models.Book) error {
+	return r.modify(ctx, book.ID, func(tx *bolt.Tx, stored *models.Book) error {
+		stored.Title = book.Title
+		stored.Author = book.Author
+		return nil
+	})
+}
+
+func (r *BookRepository) Delete(ctx context.Context, id string) error {
+	return r.store.update(ctx, func(tx *bolt.Tx) error {
+		book, err := getBook(tx, id)
+		if err != nil {
+			return err
+		}
+		for _, c := range book.Copies {
+			if err := tx.Bucket(barcodesBucket).Delete([]byte(c.Barcode)); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(booksBucket).Delete([]byte(id))
+	})
+}
+
+func (r *BookRepository) CheckOut(ctx context.Context, id, username, barcode string) (string, error) {
+	var lent string
+	err := r.modify(ctx, id, func(tx *bolt.Tx, book *models.Book) error {
+		var err error
+		lent, err = repository.CheckOutCopy(book, username, barcode)
+		return err
+	})
+	return lent, err
+}
+
+func (r *BookRepository) CheckIn(ctx context.Context, id, username string) (string, error) {
+	var returned string
+	err := r.modify(ctx, id, func(tx *bolt.Tx, book *models.Book) error {
+		var err error
+		returned, err = repository.CheckInCopy(book, username)
+		return err
+	})
+	return returned, err
+}
+
+func (r *BookRepository) HoldCopy(ctx context.Context, bookID, barcode, username string) error {
+	return r.modify(ctx, bookID, func(tx *bolt.Tx, book *models.Book) error {
+		return repository.HoldCopy(book, barcode, username)
+	})
+}
+
+func (r *BookRepository) UnholdCopy(ctx context.Context, bookID, barcode string) error {
+	return r.modify(ctx, bookID, func(tx *bolt.Tx, book *models.Book) error {
+		return repository.UnholdCopy(book, barcode)
+	})
+}
+
+func (r *BookRepository) WithdrawCopy(ctx context.Context, bookID, barcode string, withdrawal models.Withdrawal) (string, error) {
+	var borrower string
+	err := r.modify(ctx, bookID, func(tx *bolt.Tx, book *models.Book) error {
+		var err error
+		borrower, err = repository.WithdrawCopy(book, barcode, withdrawal)
+		return err
+	})
+	return borrower, err
+}
+
+func (r *BookRepository) AddCopy(ctx context.Context, bookID string, copy models.Copy) error {
+	return r.modify(ctx, bookID, func(tx *bolt.Tx, book *models.Book) error {
+		if err := claimBarcode(tx, copy.Barcode, bookID); err != nil {
+			return err
+		}
+		return repository.AddCopy(book, copy)
+	})
+}
+
+func (r *BookRepository) UpdateCopy(ctx context.Context, bookID string, copy models.Copy) error {
+	return r.modify(ctx, bookID, func(tx *bolt.Tx, book *models.Book) error {
+		return repository.UpdateCopy(book, copy)
+	})
+}
+
+func (r *BookRepository) RemoveCopy(ctx context.Context, bookID, barcode string) error {
+	return r.modify(ctx, bookID, func(tx *bolt.Tx, book *models.Book) error {
+		if err := repository.RemoveCopy(book, barcode); err != nil {
+			return err
+		}
+		return tx.Bucket(barcodesBucket).Delete([]byte(barcode))
+	})
+}
+
+// modify loads a book, applies fn and writes the result back, all in one
+// read-write transaction.
+func (r *BookRepository) modify(ctx context.Context, id string, fn func(tx *bolt.Tx, book *models.Book) error) error {
+	return r.store.update(ctx, func(tx *bolt.Tx) error {
+		book, err := getBook(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := fn(tx, book); err != nil {
+			return err
+		}
+		return putBook(tx, book)
+	})
+}
+
+// upgradeLegacyBooks gives copies to books stored before copies existed.
+func upgradeLegacyBooks(tx *bolt.Tx) error {
+	var legacy []models.Book
+	err := tx.Bucket(booksBucket).ForEach(func(k, v []byte) error {
+		var book models.Book
+		if err := decode(v, &book); err != nil {
+			return err
+		}
+		if book.Copies == nil {
+			legacy = append(legacy, book)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, book := range legacy {
+		book.Copies = repository.LegacyCopies(book)
+		for _, c := range book.Copies {
+			if err := claimBarcode(tx, c.Barcode, book.ID); err != nil {
+				return err
+			}
+		}
+		if err := putBook(tx, &book); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claimBarcode records that barcode belongs to bookID, failing if another
+// copy already uses it.
+func claimBarcode(tx *bolt.Tx, barcode, bookID string) error {
+	barcodes := tx.Bucket(barcodesBucket)
+	if barcodes.Get([]byte(barcode)) != nil {
+		return &apperrors.BarcodeAlreadyExistsError{Barcode: barcode}
+	}
+	return barcodes.Put([]byte(barcode), []byte(bookID))
+}
+
+func getBook(tx *bolt.Tx, id string) (*models.Book, error) {
+	data := tx.Bucket(booksBucket).Get([]byte(id))
+	if data == nil {
+		return nil, &apperrors.BookNotFoundError{BookID: id}
+	}
+	var book models.Book
+	if err := decode(data, &book); err != nil {
+		return nil, err
+	}
+	return &book, nil
+}
+
+func putBook(tx *bolt.Tx, book *models.Book) error {
+	data, err := encode(book)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(booksBucket).Put([]byte(book.ID), data)
+}
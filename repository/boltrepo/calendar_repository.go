@@ -0,0 +1,40 @@
+package boltrepo
+
+import (
+	"context"
+	"library_management_system/models"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// CalendarRepository stores the calendar as a single record in the calendar
+// bucket.
+type CalendarRepository struct {
+	store *Store
+}
+
+func (r *CalendarRepository) Get(ctx context.Context) (*models.Calendar, error) {
+	var calendar *models.Calendar
+	err := r.store.view(ctx, func(tx *bolt.Tx) error {
+		data := tx.Bucket(calendarBucket).Get(calendarKey)
+		if data == nil {
+			return nil
+		}
+		calendar = &models.Calendar{}
+		return decode(data, calendar)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return calendar, nil
+}
+
+func (r *CalendarRepository) Save(ctx context.Context, calendar models.Calendar) error {
+	return r.store.update(ctx, func(tx *bolt.Tx) error {
+		data, err := encode(calendar)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(calendarBucket).Put(calendarKey, data)
+	})
+}
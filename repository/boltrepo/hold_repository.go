@@ -0,0 +1,76 @@
+package boltrepo
+
+import (
+	"context"
+	"library_management_system/apperrors"
+	"library_management_system/models"
+	"library_management_system/repository"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// HoldRepository stores holds in the holds bucket, keyed by hold ID. Queue
+// lookups scan the bucket.
+type HoldRepository struct {
+	store *Store
+}
+
+func (r *HoldRepository) Insert(ctx context.Context, hold *models.Hold) error {
+	return r.store.update(ctx, func(tx *bolt.Tx) error {
+		hold.ID = primitive.NewObjectID().Hex()
+		return putHold(tx, *hold)
+	})
+}
+
+func (r *HoldRepository) Update(ctx context.Context, hold models.Hold) error {
+	return r.store.update(ctx, func(tx *bolt.Tx) error {
+		if tx.Bucket(holdsBucket).Get([]byte(hold.ID)) == nil {
+			return &apperrors.HoldNotFoundError{BookID: hold.BookID, Username: hold.Username}
+		}
+		return putHold(tx, hold)
+	})
+}
+
+func (r *HoldRepository) FindActive(ctx context.Context, bookID string) ([]models.Hold, error) {
+	return r.filter(ctx, func(hold models.Hold) bool { return hold.BookID == bookID && hold.Active() })
+}
+
+func (r *HoldRepository) FindExpired(ctx context.Context, at time.Time) ([]models.Hold, error) {
+	return r.filter(ctx, func(hold models.Hold) bool { return hold.Active() && !hold.ExpiresAt.After(at) })
+}
+
+func (r *HoldRepository) FindActiveByUser(ctx context.Context, username string) ([]models.Hold, error) {
+	return r.filter(ctx, func(hold models.Hold) bool { return hold.Username == username && hold.Active() })
+}
+
+func (r *HoldRepository) filter(ctx context.Context, keep func(hold models.Hold) bool) ([]models.Hold, error) {
+	holds := []models.Hold{}
+	err := r.store.view(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket(holdsBucket).ForEach(func(k, v []byte) error {
+			var hold models.Hold
+			if err := decode(v, &hold); err != nil {
+				return err
+			}
+			if keep(hold) {
+				holds = append(holds, hold)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	repository.SortHolds(holds)
+	return holds, nil
+}
+
+func putHold(tx *bolt.Tx, hold models.Hold) error {
+	hold.Position = 0
+	data, err := encode(hold)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(holdsBucket).Put([]byte(hold.ID), data)
+}
@@ -0,0 +1,108 @@
+package boltrepo
+
+import (
+	"context"
+	"library_management_system/apperrors"
+	"library_management_system/models"
+	"library_management_system/repository"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// JobRepository stores leases in the job_leases bucket, keyed by job name,
+// and runs in the job_runs bucket, keyed by run ID. bbolt locks the database
+// file, so leases only ever see one process.
+type JobRepository struct {
+	store *Store
+}
+
+func (r *JobRepository) AcquireLease(ctx context.Context, job, owner string, slot, now, expiresAt time.Time) (bool, error) {
+	acquired := false
+	err := r.store.update(ctx, func(tx *bolt.Tx) error {
+		current, err := getLease(tx, job)
+		if err != nil {
+			return err
+		}
+		if !repository.LeaseFree(current, slot, now) {
+			return nil
+		}
+		acquired = true
+		return putLease(tx, models.JobLease{Job: job, Owner: owner, Slot: slot, ExpiresAt: expiresAt})
+	})
+	return acquired, err
+}
+
+func (r *JobRepository) ReleaseLease(ctx context.Context, job, owner string, now time.Time) error {
+	return r.store.update(ctx, func(tx *bolt.Tx) error {
+		lease, err := getLease(tx, job)
+		if err != nil || lease == nil || lease.Owner != owner {
+			return err
+		}
+		lease.ExpiresAt = now
+		return putLease(tx, *lease)
+	})
+}
+
+func (r *JobRepository) InsertRun(ctx context.Context, run *models.JobRun) error {
+	return r.store.update(ctx, func(tx *bolt.Tx) error {
+		run.ID = primitive.NewObjectID().Hex()
+		return putRun(tx, *run)
+	})
+}
+
+func (r *JobRepository) UpdateRun(ctx context.Context, run models.JobRun) error {
+	return r.store.update(ctx, func(tx *bolt.Tx) error {
+		if tx.Bucket(runsBucket).Get([]byte(run.ID)) == nil {
+			return &apperrors.JobRunNotFoundError{ID: run.ID}
+		}
+		return putRun(tx, run)
+	})
+}
+
+func (r *JobRepository) FindRuns(ctx context.Context, query models.JobRunQuery) ([]models.JobRun, error) {
+	var runs []models.JobRun
+	err := r.store.view(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket(runsBucket).ForEach(func(k, v []byte) error {
+			var run models.JobRun
+			if err := decode(v, &run); err != nil {
+				return err
+			}
+			runs = append(runs, run)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return repository.FilterJobRuns(runs, query), nil
+}
+
+func getLease(tx *bolt.Tx, job string) (*models.JobLease, error) {
+	data := tx.Bucket(leasesBucket).Get([]byte(job))
+	if data == nil {
+		return nil, nil
+	}
+	var lease models.JobLease
+	if err := decode(data, &lease); err != nil {
+		return nil, err
+	}
+	return &lease, nil
+}
+
+func putLease(tx *bolt.Tx, lease models.JobLease) error {
+	data, err := encode(lease)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(leasesBucket).Put([]byte(lease.Job), data)
+}
+
+func putRun(tx *bolt.Tx, run models.JobRun) error {
+	data, err := encode(run)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(runsBucket).Put([]byte(run.ID), data)
+}
@@ -0,0 +1,125 @@
+package boltrepo
+
+import (
+	"context"
+	"library_management_system/apperrors"
+	"library_management_system/models"
+	"library_management_system/repository"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LoanRepository stores loans in the loans bucket, keyed by loan ID. Lookups
+// by book or user scan the bucket.
+type LoanRepository struct {
+	store *Store
+}
+
+func (r *LoanRepository) Insert(ctx context.Context, loan *models.Loan) error {
+	return r.store.update(ctx, func(tx *bolt.Tx) error {
+		loan.ID = primitive.NewObjectID().Hex()
+		return putLoan(tx, loan)
+	})
+}
+
+func (r *LoanRepository) FindOpen(ctx context.Context, bookID, username string) (*models.Loan, error) {
+	loans, err := r.filter(ctx, func(loan models.Loan) bool {
+		return loan.BookID == bookID && loan.Username == username && loan.ReturnedAt == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(loans) == 0 {
+		return nil, &apperrors.LoanNotFoundError{BookID: bookID, Username: username}
+	}
+	return &loans[0], nil
+}
+
+func (r *LoanRepository) Close(ctx context.Context, id string, closing models.LoanClosing) error {
+	return r.modifyOpen(ctx, id, func(loan *models.Loan) {
+		repository.CloseLoan(loan, closing)
+	})
+}
+
+func (r *LoanRepository) Renew(ctx context.Context, id string, dueAt time.Time) error {
+	return r.modifyOpen(ctx, id, func(loan *models.Loan) {
+		loan.DueAt = dueAt
+		loan.Renewals++
+	})
+}
+
+func (r *LoanRepository) MarkOverdue(ctx context.Context, id string, at time.Time) error {
+	return r.modifyOpen(ctx, id, func(loan *models.Loan) {
+		loan.OverdueAt = &at
+	})
+}
+
+func (r *LoanRepository) MarkReminded(ctx context.Context, id string, at time.Time) error {
+	return r.modifyOpen(ctx, id, func(loan *models.Loan) {
+		loan.RemindedAt = &at
+	})
+}
+
+// modifyOpen applies fn to the open loan with id and stores the result.
+func (r *LoanRepository) modifyOpen(ctx context.Context, id string, fn func(loan *models.Loan)) error {
+	return r.store.update(ctx, func(tx *bolt.Tx) error {
+		data := tx.Bucket(loansBucket).Get([]byte(id))
+		if data == nil {
+			return &apperrors.LoanNotFoundError{}
+		}
+		var loan models.Loan
+		if err := decode(data, &loan); err != nil {
+			return err
+		}
+		if loan.ReturnedAt != nil {
+			return &apperrors.LoanNotFoundError{BookID: loan.BookID, Username: loan.Username}
+		}
+		fn(&loan)
+		return putLoan(tx, &loan)
+	})
+}
+
+func (r *LoanRepository) FindByBook(ctx context.Context, bookID string) ([]models.Loan, error) {
+	return r.filter(ctx, func(loan models.Loan) bool { return loan.BookID == bookID })
+}
+
+func (r *LoanRepository) FindByUser(ctx context.Context, username string) ([]models.Loan, error) {
+	return r.filter(ctx, func(loan models.Loan) bool { return loan.Username == username })
+}
+
+func (r *LoanRepository) FindDueBy(ctx context.Context, at time.Time) ([]models.Loan, error) {
+	loans, err := r.filter(ctx, func(loan models.Loan) bool { return loan.ReturnedAt == nil && !loan.DueAt.After(at) })
+	repository.SortLoansByDue(loans)
+	return loans, err
+}
+
+func (r *LoanRepository) filter(ctx context.Context, keep func(loan models.Loan) bool) ([]models.Loan, error) {
+	loans := []models.Loan{}
+	err := r.store.view(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket(loansBucket).ForEach(func(k, v []byte) error {
+			var loan models.Loan
+			if err := decode(v, &loan); err != nil {
+				return err
+			}
+			if keep(loan) {
+				loans = append(loans, loan)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	repository.SortLoans(loans)
+	return loans, nil
+}
+
+func putLoan(tx *bolt.Tx, loan *models.Loan) error {
+	data, err := encode(loan)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(loansBucket).Put([]byte(loan.ID), data)
+}
@@ -0,0 +1,54 @@
+package boltrepo
+
+import (
+	"context"
+	"library_management_system/models"
+	"library_management_system/repository"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PaymentRepository stores payments in the payments bucket, keyed by payment
+// ID. Looking up the payments of a user scans the bucket.
+type PaymentRepository struct {
+	store *Store
+}
+
+func (r *PaymentRepository) Insert(ctx context.Context, payment *models.Payment) error {
+	return r.store.update(ctx, func(tx *bolt.Tx) error {
+		id := primitive.NewObjectID().Hex()
+		stored := *payment
+		stored.ID = id
+		data, err := encode(stored)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(paymentsBucket).Put([]byte(id), data); err != nil {
+			return err
+		}
+		payment.ID = id
+		return nil
+	})
+}
+
+func (r *PaymentRepository) FindByUser(ctx context.Context, username string) ([]models.Payment, error) {
+	payments := []models.Payment{}
+	err := r.store.view(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket(paymentsBucket).ForEach(func(k, v []byte) error {
+			var payment models.Payment
+			if err := decode(v, &payment); err != nil {
+				return err
+			}
+			if payment.Username == username {
+				payments = append(payments, payment)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	repository.SortPayments(payments)
+	return payments, nil
+}
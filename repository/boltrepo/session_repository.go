@@ -0,0 +1,166 @@
+package boltrepo
+
+import (
+	"context"
+	"library_management_system/apperrors"
+	"library_management_system/models"
+	"library_management_system/repository"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// SessionRepository stores sessions in the sessions bucket and revoked access
+// tokens in the revoked_tokens bucket, both keyed by ID.
+type SessionRepository struct {
+	store *Store
+}
+
+func (r *SessionRepository) Insert(ctx context.Context, session *models.Session) error {
+	return r.store.update(ctx, func(tx *bolt.Tx) error {
+		return putSession(tx, *session)
+	})
+}
+
+func (r *SessionRepository) FindByID(ctx context.Context, id string) (*models.Session, error) {
+	var session *models.Session
+	err := r.store.view(ctx, func(tx *bolt.Tx) error {
+		var err error
+		session, err = getSession(tx, id)
+		return err
+	})
+	return session, err
+}
+
+func (r *SessionRepository) Rotate(ctx context.Context, id, previousHash string, rotation models.SessionRotation) (bool, error) {
+	rotated := false
+	err := r.store.update(ctx, func(tx *bolt.Tx) error {
+		session, err := getSession(tx, id)
+		if _, missing := err.(*apperrors.SessionNotFoundError); missing {
+			return nil
+		}
+		if err != nil || session.RevokedAt != nil || session.RefreshTokenHash != previousHash {
+			return err
+		}
+		repository.RotateSession(session, rotation)
+		rotated = true
+		return putSession(tx, *session)
+	})
+	return rotated, err
+}
+
+func (r *SessionRepository) Revoke(ctx context.Context, id string, at time.Time) error {
+	return r.store.update(ctx, func(tx *bolt.Tx) error {
+		session, err := getSession(tx, id)
+		if err != nil || session.RevokedAt != nil {
+			return err
+		}
+		session.RevokedAt = &at
+		return putSession(tx, *session)
+	})
+}
+
+func (r *SessionRepository) FindActiveByUser(ctx context.Context, username string, at time.Time) ([]models.Session, error) {
+	sessions := []models.Session{}
+	err := r.store.view(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).ForEach(func(k, v []byte) error {
+			var session models.Session
+			if err := decode(v, &session); err != nil {
+				return err
+			}
+			if session.Username == username && repository.SessionActive(session, at) {
+				sessions = append(sessions, session)
+			}
+			return nil
+		})
+	})
+	repository.SortSessions(sessions)
+	return sessions, err
+}
+
+func (r *SessionRepository) RevokeToken(ctx context.Context, token models.RevokedToken) error {
+	return r.store.update(ctx, func(tx *bolt.Tx) error {
+		data, err := encode(token)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(revokedBucket).Put([]byte(token.ID), data)
+	})
+}
+
+func (r *SessionRepository) TokenRevoked(ctx context.Context, id string) (bool, error) {
+	revoked := false
+	err := r.store.view(ctx, func(tx *bolt.Tx) error {
+		revoked = tx.Bucket(revokedBucket).Get([]byte(id)) != nil
+		return nil
+	})
+	return revoked, err
+}
+
+func (r *SessionRepository) DeleteExpired(ctx context.Context, at time.Time) (int, error) {
+	deleted := 0
+	err := r.store.update(ctx, func(tx *bolt.Tx) error {
+		var err error
+		deleted, err = deleteExpired(tx.Bucket(sessionsBucket), at, func(data []byte) (time.Time, error) {
+			var session models.Session
+			err := decode(data, &session)
+			return session.ExpiresAt, err
+		})
+		if err != nil {
+			return err
+		}
+		tokens, err := deleteExpired(tx.Bucket(revokedBucket), at, func(data []byte) (time.Time, error) {
+			var token models.RevokedToken
+			err := decode(data, &token)
+			return token.ExpiresAt, err
+		})
+		deleted += tokens
+		return err
+	})
+	return deleted, err
+}
+
+// deleteExpired removes the records of bucket whose expiry, as read by
+// expiresAt, is before at.
+func deleteExpired(bucket *bolt.Bucket, at time.Time, expiresAt func(data []byte) (time.Time, error)) (int, error) {
+	var expired [][]byte
+	err := bucket.ForEach(func(k, v []byte) error {
+		expiry, err := expiresAt(v)
+		if err != nil {
+			return err
+		}
+		if expiry.Before(at) {
+			expired = append(expired, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, k := range expired {
+		if err := bucket.Delete(k); err != nil {
+			return 0, err
+		}
+	}
+	return len(expired), nil
+}
+
+func getSession(tx *bolt.Tx, id string) (*models.Session, error) {
+	data := tx.Bucket(sessionsBucket).Get([]byte(id))
+	if data == nil {
+		return nil, &apperrors.SessionNotFoundError{ID: id}
+	}
+	var session models.Session
+	if err := decode(data, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func putSession(tx *bolt.Tx, session models.Session) error {
+	data, err := encode(session)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(sessionsBucket).Put([]byte(session.ID), data)
+}
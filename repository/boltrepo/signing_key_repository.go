@@ -0,0 +1,47 @@
+package boltrepo
+
+import (
+	"context"
+	"library_management_system/models"
+	"library_management_system/repository"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// SigningKeyRepository stores signing keys in the signing_keys bucket, keyed
+// by ID.
+type SigningKeyRepository struct {
+	store *Store
+}
+
+func (r *SigningKeyRepository) Insert(ctx context.Context, key models.SigningKey) error {
+	return r.store.update(ctx, func(tx *bolt.Tx) error {
+		data, err := encode(key)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(keysBucket).Put([]byte(key.ID), data)
+	})
+}
+
+func (r *SigningKeyRepository) FindAll(ctx context.Context) ([]models.SigningKey, error) {
+	keys := []models.SigningKey{}
+	err := r.store.view(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket(keysBucket).ForEach(func(k, v []byte) error {
+			var key models.SigningKey
+			if err := decode(v, &key); err != nil {
+				return err
+			}
+			keys = append(keys, key)
+			return nil
+		})
+	})
+	repository.SortSigningKeys(keys)
+	return keys, err
+}
+
+func (r *SigningKeyRepository) Delete(ctx context.Context, id string) error {
+	return r.store.update(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket(keysBucket).Delete([]byte(id))
+	})
+}
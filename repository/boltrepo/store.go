@@ -0,0 +1,161 @@
+package boltrepo
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	booksBucket    = []byte("books")
+	usersBucket    = []byte("users")
+	barcodesBucket = []byte("barcodes")
+	loansBucket    = []byte("loans")
+	holdsBucket    = []byte("holds")
+	paymentsBucket = []byte("payments")
+	cardsBucket    = []byte("cards")
+	// calendarBucket holds the calendar under calendarKey.
+	calendarBucket = []byte("calendar")
+	calendarKey    = []byte("calendar")
+	leasesBucket   = []byte("job_leases")
+	runsBucket     = []byte("job_runs")
+	sessionsBucket = []byte("sessions")
+	revokedBucket  = []byte("revoked_tokens")
+	keysBucket     = []byte("signing_keys")
+)
+
+// Store keeps the library in a single bbolt database file. Records are gob
+// encoded so that fields hidden from JSON or BSON, such as the password hash,
+// are still persisted.
+type Store struct {
+	db *bolt.DB
+}
+
+type txKey struct{}
+
+// Open opens (creating if necessary) the database file at path, makes sure
+// every bucket exists and upgrades records written by older versions.
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{booksBucket, usersBucket, barcodesBucket, loansBucket, holdsBucket, cardsBucket, calendarBucket, leasesBucket, runsBucket,
+			sessionsBucket, revokedBucket, keysBucket, paymentsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		if err := upgradeLegacyBooks(tx); err != nil {
+			return err
+		}
+		return upgradeLegacyUsers(tx)
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// Close releases the database file.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Ping checks that the database file is still open and readable.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(booksBucket) == nil {
+			return errors.New("books bucket is missing")
+		}
+		return nil
+	})
+}
+
+// Books returns a BookRepository reading and writing this store.
+func (s *Store) Books() *BookRepository {
+	return &BookRepository{store: s}
+}
+
+// Users returns a UserRepository reading and writing this store.
+func (s *Store) Users() *UserRepository {
+	return &UserRepository{store: s}
+}
+
+// Loans returns a LoanRepository reading and writing this store.
+func (s *Store) Loans() *LoanRepository {
+	return &LoanRepository{store: s}
+}
+
+// Holds returns a HoldRepository reading and writing this store.
+func (s *Store) Holds() *HoldRepository {
+	return &HoldRepository{store: s}
+}
+
+// Payments returns a PaymentRepository reading and writing this store.
+func (s *Store) Payments() *PaymentRepository {
+	return &PaymentRepository{store: s}
+}
+
+// Calendar returns a CalendarRepository reading and writing this store.
+func (s *Store) Calendar() *CalendarRepository {
+	return &CalendarRepository{store: s}
+}
+
+// Jobs returns a JobRepository reading and writing this store.
+func (s *Store) Jobs() *JobRepository {
+	return &JobRepository{store: s}
+}
+
+// Sessions returns a SessionRepository reading and writing this store.
+func (s *Store) Sessions() *SessionRepository {
+	return &SessionRepository{store: s}
+}
+
+// SigningKeys returns a SigningKeyRepository reading and writing this store.
+func (s *Store) SigningKeys() *SigningKeyRepository {
+	return &SigningKeyRepository{store: s}
+}
+
+// WithinTransaction runs fn inside a single read-write bbolt transaction.
+func (s *Store) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*bolt.Tx); ok {
+		return fn(ctx)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
+}
+
+// view runs fn in the transaction carried by ctx, or in a new read-only one.
+func (s *Store) view(ctx context.Context, fn func(tx *bolt.Tx) error) error {
+	if tx, ok := ctx.Value(txKey{}).(*bolt.Tx); ok {
+		return fn(tx)
+	}
+	return s.db.View(fn)
+}
+
+// update runs fn in the transaction carried by ctx, or in a new read-write one.
+func (s *Store) update(ctx context.Context, fn func(tx *bolt.Tx) error) error {
+	if tx, ok := ctx.Value(txKey{}).(*bolt.Tx); ok {
+		return fn(tx)
+	}
+	return s.db.Update(fn)
+}
+
+func encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decode(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
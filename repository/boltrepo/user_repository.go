@@ -0,0 +1,263 @@
+package boltrepo
+
+import (
+	"context"
+	"library_management_system/apperrors"
+	"library_management_system/models"
+	"slices"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UserRepository stores users in the users bucket. Keying the bucket by
+// username is what guarantees usernames stay unique; the cards bucket maps
+// card numbers to usernames and keeps card numbers unique.
+type UserRepository struct {
+	store *Store
+}
+
+func (r *UserRepository) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	var user *models.User
+	err := r.store.view(ctx, func(tx *bolt.Tx) error {
+		var err error
+		user, err = getUser(tx, username)
+		return err
+	})
+	return user, err
+}
+
+func (r *UserRepository) FindByCardNumber(ctx context.Context, cardNumber string) (*models.User, error) {
+	var user *models.User
+	err := r.store.view(ctx, func(tx *bolt.Tx) error {
+		username := tx.Bucket(cardsBucket).Get([]byte(cardNumber))
+		if username == nil {
+			return &apperrors.CardNotFoundError{CardNumber: cardNumber}
+		}
+		var err error
+		user, err = getUser(tx, string(username))
+		return err
+	})
+	return user, err
+}
+
+func (r *UserRepository) FindAll(ctx context.Context) ([]models.User, error) {
+	var users []models.User
+	err := r.store.view(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket(usersBucket).ForEach(func(k, v []byte) error {
+			var user models.User
+			if err := decode(v, &user); err != nil {
+				return err
+			}
+			users = append(users, user)
+			return nil
+		})
+	})
+	return users, err
+}
+
+func (r *UserRepository) Insert(ctx context.Context, user *models.User) error {
+	return r.store.update(ctx, func(tx *bolt.Tx) error {
+		if tx.Bucket(usersBucket).Get([]byte(user.Username)) != nil {
+			return &apperrors.UsernameAlreadyExistsError{Username: user.Username}
+		}
+		if err := issueCard(tx, user.CardNumber, user.Username); err != nil {
+			return err
+		}
+		user.ID = primitive.NewObjectID().Hex()
+		return putUser(tx, user)
+	})
+}
+
+func (r *UserRepository) AddBorrowedBook(ctx context.Context, username, bookID string) error {
+	return r.store.update(ctx, func(tx *bolt.Tx) error {
+		user, err := getUser(tx, username)
+		if err != nil {
+			return err
+		}
+		if slices.Contains(user.BorrowedBookIDs, bookID) {
+			return nil
+		}
+		user.BorrowedBookIDs = append(user.BorrowedBookIDs, bookID)
+		return putUser(tx, user)
+	})
+}
+
+func (r *UserRepository) RemoveBorrowedBook(ctx context.Context, username, bookID string) error {
+	return r.store.update(ctx, func(tx *bolt.Tx) error {
+		user, err := getUser(tx, username)
+		if err != nil {
+			return err
+		}
+		i := slices.Index(user.BorrowedBookIDs, bookID)
+		if i < 0 {
+			return nil
+		}
+		user.BorrowedBookIDs = slices.Delete(user.BorrowedBookIDs, i, i+1)
+		return putUser(tx, user)
+	})
+}
+
+func (r *UserRepository) SetCardNumber(ctx context.Context, username, cardNumber string) error {
+	return r.store.update(ctx, func(tx *bolt.Tx) error {
+		user, err := getUser(tx, username)
+		if err != nil {
+			return err
+		}
+		if err := issueCard(tx, cardNumber, username); err != nil {
+			return err
+		}
+		if user.CardNumber != "" {
+			if err := tx.Bucket(cardsBucket).Delete([]byte(user.CardNumber)); err != nil {
+				return err
+			}
+		}
+		user.CardNumber = cardNumber
+		return putUser(tx, user)
+	})
+}
+
+// issueCard maps cardNumber to username unless the card is already issued.
+func issueCard(tx *bolt.Tx, cardNumber, username string) error {
+	if cardNumber == "" {
+		return nil
+	}
+	cards := tx.Bucket(cardsBucket)
+	if cards.Get([]byte(cardNumber)) != nil {
+		return &apperrors.CardNumberAlreadyExistsError{CardNumber: cardNumber}
+	}
+	return cards.Put([]byte(cardNumber), []byte(username))
+}
+
+func (r *UserRepository) SetLoanLimit(ctx context.Context, username string, limit *int) error {
+	return r.store.update(ctx, func(tx *bolt.Tx) error {
+		user, err := getUser(tx, username)
+		if err != nil {
+			return err
+		}
+		user.LoanLimit = limit
+		return putUser(tx, user)
+	})
+}
+
+func (r *UserRepository) SetRoles(ctx context.Context, username string, roles []string) error {
+	return r.store.update(ctx, func(tx *bolt.Tx) error {
+		user, err := getUser(tx, username)
+		if err != nil {
+			return err
+		}
+		user.Roles = roles
+		return putUser(tx, user)
+	})
+}
+
+func (r *UserRepository) SetDisabled(ctx context.Context, username string, disabled bool) error {
+	return r.store.update(ctx, func(tx *bolt.Tx) error {
+		user, err := getUser(tx, username)
+		if err != nil {
+			return err
+		}
+		user.Disabled = disabled
+		return putUser(tx, user)
+	})
+}
+
+func (r *UserRepository) SetPassword(ctx context.Context, username, password string) error {
+	return r.store.update(ctx, func(tx *bolt.Tx) error {
+		user, err := getUser(tx, username)
+		if err != nil {
+			return err
+		}
+		user.Password = password
+		return putUser(tx, user)
+	})
+}
+
+func (r *UserRepository) SetContact(ctx context.Context, username, email, phone string) error {
+	return r.store.update(ctx, func(tx *bolt.Tx) error {
+		user, err := getUser(tx, username)
+		if err != nil {
+			return err
+		}
+		user.Email = email
+		user.Phone = phone
+		return putUser(tx, user)
+	})
+}
+
+func (r *UserRepository) Delete(ctx context.Context, username string) error {
+	return r.store.update(ctx, func(tx *bolt.Tx) error {
+		user, err := getUser(tx, username)
+		if err != nil {
+			return err
+		}
+		if user.CardNumber != "" {
+			if err := tx.Bucket(cardsBucket).Delete([]byte(user.CardNumber)); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(usersBucket).Delete([]byte(username))
+	})
+}
+
+// legacyUser is the part of a user stored before users could hold several
+// roles that upgradeLegacyUsers needs.
+type legacyUser struct {
+	Username string
+	Role     string
+}
+
+// upgradeLegacyUsers gives users stored with a single role a list holding
+// that role.
+func upgradeLegacyUsers(tx *bolt.Tx) error {
+	var legacy []models.User
+	err := tx.Bucket(usersBucket).ForEach(func(k, v []byte) error {
+		var user models.User
+		if err := decode(v, &user); err != nil {
+			return err
+		}
+		if user.Roles != nil {
+			return nil
+		}
+		var old legacyUser
+		if err := decode(v, &old); err != nil {
+			return err
+		}
+		user.Roles = []string{}
+		if old.Role != "" {
+			user.Roles = append(user.Roles, old.Role)
+		}
+		legacy = append(legacy, user)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range legacy {
+		if err := putUser(tx, &legacy[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getUser(tx *bolt.Tx, username string) (*models.User, error) {
+	data := tx.Bucket(usersBucket).Get([]byte(username))
+	if data == nil {
+		return nil, &apperrors.UserNotFoundError{Username: username}
+	}
+	var user models.User
+	if err := decode(data, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func putUser(tx *bolt.Tx, user *models.User) error {
+	data, err := encode(user)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(usersBucket).Put([]byte(user.Username), data)
+}
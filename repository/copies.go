@@ -0,0 +1,236 @@
+package repository
+
+import (
+	"fmt"
+	"library_management_system/apperrors"
+	"library_management_system/models"
+	"slices"
+)
+
+// The helpers below change the copies of a book held in memory and keep its
+// Amount and OwnedBy in step. Backends that store whole book records, such as
+// memrepo and boltrepo, load a book, apply one of them and write it back in
+// the same transaction.
+
+// CheckOutCopy lends a copy of book to username: the copy with barcode, or the
+// first available copy when barcode is empty. A copy on hold can only be lent,
+// by barcode, to the patron it is held for. It returns the barcode lent.
+func CheckOutCopy(book *models.Book, username, barcode string) (string, error) {
+	if slices.Contains(book.OwnedBy, username) {
+		return "", &apperrors.AlreadyHaveBookError{BookTitle: book.Title}
+	}
+
+	i := -1
+	if barcode == "" {
+		i = slices.IndexFunc(book.Copies, func(c models.Copy) bool { return c.Status == models.CopyAvailable })
+		if i < 0 {
+			return "", &apperrors.AmountIsZeroError{BookTitle: book.Title}
+		}
+	} else {
+		i = copyIndex(book, barcode)
+		if i < 0 {
+			return "", &apperrors.CopyNotFoundError{Barcode: barcode}
+		}
+		if !Lendable(book.Copies[i], username) {
+			return "", &apperrors.CopyNotAvailableError{Barcode: barcode, Status: string(book.Copies[i].Status)}
+		}
+	}
+
+	book.Copies = slices.Clone(book.Copies)
+	book.Copies[i].Status = models.CopyOnLoan
+	book.Copies[i].BorrowedBy = username
+	book.Copies[i].HeldFor = ""
+	book.OwnedBy = append(slices.Clone(book.OwnedBy), username)
+	book.Amount = AvailableCopies(book.Copies)
+	return book.Copies[i].Barcode, nil
+}
+
+// CheckInCopy puts the copy lent to username back into circulation and
+// returns its barcode.
+func CheckInCopy(book *models.Book, username string) (string, error) {
+	owner := slices.Index(book.OwnedBy, username)
+	if owner < 0 {
+		return "", &apperrors.BookNotBorrowedError{BookTitle: book.Title}
+	}
+
+	book.Copies = slices.Clone(book.Copies)
+	barcode := ""
+	for i := range book.Copies {
+		if book.Copies[i].Status == models.CopyOnLoan && book.Copies[i].BorrowedBy == username {
+			book.Copies[i].Status = models.CopyAvailable
+			book.Copies[i].BorrowedBy = ""
+			barcode = book.Copies[i].Barcode
+			break
+		}
+	}
+	book.OwnedBy = slices.Delete(slices.Clone(book.OwnedBy), owner, owner+1)
+	book.Amount = AvailableCopies(book.Copies)
+	return barcode, nil
+}
+
+// HoldCopy sets the available copy with barcode aside for username.
+func HoldCopy(book *models.Book, barcode, username string) error {
+	i := copyIndex(book, barcode)
+	if i < 0 {
+		return &apperrors.CopyNotFoundError{Barcode: barcode}
+	}
+	if status := book.Copies[i].Status; status != models.CopyAvailable {
+		return &apperrors.CopyNotAvailableError{Barcode: barcode, Status: string(status)}
+	}
+	book.Copies = slices.Clone(book.Copies)
+	book.Copies[i].Status = models.CopyOnHold
+	book.Copies[i].HeldFor = username
+	book.Amount = AvailableCopies(book.Copies)
+	return nil
+}
+
+// UnholdCopy puts the copy with barcode back into circulation if it is still
+// on hold.
+func UnholdCopy(book *models.Book, barcode string) error {
+	i := copyIndex(book, barcode)
+	if i < 0 {
+		return &apperrors.CopyNotFoundError{Barcode: barcode}
+	}
+	if book.Copies[i].Status != models.CopyOnHold {
+		return nil
+	}
+	book.Copies = slices.Clone(book.Copies)
+	book.Copies[i].Status = models.CopyAvailable
+	book.Copies[i].HeldFor = ""
+	book.Amount = AvailableCopies(book.Copies)
+	return nil
+}
+
+// AddCopy appends copy to book. It only checks barcodes within the book;
+// callers must ensure the barcode is unused by other books.
+func AddCopy(book *models.Book, copy models.Copy) error {
+	if copyIndex(book, copy.Barcode) >= 0 {
+		return &apperrors.BarcodeAlreadyExistsError{Barcode: copy.Barcode}
+	}
+	book.Copies = append(slices.Clone(book.Copies), copy)
+	book.Amount = AvailableCopies(book.Copies)
+	return nil
+}
+
+// UpdateCopy overwrites the status, location and condition of the copy with
+// copy.Barcode. Copies on loan, on hold or withdrawn cannot be changed.
+func UpdateCopy(book *models.Book, copy models.Copy) error {
+	i := copyIndex(book, copy.Barcode)
+	if i < 0 {
+		return &apperrors.CopyNotFoundError{Barcode: copy.Barcode}
+	}
+	if err := InUse(book.Copies[i]); err != nil {
+		return err
+	}
+	book.Copies = slices.Clone(book.Copies)
+	book.Copies[i].Status = copy.Status
+	book.Copies[i].Location = copy.Location
+	book.Copies[i].Condition = copy.Condition
+	book.Amount = AvailableCopies(book.Copies)
+	return nil
+}
+
+// RemoveCopy deletes the copy with barcode from book. Copies on loan or on
+// hold cannot be removed, and neither can withdrawn copies, whose withdrawal
+// record is the audit trail of the write-off.
+func RemoveCopy(book *models.Book, barcode string) error {
+	i := copyIndex(book, barcode)
+	if i < 0 {
+		return &apperrors.CopyNotFoundError{Barcode: barcode}
+	}
+	if err := InUse(book.Copies[i]); err != nil {
+		return err
+	}
+	book.Copies = slices.Delete(slices.Clone(book.Copies), i, i+1)
+	book.Amount = AvailableCopies(book.Copies)
+	return nil
+}
+
+// WithdrawCopy takes the copy with barcode out of inventory, recording
+// withdrawal. A copy on loan is taken from its borrower, whose username is
+// returned. Copies on hold must be released first.
+func WithdrawCopy(book *models.Book, barcode string, withdrawal models.Withdrawal) (string, error) {
+	i := copyIndex(book, barcode)
+	if i < 0 {
+		return "", &apperrors.CopyNotFoundError{Barcode: barcode}
+	}
+	switch status := book.Copies[i].Status; status {
+	case models.CopyOnHold:
+		return "", &apperrors.CopyOnHoldError{Barcode: barcode}
+	case models.CopyWithdrawn:
+		return "", &apperrors.CopyWithdrawnError{Barcode: barcode}
+	}
+	borrower := book.Copies[i].BorrowedBy
+	book.Copies = slices.Clone(book.Copies)
+	book.Copies[i].Status = models.CopyWithdrawn
+	book.Copies[i].BorrowedBy = ""
+	book.Copies[i].Withdrawal = &withdrawal
+	if owner := slices.Index(book.OwnedBy, borrower); borrower != "" && owner >= 0 {
+		book.OwnedBy = slices.Delete(slices.Clone(book.OwnedBy), owner, owner+1)
+	}
+	book.Amount = AvailableCopies(book.Copies)
+	return borrower, nil
+}
+
+// Lendable reports whether copy can be lent to username.
+func Lendable(copy models.Copy, username string) bool {
+	return copy.Status == models.CopyAvailable ||
+		copy.Status == models.CopyOnHold && copy.HeldFor == username
+}
+
+// InUse returns *apperrors.CopyOnLoanError or *apperrors.CopyOnHoldError for a
+// copy a patron has or is about to collect, *apperrors.CopyWithdrawnError for
+// a copy that was written off, and nil otherwise.
+func InUse(copy models.Copy) error {
+	switch copy.Status {
+	case models.CopyOnLoan:
+		return &apperrors.CopyOnLoanError{Barcode: copy.Barcode}
+	case models.CopyOnHold:
+		return &apperrors.CopyOnHoldError{Barcode: copy.Barcode}
+	case models.CopyWithdrawn:
+		return &apperrors.CopyWithdrawnError{Barcode: copy.Barcode}
+	}
+	return nil
+}
+
+// AvailableCopies counts the copies that can be borrowed.
+func AvailableCopies(copies []models.Copy) int {
+	available := 0
+	for _, c := range copies {
+		if c.Status == models.CopyAvailable {
+			available++
+		}
+	}
+	return available
+}
+
+// LegacyCopies builds the copies of a book stored before copies existed: one
+// on loan per entry in OwnedBy and Amount available ones. Barcodes are derived
+// from the book ID.
+func LegacyCopies(book models.Book) []models.Copy {
+	var copies []models.Copy
+	for _, username := range book.OwnedBy {
+		copies = append(copies, models.Copy{
+			Barcode:    GeneratedBarcode(book.ID, len(copies)+1),
+			Status:     models.CopyOnLoan,
+			BorrowedBy: username,
+		})
+	}
+	for i := 0; i < book.Amount; i++ {
+		copies = append(copies, models.Copy{
+			Barcode: GeneratedBarcode(book.ID, len(copies)+1),
+			Status:  models.CopyAvailable,
+		})
+	}
+	return copies
+}
+
+// GeneratedBarcode returns the barcode given to the n-th copy of a book when
+// none was supplied.
+func GeneratedBarcode(bookID string, n int) string {
+	return fmt.Sprintf("%s-%03d", bookID, n)
+}
+
+func copyIndex(book *models.Book, barcode string) int {
+	return slices.IndexFunc(book.Copies, func(c models.Copy) bool { return c.Barcode == barcode })
+}
@@ -0,0 +1,13 @@
+package repository
+
+import "library_management_system/models"
+
+// CloseLoan applies closing to an open loan held in memory.
+func CloseLoan(loan *models.Loan, closing models.LoanClosing) {
+	at := closing.At
+	loan.ReturnedAt = &at
+	loan.Outcome = closing.Outcome
+	loan.Fine = closing.Fine
+	loan.ReplacementFee = closing.ReplacementFee
+	loan.CheckedInBy = closing.CheckedInBy
+}
@@ -0,0 +1,196 @@
+package memrepo
+
+import (
+	"context"
+	"library_management_system/apperrors"
+	"library_management_system/models"
+	"library_management_system/repository"
+)
+
+// BookRepository keeps books in process memory. It is meant for unit tests and
+// local demos; nothing survives a restart.
+type BookRepository struct {
+	store *Store
+}
+
+func (r *BookRepository) FindByID(ctx context.Context, id string) (*models.Book, error) {
+	defer r.store.read(ctx)()
+	book, ok := r.store.books[id]
+	if !ok {
+		return nil, &apperrors.BookNotFoundError{BookID: id}
+	}
+	book = copyBook(book)
+	return &book, nil
+}
+
+func (r *BookRepository) Find(ctx context.Context, query models.BookQuery) ([]models.Book, int64, error) {
+	defer r.store.read(ctx)()
+	books := make([]models.Book, 0, len(r.store.books))
+	for _, book := range r.store.books {
+		books = append(books, copyBook(book))
+	}
+	page, total := repository.FilterBooks(books, query)
+	return page, total, nil
+}
+
+// SearchCandidates returns every book; the catalog is small enough to rank in full.
+func (r *BookRepository) SearchCandidates(ctx context.Context, terms, prefixes []string) ([]models.Book, error) {
+	defer r.store.read(ctx)()
+	books := make([]models.Book, 0, len(r.store.books))
+	for _, book := range r.store.books {
+		books = append(books, copyBook(book))
+	}
+	return books, nil
+}
+
+func (r *BookRepository) FindByBarcode(ctx context.Context, barcode string) (*models.Book, error) {
+	defer r.store.read(ctx)()
+	for _, book := range r.store.books {
+		for _, c := range book.Copies {
+			if c.Barcode == barcode {
+				book = copyBook(book)
+				return &book, nil
+			}
+		}
+	}
+	return nil, &apperrors.CopyNotFoundError{Barcode: barcode}
+}
+
+func (r *BookRepository) Insert(ctx context.Context, book models.Book) error {
+	defer r.store.write(ctx)()
+	if _, ok := r.store.books[book.ID]; ok {
+		return &apperrors.BookWithSameIDError{BookID: book.ID}
+	}
+	for _, c := range book.Copies {
+		if r.barcodeInUse(c.Barcode) {
+			return &apperrors.BarcodeAlreadyExistsError{Barcode: c.Barcode}
+		}
+	}
+	r.store.books[book.ID] = copyBook(book)
+	return nil
+}
+
+func (r *BookRepository) Update(ctx context.Context, book models.Book) error {
+	defer r.store.write(ctx)()
+	stored, ok := r.store.books[book.ID]
+	if !ok {
+		return &apperrors.BookNotFoundError{BookID: book.ID}
+	}
+	stored.Title = book.Title
+	stored.Author = book.Author
+	r.store.books[book.ID] = stored
+	return nil
+}
+
+func (r *BookRepository) Delete(ctx context.Context, id string) error {
+	defer r.store.write(ctx)()
+	if _, ok := r.store.books[id]; !ok {
+		return &apperrors.BookNotFoundError{BookID: id}
+	}
+	delete(r.store.books, id)
+	return nil
+}
+
+func (r *BookRepository) CheckOut(ctx context.Context, id, username, barcode string) (string, error) {
+	var lent string
+	err := r.modify(ctx, id, func(book *models.Book) error {
+		var err error
+		lent, err = repository.CheckOutCopy(book, username, barcode)
+		return err
+	})
+	return lent, err
+}
+
+func (r *BookRepository) CheckIn(ctx context.Context, id, username string) (string, error) {
+	var returned string
+	err := r.modify(ctx, id, func(book *models.Book) error {
+		var err error
+		returned, err = repository.CheckInCopy(book, username)
+		return err
+	})
+	return returned, err
+}
+
+func (r *BookRepository) HoldCopy(ctx context.Context, bookID, barcode, username string) error {
+	return r.modify(ctx, bookID, func(book *models.Book) error {
+		return repository.HoldCopy(book, barcode, username)
+	})
+}
+
+func (r *BookRepository) UnholdCopy(ctx context.Context, bookID, barcode string) error {
+	return r.modify(ctx, bookID, func(book *models.Book) error {
+		return repository.UnholdCopy(book, barcode)
+	})
+}
+
+func (r *BookRepository) WithdrawCopy(ctx context.Context, bookID, barcode string, withdrawal models.Withdrawal) (string, error) {
+	var borrower string
+	err := r.modify(ctx, bookID, func(book *models.Book) error {
+		var err error
+		borrower, err = repository.WithdrawCopy(book, barcode, withdrawal)
+		return err
+	})
+	return borrower, err
+}
+
+func (r *BookRepository) AddCopy(ctx context.Context, bookID string, copy models.Copy) error {
+	return r.modify(ctx, bookID, func(book *models.Book) error {
+		if r.barcodeInUse(copy.Barcode) {
+			return &apperrors.BarcodeAlreadyExistsError{Barcode: copy.Barcode}
+		}
+		return repository.AddCopy(book, copy)
+	})
+}
+
+func (r *BookRepository) UpdateCopy(ctx context.Context, bookID string, copy models.Copy) error {
+	return r.modify(ctx, bookID, func(book *models.Book) error {
+		return repository.UpdateCopy(book, copy)
+	})
+}
+
+func (r *BookRepository) RemoveCopy(ctx context.Context, bookID, barcode string) error {
+	return r.modify(ctx, bookID, func(book *models.Book) error {
+		return repository.RemoveCopy(book, barcode)
+	})
+}
+
+// modify applies fn to the stored book under the write lock and keeps the
+// result only if fn succeeds.
+func (r *BookRepository) modify(ctx context.Context, id string, fn func(book *models.Book) error) error {
+	defer r.store.write(ctx)()
+	book, ok := r.store.books[id]
+	if !ok {
+		return &apperrors.BookNotFoundError{BookID: id}
+	}
+	book = copyBook(book)
+	if err := fn(&book); err != nil {
+		return err
+	}
+	r.store.books[id] = book
+	return nil
+}
+
+// barcodeInUse reports whether any stored book has a copy with barcode. The
+// caller must hold the store lock.
+func (r *BookRepository) barcodeInUse(barcode string) bool {
+	for _, book := range r.store.books {
+		for _, c := range book.Copies {
+			if c.Barcode == barcode {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// copyBook detaches the OwnedBy and Copies slices so callers cannot mutate
+// stored state.
+func copyBook(book models.Book) models.Book {
+	if book.OwnedBy != nil {
+		book.OwnedBy = append([]string{}, book.OwnedBy...)
+	}
+	if book.Copies != nil {
+		book.Copies = append([]models.Copy{}, book.Copies...)
+	}
+	return book
+}
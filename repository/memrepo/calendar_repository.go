@@ -0,0 +1,39 @@
+package memrepo
+
+import (
+	"context"
+	"library_management_system/models"
+)
+
+// CalendarRepository keeps the calendar in the store.
+type CalendarRepository struct {
+	store *Store
+}
+
+func (r *CalendarRepository) Get(ctx context.Context) (*models.Calendar, error) {
+	defer r.store.read(ctx)()
+	if r.store.calendar == nil {
+		return nil, nil
+	}
+	calendar := copyCalendar(*r.store.calendar)
+	return &calendar, nil
+}
+
+func (r *CalendarRepository) Save(ctx context.Context, calendar models.Calendar) error {
+	defer r.store.write(ctx)()
+	calendar = copyCalendar(calendar)
+	r.store.calendar = &calendar
+	return nil
+}
+
+// copyCalendar detaches the OpeningHours and Holidays slices so callers cannot
+// mutate stored state.
+func copyCalendar(calendar models.Calendar) models.Calendar {
+	if calendar.OpeningHours != nil {
+		calendar.OpeningHours = append([]models.OpeningHours{}, calendar.OpeningHours...)
+	}
+	if calendar.Holidays != nil {
+		calendar.Holidays = append([]models.Holiday{}, calendar.Holidays...)
+	}
+	return calendar
+}
@@ -0,0 +1,57 @@
+package memrepo
+
+import (
+	"context"
+	"library_management_system/apperrors"
+	"library_management_system/models"
+	"library_management_system/repository"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// HoldRepository keeps holds in process memory, keyed by hold ID.
+type HoldRepository struct {
+	store *Store
+}
+
+func (r *HoldRepository) Insert(ctx context.Context, hold *models.Hold) error {
+	defer r.store.write(ctx)()
+	hold.ID = primitive.NewObjectID().Hex()
+	r.store.holds[hold.ID] = *hold
+	return nil
+}
+
+func (r *HoldRepository) Update(ctx context.Context, hold models.Hold) error {
+	defer r.store.write(ctx)()
+	if _, ok := r.store.holds[hold.ID]; !ok {
+		return &apperrors.HoldNotFoundError{BookID: hold.BookID, Username: hold.Username}
+	}
+	hold.Position = 0
+	r.store.holds[hold.ID] = hold
+	return nil
+}
+
+func (r *HoldRepository) FindActive(ctx context.Context, bookID string) ([]models.Hold, error) {
+	return r.filter(ctx, func(hold models.Hold) bool { return hold.BookID == bookID && hold.Active() })
+}
+
+func (r *HoldRepository) FindExpired(ctx context.Context, at time.Time) ([]models.Hold, error) {
+	return r.filter(ctx, func(hold models.Hold) bool { return hold.Active() && !hold.ExpiresAt.After(at) })
+}
+
+func (r *HoldRepository) FindActiveByUser(ctx context.Context, username string) ([]models.Hold, error) {
+	return r.filter(ctx, func(hold models.Hold) bool { return hold.Username == username && hold.Active() })
+}
+
+func (r *HoldRepository) filter(ctx context.Context, keep func(hold models.Hold) bool) ([]models.Hold, error) {
+	defer r.store.read(ctx)()
+	holds := []models.Hold{}
+	for _, hold := range r.store.holds {
+		if keep(hold) {
+			holds = append(holds, hold)
+		}
+	}
+	repository.SortHolds(holds)
+	return holds, nil
+}
@@ -0,0 +1,64 @@
+package memrepo
+
+import (
+	"context"
+	"library_management_system/apperrors"
+	"library_management_system/models"
+	"library_management_system/repository"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// JobRepository keeps job leases and runs in the store. Leases only exclude
+// runs within one process, which is all an in-memory store can serve.
+type JobRepository struct {
+	store *Store
+}
+
+func (r *JobRepository) AcquireLease(ctx context.Context, job, owner string, slot, now, expiresAt time.Time) (bool, error) {
+	defer r.store.write(ctx)()
+	var current *models.JobLease
+	if lease, ok := r.store.leases[job]; ok {
+		current = &lease
+	}
+	if !repository.LeaseFree(current, slot, now) {
+		return false, nil
+	}
+	r.store.leases[job] = models.JobLease{Job: job, Owner: owner, Slot: slot, ExpiresAt: expiresAt}
+	return true, nil
+}
+
+func (r *JobRepository) ReleaseLease(ctx context.Context, job, owner string, now time.Time) error {
+	defer r.store.write(ctx)()
+	if lease, ok := r.store.leases[job]; ok && lease.Owner == owner {
+		lease.ExpiresAt = now
+		r.store.leases[job] = lease
+	}
+	return nil
+}
+
+func (r *JobRepository) InsertRun(ctx context.Context, run *models.JobRun) error {
+	defer r.store.write(ctx)()
+	run.ID = primitive.NewObjectID().Hex()
+	r.store.runs[run.ID] = *run
+	return nil
+}
+
+func (r *JobRepository) UpdateRun(ctx context.Context, run models.JobRun) error {
+	defer r.store.write(ctx)()
+	if _, ok := r.store.runs[run.ID]; !ok {
+		return &apperrors.JobRunNotFoundError{ID: run.ID}
+	}
+	r.store.runs[run.ID] = run
+	return nil
+}
+
+func (r *JobRepository) FindRuns(ctx context.Context, query models.JobRunQuery) ([]models.JobRun, error) {
+	defer r.store.read(ctx)()
+	runs := make([]models.JobRun, 0, len(r.store.runs))
+	for _, run := range r.store.runs {
+		runs = append(runs, run)
+	}
+	return repository.FilterJobRuns(runs, query), nil
+}
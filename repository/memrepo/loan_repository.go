@@ -0,0 +1,104 @@
+package memrepo
+
+import (
+	"context"
+	"library_management_system/apperrors"
+	"library_management_system/models"
+	"library_management_system/repository"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LoanRepository keeps loans in process memory, keyed by loan ID.
+type LoanRepository struct {
+	store *Store
+}
+
+func (r *LoanRepository) Insert(ctx context.Context, loan *models.Loan) error {
+	defer r.store.write(ctx)()
+	loan.ID = primitive.NewObjectID().Hex()
+	r.store.loans[loan.ID] = *loan
+	return nil
+}
+
+func (r *LoanRepository) FindOpen(ctx context.Context, bookID, username string) (*models.Loan, error) {
+	defer r.store.read(ctx)()
+	for _, loan := range r.store.loans {
+		if loan.BookID == bookID && loan.Username == username && loan.ReturnedAt == nil {
+			return &loan, nil
+		}
+	}
+	return nil, &apperrors.LoanNotFoundError{BookID: bookID, Username: username}
+}
+
+func (r *LoanRepository) Close(ctx context.Context, id string, closing models.LoanClosing) error {
+	defer r.store.write(ctx)()
+	loan, ok := r.store.loans[id]
+	if !ok || loan.ReturnedAt != nil {
+		return &apperrors.LoanNotFoundError{BookID: loan.BookID, Username: loan.Username}
+	}
+	repository.CloseLoan(&loan, closing)
+	r.store.loans[id] = loan
+	return nil
+}
+
+func (r *LoanRepository) Renew(ctx context.Context, id string, dueAt time.Time) error {
+	defer r.store.write(ctx)()
+	loan, ok := r.store.loans[id]
+	if !ok || loan.ReturnedAt != nil {
+		return &apperrors.LoanNotFoundError{BookID: loan.BookID, Username: loan.Username}
+	}
+	loan.DueAt = dueAt
+	loan.Renewals++
+	r.store.loans[id] = loan
+	return nil
+}
+
+func (r *LoanRepository) MarkOverdue(ctx context.Context, id string, at time.Time) error {
+	defer r.store.write(ctx)()
+	loan, ok := r.store.loans[id]
+	if !ok || loan.ReturnedAt != nil {
+		return &apperrors.LoanNotFoundError{BookID: loan.BookID, Username: loan.Username}
+	}
+	loan.OverdueAt = &at
+	r.store.loans[id] = loan
+	return nil
+}
+
+func (r *LoanRepository) MarkReminded(ctx context.Context, id string, at time.Time) error {
+	defer r.store.write(ctx)()
+	loan, ok := r.store.loans[id]
+	if !ok || loan.ReturnedAt != nil {
+		return &apperrors.LoanNotFoundError{BookID: loan.BookID, Username: loan.Username}
+	}
+	loan.RemindedAt = &at
+	r.store.loans[id] = loan
+	return nil
+}
+
+func (r *LoanRepository) FindByBook(ctx context.Context, bookID string) ([]models.Loan, error) {
+	return r.filter(ctx, func(loan models.Loan) bool { return loan.BookID == bookID })
+}
+
+func (r *LoanRepository) FindByUser(ctx context.Context, username string) ([]models.Loan, error) {
+	return r.filter(ctx, func(loan models.Loan) bool { return loan.Username == username })
+}
+
+func (r *LoanRepository) FindDueBy(ctx context.Context, at time.Time) ([]models.Loan, error) {
+	loans, err := r.filter(ctx, func(loan models.Loan) bool { return loan.ReturnedAt == nil && !loan.DueAt.After(at) })
+	repository.SortLoansByDue(loans)
+	return loans, err
+}
+
+func (r *LoanRepository) filter(ctx context.Context, keep func(loan models.Loan) bool) ([]models.Loan, error) {
+	defer r.store.read(ctx)()
+	loans := []models.Loan{}
+	for _, loan := range r.store.loans {
+		if keep(loan) {
+			loans = append(loans, loan)
+		}
+	}
+	repository.SortLoans(loans)
+	return loans, nil
+}
@@ -0,0 +1,33 @@
+package memrepo
+
+import (
+	"context"
+	"library_management_system/models"
+	"library_management_system/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PaymentRepository keeps payments in process memory, keyed by payment ID.
+type PaymentRepository struct {
+	store *Store
+}
+
+func (r *PaymentRepository) Insert(ctx context.Context, payment *models.Payment) error {
+	defer r.store.write(ctx)()
+	payment.ID = primitive.NewObjectID().Hex()
+	r.store.payments[payment.ID] = *payment
+	return nil
+}
+
+func (r *PaymentRepository) FindByUser(ctx context.Context, username string) ([]models.Payment, error) {
+	defer r.store.read(ctx)()
+	payments := []models.Payment{}
+	for _, payment := range r.store.payments {
+		if payment.Username == username {
+			payments = append(payments, payment)
+		}
+	}
+	repository.SortPayments(payments)
+	return payments, nil
+}
@@ -0,0 +1,96 @@
+package memrepo
+
+import (
+	"context"
+	"library_management_system/apperrors"
+	"library_management_system/models"
+	"library_management_system/repository"
+	"time"
+)
+
+// SessionRepository keeps sessions and revoked access tokens in the store,
+// keyed by ID.
+type SessionRepository struct {
+	store *Store
+}
+
+func (r *SessionRepository) Insert(ctx context.Context, session *models.Session) error {
+	defer r.store.write(ctx)()
+	r.store.sessions[session.ID] = *session
+	return nil
+}
+
+func (r *SessionRepository) FindByID(ctx context.Context, id string) (*models.Session, error) {
+	defer r.store.read(ctx)()
+	session, ok := r.store.sessions[id]
+	if !ok {
+		return nil, &apperrors.SessionNotFoundError{ID: id}
+	}
+	return &session, nil
+}
+
+func (r *SessionRepository) Rotate(ctx context.Context, id, previousHash string, rotation models.SessionRotation) (bool, error) {
+	defer r.store.write(ctx)()
+	session, ok := r.store.sessions[id]
+	if !ok || session.RevokedAt != nil || session.RefreshTokenHash != previousHash {
+		return false, nil
+	}
+	repository.RotateSession(&session, rotation)
+	r.store.sessions[id] = session
+	return true, nil
+}
+
+func (r *SessionRepository) Revoke(ctx context.Context, id string, at time.Time) error {
+	defer r.store.write(ctx)()
+	session, ok := r.store.sessions[id]
+	if !ok {
+		return &apperrors.SessionNotFoundError{ID: id}
+	}
+	if session.RevokedAt == nil {
+		session.RevokedAt = &at
+		r.store.sessions[id] = session
+	}
+	return nil
+}
+
+func (r *SessionRepository) FindActiveByUser(ctx context.Context, username string, at time.Time) ([]models.Session, error) {
+	defer r.store.read(ctx)()
+	sessions := []models.Session{}
+	for _, session := range r.store.sessions {
+		if session.Username == username && repository.SessionActive(session, at) {
+			sessions = append(sessions, session)
+		}
+	}
+	repository.SortSessions(sessions)
+	return sessions, nil
+}
+
+func (r *SessionRepository) RevokeToken(ctx context.Context, token models.RevokedToken) error {
+	defer r.store.write(ctx)()
+	r.store.revoked[token.ID] = token
+	return nil
+}
+
+func (r *SessionRepository) TokenRevoked(ctx context.Context, id string) (bool, error) {
+	defer r.store.read(ctx)()
+	_, ok := r.store.revoked[id]
+	return ok, nil
+}
+
+func (r *SessionRepository) DeleteExpired(ctx context.Context, at time.Time) (int, error) {
+	defer r.store.write(ctx)()
+	deleted := 0
+	for id, session := range r.store.sessions {
+		if session.ExpiresAt.Before(at) {
+			delete(r.store.sessions, id)
+			deleted++
+		}
+	}
+	for id, token := range r.store.revoked {
+		if token.ExpiresAt.Before(at) {
+			delete(r.store.revoked, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,34 @@
+package memrepo
+
+import (
+	"context"
+	"library_management_system/models"
+	"library_management_system/repository"
+)
+
+// SigningKeyRepository keeps signing keys in the store, keyed by ID.
+type SigningKeyRepository struct {
+	store *Store
+}
+
+func (r *SigningKeyRepository) Insert(ctx context.Context, key models.SigningKey) error {
+	defer r.store.write(ctx)()
+	r.store.keys[key.ID] = key
+	return nil
+}
+
+func (r *SigningKeyRepository) FindAll(ctx context.Context) ([]models.SigningKey, error) {
+	defer r.store.read(ctx)()
+	keys := make([]models.SigningKey, 0, len(r.store.keys))
+	for _, key := range r.store.keys {
+		keys = append(keys, key)
+	}
+	repository.SortSigningKeys(keys)
+	return keys, nil
+}
+
+func (r *SigningKeyRepository) Delete(ctx context.Context, id string) error {
+	defer r.store.write(ctx)()
+	delete(r.store.keys, id)
+	return nil
+}
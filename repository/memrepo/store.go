@@ -0,0 +1,198 @@
+package memrepo
+
+import (
+	"context"
+	"library_management_system/models"
+	"sync"
+)
+
+// Store holds the state shared by the in-memory repositories. A single lock
+// guards every collection so that WithinTransaction can span all of them.
+type Store struct {
+	mu       sync.RWMutex
+	books    map[string]models.Book
+	users    map[string]models.User
+	loans    map[string]models.Loan
+	holds    map[string]models.Hold
+	payments map[string]models.Payment
+	// calendar is nil until one is saved.
+	calendar *models.Calendar
+	leases   map[string]models.JobLease
+	runs     map[string]models.JobRun
+	sessions map[string]models.Session
+	revoked  map[string]models.RevokedToken
+	keys     map[string]models.SigningKey
+}
+
+type txKey struct{}
+
+// NewStore returns an empty Store.
+func NewStore() *Store {
+	return &Store{
+		books:    make(map[string]models.Book),
+		users:    make(map[string]models.User),
+		loans:    make(map[string]models.Loan),
+		holds:    make(map[string]models.Hold),
+		payments: make(map[string]models.Payment),
+		leases:   make(map[string]models.JobLease),
+		runs:     make(map[string]models.JobRun),
+		sessions: make(map[string]models.Session),
+		revoked:  make(map[string]models.RevokedToken),
+		keys:     make(map[string]models.SigningKey),
+	}
+}
+
+// Books returns a BookRepository reading and writing this store.
+func (s *Store) Books() *BookRepository {
+	return &BookRepository{store: s}
+}
+
+// Users returns a UserRepository reading and writing this store.
+func (s *Store) Users() *UserRepository {
+	return &UserRepository{store: s}
+}
+
+// Loans returns a LoanRepository reading and writing this store.
+func (s *Store) Loans() *LoanRepository {
+	return &LoanRepository{store: s}
+}
+
+// Holds returns a HoldRepository reading and writing this store.
+func (s *Store) Holds() *HoldRepository {
+	return &HoldRepository{store: s}
+}
+
+// Payments returns a PaymentRepository reading and writing this store.
+func (s *Store) Payments() *PaymentRepository {
+	return &PaymentRepository{store: s}
+}
+
+// Calendar returns a CalendarRepository reading and writing this store.
+func (s *Store) Calendar() *CalendarRepository {
+	return &CalendarRepository{store: s}
+}
+
+// Jobs returns a JobRepository reading and writing this store.
+func (s *Store) Jobs() *JobRepository {
+	return &JobRepository{store: s}
+}
+
+// Sessions returns a SessionRepository reading and writing this store.
+func (s *Store) Sessions() *SessionRepository {
+	return &SessionRepository{store: s}
+}
+
+// SigningKeys returns a SigningKeyRepository reading and writing this store.
+func (s *Store) SigningKeys() *SigningKeyRepository {
+	return &SigningKeyRepository{store: s}
+}
+
+// WithinTransaction runs fn while holding the store's write lock. If fn returns
+// an error or panics, every change it made is rolled back.
+func (s *Store) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	if ctx.Value(txKey{}) == s {
+		return fn(ctx)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := s.snapshot()
+	committed := false
+	defer func() {
+		if !committed {
+			s.books, s.users, s.loans, s.holds = snapshot.books, snapshot.users, snapshot.loans, snapshot.holds
+			s.payments = snapshot.payments
+			s.calendar, s.leases, s.runs = snapshot.calendar, snapshot.leases, snapshot.runs
+			s.sessions, s.revoked, s.keys = snapshot.sessions, snapshot.revoked, snapshot.keys
+		}
+	}()
+
+	err = fn(context.WithValue(ctx, txKey{}, s))
+	committed = err == nil
+	return err
+}
+
+// read acquires the read lock unless ctx already belongs to a transaction on
+// this store, and returns the matching release function.
+func (s *Store) read(ctx context.Context) func() {
+	if ctx.Value(txKey{}) == s {
+		return func() {}
+	}
+	s.mu.RLock()
+	return s.mu.RUnlock
+}
+
+// write acquires the write lock unless ctx already belongs to a transaction on
+// this store, and returns the matching release function.
+func (s *Store) write(ctx context.Context) func() {
+	if ctx.Value(txKey{}) == s {
+		return func() {}
+	}
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+// snapshot is a deep copy of the store's collections, taken to roll back a
+// failed transaction.
+type snapshot struct {
+	books map[string]models.Book
+	users map[string]models.User
+	loans map[string]models.Loan
+	holds map[string]models.Hold
+	// payments are never changed in place, only added.
+	payments map[string]models.Payment
+	// calendar is never changed in place, only replaced.
+	calendar *models.Calendar
+	leases   map[string]models.JobLease
+	runs     map[string]models.JobRun
+	sessions map[string]models.Session
+	revoked  map[string]models.RevokedToken
+	// keys are never changed in place, only added and deleted.
+	keys map[string]models.SigningKey
+}
+
+func (s *Store) snapshot() snapshot {
+	books := make(map[string]models.Book, len(s.books))
+	for id, book := range s.books {
+		books[id] = copyBook(book)
+	}
+	users := make(map[string]models.User, len(s.users))
+	for username, user := range s.users {
+		users[username] = copyUser(user)
+	}
+	loans := make(map[string]models.Loan, len(s.loans))
+	for id, loan := range s.loans {
+		loans[id] = loan
+	}
+	holds := make(map[string]models.Hold, len(s.holds))
+	for id, hold := range s.holds {
+		holds[id] = hold
+	}
+	payments := make(map[string]models.Payment, len(s.payments))
+	for id, payment := range s.payments {
+		payments[id] = payment
+	}
+	leases := make(map[string]models.JobLease, len(s.leases))
+	for job, lease := range s.leases {
+		leases[job] = lease
+	}
+	runs := make(map[string]models.JobRun, len(s.runs))
+	for id, run := range s.runs {
+		runs[id] = run
+	}
+	sessions := make(map[string]models.Session, len(s.sessions))
+	for id, session := range s.sessions {
+		sessions[id] = session
+	}
+	revoked := make(map[string]models.RevokedToken, len(s.revoked))
+	for id, token := range s.revoked {
+		revoked[id] = token
+	}
+	keys := make(map[string]models.SigningKey, len(s.keys))
+	for id, key := range s.keys {
+		keys[id] = key
+	}
+	return snapshot{books: books, users: users, loans: loans, holds: holds, payments: payments, calendar: s.calendar,
+		leases: leases, runs: runs, sessions: sessions, revoked: revoked, keys: keys}
+}
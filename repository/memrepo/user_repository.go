@@ -0,0 +1,195 @@
+package memrepo
+
+import (
+	"context"
+	"library_management_system/apperrors"
+	"library_management_system/models"
+	"slices"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UserRepository keeps users in process memory, keyed by username.
+type UserRepository struct {
+	store *Store
+}
+
+func (r *UserRepository) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	defer r.store.read(ctx)()
+	user, ok := r.store.users[username]
+	if !ok {
+		return nil, &apperrors.UserNotFoundError{Username: username}
+	}
+	user = copyUser(user)
+	return &user, nil
+}
+
+func (r *UserRepository) FindByCardNumber(ctx context.Context, cardNumber string) (*models.User, error) {
+	defer r.store.read(ctx)()
+	for _, user := range r.store.users {
+		if user.CardNumber == cardNumber {
+			user = copyUser(user)
+			return &user, nil
+		}
+	}
+	return nil, &apperrors.CardNotFoundError{CardNumber: cardNumber}
+}
+
+func (r *UserRepository) FindAll(ctx context.Context) ([]models.User, error) {
+	defer r.store.read(ctx)()
+	var users []models.User
+	for _, user := range r.store.users {
+		users = append(users, copyUser(user))
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users, nil
+}
+
+func (r *UserRepository) Insert(ctx context.Context, user *models.User) error {
+	defer r.store.write(ctx)()
+	if _, ok := r.store.users[user.Username]; ok {
+		return &apperrors.UsernameAlreadyExistsError{Username: user.Username}
+	}
+	if r.cardIssued(user.CardNumber) {
+		return &apperrors.CardNumberAlreadyExistsError{CardNumber: user.CardNumber}
+	}
+	user.ID = primitive.NewObjectID().Hex()
+	r.store.users[user.Username] = copyUser(*user)
+	return nil
+}
+
+func (r *UserRepository) AddBorrowedBook(ctx context.Context, username, bookID string) error {
+	defer r.store.write(ctx)()
+	user, ok := r.store.users[username]
+	if !ok {
+		return &apperrors.UserNotFoundError{Username: username}
+	}
+	if !slices.Contains(user.BorrowedBookIDs, bookID) {
+		user.BorrowedBookIDs = append(slices.Clone(user.BorrowedBookIDs), bookID)
+		r.store.users[username] = user
+	}
+	return nil
+}
+
+func (r *UserRepository) RemoveBorrowedBook(ctx context.Context, username, bookID string) error {
+	defer r.store.write(ctx)()
+	user, ok := r.store.users[username]
+	if !ok {
+		return &apperrors.UserNotFoundError{Username: username}
+	}
+	if i := slices.Index(user.BorrowedBookIDs, bookID); i >= 0 {
+		user.BorrowedBookIDs = slices.Delete(slices.Clone(user.BorrowedBookIDs), i, i+1)
+		r.store.users[username] = user
+	}
+	return nil
+}
+
+func (r *UserRepository) SetCardNumber(ctx context.Context, username, cardNumber string) error {
+	defer r.store.write(ctx)()
+	user, ok := r.store.users[username]
+	if !ok {
+		return &apperrors.UserNotFoundError{Username: username}
+	}
+	if r.cardIssued(cardNumber) {
+		return &apperrors.CardNumberAlreadyExistsError{CardNumber: cardNumber}
+	}
+	user.CardNumber = cardNumber
+	r.store.users[username] = user
+	return nil
+}
+
+// cardIssued reports whether a user already holds cardNumber. The caller
+// holds the store lock.
+func (r *UserRepository) cardIssued(cardNumber string) bool {
+	if cardNumber == "" {
+		return false
+	}
+	for _, user := range r.store.users {
+		if user.CardNumber == cardNumber {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *UserRepository) SetLoanLimit(ctx context.Context, username string, limit *int) error {
+	defer r.store.write(ctx)()
+	user, ok := r.store.users[username]
+	if !ok {
+		return &apperrors.UserNotFoundError{Username: username}
+	}
+	user.LoanLimit = limit
+	r.store.users[username] = copyUser(user)
+	return nil
+}
+
+func (r *UserRepository) SetRoles(ctx context.Context, username string, roles []string) error {
+	defer r.store.write(ctx)()
+	user, ok := r.store.users[username]
+	if !ok {
+		return &apperrors.UserNotFoundError{Username: username}
+	}
+	user.Roles = roles
+	r.store.users[username] = copyUser(user)
+	return nil
+}
+
+func (r *UserRepository) SetDisabled(ctx context.Context, username string, disabled bool) error {
+	defer r.store.write(ctx)()
+	user, ok := r.store.users[username]
+	if !ok {
+		return &apperrors.UserNotFoundError{Username: username}
+	}
+	user.Disabled = disabled
+	r.store.users[username] = user
+	return nil
+}
+
+func (r *UserRepository) SetPassword(ctx context.Context, username, password string) error {
+	defer r.store.write(ctx)()
+	user, ok := r.store.users[username]
+	if !ok {
+		return &apperrors.UserNotFoundError{Username: username}
+	}
+	user.Password = password
+	r.store.users[username] = user
+	return nil
+}
+
+func (r *UserRepository) SetContact(ctx context.Context, username, email, phone string) error {
+	defer r.store.write(ctx)()
+	user, ok := r.store.users[username]
+	if !ok {
+		return &apperrors.UserNotFoundError{Username: username}
+	}
+	user.Email = email
+	user.Phone = phone
+	r.store.users[username] = user
+	return nil
+}
+
+func (r *UserRepository) Delete(ctx context.Context, username string) error {
+	defer r.store.write(ctx)()
+	if _, ok := r.store.users[username]; !ok {
+		return &apperrors.UserNotFoundError{Username: username}
+	}
+	delete(r.store.users, username)
+	return nil
+}
+
+// copyUser detaches the Roles and BorrowedBookIDs slices and LoanLimit so
+// callers cannot mutate stored state.
+func copyUser(user models.User) models.User {
+	if user.Roles != nil {
+		user.Roles = append([]string{}, user.Roles...)
+	}
+	if user.BorrowedBookIDs != nil {
+		user.BorrowedBookIDs = append([]string{}, user.BorrowedBookIDs...)
+	}
+	if user.LoanLimit != nil {
+		limit := *user.LoanLimit
+		user.LoanLimit = &limit
+	}
+	return user
+}
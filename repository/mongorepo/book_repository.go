@@ -0,0 +1,543 @@
+package mongorepo
+
+import (
+	"context"
+	"fmt"
+	"library_management_system/apperrors"
+	"library_management_system/config/dbconfig"
+	"library_management_system/models"
+	"library_management_system/repository"
+	"library_management_system/search"
+	"regexp"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// sortFields maps the sort keys of models.BookQuery to document fields.
+var sortFields = map[string]string{
+	models.SortByTitle:     dbconfig.Title,
+	models.SortByAuthor:    dbconfig.Author,
+	models.SortByAvailable: dbconfig.Amount,
+}
+
+// bookDocument is the stored form of a book. SearchTerms feeds the multikey
+// index that SearchCandidates queries.
+type bookDocument struct {
+	models.Book `bson:",inline"`
+	SearchTerms []string `bson:"search_terms"`
+}
+
+// BookRepository stores books in a MongoDB collection.
+type BookRepository struct {
+	collection *mongo.Collection
+}
+
+// NewBookRepository returns a BookRepository backed by the given collection.
+func NewBookRepository(collection *mongo.Collection) *BookRepository {
+	return &BookRepository{collection: collection}
+}
+
+func (r *BookRepository) FindByID(ctx context.Context, id string) (*models.Book, error) {
+	var book models.Book
+	filter := bson.M{dbconfig.ID: id}
+	err := r.collection.FindOne(ctx, filter).Decode(&book)
+	if err == mongo.ErrNoDocuments {
+		return nil, &apperrors.BookNotFoundError{BookID: id}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &book, nil
+}
+
+func (r *BookRepository) Find(ctx context.Context, query models.BookQuery) ([]models.Book, int64, error) {
+	filter := bson.M{}
+	if query.Author != "" {
+		filter[dbconfig.Author] = query.Author
+	}
+	if query.TitlePrefix != "" {
+		filter[dbconfig.Title] = bson.M{dbconfig.RegexOperator: "^" + regexp.QuoteMeta(query.TitlePrefix)}
+	}
+	if query.Available != nil {
+		if *query.Available {
+			filter[dbconfig.Amount] = bson.M{dbconfig.GreaterThanOperator: 0}
+		} else {
+			filter[dbconfig.Amount] = bson.M{dbconfig.LessThanOrEqualOperator: 0}
+		}
+	}
+
+	total, err := r.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	direction := 1
+	if query.Order == models.OrderDescending {
+		direction = -1
+	}
+	sort := bson.D{}
+	if field, ok := sortFields[query.SortBy]; ok {
+		sort = append(sort, bson.E{Key: field, Value: direction})
+	}
+	sort = append(sort, bson.E{Key: dbconfig.ID, Value: 1})
+	opts := options.Find().SetSort(sort).SetSkip(int64(query.Offset))
+	if query.Limit > 0 {
+		opts.SetLimit(int64(query.Limit))
+	}
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	books := []models.Book{}
+	for cursor.Next(ctx) {
+		var book models.Book
+		if err := cursor.Decode(&book); err != nil {
+			return nil, 0, err
+		}
+		books = append(books, book)
+	}
+	return books, total, cursor.Err()
+}
+
+func (r *BookRepository) SearchCandidates(ctx context.Context, terms, prefixes []string) ([]models.Book, error) {
+	conditions := bson.A{}
+	for _, term := range terms {
+		conditions = append(conditions, bson.M{dbconfig.SearchTerms: term})
+	}
+	for _, prefix := range prefixes {
+		conditions = append(conditions, bson.M{dbconfig.SearchTerms: bson.M{dbconfig.RegexOperator: "^" + regexp.QuoteMeta(prefix)}})
+	}
+	filter := bson.M{}
+	if len(conditions) > 0 {
+		filter[dbconfig.AndOperator] = conditions
+	}
+
+	cursor, err := r.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var books []models.Book
+	for cursor.Next(ctx) {
+		var book models.Book
+		if err := cursor.Decode(&book); err != nil {
+			return nil, err
+		}
+		books = append(books, book)
+	}
+	return books, cursor.Err()
+}
+
+func (r *BookRepository) FindByBarcode(ctx context.Context, barcode string) (*models.Book, error) {
+	var book models.Book
+	err := r.collection.FindOne(ctx, bson.M{copiesField(dbconfig.Barcode): barcode}).Decode(&book)
+	if err == mongo.ErrNoDocuments {
+		return nil, &apperrors.CopyNotFoundError{Barcode: barcode}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &book, nil
+}
+
+func (r *BookRepository) Insert(ctx context.Context, book models.Book) error {
+	if book.OwnedBy == nil {
+		book.OwnedBy = []string{}
+	}
+	if book.Copies == nil {
+		book.Copies = []models.Copy{}
+	}
+	_, err := r.collection.InsertOne(ctx, bookDocument{Book: book, SearchTerms: search.BookTerms(book)})
+	if !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+
+	// Either the ID or one of the barcodes collided with the unique indexes.
+	if _, findErr := r.FindByID(ctx, book.ID); findErr == nil {
+		return &apperrors.BookWithSameIDError{BookID: book.ID}
+	}
+	for _, c := range book.Copies {
+		if _, findErr := r.FindByBarcode(ctx, c.Barcode); findErr == nil {
+			return &apperrors.BarcodeAlreadyExistsError{Barcode: c.Barcode}
+		}
+	}
+	return err
+}
+
+func (r *BookRepository) Update(ctx context.Context, book models.Book) error {
+	update := bson.M{dbconfig.SetOperator: bson.M{
+		dbconfig.Title:       book.Title,
+		dbconfig.Author:      book.Author,
+		dbconfig.SearchTerms: search.BookTerms(book),
+	}}
+	result, err := r.collection.UpdateByID(ctx, book.ID, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return &apperrors.BookNotFoundError{BookID: book.ID}
+	}
+	return nil
+}
+
+func (r *BookRepository) Delete(ctx context.Context, id string) error {
+	result, err := r.collection.DeleteOne(ctx, bson.M{dbconfig.ID: id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return &apperrors.BookNotFoundError{BookID: id}
+	}
+	return nil
+}
+
+// CheckOut lends a copy with a single conditional update guarded on the copy
+// being available and username not already holding the book, so concurrent
+// borrowers can never take more copies than exist.
+func (r *BookRepository) CheckOut(ctx context.Context, id, username, barcode string) (string, error) {
+	wanted := bson.M{dbconfig.Status: models.CopyAvailable}
+	if barcode != "" {
+		wanted[dbconfig.Barcode] = barcode
+	}
+	lent, err := r.lend(ctx, id, username, wanted, -1)
+	if err != mongo.ErrNoDocuments {
+		return lent, err
+	}
+	if barcode != "" {
+		// A copy on hold is lent only to its holder. It left the available
+		// count when it was set aside.
+		held := bson.M{dbconfig.Barcode: barcode, dbconfig.Status: models.CopyOnHold, dbconfig.HeldFor: username}
+		lent, err = r.lend(ctx, id, username, held, 0)
+		if err != mongo.ErrNoDocuments {
+			return lent, err
+		}
+	}
+
+	// The guard did not match; replay the checks on the current document to
+	// report which condition failed.
+	current, err := r.FindByID(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	_, err = repository.CheckOutCopy(current, username, barcode)
+	if err == nil {
+		err = &apperrors.AmountIsZeroError{BookTitle: current.Title}
+	}
+	return "", err
+}
+
+// lend puts the first copy matching wanted on loan to username, adjusting the
+// amount by increment, and returns its barcode. It returns
+// mongo.ErrNoDocuments when no copy matched or username already has the book.
+func (r *BookRepository) lend(ctx context.Context, id, username string, wanted bson.M, increment int) (string, error) {
+	filter := bson.M{
+		dbconfig.ID:      id,
+		dbconfig.OwnedBy: bson.M{dbconfig.NotEqualOperator: username},
+		dbconfig.Copies:  bson.M{dbconfig.ElemMatchOperator: wanted},
+	}
+	update := bson.M{
+		dbconfig.SetOperator: bson.M{
+			positionalCopyField(dbconfig.Status):     models.CopyOnLoan,
+			positionalCopyField(dbconfig.BorrowedBy): username,
+			positionalCopyField(dbconfig.HeldFor):    "",
+		},
+		dbconfig.IncOperator:  bson.M{dbconfig.Amount: increment},
+		dbconfig.PushOperator: bson.M{dbconfig.OwnedBy: username},
+	}
+
+	var book models.Book
+	err := r.collection.FindOneAndUpdate(ctx, filter, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&book)
+	if err != nil {
+		return "", err
+	}
+	for _, c := range book.Copies {
+		if c.Status == models.CopyOnLoan && c.BorrowedBy == username {
+			return c.Barcode, nil
+		}
+	}
+	return "", nil
+}
+
+// CheckIn returns the copy lent to username with a single conditional update.
+// The guard matches only the copies array, not owned_by as well: with two
+// arrays in the filter the positional $ may point into either of them.
+func (r *BookRepository) CheckIn(ctx context.Context, id, username string) (string, error) {
+	filter := bson.M{
+		dbconfig.ID: id,
+		dbconfig.Copies: bson.M{dbconfig.ElemMatchOperator: bson.M{
+			dbconfig.Status:     models.CopyOnLoan,
+			dbconfig.BorrowedBy: username,
+		}},
+	}
+	update := bson.M{
+		dbconfig.SetOperator: bson.M{
+			positionalCopyField(dbconfig.Status):     models.CopyAvailable,
+			positionalCopyField(dbconfig.BorrowedBy): "",
+		},
+		dbconfig.IncOperator:  bson.M{dbconfig.Amount: 1},
+		dbconfig.PullOperator: bson.M{dbconfig.OwnedBy: username},
+	}
+
+	var book models.Book
+	err := r.collection.FindOneAndUpdate(ctx, filter, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.Before)).Decode(&book)
+	if err == nil {
+		for _, c := range book.Copies {
+			if c.Status == models.CopyOnLoan && c.BorrowedBy == username {
+				return c.Barcode, nil
+			}
+		}
+		return "", nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return "", err
+	}
+
+	current, err := r.FindByID(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return "", &apperrors.BookNotBorrowedError{BookTitle: current.Title}
+}
+
+func (r *BookRepository) AddCopy(ctx context.Context, bookID string, copy models.Copy) error {
+	filter := bson.M{dbconfig.ID: bookID, copiesField(dbconfig.Barcode): bson.M{dbconfig.NotEqualOperator: copy.Barcode}}
+	increment := 0
+	if copy.Status == models.CopyAvailable {
+		increment = 1
+	}
+	update := bson.M{
+		dbconfig.PushOperator: bson.M{dbconfig.Copies: copy},
+		dbconfig.IncOperator:  bson.M{dbconfig.Amount: increment},
+	}
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if mongo.IsDuplicateKeyError(err) {
+		return &apperrors.BarcodeAlreadyExistsError{Barcode: copy.Barcode}
+	}
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		if _, err := r.FindByID(ctx, bookID); err != nil {
+			return err
+		}
+		return &apperrors.BarcodeAlreadyExistsError{Barcode: copy.Barcode}
+	}
+	return nil
+}
+
+// HoldCopy sets an available copy aside for username with a single positional
+// update.
+func (r *BookRepository) HoldCopy(ctx context.Context, bookID, barcode, username string) error {
+	return r.setCopyAside(ctx, bookID, barcode, models.CopyAvailable, bson.M{
+		positionalCopyField(dbconfig.Status):  models.CopyOnHold,
+		positionalCopyField(dbconfig.HeldFor): username,
+	}, -1)
+}
+
+// UnholdCopy makes a copy on hold available again; a copy that is no longer
+// on hold is left as it is.
+func (r *BookRepository) UnholdCopy(ctx context.Context, bookID, barcode string) error {
+	err := r.setCopyAside(ctx, bookID, barcode, models.CopyOnHold, bson.M{
+		positionalCopyField(dbconfig.Status):  models.CopyAvailable,
+		positionalCopyField(dbconfig.HeldFor): "",
+	}, 1)
+	if _, ok := err.(*apperrors.CopyNotAvailableError); ok {
+		// The copy is no longer on hold; there is nothing to release.
+		return nil
+	}
+	return err
+}
+
+// setCopyAside applies changes to the copy with barcode when it has status
+// from, adjusting the amount by increment.
+func (r *BookRepository) setCopyAside(ctx context.Context, bookID, barcode string, from models.CopyStatus, changes bson.M, increment int) error {
+	filter := bson.M{
+		dbconfig.ID: bookID,
+		dbconfig.Copies: bson.M{dbconfig.ElemMatchOperator: bson.M{
+			dbconfig.Barcode: barcode,
+			dbconfig.Status:  from,
+		}},
+	}
+	update := bson.M{
+		dbconfig.SetOperator: changes,
+		dbconfig.IncOperator: bson.M{dbconfig.Amount: increment},
+	}
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	book, err := r.FindByID(ctx, bookID)
+	if err != nil {
+		return err
+	}
+	for _, c := range book.Copies {
+		if c.Barcode == barcode {
+			return &apperrors.CopyNotAvailableError{Barcode: barcode, Status: string(c.Status)}
+		}
+	}
+	return &apperrors.CopyNotFoundError{Barcode: barcode}
+}
+
+// UpdateCopy rewrites one copy and recomputes the amount in a single pipeline
+// update guarded on the copy not being on loan or on hold.
+func (r *BookRepository) UpdateCopy(ctx context.Context, bookID string, copy models.Copy) error {
+	changes := bson.M{
+		dbconfig.Status:    copy.Status,
+		dbconfig.Location:  copy.Location,
+		dbconfig.Condition: copy.Condition,
+	}
+	copies := bson.M{dbconfig.MapOperator: bson.M{
+		"input": "$" + dbconfig.Copies,
+		"in": bson.M{dbconfig.CondOperator: bson.A{
+			bson.M{dbconfig.EqualOperator: bson.A{"$$this." + dbconfig.Barcode, copy.Barcode}},
+			bson.M{dbconfig.MergeObjectsOperator: bson.A{"$$this", changes}},
+			"$$this",
+		}},
+	}}
+	return r.rewriteCopy(ctx, bookID, copy.Barcode, copies)
+}
+
+// RemoveCopy drops one copy and recomputes the amount in a single pipeline
+// update guarded on the copy not being on loan or on hold.
+func (r *BookRepository) RemoveCopy(ctx context.Context, bookID, barcode string) error {
+	copies := bson.M{dbconfig.FilterOperator: bson.M{
+		"input": "$" + dbconfig.Copies,
+		"cond":  bson.M{dbconfig.NotEqualOperator: bson.A{"$$this." + dbconfig.Barcode, barcode}},
+	}}
+	return r.rewriteCopy(ctx, bookID, barcode, copies)
+}
+
+// withdrawAttempts is how many times WithdrawCopy rereads a copy that keeps
+// changing under it before giving up.
+const withdrawAttempts = 5
+
+// WithdrawCopy marks a copy withdrawn, drops its borrower from owned_by and
+// recomputes the amount in a single pipeline update guarded on the copy still
+// being lent to the borrower read beforehand.
+func (r *BookRepository) WithdrawCopy(ctx context.Context, bookID, barcode string, withdrawal models.Withdrawal) (string, error) {
+	for attempt := 0; attempt < withdrawAttempts; attempt++ {
+		book, err := r.FindByID(ctx, bookID)
+		if err != nil {
+			return "", err
+		}
+		borrower, err := repository.WithdrawCopy(book, barcode, withdrawal)
+		if err != nil {
+			return "", err
+		}
+
+		filter := bson.M{
+			dbconfig.ID: bookID,
+			dbconfig.Copies: bson.M{dbconfig.ElemMatchOperator: bson.M{
+				dbconfig.Barcode:    barcode,
+				dbconfig.Status:     bson.M{dbconfig.NotInOperator: bson.A{models.CopyOnHold, models.CopyWithdrawn}},
+				dbconfig.BorrowedBy: borrowedBy(borrower),
+			}},
+		}
+		changes := bson.M{
+			dbconfig.Status:     models.CopyWithdrawn,
+			dbconfig.BorrowedBy: "",
+			dbconfig.Withdrawal: bson.M{dbconfig.LiteralOperator: withdrawal},
+		}
+		copies := bson.M{dbconfig.MapOperator: bson.M{
+			"input": "$" + dbconfig.Copies,
+			"in": bson.M{dbconfig.CondOperator: bson.A{
+				bson.M{dbconfig.EqualOperator: bson.A{"$$this." + dbconfig.Barcode, barcode}},
+				bson.M{dbconfig.MergeObjectsOperator: bson.A{"$$this", changes}},
+				"$$this",
+			}},
+		}}
+		owners := bson.M{dbconfig.FilterOperator: bson.M{
+			"input": "$" + dbconfig.OwnedBy,
+			"cond":  bson.M{dbconfig.NotEqualOperator: bson.A{"$$this", borrower}},
+		}}
+		update := mongo.Pipeline{
+			{{Key: dbconfig.SetOperator, Value: bson.M{dbconfig.Copies: copies, dbconfig.OwnedBy: owners}}},
+			{{Key: dbconfig.SetOperator, Value: bson.M{dbconfig.Amount: availableCount}}},
+		}
+		result, err := r.collection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return "", err
+		}
+		if result.MatchedCount > 0 {
+			return borrower, nil
+		}
+		// The copy was lent or returned since it was read; try again with
+		// its current borrower.
+	}
+	return "", fmt.Errorf("copy %s of book %s kept changing while being withdrawn, gave up after %d attempts",
+		barcode, bookID, withdrawAttempts)
+}
+
+// borrowedBy matches the borrowed_by field of a copy lent to borrower. A copy
+// that is not lent may have an empty borrower or, if stored before the field
+// existed, none at all.
+func borrowedBy(borrower string) interface{} {
+	if borrower == "" {
+		return bson.M{dbconfig.InOperator: bson.A{"", nil}}
+	}
+	return borrower
+}
+
+// rewriteCopy replaces the copies array with the copies expression and then
+// recounts the available copies, provided the copy with barcode exists and is
+// neither on loan, on hold nor withdrawn.
+func (r *BookRepository) rewriteCopy(ctx context.Context, bookID, barcode string, copies bson.M) error {
+	filter := bson.M{
+		dbconfig.ID: bookID,
+		dbconfig.Copies: bson.M{dbconfig.ElemMatchOperator: bson.M{
+			dbconfig.Barcode: barcode,
+			dbconfig.Status:  bson.M{dbconfig.NotInOperator: bson.A{models.CopyOnLoan, models.CopyOnHold, models.CopyWithdrawn}},
+		}},
+	}
+	update := mongo.Pipeline{
+		{{Key: dbconfig.SetOperator, Value: bson.M{dbconfig.Copies: copies}}},
+		{{Key: dbconfig.SetOperator, Value: bson.M{dbconfig.Amount: availableCount}}},
+	}
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	book, err := r.FindByID(ctx, bookID)
+	if err != nil {
+		return err
+	}
+	for _, c := range book.Copies {
+		if c.Barcode == barcode {
+			if err := repository.InUse(c); err != nil {
+				return err
+			}
+			return &apperrors.CopyNotAvailableError{Barcode: barcode, Status: string(c.Status)}
+		}
+	}
+	return &apperrors.CopyNotFoundError{Barcode: barcode}
+}
+
+// availableCount is an aggregation expression counting available copies.
+var availableCount = bson.M{dbconfig.SizeOperator: bson.M{dbconfig.FilterOperator: bson.M{
+	"input": "$" + dbconfig.Copies,
+	"cond":  bson.M{dbconfig.EqualOperator: bson.A{"$$this." + dbconfig.Status, models.CopyAvailable}},
+}}}
+
+func copiesField(field string) string {
+	return dbconfig.Copies + "." + field
+}
+
+func positionalCopyField(field string) string {
+	return dbconfig.Copies + ".$." + field
+}
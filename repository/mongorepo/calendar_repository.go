@@ -0,0 +1,50 @@
+package mongorepo
+
+import (
+	"context"
+	"library_management_system/config/dbconfig"
+	"library_management_system/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// calendarID is the _id of the one calendar document.
+const calendarID = "calendar"
+
+// calendarDocument is the stored form of the calendar.
+type calendarDocument struct {
+	ID              string `bson:"_id"`
+	models.Calendar `bson:",inline"`
+}
+
+// CalendarRepository stores the calendar as a single document in a MongoDB
+// collection.
+type CalendarRepository struct {
+	collection *mongo.Collection
+}
+
+// NewCalendarRepository returns a CalendarRepository backed by the given
+// collection.
+func NewCalendarRepository(collection *mongo.Collection) *CalendarRepository {
+	return &CalendarRepository{collection: collection}
+}
+
+func (r *CalendarRepository) Get(ctx context.Context) (*models.Calendar, error) {
+	var document calendarDocument
+	err := r.collection.FindOne(ctx, bson.M{dbconfig.ID: calendarID}).Decode(&document)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &document.Calendar, nil
+}
+
+func (r *CalendarRepository) Save(ctx context.Context, calendar models.Calendar) error {
+	_, err := r.collection.ReplaceOne(ctx, bson.M{dbconfig.ID: calendarID},
+		calendarDocument{ID: calendarID, Calendar: calendar}, options.Replace().SetUpsert(true))
+	return err
+}
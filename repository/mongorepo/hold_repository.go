@@ -0,0 +1,80 @@
+package mongorepo
+
+import (
+	"context"
+	"library_management_system/apperrors"
+	"library_management_system/config/dbconfig"
+	"library_management_system/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// HoldRepository stores holds in a MongoDB collection.
+type HoldRepository struct {
+	collection *mongo.Collection
+}
+
+// NewHoldRepository returns a HoldRepository backed by the given collection.
+func NewHoldRepository(collection *mongo.Collection) *HoldRepository {
+	return &HoldRepository{collection: collection}
+}
+
+// queueOrder orders holds the way HoldRepository returns them.
+var queueOrder = bson.D{{Key: dbconfig.PlacedAt, Value: 1}, {Key: dbconfig.ID, Value: 1}}
+
+// activeStatuses matches the statuses of holds still in the queue.
+var activeStatuses = bson.M{dbconfig.InOperator: bson.A{models.HoldWaiting, models.HoldReady}}
+
+func (r *HoldRepository) Insert(ctx context.Context, hold *models.Hold) error {
+	document := *hold
+	document.ID = primitive.NewObjectID().Hex()
+	if _, err := r.collection.InsertOne(ctx, document); err != nil {
+		return err
+	}
+	hold.ID = document.ID
+	return nil
+}
+
+func (r *HoldRepository) Update(ctx context.Context, hold models.Hold) error {
+	result, err := r.collection.ReplaceOne(ctx, bson.M{dbconfig.ID: hold.ID}, hold)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return &apperrors.HoldNotFoundError{BookID: hold.BookID, Username: hold.Username}
+	}
+	return nil
+}
+
+func (r *HoldRepository) FindActive(ctx context.Context, bookID string) ([]models.Hold, error) {
+	return r.find(ctx, bson.M{dbconfig.BookID: bookID, dbconfig.Status: activeStatuses})
+}
+
+func (r *HoldRepository) FindExpired(ctx context.Context, at time.Time) ([]models.Hold, error) {
+	return r.find(ctx, bson.M{
+		dbconfig.Status:    activeStatuses,
+		dbconfig.ExpiresAt: bson.M{dbconfig.LessThanOrEqualOperator: at},
+	})
+}
+
+func (r *HoldRepository) FindActiveByUser(ctx context.Context, username string) ([]models.Hold, error) {
+	return r.find(ctx, bson.M{dbconfig.Username: username, dbconfig.Status: activeStatuses})
+}
+
+func (r *HoldRepository) find(ctx context.Context, filter bson.M) ([]models.Hold, error) {
+	cursor, err := r.collection.Find(ctx, filter, options.Find().SetSort(queueOrder))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	holds := []models.Hold{}
+	if err := cursor.All(ctx, &holds); err != nil {
+		return nil, err
+	}
+	return holds, nil
+}
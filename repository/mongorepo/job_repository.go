@@ -0,0 +1,101 @@
+package mongorepo
+
+import (
+	"context"
+	"library_management_system/apperrors"
+	"library_management_system/config/dbconfig"
+	"library_management_system/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// JobRepository stores job leases and runs in two MongoDB collections. A
+// lease is one document per job, keyed by the job name, and is taken with an
+// upsert guarded on the lease being free: when another instance holds it the
+// filter misses, the upsert tries to insert a second document with the same
+// _id and fails with a duplicate key.
+type JobRepository struct {
+	leases *mongo.Collection
+	runs   *mongo.Collection
+}
+
+// NewJobRepository returns a JobRepository backed by the given collections.
+func NewJobRepository(leases, runs *mongo.Collection) *JobRepository {
+	return &JobRepository{leases: leases, runs: runs}
+}
+
+func (r *JobRepository) AcquireLease(ctx context.Context, job, owner string, slot, now, expiresAt time.Time) (bool, error) {
+	filter := bson.M{
+		dbconfig.ID:        job,
+		dbconfig.Slot:      bson.M{dbconfig.LessThanOperator: slot},
+		dbconfig.ExpiresAt: bson.M{dbconfig.LessThanOrEqualOperator: now},
+	}
+	update := bson.M{dbconfig.SetOperator: bson.M{
+		dbconfig.Owner:     owner,
+		dbconfig.Slot:      slot,
+		dbconfig.ExpiresAt: expiresAt,
+	}}
+	_, err := r.leases.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *JobRepository) ReleaseLease(ctx context.Context, job, owner string, now time.Time) error {
+	_, err := r.leases.UpdateOne(ctx, bson.M{dbconfig.ID: job, dbconfig.Owner: owner},
+		bson.M{dbconfig.SetOperator: bson.M{dbconfig.ExpiresAt: now}})
+	return err
+}
+
+func (r *JobRepository) InsertRun(ctx context.Context, run *models.JobRun) error {
+	document := *run
+	document.ID = primitive.NewObjectID().Hex()
+	if _, err := r.runs.InsertOne(ctx, document); err != nil {
+		return err
+	}
+	run.ID = document.ID
+	return nil
+}
+
+func (r *JobRepository) UpdateRun(ctx context.Context, run models.JobRun) error {
+	result, err := r.runs.UpdateOne(ctx, bson.M{dbconfig.ID: run.ID}, bson.M{dbconfig.SetOperator: bson.M{
+		dbconfig.Status:     run.Status,
+		dbconfig.FinishedAt: run.FinishedAt,
+		dbconfig.Result:     run.Result,
+		dbconfig.Error:      run.Error,
+	}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return &apperrors.JobRunNotFoundError{ID: run.ID}
+	}
+	return nil
+}
+
+func (r *JobRepository) FindRuns(ctx context.Context, query models.JobRunQuery) ([]models.JobRun, error) {
+	filter := bson.M{}
+	if query.Job != "" {
+		filter[dbconfig.Job] = query.Job
+	}
+	opts := options.Find().SetSort(bson.D{{Key: dbconfig.StartedAt, Value: -1}, {Key: dbconfig.ID, Value: -1}})
+	if query.Limit > 0 {
+		opts.SetLimit(int64(query.Limit))
+	}
+	cursor, err := r.runs.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	runs := []models.JobRun{}
+	if err := cursor.All(ctx, &runs); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
@@ -0,0 +1,120 @@
+package mongorepo
+
+import (
+	"context"
+	"library_management_system/apperrors"
+	"library_management_system/config/dbconfig"
+	"library_management_system/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LoanRepository stores loans in a MongoDB collection. Open loans have a null
+// returned_at.
+type LoanRepository struct {
+	collection *mongo.Collection
+}
+
+// NewLoanRepository returns a LoanRepository backed by the given collection.
+func NewLoanRepository(collection *mongo.Collection) *LoanRepository {
+	return &LoanRepository{collection: collection}
+}
+
+// mostRecentFirst orders loans the way LoanRepository returns them.
+var mostRecentFirst = bson.D{{Key: dbconfig.BorrowedAt, Value: -1}, {Key: dbconfig.ID, Value: -1}}
+
+func (r *LoanRepository) Insert(ctx context.Context, loan *models.Loan) error {
+	document := *loan
+	document.ID = primitive.NewObjectID().Hex()
+	if _, err := r.collection.InsertOne(ctx, document); err != nil {
+		return err
+	}
+	loan.ID = document.ID
+	return nil
+}
+
+func (r *LoanRepository) FindOpen(ctx context.Context, bookID, username string) (*models.Loan, error) {
+	var loan models.Loan
+	filter := bson.M{dbconfig.BookID: bookID, dbconfig.Username: username, dbconfig.ReturnedAt: nil}
+	err := r.collection.FindOne(ctx, filter, options.FindOne().SetSort(mostRecentFirst)).Decode(&loan)
+	if err == mongo.ErrNoDocuments {
+		return nil, &apperrors.LoanNotFoundError{BookID: bookID, Username: username}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &loan, nil
+}
+
+func (r *LoanRepository) Close(ctx context.Context, id string, closing models.LoanClosing) error {
+	closed := bson.M{
+		dbconfig.ReturnedAt: closing.At,
+		dbconfig.Fine:       closing.Fine,
+		dbconfig.Outcome:    closing.Outcome,
+	}
+	if closing.ReplacementFee != 0 {
+		closed[dbconfig.ReplacementFee] = closing.ReplacementFee
+	}
+	if closing.CheckedInBy != "" {
+		closed[dbconfig.CheckedInBy] = closing.CheckedInBy
+	}
+	return r.updateOpen(ctx, id, bson.M{dbconfig.SetOperator: closed})
+}
+
+func (r *LoanRepository) Renew(ctx context.Context, id string, dueAt time.Time) error {
+	return r.updateOpen(ctx, id, bson.M{
+		dbconfig.SetOperator: bson.M{dbconfig.DueAt: dueAt},
+		dbconfig.IncOperator: bson.M{dbconfig.Renewals: 1},
+	})
+}
+
+func (r *LoanRepository) MarkOverdue(ctx context.Context, id string, at time.Time) error {
+	return r.updateOpen(ctx, id, bson.M{dbconfig.SetOperator: bson.M{dbconfig.OverdueAt: at}})
+}
+
+func (r *LoanRepository) MarkReminded(ctx context.Context, id string, at time.Time) error {
+	return r.updateOpen(ctx, id, bson.M{dbconfig.SetOperator: bson.M{dbconfig.RemindedAt: at}})
+}
+
+// updateOpen applies update to the loan with id if it is still open.
+func (r *LoanRepository) updateOpen(ctx context.Context, id string, update bson.M) error {
+	result, err := r.collection.UpdateOne(ctx, bson.M{dbconfig.ID: id, dbconfig.ReturnedAt: nil}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return &apperrors.LoanNotFoundError{}
+	}
+	return nil
+}
+
+func (r *LoanRepository) FindByBook(ctx context.Context, bookID string) ([]models.Loan, error) {
+	return r.find(ctx, bson.M{dbconfig.BookID: bookID}, mostRecentFirst)
+}
+
+func (r *LoanRepository) FindByUser(ctx context.Context, username string) ([]models.Loan, error) {
+	return r.find(ctx, bson.M{dbconfig.Username: username}, mostRecentFirst)
+}
+
+func (r *LoanRepository) FindDueBy(ctx context.Context, at time.Time) ([]models.Loan, error) {
+	filter := bson.M{dbconfig.ReturnedAt: nil, dbconfig.DueAt: bson.M{dbconfig.LessThanOrEqualOperator: at}}
+	return r.find(ctx, filter, bson.D{{Key: dbconfig.DueAt, Value: 1}, {Key: dbconfig.ID, Value: 1}})
+}
+
+func (r *LoanRepository) find(ctx context.Context, filter bson.M, sort bson.D) ([]models.Loan, error) {
+	cursor, err := r.collection.Find(ctx, filter, options.Find().SetSort(sort))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	loans := []models.Loan{}
+	if err := cursor.All(ctx, &loans); err != nil {
+		return nil, err
+	}
+	return loans, nil
+}
@@ -0,0 +1,48 @@
+package mongorepo
+
+import (
+	"context"
+	"library_management_system/config/dbconfig"
+	"library_management_system/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PaymentRepository stores payments in a MongoDB collection.
+type PaymentRepository struct {
+	collection *mongo.Collection
+}
+
+// NewPaymentRepository returns a PaymentRepository backed by the given
+// collection.
+func NewPaymentRepository(collection *mongo.Collection) *PaymentRepository {
+	return &PaymentRepository{collection: collection}
+}
+
+func (r *PaymentRepository) Insert(ctx context.Context, payment *models.Payment) error {
+	document := *payment
+	document.ID = primitive.NewObjectID().Hex()
+	if _, err := r.collection.InsertOne(ctx, document); err != nil {
+		return err
+	}
+	payment.ID = document.ID
+	return nil
+}
+
+func (r *PaymentRepository) FindByUser(ctx context.Context, username string) ([]models.Payment, error) {
+	sort := bson.D{{Key: dbconfig.At, Value: 1}, {Key: dbconfig.ID, Value: 1}}
+	cursor, err := r.collection.Find(ctx, bson.M{dbconfig.Username: username}, options.Find().SetSort(sort))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	payments := []models.Payment{}
+	if err := cursor.All(ctx, &payments); err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
@@ -0,0 +1,114 @@
+package mongorepo
+
+import (
+	"context"
+	"library_management_system/apperrors"
+	"library_management_system/config/dbconfig"
+	"library_management_system/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SessionRepository stores sessions and revoked access tokens in two MongoDB
+// collections. Both have a TTL index on expires_at, so MongoDB also removes
+// expired records on its own.
+type SessionRepository struct {
+	sessions *mongo.Collection
+	revoked  *mongo.Collection
+}
+
+// NewSessionRepository returns a SessionRepository backed by the given
+// collections.
+func NewSessionRepository(sessions, revoked *mongo.Collection) *SessionRepository {
+	return &SessionRepository{sessions: sessions, revoked: revoked}
+}
+
+func (r *SessionRepository) Insert(ctx context.Context, session *models.Session) error {
+	_, err := r.sessions.InsertOne(ctx, session)
+	return err
+}
+
+func (r *SessionRepository) FindByID(ctx context.Context, id string) (*models.Session, error) {
+	var session models.Session
+	err := r.sessions.FindOne(ctx, bson.M{dbconfig.ID: id}).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return nil, &apperrors.SessionNotFoundError{ID: id}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *SessionRepository) Rotate(ctx context.Context, id, previousHash string, rotation models.SessionRotation) (bool, error) {
+	filter := bson.M{dbconfig.ID: id, dbconfig.RefreshTokenHash: previousHash, dbconfig.RevokedAt: nil}
+	result, err := r.sessions.UpdateOne(ctx, filter, bson.M{dbconfig.SetOperator: bson.M{
+		dbconfig.RefreshTokenHash:     rotation.RefreshTokenHash,
+		dbconfig.AccessTokenID:        rotation.AccessTokenID,
+		dbconfig.AccessTokenExpiresAt: rotation.AccessTokenExpiresAt,
+	}})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func (r *SessionRepository) Revoke(ctx context.Context, id string, at time.Time) error {
+	result, err := r.sessions.UpdateOne(ctx, bson.M{dbconfig.ID: id, dbconfig.RevokedAt: nil},
+		bson.M{dbconfig.SetOperator: bson.M{dbconfig.RevokedAt: at}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		if _, err := r.FindByID(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *SessionRepository) FindActiveByUser(ctx context.Context, username string, at time.Time) ([]models.Session, error) {
+	filter := bson.M{
+		dbconfig.Username:  username,
+		dbconfig.RevokedAt: nil,
+		dbconfig.ExpiresAt: bson.M{dbconfig.GreaterThanOperator: at},
+	}
+	cursor, err := r.sessions.Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: dbconfig.CreatedAt, Value: 1}, {Key: dbconfig.ID, Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sessions := []models.Session{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (r *SessionRepository) RevokeToken(ctx context.Context, token models.RevokedToken) error {
+	_, err := r.revoked.ReplaceOne(ctx, bson.M{dbconfig.ID: token.ID}, token, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (r *SessionRepository) TokenRevoked(ctx context.Context, id string) (bool, error) {
+	count, err := r.revoked.CountDocuments(ctx, bson.M{dbconfig.ID: id}, options.Count().SetLimit(1))
+	return count > 0, err
+}
+
+func (r *SessionRepository) DeleteExpired(ctx context.Context, at time.Time) (int, error) {
+	filter := bson.M{dbconfig.ExpiresAt: bson.M{dbconfig.LessThanOperator: at}}
+	sessions, err := r.sessions.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	tokens, err := r.revoked.DeleteMany(ctx, filter)
+	if err != nil {
+		return int(sessions.DeletedCount), err
+	}
+	return int(sessions.DeletedCount + tokens.DeletedCount), nil
+}
@@ -0,0 +1,47 @@
+package mongorepo
+
+import (
+	"context"
+	"library_management_system/config/dbconfig"
+	"library_management_system/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SigningKeyRepository stores signing keys in a MongoDB collection.
+type SigningKeyRepository struct {
+	collection *mongo.Collection
+}
+
+// NewSigningKeyRepository returns a SigningKeyRepository backed by the given
+// collection.
+func NewSigningKeyRepository(collection *mongo.Collection) *SigningKeyRepository {
+	return &SigningKeyRepository{collection: collection}
+}
+
+func (r *SigningKeyRepository) Insert(ctx context.Context, key models.SigningKey) error {
+	_, err := r.collection.InsertOne(ctx, key)
+	return err
+}
+
+func (r *SigningKeyRepository) FindAll(ctx context.Context) ([]models.SigningKey, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{},
+		options.Find().SetSort(bson.D{{Key: dbconfig.CreatedAt, Value: 1}, {Key: dbconfig.ID, Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	keys := []models.SigningKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *SigningKeyRepository) Delete(ctx context.Context, id string) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{dbconfig.ID: id})
+	return err
+}
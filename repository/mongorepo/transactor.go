@@ -0,0 +1,58 @@
+package mongorepo
+
+import (
+	"context"
+	"errors"
+	"library_management_system/config/dbconfig"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Transactor runs repository calls inside multi-document MongoDB transactions.
+//
+// Transactions need a replica set or a sharded cluster. Borrowing, returning
+// and the account changes that end sessions write several documents that
+// must change together, so NewTransactor refuses a standalone mongod rather
+// than run them unprotected.
+type Transactor struct {
+	client *mongo.Client
+}
+
+// errStandalone is returned by NewTransactor for a deployment without
+// transactions.
+var errStandalone = errors.New("MongoDB is running standalone, but the mongo backend needs transactions; " +
+	"run it as a replica set (a single member is enough: mongod --replSet rs0, then rs.initiate()) " +
+	"and add ?replicaSet=rs0 to the URI")
+
+// NewTransactor checks that the deployment behind client supports
+// transactions.
+func NewTransactor(ctx context.Context, client *mongo.Client) (*Transactor, error) {
+	var hello bson.M
+	err := client.Database(dbconfig.AdminDatabaseName).RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return nil, err
+	}
+	_, replicaSet := hello["setName"]
+	if !replicaSet && hello["msg"] != "isdbgrid" {
+		return nil, errStandalone
+	}
+	return &Transactor{client: client}, nil
+}
+
+func (t *Transactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	session, err := t.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
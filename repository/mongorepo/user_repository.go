@@ -0,0 +1,209 @@
+package mongorepo
+
+import (
+	"context"
+	"library_management_system/apperrors"
+	"library_management_system/config/dbconfig"
+	"library_management_system/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// UserRepository stores users in a MongoDB collection. Username uniqueness is
+// enforced by the unique index created by the migrations package.
+type UserRepository struct {
+	collection *mongo.Collection
+}
+
+// NewUserRepository returns a UserRepository backed by the given collection.
+func NewUserRepository(collection *mongo.Collection) *UserRepository {
+	return &UserRepository{collection: collection}
+}
+
+func (r *UserRepository) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	var user models.User
+	filter := bson.M{dbconfig.Username: username}
+	err := r.collection.FindOne(ctx, filter).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, &apperrors.UserNotFoundError{Username: username}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *UserRepository) FindByCardNumber(ctx context.Context, cardNumber string) (*models.User, error) {
+	var user models.User
+	err := r.collection.FindOne(ctx, bson.M{dbconfig.CardNumber: cardNumber}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, &apperrors.CardNotFoundError{CardNumber: cardNumber}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *UserRepository) FindAll(ctx context.Context) ([]models.User, error) {
+	var users []models.User
+	cursor, err := r.collection.Find(ctx, bson.D{{}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, cursor.Err()
+}
+
+func (r *UserRepository) Insert(ctx context.Context, user *models.User) error {
+	if user.BorrowedBookIDs == nil {
+		user.BorrowedBookIDs = []string{}
+	}
+	result, err := r.collection.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		// Both the username and the card number are unique; tell them apart.
+		if _, findErr := r.FindByUsername(ctx, user.Username); findErr == nil {
+			return &apperrors.UsernameAlreadyExistsError{Username: user.Username}
+		}
+		return &apperrors.CardNumberAlreadyExistsError{CardNumber: user.CardNumber}
+	}
+	if err != nil {
+		return err
+	}
+	user.ID = result.InsertedID.(primitive.ObjectID).Hex()
+	return nil
+}
+
+func (r *UserRepository) AddBorrowedBook(ctx context.Context, username, bookID string) error {
+	filter := bson.M{
+		dbconfig.Username:        username,
+		dbconfig.BorrowedBookIDs: bson.M{dbconfig.NotEqualOperator: bookID},
+	}
+	update := mongo.Pipeline{{{Key: dbconfig.SetOperator, Value: bson.M{
+		dbconfig.BorrowedBookIDs: bson.M{dbconfig.ConcatArraysOperator: bson.A{
+			bson.M{dbconfig.IfNullOperator: bson.A{"$" + dbconfig.BorrowedBookIDs, bson.A{}}},
+			bson.A{bookID},
+		}},
+	}}}}
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		_, err = r.FindByUsername(ctx, username)
+	}
+	return err
+}
+
+func (r *UserRepository) SetCardNumber(ctx context.Context, username, cardNumber string) error {
+	update := bson.M{dbconfig.SetOperator: bson.M{dbconfig.CardNumber: cardNumber}}
+	result, err := r.collection.UpdateOne(ctx, bson.M{dbconfig.Username: username}, update)
+	if mongo.IsDuplicateKeyError(err) {
+		return &apperrors.CardNumberAlreadyExistsError{CardNumber: cardNumber}
+	}
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return &apperrors.UserNotFoundError{Username: username}
+	}
+	return nil
+}
+
+func (r *UserRepository) SetLoanLimit(ctx context.Context, username string, limit *int) error {
+	update := bson.M{dbconfig.UnsetOperator: bson.M{dbconfig.LoanLimit: ""}}
+	if limit != nil {
+		update = bson.M{dbconfig.SetOperator: bson.M{dbconfig.LoanLimit: *limit}}
+	}
+	result, err := r.collection.UpdateOne(ctx, bson.M{dbconfig.Username: username}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return &apperrors.UserNotFoundError{Username: username}
+	}
+	return nil
+}
+
+func (r *UserRepository) RemoveBorrowedBook(ctx context.Context, username, bookID string) error {
+	filter := bson.M{dbconfig.Username: username, dbconfig.BorrowedBookIDs: bookID}
+	update := bson.M{dbconfig.PullOperator: bson.M{dbconfig.BorrowedBookIDs: bookID}}
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		_, err = r.FindByUsername(ctx, username)
+	}
+	return err
+}
+
+func (r *UserRepository) SetRoles(ctx context.Context, username string, roles []string) error {
+	return r.update(ctx, username, bson.M{dbconfig.SetOperator: bson.M{dbconfig.Roles: roles}})
+}
+
+func (r *UserRepository) SetDisabled(ctx context.Context, username string, disabled bool) error {
+	update := bson.M{dbconfig.UnsetOperator: bson.M{dbconfig.Disabled: ""}}
+	if disabled {
+		update = bson.M{dbconfig.SetOperator: bson.M{dbconfig.Disabled: true}}
+	}
+	return r.update(ctx, username, update)
+}
+
+func (r *UserRepository) SetPassword(ctx context.Context, username, password string) error {
+	return r.update(ctx, username, bson.M{dbconfig.SetOperator: bson.M{dbconfig.Password: password}})
+}
+
+// SetContact unsets empty contact details rather than storing empty strings,
+// as Insert does.
+func (r *UserRepository) SetContact(ctx context.Context, username, email, phone string) error {
+	set, unset := bson.M{}, bson.M{}
+	for field, value := range map[string]string{dbconfig.Email: email, dbconfig.Phone: phone} {
+		if value == "" {
+			unset[field] = ""
+		} else {
+			set[field] = value
+		}
+	}
+	update := bson.M{}
+	if len(set) > 0 {
+		update[dbconfig.SetOperator] = set
+	}
+	if len(unset) > 0 {
+		update[dbconfig.UnsetOperator] = unset
+	}
+	return r.update(ctx, username, update)
+}
+
+func (r *UserRepository) Delete(ctx context.Context, username string) error {
+	result, err := r.collection.DeleteOne(ctx, bson.M{dbconfig.Username: username})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return &apperrors.UserNotFoundError{Username: username}
+	}
+	return nil
+}
+
+// update applies update to the user with username.
+func (r *UserRepository) update(ctx context.Context, username string, update bson.M) error {
+	result, err := r.collection.UpdateOne(ctx, bson.M{dbconfig.Username: username}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return &apperrors.UserNotFoundError{Username: username}
+	}
+	return nil
+}
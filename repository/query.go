@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"library_management_system/models"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FilterBooks applies query to an in-memory slice the same way the database
+// backed repositories do: it filters, sorts (breaking ties by ID) and pages
+// books, returning the page and the number of books that matched.
+func FilterBooks(books []models.Book, query models.BookQuery) ([]models.Book, int64) {
+	matched := make([]models.Book, 0, len(books))
+	for _, book := range books {
+		if query.Author != "" && book.Author != query.Author {
+			continue
+		}
+		if query.TitlePrefix != "" && !strings.HasPrefix(book.Title, query.TitlePrefix) {
+			continue
+		}
+		if query.Available != nil && (book.Amount > 0) != *query.Available {
+			continue
+		}
+		matched = append(matched, book)
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		var cmp int
+		switch query.SortBy {
+		case models.SortByTitle:
+			cmp = strings.Compare(a.Title, b.Title)
+		case models.SortByAuthor:
+			cmp = strings.Compare(a.Author, b.Author)
+		case models.SortByAvailable:
+			cmp = a.Amount - b.Amount
+		}
+		if query.Order == models.OrderDescending {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp < 0
+		}
+		return a.ID < b.ID
+	})
+
+	total := int64(len(matched))
+	if query.Offset >= len(matched) {
+		return []models.Book{}, total
+	}
+	matched = matched[query.Offset:]
+	if query.Limit > 0 && query.Limit < len(matched) {
+		matched = matched[:query.Limit]
+	}
+	return matched, total
+}
+
+// SortLoans orders loans most recent first, the order LoanRepository returns
+// them in, breaking ties by ID.
+func SortLoans(loans []models.Loan) {
+	sort.Slice(loans, func(i, j int) bool {
+		if !loans[i].BorrowedAt.Equal(loans[j].BorrowedAt) {
+			return loans[i].BorrowedAt.After(loans[j].BorrowedAt)
+		}
+		return loans[i].ID > loans[j].ID
+	})
+}
+
+// SortHolds orders holds by the time they were placed, breaking ties by ID,
+// which is the order HoldRepository returns them in.
+func SortHolds(holds []models.Hold) {
+	sort.Slice(holds, func(i, j int) bool {
+		if !holds[i].PlacedAt.Equal(holds[j].PlacedAt) {
+			return holds[i].PlacedAt.Before(holds[j].PlacedAt)
+		}
+		return holds[i].ID < holds[j].ID
+	})
+}
+
+// SortPayments orders payments oldest first, breaking ties by ID, which is
+// the order PaymentRepository returns them in.
+func SortPayments(payments []models.Payment) {
+	sort.Slice(payments, func(i, j int) bool {
+		if !payments[i].At.Equal(payments[j].At) {
+			return payments[i].At.Before(payments[j].At)
+		}
+		return payments[i].ID < payments[j].ID
+	})
+}
+
+// SortLoansByDue orders loans soonest due first, breaking ties by ID, which is
+// the order LoanRepository.FindDueBy returns them in.
+func SortLoansByDue(loans []models.Loan) {
+	sort.Slice(loans, func(i, j int) bool {
+		if !loans[i].DueAt.Equal(loans[j].DueAt) {
+			return loans[i].DueAt.Before(loans[j].DueAt)
+		}
+		return loans[i].ID < loans[j].ID
+	})
+}
+
+// FilterJobRuns applies query to an in-memory slice of job runs, returning
+// them most recently started first like JobRepository.FindRuns.
+func FilterJobRuns(runs []models.JobRun, query models.JobRunQuery) []models.JobRun {
+	matched := []models.JobRun{}
+	for _, run := range runs {
+		if query.Job == "" || run.Job == query.Job {
+			matched = append(matched, run)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if !matched[i].StartedAt.Equal(matched[j].StartedAt) {
+			return matched[i].StartedAt.After(matched[j].StartedAt)
+		}
+		return matched[i].ID > matched[j].ID
+	})
+	if query.Limit > 0 && query.Limit < len(matched) {
+		matched = matched[:query.Limit]
+	}
+	return matched
+}
+
+// LeaseFree reports whether the run scheduled at slot can be claimed given the
+// current lease, which is nil for a job never run: no run at or after slot may
+// have been claimed, and an earlier run's lease must have expired by now.
+func LeaseFree(lease *models.JobLease, slot, now time.Time) bool {
+	return lease == nil || lease.Slot.Before(slot) && !lease.ExpiresAt.After(now)
+}
+
+// SessionActive reports whether session is neither revoked nor expired at at.
+func SessionActive(session models.Session, at time.Time) bool {
+	return session.RevokedAt == nil && session.ExpiresAt.After(at)
+}
+
+// SortSessions orders sessions oldest first, breaking ties by ID, which is the
+// order SessionRepository.FindActiveByUser returns them in.
+func SortSessions(sessions []models.Session) {
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].CreatedAt.Equal(sessions[j].CreatedAt) {
+			return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+}
+
+// RotateSession applies rotation to session.
+func RotateSession(session *models.Session, rotation models.SessionRotation) {
+	session.RefreshTokenHash = rotation.RefreshTokenHash
+	session.AccessTokenID = rotation.AccessTokenID
+	session.AccessTokenExpiresAt = rotation.AccessTokenExpiresAt
+}
+
+// SortSigningKeys orders keys oldest first, breaking ties by ID, which is the
+// order SigningKeyRepository.FindAll returns them in.
+func SortSigningKeys(keys []models.SigningKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+}
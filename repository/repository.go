@@ -0,0 +1,223 @@
+package repository
+
+import (
+	"context"
+	"library_management_system/models"
+	"time"
+)
+
+// BookRepository persists the library catalog.
+//
+// Implementations report a missing book with *apperrors.BookNotFoundError and a
+// duplicate book ID with *apperrors.BookWithSameIDError.
+type BookRepository interface {
+	FindByID(ctx context.Context, id string) (*models.Book, error)
+	// Find returns the page of books selected by query together with the
+	// number of books matching its filters.
+	Find(ctx context.Context, query models.BookQuery) ([]models.Book, int64, error)
+	// SearchCandidates returns books whose search terms (see search.BookTerms)
+	// include every one of terms and, for each prefix, some term starting
+	// with it. It may return extra books; callers rank and re-check them with
+	// search.Query.
+	SearchCandidates(ctx context.Context, terms, prefixes []string) ([]models.Book, error)
+	// FindByBarcode returns the book owning the copy with barcode, or
+	// *apperrors.CopyNotFoundError.
+	FindByBarcode(ctx context.Context, barcode string) (*models.Book, error)
+	// Insert stores a new book with its copies. A barcode already used by
+	// any book fails with *apperrors.BarcodeAlreadyExistsError.
+	Insert(ctx context.Context, book models.Book) error
+	// Update overwrites the title and author of the stored book.
+	Update(ctx context.Context, book models.Book) error
+	Delete(ctx context.Context, id string) error
+	// CheckOut lends username the copy with barcode, or any available copy
+	// when barcode is empty, in a single atomic step, and returns the barcode
+	// lent. A copy on hold is only lent by barcode to the patron holding it. It fails with *apperrors.AlreadyHaveBookError,
+	// *apperrors.AmountIsZeroError, *apperrors.CopyNotFoundError or
+	// *apperrors.CopyNotAvailableError instead of overselling.
+	CheckOut(ctx context.Context, id, username, barcode string) (string, error)
+	// CheckIn returns the copy held by username to circulation and returns
+	// its barcode, failing with *apperrors.BookNotBorrowedError if username
+	// does not hold one.
+	CheckIn(ctx context.Context, id, username string) (string, error)
+	// HoldCopy sets the available copy with barcode aside for username,
+	// failing with *apperrors.CopyNotAvailableError if it is not available.
+	HoldCopy(ctx context.Context, bookID, barcode, username string) error
+	// UnholdCopy returns the copy with barcode to circulation if it is on
+	// hold.
+	UnholdCopy(ctx context.Context, bookID, barcode string) error
+	// AddCopy adds a copy to the book, failing with
+	// *apperrors.BarcodeAlreadyExistsError if the barcode is taken.
+	AddCopy(ctx context.Context, bookID string, copy models.Copy) error
+	// UpdateCopy overwrites the status, location and condition of a copy
+	// that is not on loan or on hold.
+	UpdateCopy(ctx context.Context, bookID string, copy models.Copy) error
+	// RemoveCopy withdraws a copy that is not on loan or on hold.
+	RemoveCopy(ctx context.Context, bookID, barcode string) error
+	// WithdrawCopy marks a copy that is not on hold as withdrawn, recording
+	// withdrawal. A copy on loan is taken from its borrower, whose username
+	// is returned; the borrower's own record is left to the caller.
+	WithdrawCopy(ctx context.Context, bookID, barcode string, withdrawal models.Withdrawal) (string, error)
+}
+
+// UserRepository persists library accounts.
+//
+// Implementations report a missing user with *apperrors.UserNotFoundError and a
+// taken username with *apperrors.UsernameAlreadyExistsError.
+type UserRepository interface {
+	FindByUsername(ctx context.Context, username string) (*models.User, error)
+	// FindByCardNumber returns the user holding the library card, or
+	// *apperrors.CardNotFoundError.
+	FindByCardNumber(ctx context.Context, cardNumber string) (*models.User, error)
+	FindAll(ctx context.Context) ([]models.User, error)
+	// Insert stores a new user, reporting a clash on the username or on the
+	// card number.
+	Insert(ctx context.Context, user *models.User) error
+	// SetCardNumber issues a new library card to username, replacing any
+	// previous one.
+	SetCardNumber(ctx context.Context, username, cardNumber string) error
+	// AddBorrowedBook records bookID against username; adding an ID that is
+	// already present is a no-op.
+	AddBorrowedBook(ctx context.Context, username, bookID string) error
+	// RemoveBorrowedBook drops bookID from username; removing an absent ID is
+	// a no-op.
+	RemoveBorrowedBook(ctx context.Context, username, bookID string) error
+	// SetLoanLimit stores the loan limit override of username; nil removes
+	// it.
+	SetLoanLimit(ctx context.Context, username string, limit *int) error
+	// SetRoles replaces the roles of username.
+	SetRoles(ctx context.Context, username string, roles []string) error
+	// SetDisabled disables or re-enables the account of username.
+	SetDisabled(ctx context.Context, username string, disabled bool) error
+	// SetPassword replaces the password hash of username.
+	SetPassword(ctx context.Context, username, password string) error
+	// SetContact replaces the email address and phone number of username.
+	SetContact(ctx context.Context, username, email, phone string) error
+	// Delete removes username and frees their card number. Their loan and
+	// hold history is kept.
+	Delete(ctx context.Context, username string) error
+}
+
+// LoanRepository persists the borrowing history. The OwnedBy and
+// BorrowedBookIDs arrays mirror the open loans and are kept in step with them
+// by the book service.
+type LoanRepository interface {
+	// Insert stores a new loan and sets its ID.
+	Insert(ctx context.Context, loan *models.Loan) error
+	// FindOpen returns the loan of bookID to username that has not been
+	// returned, or *apperrors.LoanNotFoundError.
+	FindOpen(ctx context.Context, bookID, username string) (*models.Loan, error)
+	// Close ends the open loan with id as described by closing, fixing its
+	// fine and replacement fee.
+	Close(ctx context.Context, id string, closing models.LoanClosing) error
+	// Renew moves the due date of the open loan with id to dueAt and counts
+	// the renewal.
+	Renew(ctx context.Context, id string, dueAt time.Time) error
+	// FindByBook returns every loan of bookID, most recent first.
+	FindByBook(ctx context.Context, bookID string) ([]models.Loan, error)
+	// FindByUser returns every loan to username, most recent first.
+	FindByUser(ctx context.Context, username string) ([]models.Loan, error)
+	// FindDueBy returns the open loans due at or before at, soonest first.
+	FindDueBy(ctx context.Context, at time.Time) ([]models.Loan, error)
+	// MarkOverdue records at as when the open loan with id was found overdue.
+	MarkOverdue(ctx context.Context, id string, at time.Time) error
+	// MarkReminded records at as when the borrower of the open loan with id
+	// was reminded that it is due soon.
+	MarkReminded(ctx context.Context, id string, at time.Time) error
+}
+
+// HoldRepository persists the hold queues.
+type HoldRepository interface {
+	// Insert stores a new hold and sets its ID.
+	Insert(ctx context.Context, hold *models.Hold) error
+	// Update overwrites the status, barcode and timestamps of a stored hold.
+	Update(ctx context.Context, hold models.Hold) error
+	// FindActive returns the waiting and ready holds on bookID in the order
+	// they were placed.
+	FindActive(ctx context.Context, bookID string) ([]models.Hold, error)
+	// FindExpired returns the waiting and ready holds on any book whose
+	// ExpiresAt is not after at.
+	FindExpired(ctx context.Context, at time.Time) ([]models.Hold, error)
+	// FindActiveByUser returns the waiting and ready holds of username on any
+	// book in the order they were placed.
+	FindActiveByUser(ctx context.Context, username string) ([]models.Hold, error)
+}
+
+// PaymentRepository persists the payments and waivers set against what users
+// owe.
+type PaymentRepository interface {
+	// Insert stores a new payment and sets its ID.
+	Insert(ctx context.Context, payment *models.Payment) error
+	// FindByUser returns every payment of username, oldest first.
+	FindByUser(ctx context.Context, username string) ([]models.Payment, error)
+}
+
+// CalendarRepository persists the library calendar, of which there is one.
+type CalendarRepository interface {
+	// Get returns the saved calendar, or nil if none has been saved yet.
+	Get(ctx context.Context) (*models.Calendar, error)
+	// Save replaces the calendar.
+	Save(ctx context.Context, calendar models.Calendar) error
+}
+
+// JobRepository persists the leases that keep replicas from running the same
+// scheduled job twice, and the history of job runs.
+type JobRepository interface {
+	// AcquireLease claims for owner, until expiresAt, the run of job
+	// scheduled at slot. It reports false, without error, when a run at or
+	// after slot was already claimed or the lease of an earlier run has not
+	// expired by now.
+	AcquireLease(ctx context.Context, job, owner string, slot, now, expiresAt time.Time) (bool, error)
+	// ReleaseLease ends owner's lease on job at now. The slot stays claimed.
+	ReleaseLease(ctx context.Context, job, owner string, now time.Time) error
+	// InsertRun stores a new job run and sets its ID.
+	InsertRun(ctx context.Context, run *models.JobRun) error
+	// UpdateRun overwrites the status, finish time and outcome of a run.
+	UpdateRun(ctx context.Context, run models.JobRun) error
+	// FindRuns returns the runs matching query, most recently started first.
+	FindRuns(ctx context.Context, query models.JobRunQuery) ([]models.JobRun, error)
+}
+
+// SessionRepository persists login sessions and the access tokens revoked
+// before they expire.
+type SessionRepository interface {
+	// Insert stores a new session; its ID is chosen by the caller.
+	Insert(ctx context.Context, session *models.Session) error
+	// FindByID returns the session with id, or *apperrors.SessionNotFoundError.
+	FindByID(ctx context.Context, id string) (*models.Session, error)
+	// Rotate applies rotation to the unrevoked session with id if its
+	// refresh token hash is still previousHash, and reports whether it did.
+	Rotate(ctx context.Context, id, previousHash string, rotation models.SessionRotation) (bool, error)
+	// Revoke ends the session with id at at. Revoking a session that is
+	// already revoked keeps the first revocation time.
+	Revoke(ctx context.Context, id string, at time.Time) error
+	// FindActiveByUser returns the sessions of username that are neither
+	// revoked nor expired at at, oldest first.
+	FindActiveByUser(ctx context.Context, username string, at time.Time) ([]models.Session, error)
+	// RevokeToken records an access token as revoked.
+	RevokeToken(ctx context.Context, token models.RevokedToken) error
+	// TokenRevoked reports whether the access token with id was revoked.
+	TokenRevoked(ctx context.Context, id string) (bool, error)
+	// DeleteExpired removes the sessions and revoked tokens that expired
+	// before at and returns how many records it removed.
+	DeleteExpired(ctx context.Context, at time.Time) (int, error)
+}
+
+// SigningKeyRepository persists the keys that sign access tokens, so that
+// every server signs with and accepts the same keys.
+type SigningKeyRepository interface {
+	Insert(ctx context.Context, key models.SigningKey) error
+	// FindAll returns every key, oldest first.
+	FindAll(ctx context.Context) ([]models.SigningKey, error)
+	// Delete removes the key with id; deleting a missing key is a no-op.
+	Delete(ctx context.Context, id string) error
+}
+
+// Transactor groups repository calls into a single all-or-nothing unit.
+//
+// fn receives a derived context that must be passed to every repository call
+// that belongs to the transaction. When fn returns an error, none of its
+// writes are kept. Calling WithinTransaction with a context that is already
+// inside a transaction joins the outer transaction.
+type Transactor interface {
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
@@ -0,0 +1,599 @@
+package sqlrepo
+
+import (
+	"context"
+	"database/sql"
+	"library_management_system/apperrors"
+	"library_management_system/models"
+	"library_management_system/repository"
+	"library_management_system/search"
+	"strings"
+	"unicode/utf8"
+)
+
+// sortColumns maps the sort keys of models.BookQuery to columns.
+var sortColumns = map[string]string{
+	models.SortByTitle:     "title",
+	models.SortByAuthor:    "author",
+	models.SortByAvailable: "amount",
+}
+
+// BookRepository stores books in the books table and their borrowers in the
+// borrowings table.
+type BookRepository struct {
+	store *Store
+}
+
+func (r *BookRepository) FindByID(ctx context.Context, id string) (*models.Book, error) {
+	var book models.Book
+	err := r.store.querier(ctx).QueryRowContext(ctx,
+		`SELECT id, title, author, amount FROM books WHERE id = ?`, id,
+	).Scan(&book.ID, &book.Title, &book.Author, &book.Amount)
+	if err == sql.ErrNoRows {
+		return nil, &apperrors.BookNotFoundError{BookID: id}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	owners, err := r.store.grouped(ctx,
+		`SELECT book_id, username FROM borrowings WHERE book_id = ? ORDER BY id`, id)
+	if err != nil {
+		return nil, err
+	}
+	book.OwnedBy = owners[id]
+	copies, err := r.copies(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	book.Copies = copies[id]
+	return &book, nil
+}
+
+func (r *BookRepository) Find(ctx context.Context, query models.BookQuery) ([]models.Book, int64, error) {
+	var conditions []string
+	var args []interface{}
+	if query.Author != "" {
+		conditions = append(conditions, "author = ?")
+		args = append(args, query.Author)
+	}
+	if query.TitlePrefix != "" {
+		conditions = append(conditions, "substr(title, 1, ?) = ?")
+		args = append(args, len(query.TitlePrefix), query.TitlePrefix)
+	}
+	if query.Available != nil {
+		if *query.Available {
+			conditions = append(conditions, "amount > 0")
+		} else {
+			conditions = append(conditions, "amount <= 0")
+		}
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int64
+	err := r.store.querier(ctx).QueryRowContext(ctx, `SELECT COUNT(*) FROM books`+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	orderBy := " ORDER BY "
+	if column, ok := sortColumns[query.SortBy]; ok {
+		orderBy += column
+		if query.Order == models.OrderDescending {
+			orderBy += " DESC"
+		}
+		orderBy += ", "
+	}
+	orderBy += "id"
+	limit := query.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+
+	books, err := r.load(ctx,
+		`SELECT id, title, author, amount FROM books`+where+orderBy+` LIMIT ? OFFSET ?`,
+		append(args, limit, query.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return books, total, nil
+}
+
+// SearchCandidates intersects the book_terms index for every term and prefix.
+func (r *BookRepository) SearchCandidates(ctx context.Context, terms, prefixes []string) ([]models.Book, error) {
+	var conditions []string
+	var args []interface{}
+	for _, term := range terms {
+		conditions = append(conditions, `id IN (SELECT book_id FROM book_terms WHERE term = ?)`)
+		args = append(args, term)
+	}
+	for _, prefix := range prefixes {
+		// A range scan keeps the prefix match on the term index.
+		conditions = append(conditions, `id IN (SELECT book_id FROM book_terms WHERE term >= ? AND term < ?)`)
+		args = append(args, prefix, prefix+string(utf8.MaxRune))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	return r.load(ctx, `SELECT id, title, author, amount FROM books`+where, args...)
+}
+
+func (r *BookRepository) FindByBarcode(ctx context.Context, barcode string) (*models.Book, error) {
+	var id string
+	err := r.store.querier(ctx).QueryRowContext(ctx,
+		`SELECT book_id FROM copies WHERE barcode = ?`, barcode).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, &apperrors.CopyNotFoundError{Barcode: barcode}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.FindByID(ctx, id)
+}
+
+func (r *BookRepository) Insert(ctx context.Context, book models.Book) error {
+	return r.store.WithinTransaction(ctx, func(ctx context.Context) error {
+		_, err := r.store.querier(ctx).ExecContext(ctx,
+			`INSERT INTO books (id, title, author, amount) VALUES (?, ?, ?, ?)`,
+			book.ID, book.Title, book.Author, repository.AvailableCopies(book.Copies))
+		if err != nil {
+			if found, _ := r.store.exists(ctx, `SELECT 1 FROM books WHERE id = ?`, book.ID); found {
+				return &apperrors.BookWithSameIDError{BookID: book.ID}
+			}
+			return err
+		}
+		for _, c := range book.Copies {
+			if err := r.insertCopy(ctx, book.ID, c); err != nil {
+				return err
+			}
+		}
+		return r.writeTerms(ctx, book)
+	})
+}
+
+func (r *BookRepository) Update(ctx context.Context, book models.Book) error {
+	return r.store.WithinTransaction(ctx, func(ctx context.Context) error {
+		result, err := r.store.querier(ctx).ExecContext(ctx,
+			`UPDATE books SET title = ?, author = ? WHERE id = ?`,
+			book.Title, book.Author, book.ID)
+		if err != nil {
+			return err
+		}
+		if err := bookAffected(result, book.ID); err != nil {
+			return err
+		}
+		return r.writeTerms(ctx, book)
+	})
+}
+
+func (r *BookRepository) Delete(ctx context.Context, id string) error {
+	return r.store.WithinTransaction(ctx, func(ctx context.Context) error {
+		_, err := r.store.querier(ctx).ExecContext(ctx, `DELETE FROM book_terms WHERE book_id = ?`, id)
+		if err != nil {
+			return err
+		}
+		_, err = r.store.querier(ctx).ExecContext(ctx, `DELETE FROM copies WHERE book_id = ?`, id)
+		if err != nil {
+			return err
+		}
+		result, err := r.store.querier(ctx).ExecContext(ctx, `DELETE FROM books WHERE id = ?`, id)
+		if err != nil {
+			return err
+		}
+		return bookAffected(result, id)
+	})
+}
+
+// writeTerms replaces the search terms indexed for book.
+func (r *BookRepository) writeTerms(ctx context.Context, book models.Book) error {
+	_, err := r.store.querier(ctx).ExecContext(ctx, `DELETE FROM book_terms WHERE book_id = ?`, book.ID)
+	if err != nil {
+		return err
+	}
+	for _, term := range search.BookTerms(book) {
+		_, err := r.store.querier(ctx).ExecContext(ctx,
+			`INSERT INTO book_terms (book_id, term) VALUES (?, ?)`, book.ID, term)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexUnindexedBooks fills book_terms for books written before the table
+// existed.
+func (r *BookRepository) indexUnindexedBooks(ctx context.Context) error {
+	return r.store.WithinTransaction(ctx, func(ctx context.Context) error {
+		rows, err := r.store.querier(ctx).QueryContext(ctx,
+			`SELECT id, title, author FROM books WHERE id NOT IN (SELECT book_id FROM book_terms)`)
+		if err != nil {
+			return err
+		}
+		var books []models.Book
+		for rows.Next() {
+			var book models.Book
+			if err := rows.Scan(&book.ID, &book.Title, &book.Author); err != nil {
+				rows.Close()
+				return err
+			}
+			books = append(books, book)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, book := range books {
+			if err := r.writeTerms(ctx, book); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// addLegacyCopies gives books written before the copies table existed one
+// copy per borrower and one per unit of their amount.
+func (r *BookRepository) addLegacyCopies(ctx context.Context) error {
+	return r.store.WithinTransaction(ctx, func(ctx context.Context) error {
+		books, err := r.load(ctx,
+			`SELECT id, title, author, amount FROM books WHERE id NOT IN (SELECT book_id FROM copies)`)
+		if err != nil {
+			return err
+		}
+		for _, book := range books {
+			for _, c := range repository.LegacyCopies(book) {
+				if err := r.insertCopy(ctx, book.ID, c); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// CheckOut marks a copy as lent with a statement guarded on its status and
+// records the borrowing, all in one transaction.
+func (r *BookRepository) CheckOut(ctx context.Context, id, username, barcode string) (string, error) {
+	var lent string
+	err := r.store.WithinTransaction(ctx, func(ctx context.Context) error {
+		book, err := r.FindByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		held, err := r.store.exists(ctx,
+			`SELECT 1 FROM borrowings WHERE book_id = ? AND username = ?`, id, username)
+		if err != nil {
+			return err
+		}
+		if held {
+			return &apperrors.AlreadyHaveBookError{BookTitle: book.Title}
+		}
+
+		// Any available copy can be picked; a copy on hold only when it is
+		// asked for by barcode and held for username.
+		lendable := `status = ?`
+		lendableArgs := []interface{}{models.CopyAvailable}
+		pick := `SELECT id FROM copies WHERE book_id = ?`
+		pickArgs := []interface{}{id}
+		if barcode != "" {
+			lendable = `(status = ? OR status = ? AND held_for = ?)`
+			lendableArgs = append(lendableArgs, models.CopyOnHold, username)
+			pick += ` AND barcode = ?`
+			pickArgs = append(pickArgs, barcode)
+		}
+		args := []interface{}{models.CopyOnLoan, username}
+		args = append(args, pickArgs...)
+		args = append(args, lendableArgs...)
+		args = append(args, lendableArgs...)
+		result, err := r.store.querier(ctx).ExecContext(ctx,
+			`UPDATE copies SET status = ?, borrowed_by = ?, held_for = NULL
+			WHERE id = (`+pick+` AND `+lendable+` ORDER BY id LIMIT 1) AND `+lendable, args...)
+		if err != nil {
+			return err
+		}
+		if affected, err := result.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			// Replay the checks on the loaded book to report which one failed.
+			if _, err := repository.CheckOutCopy(book, username, barcode); err != nil {
+				return err
+			}
+			return &apperrors.AmountIsZeroError{BookTitle: book.Title}
+		}
+
+		_, err = r.store.querier(ctx).ExecContext(ctx,
+			`INSERT INTO borrowings (book_id, username) VALUES (?, ?)`, id, username)
+		if err != nil {
+			return err
+		}
+		if err := r.recount(ctx, id); err != nil {
+			return err
+		}
+		lent, err = r.lentCopy(ctx, id, username)
+		return err
+	})
+	return lent, err
+}
+
+// CheckIn removes the borrowing and gives the copy back, both in one transaction.
+func (r *BookRepository) CheckIn(ctx context.Context, id, username string) (string, error) {
+	var returned string
+	err := r.store.WithinTransaction(ctx, func(ctx context.Context) error {
+		book, err := r.FindByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		result, err := r.store.querier(ctx).ExecContext(ctx,
+			`DELETE FROM borrowings WHERE book_id = ? AND username = ?`, id, username)
+		if err != nil {
+			return err
+		}
+		if affected, err := result.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			return &apperrors.BookNotBorrowedError{BookTitle: book.Title}
+		}
+
+		if returned, err = r.lentCopy(ctx, id, username); err != nil {
+			return err
+		}
+		_, err = r.store.querier(ctx).ExecContext(ctx,
+			`UPDATE copies SET status = ?, borrowed_by = NULL WHERE barcode = ?`,
+			models.CopyAvailable, returned)
+		if err != nil {
+			return err
+		}
+		return r.recount(ctx, id)
+	})
+	return returned, err
+}
+
+func (r *BookRepository) AddCopy(ctx context.Context, bookID string, copy models.Copy) error {
+	return r.store.WithinTransaction(ctx, func(ctx context.Context) error {
+		if _, err := r.FindByID(ctx, bookID); err != nil {
+			return err
+		}
+		if err := r.insertCopy(ctx, bookID, copy); err != nil {
+			return err
+		}
+		return r.recount(ctx, bookID)
+	})
+}
+
+// UpdateCopy overwrites a copy with a statement guarded on it not being on
+// loan, on hold or withdrawn and recounts the available copies.
+func (r *BookRepository) UpdateCopy(ctx context.Context, bookID string, copy models.Copy) error {
+	return r.changeCopy(ctx, bookID, notInUse(copy.Barcode),
+		`UPDATE copies SET status = ?, location = ?, copy_condition = ?
+		WHERE book_id = ? AND barcode = ? AND status NOT IN (?, ?, ?)`,
+		copy.Status, copy.Location, copy.Condition, bookID, copy.Barcode,
+		models.CopyOnLoan, models.CopyOnHold, models.CopyWithdrawn)
+}
+
+// RemoveCopy deletes a copy with a statement guarded on it not being on loan,
+// on hold or withdrawn and recounts the available copies.
+func (r *BookRepository) RemoveCopy(ctx context.Context, bookID, barcode string) error {
+	return r.changeCopy(ctx, bookID, notInUse(barcode),
+		`DELETE FROM copies WHERE book_id = ? AND barcode = ? AND status NOT IN (?, ?, ?)`,
+		bookID, barcode, models.CopyOnLoan, models.CopyOnHold, models.CopyWithdrawn)
+}
+
+// HoldCopy sets a copy aside with a statement guarded on it being available.
+func (r *BookRepository) HoldCopy(ctx context.Context, bookID, barcode, username string) error {
+	return r.changeCopy(ctx, bookID,
+		func(book *models.Book) error { return repository.HoldCopy(book, barcode, username) },
+		`UPDATE copies SET status = ?, held_for = ? WHERE book_id = ? AND barcode = ? AND status = ?`,
+		models.CopyOnHold, username, bookID, barcode, models.CopyAvailable)
+}
+
+// UnholdCopy makes a copy on hold available again; a copy that is no longer
+// on hold is left as it is.
+func (r *BookRepository) UnholdCopy(ctx context.Context, bookID, barcode string) error {
+	return r.changeCopy(ctx, bookID,
+		func(book *models.Book) error { return repository.UnholdCopy(book, barcode) },
+		`UPDATE copies SET status = ?, held_for = NULL WHERE book_id = ? AND barcode = ? AND status = ?`,
+		models.CopyAvailable, bookID, barcode, models.CopyOnHold)
+}
+
+// WithdrawCopy marks a copy withdrawn with a statement guarded on it not being
+// on hold or withdrawn already, and ends the borrowing of a copy on loan.
+func (r *BookRepository) WithdrawCopy(ctx context.Context, bookID, barcode string, withdrawal models.Withdrawal) (string, error) {
+	var borrower string
+	err := r.store.WithinTransaction(ctx, func(ctx context.Context) error {
+		err := r.store.querier(ctx).QueryRowContext(ctx,
+			`SELECT COALESCE(borrowed_by, '') FROM copies WHERE book_id = ? AND barcode = ?`,
+			bookID, barcode).Scan(&borrower)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		err = r.changeCopy(ctx, bookID,
+			func(book *models.Book) error {
+				_, err := repository.WithdrawCopy(book, barcode, withdrawal)
+				return err
+			},
+			`UPDATE copies SET status = ?, borrowed_by = NULL,
+				withdrawn_reason = ?, withdrawn_note = ?, withdrawn_at = ?, withdrawn_by = ?
+			WHERE book_id = ? AND barcode = ? AND status NOT IN (?, ?)`,
+			models.CopyWithdrawn, withdrawal.Reason, withdrawal.Note, withdrawal.At.UTC(), withdrawal.By,
+			bookID, barcode, models.CopyOnHold, models.CopyWithdrawn)
+		if err != nil || borrower == "" {
+			return err
+		}
+		_, err = r.store.querier(ctx).ExecContext(ctx,
+			`DELETE FROM borrowings WHERE book_id = ? AND username = ?`, bookID, borrower)
+		return err
+	})
+	return borrower, err
+}
+
+// changeCopy runs statement and recounts the available copies. When the
+// statement affected nothing, diagnose is replayed on the current book to
+// report why.
+func (r *BookRepository) changeCopy(ctx context.Context, bookID string, diagnose func(book *models.Book) error, statement string, args ...interface{}) error {
+	return r.store.WithinTransaction(ctx, func(ctx context.Context) error {
+		book, err := r.FindByID(ctx, bookID)
+		if err != nil {
+			return err
+		}
+		result, err := r.store.querier(ctx).ExecContext(ctx, statement, args...)
+		if err != nil {
+			return err
+		}
+		if affected, err := result.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			return diagnose(book)
+		}
+		return r.recount(ctx, bookID)
+	})
+}
+
+// notInUse diagnoses a copy that could not be changed because it is missing,
+// on loan, on hold or withdrawn.
+func notInUse(barcode string) func(book *models.Book) error {
+	return func(book *models.Book) error {
+		for _, c := range book.Copies {
+			if c.Barcode == barcode {
+				return repository.InUse(c)
+			}
+		}
+		return &apperrors.CopyNotFoundError{Barcode: barcode}
+	}
+}
+
+// insertCopy adds one row to the copies table, translating a barcode clash
+// into *apperrors.BarcodeAlreadyExistsError.
+func (r *BookRepository) insertCopy(ctx context.Context, bookID string, copy models.Copy) error {
+	var borrowedBy, heldFor interface{}
+	if copy.BorrowedBy != "" {
+		borrowedBy = copy.BorrowedBy
+	}
+	if copy.HeldFor != "" {
+		heldFor = copy.HeldFor
+	}
+	_, err := r.store.querier(ctx).ExecContext(ctx,
+		`INSERT INTO copies (barcode, book_id, status, location, copy_condition, borrowed_by, held_for)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		copy.Barcode, bookID, copy.Status, copy.Location, copy.Condition, borrowedBy, heldFor)
+	if err != nil {
+		if found, _ := r.store.exists(ctx, `SELECT 1 FROM copies WHERE barcode = ?`, copy.Barcode); found {
+			return &apperrors.BarcodeAlreadyExistsError{Barcode: copy.Barcode}
+		}
+		return err
+	}
+	return nil
+}
+
+// recount sets books.amount to the number of available copies.
+func (r *BookRepository) recount(ctx context.Context, id string) error {
+	_, err := r.store.querier(ctx).ExecContext(ctx,
+		`UPDATE books SET amount = (SELECT COUNT(*) FROM copies WHERE book_id = ? AND status = ?) WHERE id = ?`,
+		id, models.CopyAvailable, id)
+	return err
+}
+
+// lentCopy returns the barcode of the copy of the book lent to username.
+func (r *BookRepository) lentCopy(ctx context.Context, id, username string) (string, error) {
+	var barcode string
+	err := r.store.querier(ctx).QueryRowContext(ctx,
+		`SELECT barcode FROM copies WHERE book_id = ? AND borrowed_by = ? AND status = ?`,
+		id, username, models.CopyOnLoan).Scan(&barcode)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return barcode, err
+}
+
+// copies returns the copies of the given books keyed by book ID, in the order
+// they were added.
+func (r *BookRepository) copies(ctx context.Context, ids ...interface{}) (map[string][]models.Copy, error) {
+	rows, err := r.store.querier(ctx).QueryContext(ctx,
+		`SELECT book_id, barcode, status, location, copy_condition, COALESCE(borrowed_by, ''), COALESCE(held_for, ''),
+			withdrawn_reason, COALESCE(withdrawn_note, ''), withdrawn_at, COALESCE(withdrawn_by, '')
+		FROM copies WHERE book_id IN (`+placeholders(len(ids))+`) ORDER BY id`, ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	copies := make(map[string][]models.Copy)
+	for rows.Next() {
+		var bookID string
+		var c models.Copy
+		var reason sql.NullString
+		var withdrawal models.Withdrawal
+		var withdrawnAt sql.NullTime
+		err := rows.Scan(&bookID, &c.Barcode, &c.Status, &c.Location, &c.Condition, &c.BorrowedBy, &c.HeldFor,
+			&reason, &withdrawal.Note, &withdrawnAt, &withdrawal.By)
+		if err != nil {
+			return nil, err
+		}
+		if reason.Valid {
+			withdrawal.Reason = models.LoanOutcome(reason.String)
+			withdrawal.At = withdrawnAt.Time
+			c.Withdrawal = &withdrawal
+		}
+		copies[bookID] = append(copies[bookID], c)
+	}
+	return copies, rows.Err()
+}
+
+// load runs a query selecting id, title, author and amount and attaches the
+// borrowers and copies of every book it returns.
+func (r *BookRepository) load(ctx context.Context, query string, args ...interface{}) ([]models.Book, error) {
+	rows, err := r.store.querier(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	books := []models.Book{}
+	var ids []interface{}
+	for rows.Next() {
+		var book models.Book
+		if err := rows.Scan(&book.ID, &book.Title, &book.Author, &book.Amount); err != nil {
+			return nil, err
+		}
+		books = append(books, book)
+		ids = append(ids, book.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if len(books) == 0 {
+		return books, nil
+	}
+
+	owners, err := r.store.grouped(ctx,
+		`SELECT book_id, username FROM borrowings WHERE book_id IN (`+placeholders(len(ids))+`) ORDER BY id`, ids...)
+	if err != nil {
+		return nil, err
+	}
+	copies, err := r.copies(ctx, ids...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range books {
+		books[i].OwnedBy = owners[books[i].ID]
+		books[i].Copies = copies[books[i].ID]
+	}
+	return books, nil
+}
+
+func bookAffected(result sql.Result, id string) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return &apperrors.BookNotFoundError{BookID: id}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package sqlrepo
+
+import (
+	"context"
+	"database/sql"
+	"library_management_system/models"
+)
+
+// CalendarRepository stores the time zone in the single row of the calendar
+// table and the opening hours and holidays in tables of their own, in the
+// order they were given.
+type CalendarRepository struct {
+	store *Store
+}
+
+func (r *CalendarRepository) Get(ctx context.Context) (*models.Calendar, error) {
+	var calendar models.Calendar
+	err := r.store.querier(ctx).QueryRowContext(ctx,
+		`SELECT time_zone FROM calendar WHERE id = 1`).Scan(&calendar.TimeZone)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.store.querier(ctx).QueryContext(ctx,
+		`SELECT weekday, opens, closes FROM opening_hours ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	calendar.OpeningHours = []models.OpeningHours{}
+	for rows.Next() {
+		var hours models.OpeningHours
+		if err := rows.Scan(&hours.Weekday, &hours.Opens, &hours.Closes); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		calendar.OpeningHours = append(calendar.OpeningHours, hours)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = r.store.querier(ctx).QueryContext(ctx,
+		`SELECT holiday_date, name FROM holidays ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	calendar.Holidays = []models.Holiday{}
+	for rows.Next() {
+		var holiday models.Holiday
+		if err := rows.Scan(&holiday.Date, &holiday.Name); err != nil {
+			return nil, err
+		}
+		calendar.Holidays = append(calendar.Holidays, holiday)
+	}
+	return &calendar, rows.Err()
+}
+
+func (r *CalendarRepository) Save(ctx context.Context, calendar models.Calendar) error {
+	return r.store.WithinTransaction(ctx, func(ctx context.Context) error {
+		for _, table := range []string{"calendar", "opening_hours", "holidays"} {
+			if _, err := r.store.querier(ctx).ExecContext(ctx, `DELETE FROM `+table); err != nil {
+				return err
+			}
+		}
+		_, err := r.store.querier(ctx).ExecContext(ctx,
+			`INSERT INTO calendar (id, time_zone) VALUES (1, ?)`, calendar.TimeZone)
+		if err != nil {
+			return err
+		}
+		for _, hours := range calendar.OpeningHours {
+			_, err := r.store.querier(ctx).ExecContext(ctx,
+				`INSERT INTO opening_hours (weekday, opens, closes) VALUES (?, ?, ?)`,
+				hours.Weekday, hours.Opens, hours.Closes)
+			if err != nil {
+				return err
+			}
+		}
+		for _, holiday := range calendar.Holidays {
+			_, err := r.store.querier(ctx).ExecContext(ctx,
+				`INSERT INTO holidays (holiday_date, name) VALUES (?, ?)`, holiday.Date, holiday.Name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
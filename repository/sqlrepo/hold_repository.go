@@ -0,0 +1,93 @@
+package sqlrepo
+
+import (
+	"context"
+	"database/sql"
+	"library_management_system/apperrors"
+	"library_management_system/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// HoldRepository stores holds in the holds table. Like loans, holds keep the
+// book ID and username rather than foreign keys.
+type HoldRepository struct {
+	store *Store
+}
+
+const holdColumns = `id, book_id, username, status, barcode, placed_at, ready_at, expires_at, closed_at`
+
+func (r *HoldRepository) Insert(ctx context.Context, hold *models.Hold) error {
+	id := primitive.NewObjectID().Hex()
+	_, err := r.store.querier(ctx).ExecContext(ctx,
+		`INSERT INTO holds (`+holdColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, hold.BookID, hold.Username, hold.Status, hold.Barcode, hold.PlacedAt.UTC(),
+		nullTime(hold.ReadyAt), hold.ExpiresAt.UTC(), nullTime(hold.ClosedAt))
+	if err != nil {
+		return err
+	}
+	hold.ID = id
+	return nil
+}
+
+func (r *HoldRepository) Update(ctx context.Context, hold models.Hold) error {
+	result, err := r.store.querier(ctx).ExecContext(ctx,
+		`UPDATE holds SET status = ?, barcode = ?, ready_at = ?, expires_at = ?, closed_at = ? WHERE id = ?`,
+		hold.Status, hold.Barcode, nullTime(hold.ReadyAt), hold.ExpiresAt.UTC(), nullTime(hold.ClosedAt), hold.ID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return &apperrors.HoldNotFoundError{BookID: hold.BookID, Username: hold.Username}
+	}
+	return nil
+}
+
+func (r *HoldRepository) FindActive(ctx context.Context, bookID string) ([]models.Hold, error) {
+	return r.load(ctx,
+		`SELECT `+holdColumns+` FROM holds WHERE book_id = ? AND status IN (?, ?)
+		ORDER BY placed_at, id`, bookID, models.HoldWaiting, models.HoldReady)
+}
+
+func (r *HoldRepository) FindExpired(ctx context.Context, at time.Time) ([]models.Hold, error) {
+	return r.load(ctx,
+		`SELECT `+holdColumns+` FROM holds WHERE status IN (?, ?) AND expires_at <= ?
+		ORDER BY placed_at, id`, models.HoldWaiting, models.HoldReady, at.UTC())
+}
+
+func (r *HoldRepository) FindActiveByUser(ctx context.Context, username string) ([]models.Hold, error) {
+	return r.load(ctx,
+		`SELECT `+holdColumns+` FROM holds WHERE username = ? AND status IN (?, ?)
+		ORDER BY placed_at, id`, username, models.HoldWaiting, models.HoldReady)
+}
+
+// load runs a query selecting holdColumns.
+func (r *HoldRepository) load(ctx context.Context, query string, args ...interface{}) ([]models.Hold, error) {
+	rows, err := r.store.querier(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holds := []models.Hold{}
+	for rows.Next() {
+		var hold models.Hold
+		var readyAt, closedAt sql.NullTime
+		err := rows.Scan(&hold.ID, &hold.BookID, &hold.Username, &hold.Status, &hold.Barcode,
+			&hold.PlacedAt, &readyAt, &hold.ExpiresAt, &closedAt)
+		if err != nil {
+			return nil, err
+		}
+		if readyAt.Valid {
+			hold.ReadyAt = &readyAt.Time
+		}
+		if closedAt.Valid {
+			hold.ClosedAt = &closedAt.Time
+		}
+		holds = append(holds, hold)
+	}
+	return holds, rows.Err()
+}
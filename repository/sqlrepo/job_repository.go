@@ -0,0 +1,101 @@
+package sqlrepo
+
+import (
+	"context"
+	"database/sql"
+	"library_management_system/apperrors"
+	"library_management_system/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// JobRepository stores leases in the job_leases table, one row per job, and
+// runs in the job_runs table. A lease is taken with a single upsert so that
+// instances sharing the database race on the row rather than on a read.
+type JobRepository struct {
+	store *Store
+}
+
+const jobRunColumns = `id, job, owner, status, scheduled_at, started_at, finished_at, result, error`
+
+func (r *JobRepository) AcquireLease(ctx context.Context, job, owner string, slot, now, expiresAt time.Time) (bool, error) {
+	result, err := r.store.querier(ctx).ExecContext(ctx,
+		`INSERT INTO job_leases (job, owner, slot, expires_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (job) DO UPDATE SET owner = excluded.owner, slot = excluded.slot, expires_at = excluded.expires_at
+		WHERE job_leases.slot < excluded.slot AND job_leases.expires_at <= ?`,
+		job, owner, slot.UTC(), expiresAt.UTC(), now.UTC())
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (r *JobRepository) ReleaseLease(ctx context.Context, job, owner string, now time.Time) error {
+	_, err := r.store.querier(ctx).ExecContext(ctx,
+		`UPDATE job_leases SET expires_at = ? WHERE job = ? AND owner = ?`, now.UTC(), job, owner)
+	return err
+}
+
+func (r *JobRepository) InsertRun(ctx context.Context, run *models.JobRun) error {
+	id := primitive.NewObjectID().Hex()
+	_, err := r.store.querier(ctx).ExecContext(ctx,
+		`INSERT INTO job_runs (`+jobRunColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, run.Job, run.Owner, run.Status, run.ScheduledAt.UTC(), run.StartedAt.UTC(), nullTime(run.FinishedAt),
+		run.Result, run.Error)
+	if err != nil {
+		return err
+	}
+	run.ID = id
+	return nil
+}
+
+func (r *JobRepository) UpdateRun(ctx context.Context, run models.JobRun) error {
+	result, err := r.store.querier(ctx).ExecContext(ctx,
+		`UPDATE job_runs SET status = ?, finished_at = ?, result = ?, error = ? WHERE id = ?`,
+		run.Status, nullTime(run.FinishedAt), run.Result, run.Error, run.ID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return &apperrors.JobRunNotFoundError{ID: run.ID}
+	}
+	return nil
+}
+
+func (r *JobRepository) FindRuns(ctx context.Context, query models.JobRunQuery) ([]models.JobRun, error) {
+	statement := `SELECT ` + jobRunColumns + ` FROM job_runs`
+	args := []interface{}{}
+	if query.Job != "" {
+		statement += ` WHERE job = ?`
+		args = append(args, query.Job)
+	}
+	statement += ` ORDER BY started_at DESC, id DESC`
+	if query.Limit > 0 {
+		statement += ` LIMIT ?`
+		args = append(args, query.Limit)
+	}
+
+	rows, err := r.store.querier(ctx).QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []models.JobRun{}
+	for rows.Next() {
+		var run models.JobRun
+		var finishedAt sql.NullTime
+		err := rows.Scan(&run.ID, &run.Job, &run.Owner, &run.Status, &run.ScheduledAt, &run.StartedAt, &finishedAt,
+			&run.Result, &run.Error)
+		if err != nil {
+			return nil, err
+		}
+		run.FinishedAt = timePointer(finishedAt)
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
@@ -0,0 +1,139 @@
+package sqlrepo
+
+import (
+	"context"
+	"database/sql"
+	"library_management_system/apperrors"
+	"library_management_system/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LoanRepository stores loans in the loans table. Loans keep the book ID and
+// username rather than foreign keys so that the history outlives deleted
+// books.
+type LoanRepository struct {
+	store *Store
+}
+
+const loanColumns = `id, book_id, barcode, username, borrowed_at, due_at, returned_at, fine, renewals,
+	checked_out_by, checked_in_by, outcome, replacement_fee, overdue_at, reminded_at`
+
+func (r *LoanRepository) Insert(ctx context.Context, loan *models.Loan) error {
+	id := primitive.NewObjectID().Hex()
+	_, err := r.store.querier(ctx).ExecContext(ctx,
+		`INSERT INTO loans (`+loanColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, loan.BookID, loan.Barcode, loan.Username, loan.BorrowedAt.UTC(), loan.DueAt.UTC(), nullTime(loan.ReturnedAt),
+		loan.Fine, loan.Renewals, loan.CheckedOutBy, loan.CheckedInBy, loan.Outcome, loan.ReplacementFee,
+		nullTime(loan.OverdueAt), nullTime(loan.RemindedAt))
+	if err != nil {
+		return err
+	}
+	loan.ID = id
+	return nil
+}
+
+func (r *LoanRepository) FindOpen(ctx context.Context, bookID, username string) (*models.Loan, error) {
+	loans, err := r.load(ctx,
+		`SELECT `+loanColumns+` FROM loans
+		WHERE book_id = ? AND username = ? AND returned_at IS NULL
+		ORDER BY borrowed_at DESC, id DESC`, bookID, username)
+	if err != nil {
+		return nil, err
+	}
+	if len(loans) == 0 {
+		return nil, &apperrors.LoanNotFoundError{BookID: bookID, Username: username}
+	}
+	return &loans[0], nil
+}
+
+func (r *LoanRepository) Close(ctx context.Context, id string, closing models.LoanClosing) error {
+	return r.updateOpen(ctx,
+		`UPDATE loans SET returned_at = ?, fine = ?, replacement_fee = ?, outcome = ?, checked_in_by = ?
+		WHERE id = ? AND returned_at IS NULL`,
+		closing.At.UTC(), closing.Fine, closing.ReplacementFee, closing.Outcome, closing.CheckedInBy, id)
+}
+
+func (r *LoanRepository) Renew(ctx context.Context, id string, dueAt time.Time) error {
+	return r.updateOpen(ctx, `UPDATE loans SET due_at = ?, renewals = renewals + 1 WHERE id = ? AND returned_at IS NULL`,
+		dueAt.UTC(), id)
+}
+
+func (r *LoanRepository) MarkOverdue(ctx context.Context, id string, at time.Time) error {
+	return r.updateOpen(ctx, `UPDATE loans SET overdue_at = ? WHERE id = ? AND returned_at IS NULL`, at.UTC(), id)
+}
+
+func (r *LoanRepository) MarkReminded(ctx context.Context, id string, at time.Time) error {
+	return r.updateOpen(ctx, `UPDATE loans SET reminded_at = ? WHERE id = ? AND returned_at IS NULL`, at.UTC(), id)
+}
+
+// updateOpen runs an update guarded on the loan being open and reports a loan
+// that is missing or already returned.
+func (r *LoanRepository) updateOpen(ctx context.Context, statement string, args ...interface{}) error {
+	result, err := r.store.querier(ctx).ExecContext(ctx, statement, args...)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return &apperrors.LoanNotFoundError{}
+	}
+	return nil
+}
+
+func (r *LoanRepository) FindByBook(ctx context.Context, bookID string) ([]models.Loan, error) {
+	return r.load(ctx,
+		`SELECT `+loanColumns+` FROM loans WHERE book_id = ? ORDER BY borrowed_at DESC, id DESC`, bookID)
+}
+
+func (r *LoanRepository) FindByUser(ctx context.Context, username string) ([]models.Loan, error) {
+	return r.load(ctx,
+		`SELECT `+loanColumns+` FROM loans WHERE username = ? ORDER BY borrowed_at DESC, id DESC`, username)
+}
+
+func (r *LoanRepository) FindDueBy(ctx context.Context, at time.Time) ([]models.Loan, error) {
+	return r.load(ctx,
+		`SELECT `+loanColumns+` FROM loans WHERE returned_at IS NULL AND due_at <= ? ORDER BY due_at, id`, at.UTC())
+}
+
+// load runs a query selecting loanColumns.
+func (r *LoanRepository) load(ctx context.Context, query string, args ...interface{}) ([]models.Loan, error) {
+	rows, err := r.store.querier(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	loans := []models.Loan{}
+	for rows.Next() {
+		var loan models.Loan
+		var returnedAt, overdueAt, remindedAt sql.NullTime
+		err := rows.Scan(&loan.ID, &loan.BookID, &loan.Barcode, &loan.Username,
+			&loan.BorrowedAt, &loan.DueAt, &returnedAt, &loan.Fine, &loan.Renewals, &loan.CheckedOutBy, &loan.CheckedInBy,
+			&loan.Outcome, &loan.ReplacementFee, &overdueAt, &remindedAt)
+		if err != nil {
+			return nil, err
+		}
+		loan.ReturnedAt = timePointer(returnedAt)
+		loan.OverdueAt = timePointer(overdueAt)
+		loan.RemindedAt = timePointer(remindedAt)
+		loans = append(loans, loan)
+	}
+	return loans, rows.Err()
+}
+
+func timePointer(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
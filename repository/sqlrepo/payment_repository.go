@@ -0,0 +1,50 @@
+package sqlrepo
+
+import (
+	"context"
+	"library_management_system/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PaymentRepository stores payments in the payments table. Like loans, they
+// keep the username rather than a foreign key, so that they outlive the
+// account.
+type PaymentRepository struct {
+	store *Store
+}
+
+const paymentColumns = `id, username, kind, amount, note, paid_at, paid_by`
+
+func (r *PaymentRepository) Insert(ctx context.Context, payment *models.Payment) error {
+	id := primitive.NewObjectID().Hex()
+	_, err := r.store.querier(ctx).ExecContext(ctx,
+		`INSERT INTO payments (`+paymentColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		id, payment.Username, payment.Kind, payment.Amount, payment.Note, payment.At.UTC(), payment.By)
+	if err != nil {
+		return err
+	}
+	payment.ID = id
+	return nil
+}
+
+func (r *PaymentRepository) FindByUser(ctx context.Context, username string) ([]models.Payment, error) {
+	rows, err := r.store.querier(ctx).QueryContext(ctx,
+		`SELECT `+paymentColumns+` FROM payments WHERE username = ? ORDER BY paid_at, id`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []models.Payment{}
+	for rows.Next() {
+		var payment models.Payment
+		err := rows.Scan(&payment.ID, &payment.Username, &payment.Kind, &payment.Amount, &payment.Note,
+			&payment.At, &payment.By)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+	return payments, rows.Err()
+}
@@ -0,0 +1,125 @@
+package sqlrepo
+
+import (
+	"context"
+	"database/sql"
+	"library_management_system/apperrors"
+	"library_management_system/models"
+	"time"
+)
+
+// SessionRepository stores sessions in the sessions table and revoked access
+// tokens in the revoked_tokens table.
+type SessionRepository struct {
+	store *Store
+}
+
+const sessionColumns = `id, username, refresh_token_hash, access_token_id, access_token_expires_at, created_at,
+	expires_at, revoked_at`
+
+func (r *SessionRepository) Insert(ctx context.Context, session *models.Session) error {
+	_, err := r.store.querier(ctx).ExecContext(ctx,
+		`INSERT INTO sessions (`+sessionColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		session.ID, session.Username, session.RefreshTokenHash, session.AccessTokenID,
+		session.AccessTokenExpiresAt.UTC(), session.CreatedAt.UTC(), session.ExpiresAt.UTC(), nullTime(session.RevokedAt))
+	return err
+}
+
+func (r *SessionRepository) FindByID(ctx context.Context, id string) (*models.Session, error) {
+	sessions, err := r.load(ctx, `SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(sessions) == 0 {
+		return nil, &apperrors.SessionNotFoundError{ID: id}
+	}
+	return &sessions[0], nil
+}
+
+func (r *SessionRepository) Rotate(ctx context.Context, id, previousHash string, rotation models.SessionRotation) (bool, error) {
+	result, err := r.store.querier(ctx).ExecContext(ctx,
+		`UPDATE sessions SET refresh_token_hash = ?, access_token_id = ?, access_token_expires_at = ?
+		WHERE id = ? AND refresh_token_hash = ? AND revoked_at IS NULL`,
+		rotation.RefreshTokenHash, rotation.AccessTokenID, rotation.AccessTokenExpiresAt.UTC(), id, previousHash)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (r *SessionRepository) Revoke(ctx context.Context, id string, at time.Time) error {
+	result, err := r.store.querier(ctx).ExecContext(ctx,
+		`UPDATE sessions SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ?`, at.UTC(), id)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return &apperrors.SessionNotFoundError{ID: id}
+	}
+	return nil
+}
+
+func (r *SessionRepository) FindActiveByUser(ctx context.Context, username string, at time.Time) ([]models.Session, error) {
+	return r.load(ctx,
+		`SELECT `+sessionColumns+` FROM sessions WHERE username = ? AND revoked_at IS NULL AND expires_at > ?
+		ORDER BY created_at, id`, username, at.UTC())
+}
+
+func (r *SessionRepository) RevokeToken(ctx context.Context, token models.RevokedToken) error {
+	_, err := r.store.querier(ctx).ExecContext(ctx,
+		`INSERT INTO revoked_tokens (id, expires_at) VALUES (?, ?)
+		ON CONFLICT (id) DO UPDATE SET expires_at = excluded.expires_at`, token.ID, token.ExpiresAt.UTC())
+	return err
+}
+
+func (r *SessionRepository) TokenRevoked(ctx context.Context, id string) (bool, error) {
+	return r.store.exists(ctx, `SELECT 1 FROM revoked_tokens WHERE id = ?`, id)
+}
+
+func (r *SessionRepository) DeleteExpired(ctx context.Context, at time.Time) (int, error) {
+	deleted := 0
+	err := r.store.WithinTransaction(ctx, func(ctx context.Context) error {
+		for _, statement := range []string{
+			`DELETE FROM sessions WHERE expires_at < ?`,
+			`DELETE FROM revoked_tokens WHERE expires_at < ?`,
+		} {
+			result, err := r.store.querier(ctx).ExecContext(ctx, statement, at.UTC())
+			if err != nil {
+				return err
+			}
+			affected, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			deleted += int(affected)
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+// load runs a query selecting sessionColumns.
+func (r *SessionRepository) load(ctx context.Context, query string, args ...interface{}) ([]models.Session, error) {
+	rows, err := r.store.querier(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		var session models.Session
+		var revokedAt sql.NullTime
+		err := rows.Scan(&session.ID, &session.Username, &session.RefreshTokenHash, &session.AccessTokenID,
+			&session.AccessTokenExpiresAt, &session.CreatedAt, &session.ExpiresAt, &revokedAt)
+		if err != nil {
+			return nil, err
+		}
+		session.RevokedAt = timePointer(revokedAt)
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
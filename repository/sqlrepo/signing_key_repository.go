@@ -0,0 +1,42 @@
+package sqlrepo
+
+import (
+	"context"
+	"library_management_system/models"
+)
+
+// SigningKeyRepository stores signing keys in the signing_keys table.
+type SigningKeyRepository struct {
+	store *Store
+}
+
+func (r *SigningKeyRepository) Insert(ctx context.Context, key models.SigningKey) error {
+	_, err := r.store.querier(ctx).ExecContext(ctx,
+		`INSERT INTO signing_keys (id, algorithm, private_key, created_at) VALUES (?, ?, ?, ?)`,
+		key.ID, key.Algorithm, key.PrivateKey, key.CreatedAt.UTC())
+	return err
+}
+
+func (r *SigningKeyRepository) FindAll(ctx context.Context) ([]models.SigningKey, error) {
+	rows, err := r.store.querier(ctx).QueryContext(ctx,
+		`SELECT id, algorithm, private_key, created_at FROM signing_keys ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.SigningKey{}
+	for rows.Next() {
+		var key models.SigningKey
+		if err := rows.Scan(&key.ID, &key.Algorithm, &key.PrivateKey, &key.CreatedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (r *SigningKeyRepository) Delete(ctx context.Context, id string) error {
+	_, err := r.store.querier(ctx).ExecContext(ctx, `DELETE FROM signing_keys WHERE id = ?`, id)
+	return err
+}
@@ -0,0 +1,402 @@
+package sqlrepo
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// schema creates the tables used by the repositories. Borrowing is modelled as
+// its own table instead of the owned_by / borrowed_book_ids arrays; the arrays
+// on models.Book and models.User are derived from it when reading. The roles
+// of a user are rows of user_roles in the same way. Copies live in their own
+// table too, with books.amount kept as the count of available copies so
+// listings can filter and sort on it.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS books (
+		id     TEXT PRIMARY KEY,
+		title  TEXT NOT NULL,
+		author TEXT NOT NULL,
+		amount INTEGER NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS books_author ON books (author, id)`,
+	`CREATE INDEX IF NOT EXISTS books_title ON books (title, id)`,
+	`CREATE INDEX IF NOT EXISTS books_amount ON books (amount, id)`,
+	`CREATE TABLE IF NOT EXISTS book_terms (
+		book_id TEXT NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+		term    TEXT NOT NULL,
+		PRIMARY KEY (book_id, term)
+	)`,
+	`CREATE INDEX IF NOT EXISTS book_terms_term ON book_terms (term, book_id)`,
+	`CREATE TABLE IF NOT EXISTS copies (
+		id               INTEGER PRIMARY KEY,
+		barcode          TEXT NOT NULL UNIQUE,
+		book_id          TEXT NOT NULL REFERENCES books (id),
+		status           TEXT NOT NULL,
+		location         TEXT NOT NULL,
+		copy_condition   TEXT NOT NULL,
+		borrowed_by      TEXT,
+		held_for         TEXT,
+		withdrawn_reason TEXT,
+		withdrawn_note   TEXT,
+		withdrawn_at     TIMESTAMP,
+		withdrawn_by     TEXT
+	)`,
+	`CREATE INDEX IF NOT EXISTS copies_book ON copies (book_id, status)`,
+	`CREATE TABLE IF NOT EXISTS users (
+		id          TEXT PRIMARY KEY,
+		username    TEXT NOT NULL UNIQUE,
+		password    TEXT NOT NULL,
+		loan_limit  INTEGER,
+		card_number TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_roles (
+		username TEXT NOT NULL REFERENCES users (username),
+		role     TEXT NOT NULL,
+		PRIMARY KEY (username, role)
+	)`,
+	`CREATE INDEX IF NOT EXISTS user_roles_role ON user_roles (role, username)`,
+	`CREATE TABLE IF NOT EXISTS borrowings (
+		id       INTEGER PRIMARY KEY,
+		book_id  TEXT NOT NULL REFERENCES books (id),
+		username TEXT NOT NULL REFERENCES users (username),
+		UNIQUE (book_id, username)
+	)`,
+	`CREATE TABLE IF NOT EXISTS loans (
+		id              TEXT PRIMARY KEY,
+		book_id         TEXT NOT NULL,
+		barcode         TEXT NOT NULL,
+		username        TEXT NOT NULL,
+		borrowed_at     TIMESTAMP NOT NULL,
+		due_at          TIMESTAMP NOT NULL,
+		returned_at     TIMESTAMP,
+		fine            INTEGER NOT NULL DEFAULT 0,
+		renewals        INTEGER NOT NULL DEFAULT 0,
+		checked_out_by  TEXT NOT NULL DEFAULT '',
+		checked_in_by   TEXT NOT NULL DEFAULT '',
+		outcome         TEXT NOT NULL DEFAULT '',
+		replacement_fee INTEGER NOT NULL DEFAULT 0,
+		overdue_at      TIMESTAMP,
+		reminded_at     TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS loans_book ON loans (book_id, borrowed_at)`,
+	`CREATE INDEX IF NOT EXISTS loans_username ON loans (username, borrowed_at)`,
+	`CREATE INDEX IF NOT EXISTS loans_due ON loans (due_at)`,
+	`CREATE TABLE IF NOT EXISTS holds (
+		id         TEXT PRIMARY KEY,
+		book_id    TEXT NOT NULL,
+		username   TEXT NOT NULL,
+		status     TEXT NOT NULL,
+		barcode    TEXT NOT NULL DEFAULT '',
+		placed_at  TIMESTAMP NOT NULL,
+		ready_at   TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL,
+		closed_at  TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS holds_book ON holds (book_id, status, placed_at)`,
+	`CREATE INDEX IF NOT EXISTS holds_expiry ON holds (status, expires_at)`,
+	`CREATE TABLE IF NOT EXISTS payments (
+		id       TEXT PRIMARY KEY,
+		username TEXT NOT NULL,
+		kind     TEXT NOT NULL,
+		amount   INTEGER NOT NULL,
+		note     TEXT NOT NULL DEFAULT '',
+		paid_at  TIMESTAMP NOT NULL,
+		paid_by  TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS payments_username ON payments (username, paid_at)`,
+	`CREATE TABLE IF NOT EXISTS calendar (
+		id        INTEGER PRIMARY KEY CHECK (id = 1),
+		time_zone TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS opening_hours (
+		id      INTEGER PRIMARY KEY,
+		weekday TEXT NOT NULL UNIQUE,
+		opens   TEXT NOT NULL,
+		closes  TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS holidays (
+		id           INTEGER PRIMARY KEY,
+		holiday_date TEXT NOT NULL UNIQUE,
+		name         TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE TABLE IF NOT EXISTS job_leases (
+		job        TEXT PRIMARY KEY,
+		owner      TEXT NOT NULL,
+		slot       TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS job_runs (
+		id           TEXT PRIMARY KEY,
+		job          TEXT NOT NULL,
+		owner        TEXT NOT NULL,
+		status       TEXT NOT NULL,
+		scheduled_at TIMESTAMP NOT NULL,
+		started_at   TIMESTAMP NOT NULL,
+		finished_at  TIMESTAMP,
+		result       TEXT NOT NULL DEFAULT '',
+		error        TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE INDEX IF NOT EXISTS job_runs_job ON job_runs (job, started_at)`,
+	`CREATE TABLE IF NOT EXISTS sessions (
+		id                      TEXT PRIMARY KEY,
+		username                TEXT NOT NULL,
+		refresh_token_hash      TEXT NOT NULL,
+		access_token_id         TEXT NOT NULL,
+		access_token_expires_at TIMESTAMP NOT NULL,
+		created_at              TIMESTAMP NOT NULL,
+		expires_at              TIMESTAMP NOT NULL,
+		revoked_at              TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS sessions_username ON sessions (username, created_at)`,
+	`CREATE TABLE IF NOT EXISTS revoked_tokens (
+		id         TEXT PRIMARY KEY,
+		expires_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS signing_keys (
+		id          TEXT PRIMARY KEY,
+		algorithm   TEXT NOT NULL,
+		private_key BLOB NOT NULL,
+		created_at  TIMESTAMP NOT NULL
+	)`,
+}
+
+// addedColumns lists the columns that were added to a table after it was
+// released, so that Open can add them to older databases.
+var addedColumns = []struct{ table, name, definition string }{
+	{"loans", "fine", "INTEGER NOT NULL DEFAULT 0"},
+	{"loans", "renewals", "INTEGER NOT NULL DEFAULT 0"},
+	{"copies", "held_for", "TEXT"},
+	{"users", "loan_limit", "INTEGER"},
+	{"loans", "checked_out_by", "TEXT NOT NULL DEFAULT ''"},
+	{"loans", "checked_in_by", "TEXT NOT NULL DEFAULT ''"},
+	{"users", "card_number", "TEXT"},
+	{"loans", "outcome", "TEXT NOT NULL DEFAULT ''"},
+	{"loans", "replacement_fee", "INTEGER NOT NULL DEFAULT 0"},
+	{"copies", "withdrawn_reason", "TEXT"},
+	{"copies", "withdrawn_note", "TEXT"},
+	{"copies", "withdrawn_at", "TIMESTAMP"},
+	{"copies", "withdrawn_by", "TEXT"},
+	{"loans", "overdue_at", "TIMESTAMP"},
+	{"loans", "reminded_at", "TIMESTAMP"},
+	{"users", "disabled", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"users", "email", "TEXT NOT NULL DEFAULT ''"},
+	{"users", "phone", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// addedIndexes index columns listed in addedColumns, so they can only be
+// created once those columns exist.
+var addedIndexes = []string{
+	`CREATE UNIQUE INDEX IF NOT EXISTS users_card_number ON users (card_number)`,
+}
+
+// addColumn adds a column to table unless it is already there.
+func addColumn(ctx context.Context, db *sql.DB, table, name, definition string) error {
+	if hasColumn(ctx, db, table, name) {
+		return nil
+	}
+	_, err := db.ExecContext(ctx, `ALTER TABLE `+table+` ADD COLUMN `+name+` `+definition)
+	return err
+}
+
+// hasColumn reports whether table has a column called name.
+func hasColumn(ctx context.Context, db *sql.DB, table, name string) bool {
+	rows, err := db.QueryContext(ctx, `SELECT `+name+` FROM `+table+` LIMIT 0`)
+	if err != nil {
+		return false
+	}
+	rows.Close()
+	return true
+}
+
+// Store keeps the library in a SQL database reached through database/sql.
+// Queries use ? placeholders; SQLite is the reference driver.
+type Store struct {
+	db *sql.DB
+}
+
+type txKey struct{}
+
+// querier is the part of *sql.DB and *sql.Tx the repositories use.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Open connects with the given driver and data source name and creates the
+// schema if it does not exist yet.
+func Open(ctx context.Context, driverName, dataSourceName string) (*Store, error) {
+	db, err := sql.Open(driverName, dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	if driverName == "sqlite3" {
+		// SQLite allows a single writer; sharing one connection serialises
+		// transactions instead of failing them with "database is locked".
+		db.SetMaxOpenConns(1)
+		if _, err := db.ExecContext(ctx, "PRAGMA foreign_keys = ON"); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	for _, statement := range schema {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	// Columns added after a table was first released are not created by
+	// CREATE TABLE IF NOT EXISTS on existing databases.
+	for _, column := range addedColumns {
+		if err := addColumn(ctx, db, column.table, column.name, column.definition); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	for _, statement := range addedIndexes {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	store := &Store{db: db}
+	if err := store.Books().indexUnindexedBooks(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := store.Books().addLegacyCopies(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := store.Users().moveLegacyRoles(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// Close closes the underlying connection pool.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Ping checks that the database is reachable.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Books returns a BookRepository reading and writing this store.
+func (s *Store) Books() *BookRepository {
+	return &BookRepository{store: s}
+}
+
+// Users returns a UserRepository reading and writing this store.
+func (s *Store) Users() *UserRepository {
+	return &UserRepository{store: s}
+}
+
+// Loans returns a LoanRepository reading and writing this store.
+func (s *Store) Loans() *LoanRepository {
+	return &LoanRepository{store: s}
+}
+
+// Holds returns a HoldRepository reading and writing this store.
+func (s *Store) Holds() *HoldRepository {
+	return &HoldRepository{store: s}
+}
+
+// Payments returns a PaymentRepository reading and writing this store.
+func (s *Store) Payments() *PaymentRepository {
+	return &PaymentRepository{store: s}
+}
+
+// Calendar returns a CalendarRepository reading and writing this store.
+func (s *Store) Calendar() *CalendarRepository {
+	return &CalendarRepository{store: s}
+}
+
+// Jobs returns a JobRepository reading and writing this store.
+func (s *Store) Jobs() *JobRepository {
+	return &JobRepository{store: s}
+}
+
+// Sessions returns a SessionRepository reading and writing this store.
+func (s *Store) Sessions() *SessionRepository {
+	return &SessionRepository{store: s}
+}
+
+// SigningKeys returns a SigningKeyRepository reading and writing this store.
+func (s *Store) SigningKeys() *SigningKeyRepository {
+	return &SigningKeyRepository{store: s}
+}
+
+// WithinTransaction runs fn inside a database transaction, committing when fn
+// returns nil and rolling back otherwise.
+func (s *Store) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	if _, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	return fn(context.WithValue(ctx, txKey{}, tx))
+}
+
+// querier returns the transaction carried by ctx, or the pool itself.
+func (s *Store) querier(ctx context.Context) querier {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return tx
+	}
+	return s.db
+}
+
+// exists reports whether query returns at least one row.
+func (s *Store) exists(ctx context.Context, query string, args ...interface{}) (bool, error) {
+	var one int
+	err := s.querier(ctx).QueryRowContext(ctx, query, args...).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// grouped runs a two-column query and collects the second column under the
+// first, preserving row order. It is used to rebuild the owned_by and
+// borrowed_book_ids arrays from the borrowings table.
+func (s *Store) grouped(ctx context.Context, query string, args ...interface{}) (map[string][]string, error) {
+	rows, err := s.querier(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := make(map[string][]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		groups[key] = append(groups[key], value)
+	}
+	return groups, rows.Err()
+}
+
+// placeholders returns n comma separated ? placeholders for an IN list.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
@@ -0,0 +1,300 @@
+package sqlrepo
+
+import (
+	"context"
+	"database/sql"
+	"library_management_system/apperrors"
+	"library_management_system/models"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UserRepository stores users in the users table and their roles in the
+// user_roles table, one row per role. The UNIQUE constraint on username is
+// what guarantees usernames stay unique.
+type UserRepository struct {
+	store *Store
+}
+
+func (r *UserRepository) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	var user models.User
+	var loanLimit sql.NullInt64
+	err := r.store.querier(ctx).QueryRowContext(ctx,
+		`SELECT id, username, password, loan_limit, COALESCE(card_number, ''), disabled, email, phone FROM users WHERE username = ?`, username,
+	).Scan(&user.ID, &user.Username, &user.Password, &loanLimit, &user.CardNumber, &user.Disabled, &user.Email, &user.Phone)
+	if err == sql.ErrNoRows {
+		return nil, &apperrors.UserNotFoundError{Username: username}
+	}
+	if err != nil {
+		return nil, err
+	}
+	user.LoanLimit = nullInt(loanLimit)
+
+	roles, err := r.store.grouped(ctx,
+		`SELECT username, role FROM user_roles WHERE username = ? ORDER BY role`, username)
+	if err != nil {
+		return nil, err
+	}
+	user.Roles = rolesOf(roles[username])
+	borrowed, err := r.store.grouped(ctx,
+		`SELECT username, book_id FROM borrowings WHERE username = ? ORDER BY id`, username)
+	if err != nil {
+		return nil, err
+	}
+	user.BorrowedBookIDs = borrowed[username]
+	return &user, nil
+}
+
+func (r *UserRepository) FindByCardNumber(ctx context.Context, cardNumber string) (*models.User, error) {
+	var username string
+	err := r.store.querier(ctx).QueryRowContext(ctx,
+		`SELECT username FROM users WHERE card_number = ?`, cardNumber).Scan(&username)
+	if err == sql.ErrNoRows {
+		return nil, &apperrors.CardNotFoundError{CardNumber: cardNumber}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.FindByUsername(ctx, username)
+}
+
+func (r *UserRepository) FindAll(ctx context.Context) ([]models.User, error) {
+	rows, err := r.store.querier(ctx).QueryContext(ctx,
+		`SELECT id, username, password, loan_limit, COALESCE(card_number, ''), disabled, email, phone FROM users ORDER BY username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		var loanLimit sql.NullInt64
+		if err := rows.Scan(&user.ID, &user.Username, &user.Password, &loanLimit, &user.CardNumber, &user.Disabled, &user.Email, &user.Phone); err != nil {
+			return nil, err
+		}
+		user.LoanLimit = nullInt(loanLimit)
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	roles, err := r.store.grouped(ctx, `SELECT username, role FROM user_roles ORDER BY role`)
+	if err != nil {
+		return nil, err
+	}
+	borrowed, err := r.store.grouped(ctx,
+		`SELECT username, book_id FROM borrowings ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	for i := range users {
+		users[i].Roles = rolesOf(roles[users[i].Username])
+		users[i].BorrowedBookIDs = borrowed[users[i].Username]
+	}
+	return users, nil
+}
+
+// Insert stores the user row and its roles in one transaction.
+func (r *UserRepository) Insert(ctx context.Context, user *models.User) error {
+	id := primitive.NewObjectID().Hex()
+	err := r.store.WithinTransaction(ctx, func(ctx context.Context) error {
+		_, err := r.store.querier(ctx).ExecContext(ctx,
+			`INSERT INTO users (id, username, password, loan_limit, card_number, email, phone) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			id, user.Username, user.Password, user.LoanLimit, nullString(user.CardNumber), user.Email, user.Phone)
+		if err != nil {
+			if found, _ := r.store.exists(ctx, `SELECT 1 FROM users WHERE username = ?`, user.Username); found {
+				return &apperrors.UsernameAlreadyExistsError{Username: user.Username}
+			}
+			if found, _ := r.store.exists(ctx, `SELECT 1 FROM users WHERE card_number = ?`, user.CardNumber); found {
+				return &apperrors.CardNumberAlreadyExistsError{CardNumber: user.CardNumber}
+			}
+			return err
+		}
+		return r.insertRoles(ctx, user.Username, user.Roles)
+	})
+	if err != nil {
+		return err
+	}
+	user.ID = id
+	return nil
+}
+
+// AddBorrowedBook inserts the borrowing row unless BookRepository.CheckOut has
+// already written it.
+func (r *UserRepository) AddBorrowedBook(ctx context.Context, username, bookID string) error {
+	if err := r.ensureUser(ctx, username); err != nil {
+		return err
+	}
+	_, err := r.store.querier(ctx).ExecContext(ctx,
+		`INSERT INTO borrowings (book_id, username)
+		 SELECT ?, ? WHERE NOT EXISTS (SELECT 1 FROM borrowings WHERE book_id = ? AND username = ?)`,
+		bookID, username, bookID, username)
+	return err
+}
+
+// RemoveBorrowedBook deletes the borrowing row unless BookRepository.CheckIn
+// has already removed it.
+func (r *UserRepository) RemoveBorrowedBook(ctx context.Context, username, bookID string) error {
+	if err := r.ensureUser(ctx, username); err != nil {
+		return err
+	}
+	_, err := r.store.querier(ctx).ExecContext(ctx,
+		`DELETE FROM borrowings WHERE book_id = ? AND username = ?`, bookID, username)
+	return err
+}
+
+func (r *UserRepository) SetCardNumber(ctx context.Context, username, cardNumber string) error {
+	result, err := r.store.querier(ctx).ExecContext(ctx,
+		`UPDATE users SET card_number = ? WHERE username = ?`, cardNumber, username)
+	if err != nil {
+		if found, _ := r.store.exists(ctx, `SELECT 1 FROM users WHERE card_number = ?`, cardNumber); found {
+			return &apperrors.CardNumberAlreadyExistsError{CardNumber: cardNumber}
+		}
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return &apperrors.UserNotFoundError{Username: username}
+	}
+	return nil
+}
+
+func (r *UserRepository) SetLoanLimit(ctx context.Context, username string, limit *int) error {
+	result, err := r.store.querier(ctx).ExecContext(ctx,
+		`UPDATE users SET loan_limit = ? WHERE username = ?`, limit, username)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return &apperrors.UserNotFoundError{Username: username}
+	}
+	return nil
+}
+
+func (r *UserRepository) SetRoles(ctx context.Context, username string, roles []string) error {
+	return r.store.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := r.ensureUser(ctx, username); err != nil {
+			return err
+		}
+		_, err := r.store.querier(ctx).ExecContext(ctx, `DELETE FROM user_roles WHERE username = ?`, username)
+		if err != nil {
+			return err
+		}
+		return r.insertRoles(ctx, username, roles)
+	})
+}
+
+func (r *UserRepository) SetDisabled(ctx context.Context, username string, disabled bool) error {
+	return r.updateUser(ctx, username, `UPDATE users SET disabled = ? WHERE username = ?`, disabled, username)
+}
+
+func (r *UserRepository) SetPassword(ctx context.Context, username, password string) error {
+	return r.updateUser(ctx, username, `UPDATE users SET password = ? WHERE username = ?`, password, username)
+}
+
+func (r *UserRepository) SetContact(ctx context.Context, username, email, phone string) error {
+	return r.updateUser(ctx, username, `UPDATE users SET email = ?, phone = ? WHERE username = ?`, email, phone, username)
+}
+
+func (r *UserRepository) Delete(ctx context.Context, username string) error {
+	return r.store.WithinTransaction(ctx, func(ctx context.Context) error {
+		_, err := r.store.querier(ctx).ExecContext(ctx, `DELETE FROM user_roles WHERE username = ?`, username)
+		if err != nil {
+			return err
+		}
+		return r.updateUser(ctx, username, `DELETE FROM users WHERE username = ?`, username)
+	})
+}
+
+// insertRoles adds roles to username, skipping any they already have.
+func (r *UserRepository) insertRoles(ctx context.Context, username string, roles []string) error {
+	for _, role := range roles {
+		_, err := r.store.querier(ctx).ExecContext(ctx,
+			`INSERT INTO user_roles (username, role)
+			 SELECT ?, ? WHERE NOT EXISTS (SELECT 1 FROM user_roles WHERE username = ? AND role = ?)`,
+			username, role, username, role)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveLegacyRoles copies the roles of users stored before the user_roles
+// table existed into it and drops the role column that held them, either a
+// single role or several separated by commas.
+func (r *UserRepository) moveLegacyRoles(ctx context.Context) error {
+	if !hasColumn(ctx, r.store.db, "users", "role") {
+		return nil
+	}
+	return r.store.WithinTransaction(ctx, func(ctx context.Context) error {
+		legacy, err := r.store.grouped(ctx, `SELECT username, role FROM users`)
+		if err != nil {
+			return err
+		}
+		for username, columns := range legacy {
+			for _, column := range columns {
+				roles := strings.FieldsFunc(column, func(c rune) bool { return c == ',' })
+				if err := r.insertRoles(ctx, username, roles); err != nil {
+					return err
+				}
+			}
+		}
+		_, err = r.store.querier(ctx).ExecContext(ctx, `ALTER TABLE users DROP COLUMN role`)
+		return err
+	})
+}
+
+// updateUser runs statement, which changes the row of username, and reports
+// a missing user when it changed nothing.
+func (r *UserRepository) updateUser(ctx context.Context, username, statement string, args ...interface{}) error {
+	result, err := r.store.querier(ctx).ExecContext(ctx, statement, args...)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return &apperrors.UserNotFoundError{Username: username}
+	}
+	return nil
+}
+
+func (r *UserRepository) ensureUser(ctx context.Context, username string) error {
+	found, err := r.store.exists(ctx, `SELECT 1 FROM users WHERE username = ?`, username)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return &apperrors.UserNotFoundError{Username: username}
+	}
+	return nil
+}
+
+// rolesOf returns the roles read for a user, which has none rather than nil
+// roles when user_roles has no rows for them.
+func rolesOf(roles []string) []string {
+	if roles == nil {
+		return []string{}
+	}
+	return roles
+}
+
+func nullInt(n sql.NullInt64) *int {
+	if !n.Valid {
+		return nil
+	}
+	value := int(n.Int64)
+	return &value
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,71 @@
+package sqlrepo
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestOpenMovesLegacyRoles(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "library.db")
+	legacy, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, statement := range []string{
+		`CREATE TABLE users (
+			id          TEXT PRIMARY KEY,
+			username    TEXT NOT NULL UNIQUE,
+			password    TEXT NOT NULL,
+			role        TEXT NOT NULL,
+			loan_limit  INTEGER,
+			card_number TEXT
+		)`,
+		`INSERT INTO users (id, username, password, role) VALUES
+			('1', 'ann', 'x', 'admin'),
+			('2', 'bob', 'x', 'user,librarian'),
+			('3', 'cid', 'x', 'user,')`,
+	} {
+		if _, err := legacy.ExecContext(ctx, statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := legacy.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]string{
+		"ann": {"admin"},
+		"bob": {"librarian", "user"},
+		"cid": {"user"},
+	}
+	// Opening again finds the roles already moved.
+	for range 2 {
+		store, err := Open(ctx, "sqlite3", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hasColumn(ctx, store.db, "users", "role") {
+			t.Error("the legacy role column was not dropped")
+		}
+		for username, roles := range want {
+			user, err := store.Users().FindByUsername(ctx, username)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := slices.Clone(user.Roles)
+			slices.Sort(got)
+			if !slices.Equal(got, roles) {
+				t.Errorf("%s has roles %q, want %q", username, got, roles)
+			}
+		}
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
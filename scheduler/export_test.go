@@ -0,0 +1,13 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+)
+
+// RunJob runs the job named name for slot on s, as Run does when it falls
+// due. The tests of the leases live outside the package, since the test
+// backends import the configuration, which imports this package.
+func RunJob(s *Scheduler, ctx context.Context, name string, run Func, slot time.Time) {
+	s.runJob(ctx, &job{name: name, run: run}, slot)
+}
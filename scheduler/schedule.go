@@ -0,0 +1,164 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSearch bounds the search for the next run of a schedule, so that
+// schedules that never fire, such as "0 0 31 2 *", do not loop forever.
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+var shorthands = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+}
+
+// field is the range of one of the five fields of a cron expression.
+type field struct {
+	name     string
+	min, max int
+}
+
+var fields = []field{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// Schedule is a parsed cron expression of five fields: minute, hour, day of
+// month, month and day of week, read in UTC. Each field is "*", a number, a
+// range "a-b", any of those with a step "/n", or a comma separated list of
+// them. Day of week runs from 0 (Sunday) to 6, and 7 is Sunday too. As in
+// cron, when both day fields are restricted a day matching either is used.
+type Schedule struct {
+	spec    string
+	sets    [5]uint64
+	anyDay  bool
+	anyWeek bool
+}
+
+// ParseSchedule parses a cron expression or one of the shorthands @hourly,
+// @daily, @midnight, @weekly, @monthly and @yearly.
+func ParseSchedule(spec string) (*Schedule, error) {
+	expression := strings.TrimSpace(spec)
+	if expanded, ok := shorthands[expression]; ok {
+		expression = expanded
+	}
+	parts := strings.Fields(expression)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("schedule %q must have %d fields", spec, len(fields))
+	}
+
+	schedule := &Schedule{spec: spec}
+	for i, part := range parts {
+		set, err := parseField(part, fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", spec, err)
+		}
+		schedule.sets[i] = set
+	}
+	if schedule.sets[4]&(1<<7) != 0 {
+		schedule.sets[4] |= 1
+	}
+	schedule.anyDay = parts[2] == "*"
+	schedule.anyWeek = parts[4] == "*"
+	return schedule, nil
+}
+
+func parseField(part string, f field) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(part, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("%s step %q is not a positive number", f.name, stepPart)
+			}
+		}
+
+		low, high := f.min, f.max
+		if rangePart != "*" {
+			lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = parseValue(lowPart, f); err != nil {
+				return 0, err
+			}
+			high = low
+			if isRange {
+				if high, err = parseValue(highPart, f); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				high = f.max
+			}
+			if high < low {
+				return 0, fmt.Errorf("%s range %q runs backwards", f.name, rangePart)
+			}
+		}
+		for value := low; value <= high; value += step {
+			set |= 1 << value
+		}
+	}
+	return set, nil
+}
+
+func parseValue(s string, f field) (int, error) {
+	value, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%s %q is not a number", f.name, s)
+	}
+	if value < f.min || value > f.max {
+		return 0, fmt.Errorf("%s %d is not between %d and %d", f.name, value, f.min, f.max)
+	}
+	return value, nil
+}
+
+func (s *Schedule) String() string {
+	return s.spec
+}
+
+// Next returns the first time the schedule fires strictly after after, or
+// the zero time if it never fires.
+func (s *Schedule) Next(after time.Time) time.Time {
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxSearch)
+	for t.Before(limit) {
+		switch {
+		case !s.has(3, int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case !s.has(1, t.Hour()):
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case !s.has(0, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) has(field, value int) bool {
+	return s.sets[field]&(1<<value) != 0
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	day := s.has(2, t.Day())
+	weekday := s.has(4, int(t.Weekday()))
+	if s.anyDay || s.anyWeek {
+		return day && weekday
+	}
+	return day || weekday
+}
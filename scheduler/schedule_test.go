@@ -0,0 +1,59 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		spec  string
+		after time.Time
+		want  time.Time
+	}{
+		{"*/15 * * * *", time.Date(2024, 3, 4, 10, 7, 30, 0, time.UTC), time.Date(2024, 3, 4, 10, 15, 0, 0, time.UTC)},
+		{"0 * * * *", time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC), time.Date(2024, 3, 4, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC), time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)},
+		{" @hourly ", time.Date(2024, 3, 4, 10, 59, 0, 0, time.UTC), time.Date(2024, 3, 4, 11, 0, 0, 0, time.UTC)},
+		{"30 2 * * 1-5", time.Date(2024, 3, 8, 3, 0, 0, 0, time.UTC), time.Date(2024, 3, 11, 2, 30, 0, 0, time.UTC)},
+		{"0 9 * * 7", time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 10, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Either day field may match when both are restricted.
+		{"0 0 13 * 5", time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 8, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 2 *", time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC), time.Time{}},
+	}
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			schedule, err := ParseSchedule(test.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := schedule.Next(test.after); !got.Equal(test.want) {
+				t.Errorf("Next(%v) = %v, want %v", test.after, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseScheduleRejects(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"@often",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"1-x * * * *",
+	} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q) succeeded", spec)
+		}
+	}
+}
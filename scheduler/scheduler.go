@@ -0,0 +1,210 @@
+// Package scheduler runs background jobs on cron schedules inside the server
+// process. Every replica runs a Scheduler, and a lease stored in the database
+// makes sure each scheduled run of a job happens on only one of them.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"library_management_system/apperrors"
+	"library_management_system/models"
+	"library_management_system/repository"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRunsLimit = 20
+	maxRunsLimit     = 100
+)
+
+// Func is the work of a job. It returns a short summary of what it did.
+type Func func(ctx context.Context) (string, error)
+
+type job struct {
+	name     string
+	schedule *Schedule
+	run      Func
+	next     time.Time
+	running  bool
+}
+
+// Scheduler runs the jobs added to it when they are due. A run is recorded
+// in the job run history of the repository, which also holds the leases.
+type Scheduler struct {
+	repo     repository.JobRepository
+	owner    string
+	tick     time.Duration
+	leaseTTL time.Duration
+
+	mu   sync.Mutex
+	jobs []*job
+}
+
+// New returns a Scheduler that checks for due jobs every tick. owner names
+// this instance in leases and runs; when empty the host name and process ID
+// are used. A run may take up to leaseTTL, after which it is cancelled and
+// its lease lapses.
+func New(repo repository.JobRepository, owner string, tick, leaseTTL time.Duration) *Scheduler {
+	if owner == "" {
+		host, _ := os.Hostname()
+		owner = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	return &Scheduler{repo: repo, owner: owner, tick: tick, leaseTTL: leaseTTL}
+}
+
+// Add registers a job to run on the cron schedule spec.
+func (s *Scheduler) Add(name, spec string, run Func) error {
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		if j.name == name {
+			return fmt.Errorf("job %q is already scheduled", name)
+		}
+	}
+	s.jobs = append(s.jobs, &job{name: name, schedule: schedule, run: run, next: schedule.Next(time.Now())})
+	return nil
+}
+
+// Run starts due jobs until ctx is done and then waits for the runs in
+// progress to finish. Runs see ctx and so are cancelled with it. A job that
+// is still running when it falls due again skips that run, and runs missed
+// while the server was down are not made up.
+func (s *Scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	ticker := time.NewTicker(s.tick)
+	defer ticker.Stop()
+	for {
+		now := time.Now()
+		s.mu.Lock()
+		for _, j := range s.jobs {
+			if j.next.IsZero() || j.next.After(now) {
+				continue
+			}
+			slot := j.next
+			j.next = j.schedule.Next(now)
+			if j.running {
+				continue
+			}
+			j.running = true
+			wg.Add(1)
+			go func(j *job) {
+				defer wg.Done()
+				s.runJob(ctx, j, slot)
+				s.mu.Lock()
+				j.running = false
+				s.mu.Unlock()
+			}(j)
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runJob runs j for the scheduled time slot if this instance wins its lease,
+// and records the run. The records are written with a context of their own
+// so that a run cancelled by shutdown is still recorded as failed.
+func (s *Scheduler) runJob(ctx context.Context, j *job, slot time.Time) {
+	recordCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.leaseTTL)
+	defer cancel()
+
+	now := time.Now().UTC()
+	acquired, err := s.repo.AcquireLease(recordCtx, j.name, s.owner, slot.UTC(), now, now.Add(s.leaseTTL))
+	if err != nil {
+		log.Printf("job %s: acquiring lease: %v", j.name, err)
+		return
+	}
+	if !acquired {
+		return
+	}
+	defer func() {
+		if err := s.repo.ReleaseLease(recordCtx, j.name, s.owner, time.Now().UTC()); err != nil {
+			log.Printf("job %s: releasing lease: %v", j.name, err)
+		}
+	}()
+
+	run := models.JobRun{
+		Job:         j.name,
+		Owner:       s.owner,
+		Status:      models.JobRunning,
+		ScheduledAt: slot.UTC(),
+		StartedAt:   now,
+	}
+	if err := s.repo.InsertRun(recordCtx, &run); err != nil {
+		log.Printf("job %s: recording run: %v", j.name, err)
+		return
+	}
+
+	runCtx, cancelRun := context.WithTimeout(ctx, s.leaseTTL)
+	result, err := j.run(runCtx)
+	cancelRun()
+
+	finishedAt := time.Now().UTC()
+	run.FinishedAt = &finishedAt
+	run.Result = result
+	run.Status = models.JobSucceeded
+	if err != nil {
+		run.Status = models.JobFailed
+		run.Error = err.Error()
+		log.Printf("job %s failed: %v", j.name, err)
+	}
+	if err := s.repo.UpdateRun(recordCtx, run); err != nil {
+		log.Printf("job %s: recording run: %v", j.name, err)
+	}
+}
+
+// Jobs returns the scheduled jobs with the time this instance will next try
+// to run them, by name.
+func (s *Scheduler) Jobs() []models.Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]models.Job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, models.Job{Name: j.name, Schedule: j.schedule.String(), NextRunAt: j.next})
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].Name < jobs[k].Name })
+	return jobs
+}
+
+// Runs returns the most recent runs of all instances, newest first, of the
+// job named in query or of every job.
+func (s *Scheduler) Runs(ctx context.Context, query models.JobRunQuery) ([]models.JobRun, error) {
+	var errorMessages []string
+	if query.Job != "" && !s.scheduled(query.Job) {
+		errorMessages = append(errorMessages, fmt.Sprintf("job %q is not scheduled", query.Job))
+	}
+	if query.Limit == 0 {
+		query.Limit = defaultRunsLimit
+	}
+	if query.Limit < 0 || query.Limit > maxRunsLimit {
+		errorMessages = append(errorMessages, fmt.Sprintf("limit must be between 1 and %d", maxRunsLimit))
+	}
+	if len(errorMessages) > 0 {
+		return nil, &apperrors.InvalidQueryError{ErrorMessages: errorMessages}
+	}
+	return s.repo.FindRuns(ctx, query)
+}
+
+func (s *Scheduler) scheduled(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		if j.name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package scheduler_test
+
+import (
+	"context"
+	"library_management_system/internal/testbackends"
+	"library_management_system/models"
+	"library_management_system/scheduler"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLeaseBetweenInstances(t *testing.T) {
+	ctx := context.Background()
+	slot := time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC)
+	next := slot.Add(time.Hour)
+	for name, repos := range testbackends.Open(t) {
+		t.Run(name, func(t *testing.T) {
+			jobs := repos.Jobs
+			acquire := func(owner string, slot, now time.Time) bool {
+				t.Helper()
+				acquired, err := jobs.AcquireLease(ctx, "report", owner, slot, now, now.Add(time.Minute))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return acquired
+			}
+
+			if !acquire("a", slot, slot) {
+				t.Fatal("a could not claim a free slot")
+			}
+			if acquire("b", slot, slot.Add(time.Second)) {
+				t.Error("b claimed the slot a holds")
+			}
+			if acquire("b", next, slot.Add(time.Second)) {
+				t.Error("b claimed the next slot while a's lease is live")
+			}
+			if err := jobs.ReleaseLease(ctx, "report", "a", slot.Add(2*time.Second)); err != nil {
+				t.Fatal(err)
+			}
+			if acquire("b", slot, slot.Add(3*time.Second)) {
+				t.Error("b claimed the slot a already ran")
+			}
+			if !acquire("b", next, next) {
+				t.Fatal("b could not claim the next slot once a released it")
+			}
+			if acquire("a", slot, next) {
+				t.Error("a claimed an earlier slot than the one claimed")
+			}
+
+			// b stops without releasing; its lease lapses after a minute.
+			later := next.Add(time.Hour)
+			if acquire("a", later, next.Add(30*time.Second)) {
+				t.Error("a claimed a slot while b's lease is live")
+			}
+			if !acquire("a", later, next.Add(2*time.Minute)) {
+				t.Error("a could not claim a slot once b's lease expired")
+			}
+		})
+	}
+}
+
+func TestSlotRunsOnce(t *testing.T) {
+	ctx := context.Background()
+	slot := time.Now().UTC().Truncate(time.Minute)
+	for name, repos := range testbackends.Open(t) {
+		t.Run(name, func(t *testing.T) {
+			var runs atomic.Int32
+			report := func(ctx context.Context) (string, error) {
+				runs.Add(1)
+				return "done", nil
+			}
+			var wg sync.WaitGroup
+			for _, owner := range []string{"a", "b", "c", "d"} {
+				s := scheduler.New(repos.Jobs, owner, time.Second, time.Minute)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					scheduler.RunJob(s, ctx, "report", report, slot)
+				}()
+			}
+			wg.Wait()
+
+			if n := runs.Load(); n != 1 {
+				t.Errorf("the slot ran %d times", n)
+			}
+			recorded, err := repos.Jobs.FindRuns(ctx, models.JobRunQuery{Job: "report", Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(recorded) != 1 || recorded[0].Status != models.JobSucceeded || recorded[0].Result != "done" {
+				t.Errorf("recorded runs are %+v", recorded)
+			}
+		})
+	}
+}
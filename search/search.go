@@ -0,0 +1,205 @@
+// Package search implements the catalog's full-text search: normalising text
+// into terms, parsing user queries and ranking books by relevance. Every
+// storage backend uses it so that search behaves the same whichever one is
+// configured; the backends only differ in how they find candidate books.
+package search
+
+import (
+	"fmt"
+	"library_management_system/models"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	titleWeight  = 2.0
+	authorWeight = 1.0
+	// prefixWeight discounts a prefix match against an exact term match.
+	prefixWeight = 0.5
+	// phraseBonus rewards a phrase over the same words found apart.
+	phraseBonus = 1.5
+)
+
+// Clause is one part of a query: a single term, a prefix (written "term*") or
+// a quoted phrase of several terms.
+type Clause struct {
+	Terms  []string
+	Prefix bool
+}
+
+// Query is a parsed search query. A book matches when it satisfies every clause.
+type Query struct {
+	Clauses []Clause
+}
+
+// Normalize splits text into lower-case terms with diacritics removed, so that
+// "Émile Zola" yields ["emile", "zola"].
+func Normalize(text string) []string {
+	var terms []string
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			terms = append(terms, current.String())
+			current.Reset()
+		}
+	}
+	for _, r := range norm.NFD.String(text) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// Combining mark left over from decomposing an accented letter.
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			current.WriteRune(unicode.ToLower(r))
+		default:
+			flush()
+		}
+	}
+	flush()
+	return terms
+}
+
+// BookTerms returns the distinct normalised terms of a book's title and
+// author. Backends that keep a term index store exactly these.
+func BookTerms(book models.Book) []string {
+	seen := make(map[string]bool)
+	var terms []string
+	for _, term := range append(Normalize(book.Title), Normalize(book.Author)...) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	sort.Strings(terms)
+	return terms
+}
+
+// Parse turns user input into a Query. Double-quoted text is a phrase and a
+// trailing * makes a word a prefix; everything else is a plain term.
+func Parse(input string) (Query, error) {
+	var query Query
+	parts := strings.Split(input, `"`)
+	if len(parts)%2 == 0 {
+		return query, fmt.Errorf("unbalanced quote in %q", input)
+	}
+	for i, part := range parts {
+		if i%2 == 1 {
+			if terms := Normalize(part); len(terms) > 0 {
+				query.Clauses = append(query.Clauses, Clause{Terms: terms})
+			}
+			continue
+		}
+		for _, word := range strings.Fields(part) {
+			terms := Normalize(word)
+			for j, term := range terms {
+				// Only the last term of a word such as "jean-paul*" is a prefix.
+				prefix := j == len(terms)-1 && strings.HasSuffix(word, "*")
+				query.Clauses = append(query.Clauses, Clause{Terms: []string{term}, Prefix: prefix})
+			}
+		}
+	}
+	if len(query.Clauses) == 0 {
+		return query, fmt.Errorf("%q contains nothing to search for", input)
+	}
+	return query, nil
+}
+
+// RequiredTerms returns the whole terms every match must contain and the
+// prefixes each of which some term of a match must start with. Backends use
+// them to narrow down candidates before ranking.
+func (q Query) RequiredTerms() (terms []string, prefixes []string) {
+	for _, clause := range q.Clauses {
+		if clause.Prefix {
+			prefixes = append(prefixes, clause.Terms[0])
+		} else {
+			terms = append(terms, clause.Terms...)
+		}
+	}
+	return terms, prefixes
+}
+
+// Score reports whether book matches q and, if so, how relevant it is.
+// Matches in the title count more than matches in the author, exact terms
+// more than prefixes, and shorter fields more than longer ones.
+func (q Query) Score(book models.Book) (float64, bool) {
+	fields := []struct {
+		terms  []string
+		weight float64
+	}{
+		{Normalize(book.Title), titleWeight},
+		{Normalize(book.Author), authorWeight},
+	}
+
+	var score float64
+	for _, clause := range q.Clauses {
+		var clauseScore float64
+		for _, field := range fields {
+			if hits := clause.count(field.terms); hits > 0 {
+				clauseScore += field.weight * float64(hits) / math.Sqrt(float64(len(field.terms)))
+			}
+		}
+		if clauseScore == 0 {
+			return 0, false
+		}
+		if clause.Prefix {
+			clauseScore *= prefixWeight
+		}
+		if len(clause.Terms) > 1 {
+			clauseScore *= phraseBonus * float64(len(clause.Terms))
+		}
+		score += clauseScore
+	}
+	return score, true
+}
+
+// count returns how many times the clause occurs in a field's terms.
+func (c Clause) count(terms []string) int {
+	hits := 0
+	for i := 0; i+len(c.Terms) <= len(terms); i++ {
+		if c.matchesAt(terms, i) {
+			hits++
+		}
+	}
+	return hits
+}
+
+func (c Clause) matchesAt(terms []string, i int) bool {
+	if c.Prefix {
+		return strings.HasPrefix(terms[i], c.Terms[0])
+	}
+	for j, term := range c.Terms {
+		if terms[i+j] != term {
+			return false
+		}
+	}
+	return true
+}
+
+// Rank keeps the candidates that match q and orders them by descending
+// relevance, breaking ties by book ID.
+func (q Query) Rank(candidates []models.Book) []models.Book {
+	type scored struct {
+		book  models.Book
+		score float64
+	}
+	var matches []scored
+	for _, book := range candidates {
+		if score, ok := q.Score(book); ok {
+			matches = append(matches, scored{book, score})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].book.ID < matches[j].book.ID
+	})
+
+	books := make([]models.Book, len(matches))
+	for i, match := range matches {
+		books[i] = match.book
+	}
+	return books
+}
@@ -0,0 +1,155 @@
+package search
+
+import (
+	"library_management_system/models"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Émile Zola", []string{"emile", "zola"}},
+		{"ÉMILE ZOLA", []string{"emile", "zola"}},
+		{"Jean-Paul Sartre", []string{"jean", "paul", "sartre"}},
+		{"  Crème brûlée!! ", []string{"creme", "brulee"}},
+		{"Ångström 42", []string{"angstrom", "42"}},
+		{"Dvořák", []string{"dvorak"}},
+		{"", nil},
+		{"--", nil},
+	}
+	for _, test := range tests {
+		if got := Normalize(test.text); !slices.Equal(got, test.want) {
+			t.Errorf("Normalize(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []Clause
+	}{
+		{"zola", []Clause{{Terms: []string{"zola"}}}},
+		{"Thérèse  Raquin", []Clause{{Terms: []string{"therese"}}, {Terms: []string{"raquin"}}}},
+		{"zol*", []Clause{{Terms: []string{"zol"}, Prefix: true}}},
+		{"jean-paul*", []Clause{{Terms: []string{"jean"}}, {Terms: []string{"paul"}, Prefix: true}}},
+		{`"Le Noir" sart*`, []Clause{{Terms: []string{"le", "noir"}}, {Terms: []string{"sart"}, Prefix: true}}},
+	}
+	for _, test := range tests {
+		query, err := Parse(test.input)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(query.Clauses, test.want) {
+			t.Errorf("Parse(%q) = %+v, want %+v", test.input, query.Clauses, test.want)
+		}
+	}
+
+	for _, input := range []string{"", "   ", `"`, `"le noir`, `""`, "***"} {
+		if _, err := Parse(input); err == nil {
+			t.Errorf("Parse(%q) succeeded", input)
+		}
+	}
+}
+
+var catalog = []models.Book{
+	{ID: "about", Title: "Zola", Author: "Frederick Brown"},
+	{ID: "black", Title: "Black Red", Author: "Anonymous"},
+	{ID: "germinal", Title: "Germinal", Author: "Émile Zola"},
+	{ID: "nausea", Title: "Noir", Author: "Jean-Paul Sartre"},
+	{ID: "red", Title: "Red Black", Author: "Anonymous"},
+	{ID: "rouge", Title: "Le Rouge et le Noir", Author: "Stendhal"},
+	{ID: "therese", Title: "Thérèse Raquin", Author: "Émile Zola"},
+}
+
+func TestRank(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		// A title match outranks an author match; ties go by ID.
+		{"zola", []string{"about", "germinal", "therese"}},
+		// Diacritics and case are folded on both sides.
+		{"emile ZOLA", []string{"germinal", "therese"}},
+		{"ÉMILE", []string{"germinal", "therese"}},
+		{"therese", []string{"therese"}},
+		// Shorter fields count more.
+		{"noir", []string{"nausea", "rouge"}},
+		// Every clause must match.
+		{"germinal zola", []string{"germinal"}},
+		{"zola sartre", nil},
+		// Prefixes match the start of a term only, and count less than
+		// whole terms.
+		{"zol*", []string{"about", "germinal", "therese"}},
+		{"ther*", []string{"therese"}},
+		{"sart", nil},
+		{"sart*", []string{"nausea"}},
+		{"artre*", nil},
+		{"jean-pa*", []string{"nausea"}},
+		// A phrase matches its words only in order and next to each other.
+		{`"red black"`, []string{"red"}},
+		{"red black", []string{"black", "red"}},
+		{`"le noir"`, []string{"rouge"}},
+		{`"rouge le"`, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			query, err := Parse(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, book := range query.Rank(catalog) {
+				got = append(got, book.ID)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("Rank = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestScoreWeights(t *testing.T) {
+	tests := []struct {
+		name          string
+		better, worse string
+		book          models.Book
+	}{
+		{"title over author", "zola", "emile", models.Book{Title: "Zola", Author: "Emile"}},
+		{"whole term over prefix", "germinal", "germ*", models.Book{Title: "Germinal"}},
+		{"phrase over words apart", `"red black"`, "red black", models.Book{Title: "Red Black"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			better, _ := Parse(test.better)
+			worse, _ := Parse(test.worse)
+			betterScore, ok := better.Score(test.book)
+			if !ok {
+				t.Fatalf("%s does not match", test.better)
+			}
+			worseScore, ok := worse.Score(test.book)
+			if !ok {
+				t.Fatalf("%s does not match", test.worse)
+			}
+			if betterScore <= worseScore {
+				t.Errorf("%s scores %v, not more than %s at %v", test.better, betterScore, test.worse, worseScore)
+			}
+		})
+	}
+}
+
+func TestRequiredTerms(t *testing.T) {
+	query, err := Parse(`"le noir" sart* zola`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	terms, prefixes := query.RequiredTerms()
+	if !slices.Equal(terms, []string{"le", "noir", "zola"}) || !slices.Equal(prefixes, []string{"sart"}) {
+		t.Errorf("RequiredTerms = %q, %q", terms, prefixes)
+	}
+}
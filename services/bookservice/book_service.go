@@ -2,87 +2,365 @@ package bookservice
 
 import (
 	"context"
+	"encoding/base64"
+	"fmt"
 	"library_management_system/apperrors"
-	"library_management_system/config/dbconfig"
-	"library_management_system/db"
+	"library_management_system/calendar"
+	"library_management_system/config/appconfig"
+	"library_management_system/fines"
 	"library_management_system/models"
-	"library_management_system/services/userservice"
+	"library_management_system/notify"
+	"library_management_system/repository"
+	"library_management_system/search"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
 
-	"go.mongodb.org/mongo-driver/bson"
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
 )
 
+// Service implements catalog, borrowing and hold operations on top of the
+// book, user, loan and hold repositories, and settles fines through the
+// payment repository. Due dates and fines follow the
+// calendar in the calendar repository, and patrons are told about due and
+// overdue loans through a notifier.
+type Service struct {
+	books        repository.BookRepository
+	users        repository.UserRepository
+	loans        repository.LoanRepository
+	holds        repository.HoldRepository
+	payments     repository.PaymentRepository
+	calendars    repository.CalendarRepository
+	transactor   repository.Transactor
+	notifier     notify.Notifier
+	loanPeriod   time.Duration
+	maxRenewals  int
+	holdExpiry   time.Duration
+	pickupWindow time.Duration
+	policy       fines.Policy
+	maxBalance   int
+	loanLimits   map[string]int
+	defaultLimit int
+	dueSoon      time.Duration
+}
+
+// NewService returns a Service that reads and writes through the given
+// repositories, using transactor to keep book, user, loan and hold records in
+// step, and sends notices through notifier.
+func NewService(books repository.BookRepository, users repository.UserRepository, loans repository.LoanRepository,
+	holds repository.HoldRepository, payments repository.PaymentRepository, calendars repository.CalendarRepository,
+	transactor repository.Transactor, notifier notify.Notifier, config appconfig.CirculationConfig) *Service {
+	return &Service{
+		books:        books,
+		users:        users,
+		loans:        loans,
+		holds:        holds,
+		payments:     payments,
+		calendars:    calendars,
+		transactor:   transactor,
+		notifier:     notifier,
+		loanPeriod:   time.Duration(config.LoanPeriod),
+		maxRenewals:  config.MaxRenewals,
+		holdExpiry:   time.Duration(config.HoldExpiry),
+		pickupWindow: time.Duration(config.PickupWindow),
+		policy: fines.Policy{
+			DailyRate:  config.FineDailyRate,
+			GraceDays:  config.FineGraceDays,
+			MaxPerItem: config.FineMaxPerItem,
+		},
+		maxBalance:   config.MaxBalance,
+		loanLimits:   config.LoanLimits,
+		defaultLimit: config.DefaultLoanLimit,
+		dueSoon:      time.Duration(config.DueSoonWindow),
+	}
+}
+
 // GetBookByID retrieves a book by its ID from the database.
-func GetBookByID(id string, ctx context.Context) (*models.Book, error) {
-	var book models.Book
-	filter := bson.M{dbconfig.ID: id}
-	err := db.BooksCollection.FindOne(ctx, filter).Decode(&book)
+func (s *Service) GetBookByID(id string, ctx context.Context) (*models.Book, error) {
+	return s.books.FindByID(ctx, id)
+}
+
+// ListBooks retrieves one page of the catalog, filtered and sorted as query
+// asks. The returned page carries a token for the next page when there is one.
+func (s *Service) ListBooks(query models.BookQuery, ctx context.Context) (*models.BookPage, error) {
+	query, err := prepareBookQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	books, total, err := s.books.Find(ctx, query)
 	if err != nil {
 		return nil, err
 	}
-	return &book, nil
+
+	page := &models.BookPage{
+		Books:  books,
+		Total:  total,
+		Limit:  query.Limit,
+		Offset: query.Offset,
+	}
+	if next := query.Offset + len(books); len(books) > 0 && int64(next) < total {
+		page.NextPageToken = encodePageToken(next)
+	}
+	return page, nil
 }
 
-// GetAllBooks retrieves all books from the database.
-func GetAllBooks(ctx context.Context) ([]models.Book, error) {
-	var books []models.Book
-	cursor, err := db.BooksCollection.Find(ctx, bson.D{{}})
+// BorrowBook allows a user to borrow a copy of a book, updating the book and
+// user records and opening a loan in one transaction. The copy with barcode is
+// lent, or the first available one when barcode is empty. Taking the copy is
+// a guarded update, so two concurrent borrows of the last copy cannot both
+// succeed. Users owing more than the configured balance or already at their
+// loan limit cannot borrow. Copies set aside for holds are lent only to their
+// holders; a holder borrowing the book fulfils their hold.
+func (s *Service) BorrowBook(bookId, username, barcode string, ctx context.Context) (bool, error) {
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		_, err := s.borrow(ctx, bookId, username, barcode, "")
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ReleaseBook returns the copy of a book lent to username, updating the book
+// and user records and closing the loan in one transaction. A late return is
+// fined according to the fine policy. The returned copy is set aside for the
+// next hold in the queue, if any.
+func (s *Service) ReleaseBook(bookId, username string, ctx context.Context) (bool, error) {
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		_, err := s.release(ctx, bookId, username, "")
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CheckOutForPatron lends a copy of a book at the desk to the patron named by
+// username or by cardNumber, with the same checks as BorrowBook. The loan
+// records staff as the one who checked the copy out.
+func (s *Service) CheckOutForPatron(bookId, username, cardNumber, barcode, staff string, ctx context.Context) (*models.Loan, error) {
+	var loan *models.Loan
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		patron, err := s.patron(ctx, username, cardNumber)
+		if err != nil {
+			return err
+		}
+		loan, err = s.borrow(ctx, bookId, patron.Username, barcode, staff)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(ctx)
+	return loan, nil
+}
 
-	for cursor.Next(ctx) {
-		var book models.Book
-		if err := cursor.Decode(&book); err != nil {
+// CheckInForPatron takes back at the desk the copy of a book lent to the
+// patron named by username or by cardNumber, as ReleaseBook does. It returns
+// the closed loan, recording staff as the one who checked the copy in, or nil
+// for books borrowed before loans were recorded.
+func (s *Service) CheckInForPatron(bookId, username, cardNumber, staff string, ctx context.Context) (*models.Loan, error) {
+	var loan *models.Loan
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		patron, err := s.patron(ctx, username, cardNumber)
+		if err != nil {
+			return err
+		}
+		loan, err = s.release(ctx, bookId, patron.Username, staff)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return loan, nil
+}
+
+// patron finds the user a desk request is for, given exactly one of a
+// username and a library card number.
+func (s *Service) patron(ctx context.Context, username, cardNumber string) (*models.User, error) {
+	switch {
+	case username != "" && cardNumber != "":
+		return nil, &apperrors.UserValidationError{ErrorMessages: []string{"give either a username or a card number, not both"}}
+	case username != "":
+		return s.users.FindByUsername(ctx, username)
+	case cardNumber != "":
+		return s.users.FindByCardNumber(ctx, cardNumber)
+	}
+	return nil, &apperrors.UserValidationError{ErrorMessages: []string{"username or card number is required"}}
+}
+
+// borrow lends a copy of a book to username and opens the loan, recording
+// staff when the copy is lent at the desk. It must run in a transaction.
+func (s *Service) borrow(ctx context.Context, bookId, username, barcode, staff string) (*models.Loan, error) {
+	book, err := s.books.FindByID(ctx, bookId)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.users.FindByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if limit := s.loanLimit(user); len(user.BorrowedBookIDs) >= limit && !slices.Contains(user.BorrowedBookIDs, bookId) {
+		return nil, &apperrors.LoanLimitReachedError{BookTitle: book.Title, Limit: limit}
+	}
+	balance, err := s.balance(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if balance.Total > s.maxBalance {
+		return nil, &apperrors.BalanceExceededError{Username: username, Balance: balance.Total, Limit: s.maxBalance}
+	}
+	days, err := s.calendar(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	holds, err := s.serveQueue(ctx, bookId, now)
+	if err != nil {
+		return nil, err
+	}
+	hold := holdOf(holds, username)
+	if hold != nil && hold.Status == models.HoldReady && barcode == "" {
+		barcode = hold.Barcode
+	}
+	lent, err := s.books.CheckOut(ctx, bookId, username, barcode)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.users.AddBorrowedBook(ctx, username, bookId); err != nil {
+		return nil, err
+	}
+	if hold != nil {
+		if err := s.fulfilHold(ctx, *hold, lent, now); err != nil {
 			return nil, err
 		}
-		books = append(books, book)
 	}
-	return books, nil
+	loan := &models.Loan{
+		BookID:       bookId,
+		Barcode:      lent,
+		Username:     username,
+		BorrowedAt:   now,
+		DueAt:        days.NextOpenDay(now.Add(s.loanPeriod)),
+		CheckedOutBy: staff,
+	}
+	if err := s.loans.Insert(ctx, loan); err != nil {
+		return nil, err
+	}
+	loan.BookTitle = book.Title
+	return loan, nil
 }
 
-// BorrowBook allows a user to borrow a book, updating both the book and user records.
-func BorrowBook(bookId, username string, ctx context.Context) (bool, error) {
-	var book models.Book
-	filter := bson.M{dbconfig.ID: bookId}
-	err := db.BooksCollection.FindOne(ctx, filter).Decode(&book)
+// release takes back the copy of a book lent to username and closes the loan,
+// recording staff when the copy is returned at the desk. It returns the closed
+// loan, or nil when the borrowing predates loan records. It must run in a
+// transaction.
+func (s *Service) release(ctx context.Context, bookId, username, staff string) (*models.Loan, error) {
+	if _, err := s.users.FindByUsername(ctx, username); err != nil {
+		return nil, err
+	}
+	if _, err := s.books.CheckIn(ctx, bookId, username); err != nil {
+		return nil, err
+	}
+	if err := s.users.RemoveBorrowedBook(ctx, username, bookId); err != nil {
+		return nil, err
+	}
+	policy, err := s.finePolicy(ctx)
 	if err != nil {
-		return false, &apperrors.BookNotFoundError{BookID: bookId}
+		return nil, err
+	}
+	now := time.Now().UTC()
+	loan, err := s.loans.FindOpen(ctx, bookId, username)
+	switch err.(type) {
+	case nil:
+		closing := models.LoanClosing{
+			At:          now,
+			Outcome:     models.LoanReturned,
+			Fine:        policy.Fine(loan.DueAt, now),
+			CheckedInBy: staff,
+		}
+		if err := s.loans.Close(ctx, loan.ID, closing); err != nil {
+			return nil, err
+		}
+		repository.CloseLoan(loan, closing)
+	case *apperrors.LoanNotFoundError:
+		// Books borrowed before loans were recorded have none to close.
+		loan = nil
+	default:
+		return nil, err
+	}
+	if _, err := s.serveQueue(ctx, bookId, now); err != nil {
+		return nil, err
+	}
+	return loan, nil
+}
+
+func (s *Service) AddBook(book models.Book, ctx context.Context) (bool, error) {
+
+	_, err := s.books.FindByID(ctx, book.ID)
+	if err == nil {
+		return false, &apperrors.BookWithSameIDError{BookID: book.ID}
 	}
 
-	// Find the user and update borrowed books
-	user, err := userservice.FindUser(ctx, username)
+	_, err = validateBookDataForAddition(book)
 	if err != nil {
 		return false, err
 	}
-
-	if user.BorrowedBookIDs == nil {
-		user.BorrowedBookIDs = []string{}
+	book.Copies = copiesForAddition(book)
+	book.Amount = repository.AvailableCopies(book.Copies)
+	err = s.books.Insert(ctx, book)
+	if err != nil {
+		return false, err
 	}
+	return true, nil
+}
 
-	for _, b := range user.BorrowedBookIDs {
-		if b == bookId {
-			return false, &apperrors.AlreadyHaveBookError{BookTitle: book.Title}
+func (s *Service) DeleteBook(id string, ctx context.Context) (bool, error) {
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		book, err := s.books.FindByID(ctx, id)
+		if err != nil {
+			return err
 		}
+		if len(book.OwnedBy) > 0 {
+			return &apperrors.DeleteBorrowedBookError{BookTitle: book.Title}
+		}
+		holds, err := s.holds.FindActive(ctx, id)
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		for _, hold := range holds {
+			if err := s.closeHold(ctx, hold, models.HoldCancelled, now); err != nil {
+				return err
+			}
+		}
+		return s.books.Delete(ctx, id)
+	})
+	if err != nil {
+		return false, err
 	}
+	return true, nil
+}
 
-	if book.Amount == 0 {
-		return false, &apperrors.AmountIsZeroError{BookTitle: book.Title}
-	}
+func (s *Service) UpdateBook(id string, book models.Book, ctx context.Context) (bool, error) {
 
-	book.Amount--
-	if book.OwnedBy == nil {
-		book.OwnedBy = make([]string, 0)
+	_, err := s.books.FindByID(ctx, id)
+	if err != nil {
+		return false, err
 	}
-	book.OwnedBy = append(book.OwnedBy, username)
-	user.BorrowedBookIDs = append(user.BorrowedBookIDs, book.ID)
-	update := bson.M{dbconfig.SetOperator: bson.M{dbconfig.Amount: book.Amount, dbconfig.OwnedBy: book.OwnedBy}}
-	_, err = db.BooksCollection.UpdateByID(ctx, bookId, update)
+	_, err = validateBookDataForUpdate(book)
 	if err != nil {
 		return false, err
 	}
+	book.ID = id
+
+	err = s.books.Update(ctx, book)
 
-	_, err = userservice.UpdateUser(ctx, user)
 	if err != nil {
 		return false, err
 	}
@@ -90,117 +368,917 @@ func BorrowBook(bookId, username string, ctx context.Context) (bool, error) {
 	return true, nil
 }
 
-func ReleaseBook(bookId, username string, ctx context.Context) (bool, error) {
-	// Check and update the book document
-	var book models.Book
-	filter := bson.M{dbconfig.ID: bookId}
-	err := db.BooksCollection.FindOne(ctx, filter).Decode(&book)
+// RenewBook pushes back the due date of username's loan of a book by one loan
+// period. Overdue loans, loans renewed the maximum number of times and books
+// other patrons are waiting for cannot be renewed.
+func (s *Service) RenewBook(bookId, username string, ctx context.Context) (*models.Loan, error) {
+	var renewed *models.Loan
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		book, err := s.books.FindByID(ctx, bookId)
+		if err != nil {
+			return err
+		}
+		loan, err := s.loans.FindOpen(ctx, bookId, username)
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		if now.After(loan.DueAt) {
+			return &apperrors.LoanOverdueError{BookTitle: book.Title, DueAt: loan.DueAt}
+		}
+		if loan.Renewals >= s.maxRenewals {
+			return &apperrors.RenewalLimitReachedError{BookTitle: book.Title, Limit: s.maxRenewals}
+		}
+		// Holds whose time ran out do not count, even before ExpireHolds
+		// gets to them.
+		holds, err := s.serveQueue(ctx, bookId, now)
+		if err != nil {
+			return err
+		}
+		for _, hold := range holds {
+			if hold.Status == models.HoldWaiting {
+				return &apperrors.HoldsPendingError{BookTitle: book.Title}
+			}
+		}
+
+		days, err := s.calendar(ctx)
+		if err != nil {
+			return err
+		}
+		dueAt := days.NextOpenDay(loan.DueAt.Add(s.loanPeriod))
+		if err := s.loans.Renew(ctx, loan.ID, dueAt); err != nil {
+			return err
+		}
+		loan.DueAt = dueAt
+		loan.Renewals++
+		renewed = loan
+		return nil
+	})
 	if err != nil {
-		return false, &apperrors.BookNotFoundError{BookID: bookId}
+		return nil, err
+	}
+	return renewed, nil
+}
+
+// GetBookLoans returns the loan history of a book, most recent first.
+func (s *Service) GetBookLoans(id string, ctx context.Context) ([]models.Loan, error) {
+	if _, err := s.books.FindByID(ctx, id); err != nil {
+		return nil, err
 	}
+	return s.loans.FindByBook(ctx, id)
+}
 
-	// Find the user and check borrowed books
-	user, err := userservice.FindUser(ctx, username)
+// GetUserLoans returns one page of username's current and past loans, most
+// recent first, with the titles of the books. query.Status narrows the loans
+// to open, overdue or returned ones.
+func (s *Service) GetUserLoans(username string, query models.LoanQuery, ctx context.Context) (*models.LoanPage, error) {
+	query, err := prepareLoanQuery(query)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	var found bool = false
-	for i, b := range user.BorrowedBookIDs {
-		if b == bookId {
-			// Remove the book from borrowed list
-			user.BorrowedBookIDs = append(user.BorrowedBookIDs[:i], user.BorrowedBookIDs[i+1:]...)
-			found = true
-			break
+	if _, err := s.users.FindByUsername(ctx, username); err != nil {
+		return nil, err
+	}
+	loans, err := s.loans.FindByUser(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	matching := []models.Loan{}
+	for _, loan := range loans {
+		if loanHasStatus(loan, query.Status, now) {
+			matching = append(matching, loan)
 		}
 	}
-	if !found {
-		return false, &apperrors.BookNotBorrowedError{BookTitle: book.Title}
+
+	page := &models.LoanPage{
+		Loans:  []models.Loan{},
+		Total:  int64(len(matching)),
+		Limit:  query.Limit,
+		Offset: query.Offset,
+	}
+	if query.Offset < len(matching) {
+		end := min(query.Offset+query.Limit, len(matching))
+		page.Loans = matching[query.Offset:end]
+		if end < len(matching) {
+			page.NextPageToken = encodePageToken(end)
+		}
 	}
 
-	for j, u := range book.OwnedBy {
-		if u == user.Username {
-			book.OwnedBy = append(book.OwnedBy[:j], book.OwnedBy[j+1:]...)
-			break
+	titles := make(map[string]string)
+	for i, loan := range page.Loans {
+		title, ok := titles[loan.BookID]
+		if !ok {
+			book, err := s.books.FindByID(ctx, loan.BookID)
+			if _, deleted := err.(*apperrors.BookNotFoundError); err != nil && !deleted {
+				return nil, err
+			}
+			if book != nil {
+				title = book.Title
+			}
+			titles[loan.BookID] = title
 		}
+		page.Loans[i].BookTitle = title
 	}
+	return page, nil
+}
 
-	// Update the book amount
-	book.Amount++
-	update := bson.M{dbconfig.SetOperator: bson.M{dbconfig.Amount: book.Amount, dbconfig.OwnedBy: book.OwnedBy}}
-	_, err = db.BooksCollection.UpdateByID(ctx, bookId, update)
+// GetProfile returns the account of username with the books they have on
+// loan now.
+func (s *Service) GetProfile(username string, ctx context.Context) (*models.Profile, error) {
+	user, err := s.users.FindByUsername(ctx, username)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+	loans, err := s.loans.FindByUser(ctx, username)
+	if err != nil {
+		return nil, err
 	}
 
-	// Update the user document
-	_, err = userservice.UpdateUser(ctx, user)
+	profile := &models.Profile{User: *user, Loans: []models.Loan{}}
+	titles := make(map[string]string)
+	for _, loan := range loans {
+		if loan.ReturnedAt != nil {
+			continue
+		}
+		if loan.BookTitle, err = s.loanTitle(ctx, loan, titles); err != nil {
+			return nil, err
+		}
+		profile.Loans = append(profile.Loans, loan)
+	}
+	return profile, nil
+}
+
+// GetBalance returns what username owes in fines, including fines still
+// accruing on overdue books.
+func (s *Service) GetBalance(username string, ctx context.Context) (*models.Balance, error) {
+	if _, err := s.users.FindByUsername(ctx, username); err != nil {
+		return nil, err
+	}
+	balance, err := s.balance(ctx, username)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
+	return &balance, nil
+}
 
-	return true, nil
+// SettleBalance records, on behalf of staff, that username paid amount of
+// what they owe or was let off it, and returns the new balance. Nothing can
+// be settled beyond the balance, so that no patron is ever in credit.
+func (s *Service) SettleBalance(username string, kind models.PaymentKind, amount int, note, staff string, ctx context.Context) (*models.Balance, error) {
+	var errorMessages []string
+	if kind != models.PaymentPaid && kind != models.PaymentWaived {
+		errorMessages = append(errorMessages, fmt.Sprintf("kind must be %s or %s, not %q", models.PaymentPaid, models.PaymentWaived, kind))
+	}
+	if amount <= 0 {
+		errorMessages = append(errorMessages, "amount must be positive")
+	}
+	if len(errorMessages) > 0 {
+		return nil, &apperrors.PaymentValidationError{ErrorMessages: errorMessages}
+	}
+
+	var balance models.Balance
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if _, err := s.users.FindByUsername(ctx, username); err != nil {
+			return err
+		}
+		var err error
+		balance, err = s.balance(ctx, username)
+		if err != nil {
+			return err
+		}
+		if amount > balance.Total {
+			return &apperrors.PaymentValidationError{ErrorMessages: []string{
+				fmt.Sprintf("%s of %d exceeds the balance of %d", kind, amount, balance.Total),
+			}}
+		}
+		payment := models.Payment{Username: username, Kind: kind, Amount: amount, Note: note, At: time.Now().UTC(), By: staff}
+		if err := s.payments.Insert(ctx, &payment); err != nil {
+			return err
+		}
+		balance, err = s.balance(ctx, username)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &balance, nil
 }
 
-func AddBook(book models.Book, ctx context.Context) (bool, error) {
+// GetPayments returns the payments and waivers recorded for username, oldest
+// first.
+func (s *Service) GetPayments(username string, ctx context.Context) ([]models.Payment, error) {
+	if _, err := s.users.FindByUsername(ctx, username); err != nil {
+		return nil, err
+	}
+	return s.payments.FindByUser(ctx, username)
+}
 
-	filter := bson.M{dbconfig.ID: book.ID}
-	var existingBook models.Book
-	err := db.BooksCollection.FindOne(ctx, filter).Decode(&existingBook)
-	if err == nil {
-		return false, &apperrors.BookWithSameIDError{BookID: book.ID}
+// loanLimit returns how many books user may have on loan at once: their own
+// override, or else the highest limit of their roles.
+func (s *Service) loanLimit(user *models.User) int {
+	if user.LoanLimit != nil {
+		return *user.LoanLimit
 	}
+	limit, found := 0, false
+	for _, role := range user.Roles {
+		if roleLimit, ok := s.loanLimits[role]; ok && (!found || roleLimit > limit) {
+			limit, found = roleLimit, true
+		}
+	}
+	if !found {
+		return s.defaultLimit
+	}
+	return limit
+}
 
-	_, err = validateBookDataForAddition(book)
+func (s *Service) balance(ctx context.Context, username string) (models.Balance, error) {
+	policy, err := s.finePolicy(ctx)
+	if err != nil {
+		return models.Balance{}, err
+	}
+	loans, err := s.loans.FindByUser(ctx, username)
+	if err != nil {
+		return models.Balance{}, err
+	}
+	payments, err := s.payments.FindByUser(ctx, username)
+	if err != nil {
+		return models.Balance{}, err
+	}
+	return policy.Balance(username, loans, payments, time.Now().UTC()), nil
+}
+
+// calendar returns the saved calendar, or the default one if none was saved.
+func (s *Service) calendar(ctx context.Context) (*calendar.Calendar, error) {
+	saved, err := s.calendars.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if saved == nil {
+		return calendar.New(calendar.Default())
+	}
+	return calendar.New(*saved)
+}
+
+// finePolicy returns the fine policy with the calendar applied, so that days
+// the library is closed are not fined.
+func (s *Service) finePolicy(ctx context.Context) (fines.Policy, error) {
+	days, err := s.calendar(ctx)
+	if err != nil {
+		return fines.Policy{}, err
+	}
+	policy := s.policy
+	policy.Calendar = days
+	return policy, nil
+}
+
+// AddCopy adds a physical copy to the book with id. The copy starts out
+// available unless another status is given, and an available copy is set
+// aside for the next hold in the queue, if any.
+func (s *Service) AddCopy(id string, copy models.Copy, ctx context.Context) (bool, error) {
+	if copy.Status == "" {
+		copy.Status = models.CopyAvailable
+	}
+	_, err := validateCopy(copy)
 	if err != nil {
 		return false, err
 	}
-	_, err = db.BooksCollection.InsertOne(ctx, book)
+	err = s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.books.AddCopy(ctx, id, copy); err != nil {
+			return err
+		}
+		_, err := s.serveQueue(ctx, id, time.Now().UTC())
+		return err
+	})
 	if err != nil {
 		return false, err
 	}
 	return true, nil
 }
 
-func DeleteBook(id string, ctx context.Context) (bool, error) {
-	filter := bson.M{dbconfig.ID: id}
-	var book models.Book
-	err := db.BooksCollection.FindOne(ctx, filter).Decode(&book)
+// UpdateCopy changes the status, shelf location and condition of the copy
+// with barcode. Copies on loan or on hold are changed by borrowing, releasing
+// and holds only.
+func (s *Service) UpdateCopy(id, barcode string, copy models.Copy, ctx context.Context) (bool, error) {
+	if copy.Barcode != "" && copy.Barcode != barcode {
+		return false, &apperrors.BookValidationError{ErrorMessages: []string{"cannot change copy barcode"}}
+	}
+	copy.Barcode = barcode
+	_, err := validateCopy(copy)
 	if err != nil {
-		return false, &apperrors.BookNotFoundError{BookID: id}
+		return false, err
 	}
-	if len(book.OwnedBy) > 0 {
-		return false, &apperrors.DeleteBorrowedBookError{BookTitle: book.Title}
+	err = s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.books.UpdateCopy(ctx, id, copy); err != nil {
+			return err
+		}
+		_, err := s.serveQueue(ctx, id, time.Now().UTC())
+		return err
+	})
+	if err != nil {
+		return false, err
 	}
-	_, err = db.BooksCollection.DeleteOne(ctx, filter)
+	return true, nil
+}
+
+// RemoveCopy deletes the copy with barcode from the catalog. Copies that were
+// written off stay, as the record of the write-off.
+func (s *Service) RemoveCopy(id, barcode string, ctx context.Context) (bool, error) {
+	err := s.books.RemoveCopy(ctx, id, barcode)
 	if err != nil {
 		return false, err
 	}
 	return true, nil
 }
 
-func UpdateBook(id string, book models.Book, ctx context.Context) (bool, error) {
+// WriteOffCopy declares the copy with barcode lost or damaged and withdraws it
+// from inventory, recording note and staff. If the copy is on loan, the loan
+// is closed with its overdue fine and replacementFee is charged to the
+// borrower. A patron the copy was set aside for goes back to waiting in the
+// hold queue.
+func (s *Service) WriteOffCopy(bookId, barcode string, outcome models.LoanOutcome, replacementFee int, note, staff string, ctx context.Context) (*models.WriteOff, error) {
+	if outcome != models.LoanLost && outcome != models.LoanDamaged {
+		return nil, &apperrors.BookValidationError{ErrorMessages: []string{fmt.Sprintf("unknown write-off reason %s", outcome)}}
+	}
+	if replacementFee < 0 {
+		return nil, &apperrors.BookValidationError{ErrorMessages: []string{"replacement fee cannot be negative"}}
+	}
 
-	filter := bson.M{dbconfig.ID: id}
-	var oldBook models.Book
-	err := db.BooksCollection.FindOne(ctx, filter).Decode(&oldBook)
+	var writeOff *models.WriteOff
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		now := time.Now().UTC()
+		book, err := s.books.FindByID(ctx, bookId)
+		if err != nil {
+			return err
+		}
+		i := slices.IndexFunc(book.Copies, func(c models.Copy) bool { return c.Barcode == barcode })
+		if i < 0 {
+			return &apperrors.CopyNotFoundError{Barcode: barcode}
+		}
+		status := book.Copies[i].Status
+		if replacementFee > 0 && status != models.CopyOnLoan {
+			return &apperrors.ReplacementFeeError{Barcode: barcode}
+		}
+		if status == models.CopyOnHold {
+			if err := s.requeueHold(ctx, bookId, barcode, now); err != nil {
+				return err
+			}
+		}
+
+		withdrawal := models.Withdrawal{Reason: outcome, Note: note, At: now, By: staff}
+		borrower, err := s.books.WithdrawCopy(ctx, bookId, barcode, withdrawal)
+		if err != nil {
+			return err
+		}
+		copy := book.Copies[i]
+		copy.Status = models.CopyWithdrawn
+		copy.BorrowedBy = ""
+		copy.HeldFor = ""
+		copy.Withdrawal = &withdrawal
+		writeOff = &models.WriteOff{BookID: bookId, Copy: copy}
+		if borrower != "" {
+			if writeOff.Loan, err = s.writeOffLoan(ctx, bookId, borrower, outcome, replacementFee, staff, now); err != nil {
+				return err
+			}
+		}
+		_, err = s.serveQueue(ctx, bookId, now)
+		return err
+	})
 	if err != nil {
-		return false, &apperrors.BookNotFoundError{BookID: id}
+		return nil, err
+	}
+	return writeOff, nil
+}
+
+// writeOffLoan ends borrower's borrowing of a book whose copy was written off
+// and closes the loan with outcome. It returns nil for borrowings that predate
+// loan records, which cannot be charged a replacement fee.
+func (s *Service) writeOffLoan(ctx context.Context, bookId, borrower string, outcome models.LoanOutcome, replacementFee int, staff string, now time.Time) (*models.Loan, error) {
+	if err := s.users.RemoveBorrowedBook(ctx, borrower, bookId); err != nil {
+		return nil, err
+	}
+	loan, err := s.loans.FindOpen(ctx, bookId, borrower)
+	if _, ok := err.(*apperrors.LoanNotFoundError); ok && replacementFee == 0 {
+		return nil, nil
 	}
-	_, err = validateBookDataForUpdate(book)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+	policy, err := s.finePolicy(ctx)
+	if err != nil {
+		return nil, err
+	}
+	closing := models.LoanClosing{
+		At:             now,
+		Outcome:        outcome,
+		Fine:           policy.Fine(loan.DueAt, now),
+		ReplacementFee: replacementFee,
+		CheckedInBy:    staff,
+	}
+	if err := s.loans.Close(ctx, loan.ID, closing); err != nil {
+		return nil, err
 	}
-	update := bson.M{dbconfig.SetOperator: bson.M{dbconfig.Title: book.Title, dbconfig.Author: book.Author, dbconfig.Amount: book.Amount}}
+	repository.CloseLoan(loan, closing)
+	return loan, nil
+}
 
-	_, err = db.BooksCollection.UpdateByID(ctx, id, update)
+// requeueHold puts the hold a copy was set aside for back to waiting, keeping
+// its place in the queue, and releases the copy.
+func (s *Service) requeueHold(ctx context.Context, bookId, barcode string, now time.Time) error {
+	holds, err := s.holds.FindActive(ctx, bookId)
+	if err != nil {
+		return err
+	}
+	for _, hold := range holds {
+		if hold.Status != models.HoldReady || hold.Barcode != barcode {
+			continue
+		}
+		hold.Status = models.HoldWaiting
+		hold.Barcode = ""
+		hold.ReadyAt = nil
+		hold.ExpiresAt = now.Add(s.holdExpiry)
+		if err := s.holds.Update(ctx, hold); err != nil {
+			return err
+		}
+	}
+	return s.books.UnholdCopy(ctx, bookId, barcode)
+}
 
+// PlaceHold queues username for the next copy of a book that has no copies
+// available. The hold lapses if no copy comes back within the hold expiry.
+func (s *Service) PlaceHold(bookId, username string, ctx context.Context) (*models.Hold, error) {
+	var placed *models.Hold
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if _, err := s.users.FindByUsername(ctx, username); err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		holds, err := s.serveQueue(ctx, bookId, now)
+		if err != nil {
+			return err
+		}
+		book, err := s.books.FindByID(ctx, bookId)
+		if err != nil {
+			return err
+		}
+		if slices.Contains(book.OwnedBy, username) {
+			return &apperrors.AlreadyHaveBookError{BookTitle: book.Title}
+		}
+		if holdOf(holds, username) != nil {
+			return &apperrors.HoldAlreadyPlacedError{BookTitle: book.Title}
+		}
+		if book.Amount > 0 {
+			return &apperrors.HoldNotNeededError{BookTitle: book.Title}
+		}
+
+		hold := models.Hold{
+			BookID:    bookId,
+			Username:  username,
+			Status:    models.HoldWaiting,
+			PlacedAt:  now,
+			ExpiresAt: now.Add(s.holdExpiry),
+		}
+		if err := s.holds.Insert(ctx, &hold); err != nil {
+			return err
+		}
+		hold.Position = 1
+		for _, h := range holds {
+			if h.Status == models.HoldWaiting {
+				hold.Position++
+			}
+		}
+		placed = &hold
+		return nil
+	})
 	if err != nil {
-		return false, err
+		return nil, err
 	}
+	return placed, nil
+}
+
+// GetHold returns username's active hold on a book with its queue position.
+func (s *Service) GetHold(bookId, username string, ctx context.Context) (*models.Hold, error) {
+	holds, err := s.GetHolds(bookId, ctx)
+	if err != nil {
+		return nil, err
+	}
+	hold := holdOf(holds, username)
+	if hold == nil {
+		return nil, &apperrors.HoldNotFoundError{BookID: bookId, Username: username}
+	}
+	return hold, nil
+}
+
+// GetHolds returns the active holds on a book in queue order. Holds whose
+// time ran out are expired first.
+func (s *Service) GetHolds(bookId string, ctx context.Context) ([]models.Hold, error) {
+	var holds []models.Hold
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		holds, err = s.serveQueue(ctx, bookId, time.Now().UTC())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return holds, nil
+}
 
+// CancelHold withdraws username from the queue for a book. A copy already set
+// aside for them goes to the next hold.
+func (s *Service) CancelHold(bookId, username string, ctx context.Context) (bool, error) {
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		now := time.Now().UTC()
+		holds, err := s.serveQueue(ctx, bookId, now)
+		if err != nil {
+			return err
+		}
+		hold := holdOf(holds, username)
+		if hold == nil {
+			return &apperrors.HoldNotFoundError{BookID: bookId, Username: username}
+		}
+		if err := s.closeHold(ctx, *hold, models.HoldCancelled, now); err != nil {
+			return err
+		}
+		_, err = s.serveQueue(ctx, bookId, now)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
 	return true, nil
 }
 
+// ExpireHolds expires every hold whose wait or pickup window has ended and
+// passes the copies they released on to the next holds. It returns the number
+// of holds expired.
+func (s *Service) ExpireHolds(ctx context.Context) (int, error) {
+	var expired int
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		now := time.Now().UTC()
+		holds, err := s.holds.FindExpired(ctx, now)
+		if err != nil {
+			return err
+		}
+		// Set rather than added to, as the transaction may be retried.
+		expired = len(holds)
+		served := make(map[string]bool)
+		for _, hold := range holds {
+			if served[hold.BookID] {
+				continue
+			}
+			served[hold.BookID] = true
+			if _, err := s.serveQueue(ctx, hold.BookID, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return expired, nil
+}
+
+// MarkOverdueLoans marks the open loans that have passed their due date since
+// the last call as overdue and tells their borrowers. It returns the number
+// of loans marked.
+func (s *Service) MarkOverdueLoans(ctx context.Context) (int, error) {
+	now := time.Now().UTC()
+	loans, err := s.loans.FindDueBy(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	titles := make(map[string]string)
+	marked := 0
+	for _, loan := range loans {
+		if loan.OverdueAt != nil || !loan.DueAt.Before(now) {
+			continue
+		}
+		title, err := s.loanTitle(ctx, loan, titles)
+		if err != nil {
+			return marked, err
+		}
+		err = s.notifier.Notify(ctx, notify.Notice{
+			Username: loan.Username,
+			Subject:  "Overdue: " + title,
+			Body:     fmt.Sprintf("%s (copy %s) was due on %s. Fines are charged until it is returned.", title, loan.Barcode, formatDue(loan.DueAt)),
+		})
+		if err != nil {
+			return marked, err
+		}
+		if err := s.loans.MarkOverdue(ctx, loan.ID, now); err != nil {
+			if _, returned := err.(*apperrors.LoanNotFoundError); returned {
+				continue
+			}
+			return marked, err
+		}
+		marked++
+	}
+	return marked, nil
+}
+
+// SendDueSoonReminders reminds borrowers of open loans falling due within the
+// due soon window, once per loan. It returns the number of reminders sent.
+func (s *Service) SendDueSoonReminders(ctx context.Context) (int, error) {
+	now := time.Now().UTC()
+	loans, err := s.loans.FindDueBy(ctx, now.Add(s.dueSoon))
+	if err != nil {
+		return 0, err
+	}
+	titles := make(map[string]string)
+	sent := 0
+	for _, loan := range loans {
+		if loan.RemindedAt != nil || !loan.DueAt.After(now) {
+			continue
+		}
+		title, err := s.loanTitle(ctx, loan, titles)
+		if err != nil {
+			return sent, err
+		}
+		err = s.notifier.Notify(ctx, notify.Notice{
+			Username: loan.Username,
+			Subject:  "Due soon: " + title,
+			Body:     fmt.Sprintf("%s (copy %s) is due on %s.", title, loan.Barcode, formatDue(loan.DueAt)),
+		})
+		if err != nil {
+			return sent, err
+		}
+		if err := s.loans.MarkReminded(ctx, loan.ID, now); err != nil {
+			if _, returned := err.(*apperrors.LoanNotFoundError); returned {
+				continue
+			}
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// loanTitle returns the title of the book on loan, caching titles by book ID.
+// A deleted book has an empty title.
+func (s *Service) loanTitle(ctx context.Context, loan models.Loan, titles map[string]string) (string, error) {
+	if title, ok := titles[loan.BookID]; ok {
+		return title, nil
+	}
+	book, err := s.books.FindByID(ctx, loan.BookID)
+	if _, deleted := err.(*apperrors.BookNotFoundError); err != nil && !deleted {
+		return "", err
+	}
+	title := ""
+	if book != nil {
+		title = book.Title
+	}
+	titles[loan.BookID] = title
+	return title, nil
+}
+
+func formatDue(dueAt time.Time) string {
+	return dueAt.UTC().Format("Mon 2 Jan 2006 15:04 MST")
+}
+
+// serveQueue brings the hold queue of a book up to date at now: holds whose
+// time ran out are expired, and available copies are set aside for waiting
+// holds in the order they were placed. It returns the remaining active holds
+// with the positions of the waiting ones.
+func (s *Service) serveQueue(ctx context.Context, bookId string, now time.Time) ([]models.Hold, error) {
+	holds, err := s.holds.FindActive(ctx, bookId)
+	if err != nil {
+		return nil, err
+	}
+	active := []models.Hold{}
+	for _, hold := range holds {
+		if hold.ExpiresAt.After(now) {
+			active = append(active, hold)
+			continue
+		}
+		if err := s.closeHold(ctx, hold, models.HoldExpired, now); err != nil {
+			return nil, err
+		}
+	}
+
+	book, err := s.books.FindByID(ctx, bookId)
+	if err != nil {
+		return nil, err
+	}
+	var available []string
+	for _, c := range book.Copies {
+		if c.Status == models.CopyAvailable {
+			available = append(available, c.Barcode)
+		}
+	}
+	position := 0
+	for i := range active {
+		hold := &active[i]
+		if hold.Status != models.HoldWaiting {
+			continue
+		}
+		if len(available) == 0 {
+			position++
+			hold.Position = position
+			continue
+		}
+		if err := s.books.HoldCopy(ctx, bookId, available[0], hold.Username); err != nil {
+			return nil, err
+		}
+		readyAt := now
+		hold.Status = models.HoldReady
+		hold.Barcode = available[0]
+		hold.ReadyAt = &readyAt
+		hold.ExpiresAt = now.Add(s.pickupWindow)
+		if err := s.holds.Update(ctx, *hold); err != nil {
+			return nil, err
+		}
+		available = available[1:]
+	}
+	return active, nil
+}
+
+// fulfilHold closes the hold of a patron who borrowed the book. If they took
+// a different copy than the one set aside for them, that copy is released to
+// the queue.
+func (s *Service) fulfilHold(ctx context.Context, hold models.Hold, lent string, now time.Time) error {
+	if hold.Status == models.HoldReady && hold.Barcode == lent {
+		hold.Status = models.HoldFulfilled
+		hold.ClosedAt = &now
+		return s.holds.Update(ctx, hold)
+	}
+	if err := s.closeHold(ctx, hold, models.HoldFulfilled, now); err != nil {
+		return err
+	}
+	_, err := s.serveQueue(ctx, hold.BookID, now)
+	return err
+}
+
+// closeHold ends a hold with status, making the copy set aside for it
+// available again.
+func (s *Service) closeHold(ctx context.Context, hold models.Hold, status models.HoldStatus, now time.Time) error {
+	if hold.Status == models.HoldReady {
+		if err := s.books.UnholdCopy(ctx, hold.BookID, hold.Barcode); err != nil {
+			return err
+		}
+	}
+	hold.Status = status
+	hold.ClosedAt = &now
+	hold.Position = 0
+	return s.holds.Update(ctx, hold)
+}
+
+// holdOf returns username's hold among holds, or nil.
+func holdOf(holds []models.Hold, username string) *models.Hold {
+	for i := range holds {
+		if holds[i].Username == username {
+			return &holds[i]
+		}
+	}
+	return nil
+}
+
+// SearchBooks runs a full-text search over titles and authors and returns one
+// page of matches, most relevant first. Only the Limit and PageToken fields of
+// query are used.
+func (s *Service) SearchBooks(text string, query models.BookQuery, ctx context.Context) (*models.BookPage, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, &apperrors.InvalidQueryError{ErrorMessages: []string{"search text is empty"}}
+	}
+	parsed, err := search.Parse(text)
+	if err != nil {
+		return nil, &apperrors.InvalidQueryError{ErrorMessages: []string{err.Error()}}
+	}
+	query, err = prepareBookQuery(models.BookQuery{Limit: query.Limit, PageToken: query.PageToken})
+	if err != nil {
+		return nil, err
+	}
+
+	terms, prefixes := parsed.RequiredTerms()
+	candidates, err := s.books.SearchCandidates(ctx, terms, prefixes)
+	if err != nil {
+		return nil, err
+	}
+	ranked := parsed.Rank(candidates)
+
+	page := &models.BookPage{
+		Books:  []models.Book{},
+		Total:  int64(len(ranked)),
+		Limit:  query.Limit,
+		Offset: query.Offset,
+	}
+	if query.Offset < len(ranked) {
+		end := min(query.Offset+query.Limit, len(ranked))
+		page.Books = ranked[query.Offset:end]
+		if end < len(ranked) {
+			page.NextPageToken = encodePageToken(end)
+		}
+	}
+	return page, nil
+}
+
+// prepareBookQuery validates query, fills in defaults and resolves the page
+// token into an offset.
+func prepareBookQuery(query models.BookQuery) (models.BookQuery, error) {
+	var errorMessages []string
+
+	switch query.SortBy {
+	case "", models.SortByTitle, models.SortByAuthor, models.SortByAvailable:
+	default:
+		errorMessages = append(errorMessages, fmt.Sprintf("cannot sort by %s", query.SortBy))
+	}
+	switch query.Order {
+	case "":
+		query.Order = models.OrderAscending
+	case models.OrderAscending, models.OrderDescending:
+	default:
+		errorMessages = append(errorMessages, fmt.Sprintf("order must be %s or %s", models.OrderAscending, models.OrderDescending))
+	}
+	if query.Limit == 0 {
+		query.Limit = defaultPageSize
+	}
+	if query.Limit < 0 || query.Limit > maxPageSize {
+		errorMessages = append(errorMessages, fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+	}
+	query.Offset = 0
+	if query.PageToken != "" {
+		offset, err := decodePageToken(query.PageToken)
+		if err != nil {
+			errorMessages = append(errorMessages, "page token is invalid")
+		}
+		query.Offset = offset
+	}
+
+	if len(errorMessages) > 0 {
+		return query, &apperrors.InvalidQueryError{ErrorMessages: errorMessages}
+	}
+	return query, nil
+}
+
+// prepareLoanQuery validates query, fills in defaults and resolves the page
+// token into an offset.
+func prepareLoanQuery(query models.LoanQuery) (models.LoanQuery, error) {
+	var errorMessages []string
+
+	switch query.Status {
+	case "", models.LoanStatusOpen, models.LoanStatusOverdue, models.LoanStatusReturned:
+	default:
+		errorMessages = append(errorMessages, fmt.Sprintf("status must be %s, %s or %s",
+			models.LoanStatusOpen, models.LoanStatusOverdue, models.LoanStatusReturned))
+	}
+	if query.Limit == 0 {
+		query.Limit = defaultPageSize
+	}
+	if query.Limit < 0 || query.Limit > maxPageSize {
+		errorMessages = append(errorMessages, fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+	}
+	query.Offset = 0
+	if query.PageToken != "" {
+		offset, err := decodePageToken(query.PageToken)
+		if err != nil {
+			errorMessages = append(errorMessages, "page token is invalid")
+		}
+		query.Offset = offset
+	}
+
+	if len(errorMessages) > 0 {
+		return query, &apperrors.InvalidQueryError{ErrorMessages: errorMessages}
+	}
+	return query, nil
+}
+
+// loanHasStatus reports whether loan matches a LoanQuery status at now. An
+// empty status matches every loan; overdue loans are also open.
+func loanHasStatus(loan models.Loan, status string, now time.Time) bool {
+	switch status {
+	case models.LoanStatusOpen:
+		return loan.ReturnedAt == nil
+	case models.LoanStatusOverdue:
+		return loan.ReturnedAt == nil && now.After(loan.DueAt)
+	case models.LoanStatusReturned:
+		return loan.ReturnedAt != nil
+	}
+	return true
+}
+
+// Page tokens are opaque to clients; they currently wrap the offset of the
+// next page.
+func encodePageToken(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func decodePageToken(token string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, err
+	}
+	offset, err := strconv.Atoi(string(raw))
+	if err != nil {
+		return 0, err
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %d", offset)
+	}
+	return offset, nil
+}
+
 func validateBookDataForAddition(book models.Book) (bool, error) {
 	var errorMessages []string = make([]string, 0)
 
@@ -213,12 +1291,28 @@ func validateBookDataForAddition(book models.Book) (bool, error) {
 	if book.Author == "" {
 		errorMessages = append(errorMessages, "author is empty")
 	}
-	if book.Amount <= 0 {
+	if len(book.Copies) == 0 && book.Amount <= 0 {
 		errorMessages = append(errorMessages, "amount is less than or equal to zero")
 	}
+	if len(book.Copies) > 0 && book.Amount != 0 {
+		errorMessages = append(errorMessages, "amount is derived from copies")
+	}
 	if book.OwnedBy != nil {
 		errorMessages = append(errorMessages, "cannot add book with non empty owned_by")
 	}
+	barcodes := make(map[string]bool)
+	for _, copy := range book.Copies {
+		if copy.Status == "" {
+			copy.Status = models.CopyAvailable
+		}
+		if _, err := validateCopy(copy); err != nil {
+			errorMessages = append(errorMessages, err.(*apperrors.BookValidationError).ErrorMessages...)
+		}
+		if barcodes[copy.Barcode] {
+			errorMessages = append(errorMessages, fmt.Sprintf("barcode %s is repeated", copy.Barcode))
+		}
+		barcodes[copy.Barcode] = true
+	}
 
 	if len(errorMessages) > 0 {
 		return false, &apperrors.BookValidationError{ErrorMessages: errorMessages}
@@ -237,15 +1331,79 @@ func validateBookDataForUpdate(book models.Book) (bool, error) {
 	if book.Author == "" {
 		errorMessages = append(errorMessages, "author is empty")
 	}
-	if book.Amount <= 0 {
-		errorMessages = append(errorMessages, "amount is less than or equal to zero")
+	if book.Amount != 0 {
+		errorMessages = append(errorMessages, "amount is derived from copies")
 	}
 	if book.OwnedBy != nil {
 		errorMessages = append(errorMessages, "cannot edit book owned_by")
 	}
+	if book.Copies != nil {
+		errorMessages = append(errorMessages, "cannot edit book copies")
+	}
 
 	if len(errorMessages) > 0 {
 		return false, &apperrors.BookValidationError{ErrorMessages: errorMessages}
 	}
 	return true, nil
 }
+
+// validateCopy checks a copy supplied by an administrator. Copies go on loan
+// only through BorrowBook, on hold only through the hold queue and are
+// withdrawn only through WriteOffCopy, so those statuses cannot be set
+// directly.
+func validateCopy(copy models.Copy) (bool, error) {
+	var errorMessages []string
+	if copy.Barcode == "" {
+		errorMessages = append(errorMessages, "barcode is empty")
+	}
+	switch copy.Status {
+	case models.CopyAvailable, models.CopyMissing, models.CopyInRepair:
+	case models.CopyOnLoan:
+		errorMessages = append(errorMessages, fmt.Sprintf("copy %s cannot be set on loan directly", copy.Barcode))
+	case models.CopyOnHold:
+		errorMessages = append(errorMessages, fmt.Sprintf("copy %s cannot be set on hold directly", copy.Barcode))
+	case models.CopyWithdrawn:
+		errorMessages = append(errorMessages, fmt.Sprintf("copy %s can only be withdrawn by declaring it lost or damaged", copy.Barcode))
+	default:
+		errorMessages = append(errorMessages, fmt.Sprintf("copy %s has unknown status %s", copy.Barcode, copy.Status))
+	}
+	if copy.BorrowedBy != "" {
+		errorMessages = append(errorMessages, fmt.Sprintf("cannot set borrowed_by of copy %s", copy.Barcode))
+	}
+	if copy.HeldFor != "" {
+		errorMessages = append(errorMessages, fmt.Sprintf("cannot set held_for of copy %s", copy.Barcode))
+	}
+	if copy.Withdrawal != nil {
+		errorMessages = append(errorMessages, fmt.Sprintf("cannot set withdrawal of copy %s", copy.Barcode))
+	}
+
+	if len(errorMessages) > 0 {
+		return false, &apperrors.BookValidationError{ErrorMessages: errorMessages}
+	}
+	return true, nil
+}
+
+// copiesForAddition returns the copies a new book is stored with: the given
+// ones with their status defaulted to available, or Amount available copies
+// with generated barcodes when none were given.
+func copiesForAddition(book models.Book) []models.Copy {
+	if len(book.Copies) == 0 {
+		copies := make([]models.Copy, book.Amount)
+		for i := range copies {
+			copies[i] = models.Copy{
+				Barcode: repository.GeneratedBarcode(book.ID, i+1),
+				Status:  models.CopyAvailable,
+			}
+		}
+		return copies
+	}
+
+	copies := make([]models.Copy, len(book.Copies))
+	for i, copy := range book.Copies {
+		if copy.Status == "" {
+			copy.Status = models.CopyAvailable
+		}
+		copies[i] = copy
+	}
+	return copies
+}
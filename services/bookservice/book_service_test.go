@@ -0,0 +1,567 @@
+package bookservice
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"library_management_system/apperrors"
+	"library_management_system/config/appconfig"
+	"library_management_system/db"
+	"library_management_system/internal/testbackends"
+	"library_management_system/models"
+	"library_management_system/notify"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// borrowers is how many goroutines race for the same book.
+const borrowers = 16
+
+func newTestService(repos *db.Repositories) *Service {
+	return NewService(repos.Books, repos.Users, repos.Loans, repos.Holds, repos.Payments, repos.Calendar, repos.Transactor,
+		notify.LogNotifier{}, appconfig.Default().Circulation)
+}
+
+// addPatrons stores n users named prefix0 to prefix<n-1> and returns their
+// names.
+func addPatrons(t *testing.T, repos *db.Repositories, prefix string, n int) []string {
+	usernames := make([]string, n)
+	for i := range usernames {
+		usernames[i] = fmt.Sprint(prefix, i)
+		user := models.User{Username: usernames[i], Password: "x", Roles: []string{"user"}, CardNumber: usernames[i]}
+		if err := repos.Users.Insert(context.Background(), &user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return usernames
+}
+
+// race runs fn for every username at once and returns the errors by
+// username.
+func race(usernames []string, fn func(username string) error) map[string]error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	start := make(chan struct{})
+	errs := make(map[string]error)
+	for _, username := range usernames {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			err := fn(username)
+			mu.Lock()
+			errs[username] = err
+			mu.Unlock()
+		}()
+	}
+	close(start)
+	wg.Wait()
+	return errs
+}
+
+// checkConsistent fails unless the copies on loan of bookID, its Amount and
+// OwnedBy, the borrowed books of every user and the open loans of the book
+// all agree, and returns who has the book.
+func checkConsistent(t *testing.T, repos *db.Repositories, bookID string) []string {
+	t.Helper()
+	ctx := context.Background()
+	book, err := repos.Books.FindByID(ctx, bookID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var holders []string
+	available := 0
+	for _, copy := range book.Copies {
+		switch copy.Status {
+		case models.CopyOnLoan:
+			holders = append(holders, copy.BorrowedBy)
+		case models.CopyAvailable:
+			available++
+		}
+	}
+	slices.Sort(holders)
+	if book.Amount != available {
+		t.Errorf("amount is %d but %d copies are available", book.Amount, available)
+	}
+	if owners := sorted(book.OwnedBy); !slices.Equal(owners, holders) {
+		t.Errorf("owned by %v but copies are lent to %v", owners, holders)
+	}
+
+	users, err := repos.Users.FindAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var borrowing []string
+	for _, user := range users {
+		if slices.Contains(user.BorrowedBookIDs, bookID) {
+			borrowing = append(borrowing, user.Username)
+		}
+	}
+	slices.Sort(borrowing)
+	if !slices.Equal(borrowing, holders) {
+		t.Errorf("users %v list the book as borrowed but copies are lent to %v", borrowing, holders)
+	}
+
+	loans, err := repos.Loans.FindByBook(ctx, bookID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var open []string
+	for _, loan := range loans {
+		if loan.ReturnedAt == nil {
+			open = append(open, loan.Username)
+		}
+	}
+	slices.Sort(open)
+	if !slices.Equal(open, holders) {
+		t.Errorf("open loans are to %v but copies are lent to %v", open, holders)
+	}
+	return holders
+}
+
+// noCopyFree reports whether err is a borrow turned down because every copy
+// was taken.
+func noCopyFree(err error) bool {
+	switch err.(type) {
+	case *apperrors.AmountIsZeroError, *apperrors.CopyNotAvailableError:
+		return true
+	}
+	return false
+}
+
+func sorted(usernames []string) []string {
+	usernames = slices.Clone(usernames)
+	slices.Sort(usernames)
+	return usernames
+}
+
+func TestConcurrentBorrowOfLastCopy(t *testing.T) {
+	ctx := context.Background()
+	for name, repos := range testbackends.Open(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newTestService(repos)
+			if _, err := s.AddBook(models.Book{ID: "dune", Title: "Dune", Author: "Herbert", Amount: 3}, ctx); err != nil {
+				t.Fatal(err)
+			}
+			for _, username := range addPatrons(t, repos, "early", 2) {
+				if _, err := s.BorrowBook("dune", username, "", ctx); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			errs := race(addPatrons(t, repos, "patron", borrowers), func(username string) error {
+				_, err := s.BorrowBook("dune", username, "", ctx)
+				return err
+			})
+
+			var winners []string
+			for username, err := range errs {
+				switch {
+				case err == nil:
+					winners = append(winners, username)
+				case !noCopyFree(err):
+					t.Errorf("%s: %v", username, err)
+				}
+			}
+			if len(winners) != 1 {
+				t.Fatalf("%d borrows of the last copy succeeded: %v", len(winners), winners)
+			}
+			holders := checkConsistent(t, repos, "dune")
+			if want := []string{"early0", "early1", winners[0]}; !slices.Equal(holders, sorted(want)) {
+				t.Errorf("copies are lent to %v, want %v", holders, want)
+			}
+		})
+	}
+}
+
+func TestConcurrentReleaseOfSameBook(t *testing.T) {
+	ctx := context.Background()
+	for name, repos := range testbackends.Open(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newTestService(repos)
+			if _, err := s.AddBook(models.Book{ID: "emma", Title: "Emma", Author: "Austen", Amount: 1}, ctx); err != nil {
+				t.Fatal(err)
+			}
+			addPatrons(t, repos, "reader", 1)
+			if _, err := s.BorrowBook("emma", "reader0", "", ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			attempts := make([]string, borrowers)
+			for i := range attempts {
+				attempts[i] = fmt.Sprint(i)
+			}
+			errs := race(attempts, func(string) error {
+				_, err := s.ReleaseBook("emma", "reader0", ctx)
+				return err
+			})
+
+			released := 0
+			for attempt, err := range errs {
+				switch err.(type) {
+				case nil:
+					released++
+				case *apperrors.BookNotBorrowedError:
+				default:
+					t.Errorf("release %s: %v", attempt, err)
+				}
+			}
+			if released != 1 {
+				t.Fatalf("%d releases of one loan succeeded", released)
+			}
+			if holders := checkConsistent(t, repos, "emma"); len(holders) > 0 {
+				t.Errorf("copies are still lent to %v", holders)
+			}
+		})
+	}
+}
+
+func TestConcurrentBorrowAndRelease(t *testing.T) {
+	const rounds = 5
+	ctx := context.Background()
+	for name, repos := range testbackends.Open(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newTestService(repos)
+			if _, err := s.AddBook(models.Book{ID: "ulysses", Title: "Ulysses", Author: "Joyce", Amount: 2}, ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			errs := race(addPatrons(t, repos, "patron", borrowers), func(username string) error {
+				for range rounds {
+					_, err := s.BorrowBook("ulysses", username, "", ctx)
+					if noCopyFree(err) {
+						continue
+					}
+					if err != nil {
+						return err
+					}
+					if _, err := s.ReleaseBook("ulysses", username, ctx); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+
+			for username, err := range errs {
+				if err != nil {
+					t.Errorf("%s: %v", username, err)
+				}
+			}
+			if holders := checkConsistent(t, repos, "ulysses"); len(holders) > 0 {
+				t.Errorf("copies are still lent to %v", holders)
+			}
+			book, err := repos.Books.FindByID(ctx, "ulysses")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if book.Amount != 2 {
+				t.Errorf("amount is %d after every copy came back, want 2", book.Amount)
+			}
+		})
+	}
+}
+
+func TestWithdrawnCopyCannotBeChanged(t *testing.T) {
+	ctx := context.Background()
+	for name, repos := range testbackends.Open(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newTestService(repos)
+			if _, err := s.AddBook(models.Book{ID: "emma", Title: "Emma", Author: "Austen", Amount: 2}, ctx); err != nil {
+				t.Fatal(err)
+			}
+			book, err := repos.Books.FindByID(ctx, "emma")
+			if err != nil {
+				t.Fatal(err)
+			}
+			barcode := book.Copies[0].Barcode
+			if _, err := s.WriteOffCopy("emma", barcode, models.LoanDamaged, 0, "water damage", "staff", ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = s.UpdateCopy("emma", barcode, models.Copy{Status: models.CopyAvailable}, ctx)
+			if _, withdrawn := err.(*apperrors.CopyWithdrawnError); !withdrawn {
+				t.Errorf("putting a withdrawn copy back: %v", err)
+			}
+			_, err = s.RemoveCopy("emma", barcode, ctx)
+			if _, withdrawn := err.(*apperrors.CopyWithdrawnError); !withdrawn {
+				t.Errorf("deleting a withdrawn copy: %v", err)
+			}
+
+			book, err = repos.Books.FindByID(ctx, "emma")
+			if err != nil {
+				t.Fatal(err)
+			}
+			copy := book.Copies[0]
+			if copy.Status != models.CopyWithdrawn || copy.Withdrawal == nil || copy.Withdrawal.Note != "water damage" {
+				t.Errorf("the write-off was lost: %+v", copy)
+			}
+			if book.Amount != 1 {
+				t.Errorf("amount is %d, want 1", book.Amount)
+			}
+		})
+	}
+}
+
+func TestBalanceBlocksBorrowingUntilSettled(t *testing.T) {
+	ctx := context.Background()
+	for name, repos := range testbackends.Open(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newTestService(repos)
+			patron := addPatrons(t, repos, "patron", 1)[0]
+			for _, id := range []string{"emma", "persuasion"} {
+				if _, err := s.AddBook(models.Book{ID: id, Title: id, Author: "Austen", Amount: 1}, ctx); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := s.BorrowBook("emma", patron, "", ctx); err != nil {
+				t.Fatal(err)
+			}
+			book, err := repos.Books.FindByID(ctx, "emma")
+			if err != nil {
+				t.Fatal(err)
+			}
+			fee := s.maxBalance + 100
+			if _, err := s.WriteOffCopy("emma", book.Copies[0].Barcode, models.LoanLost, fee, "", "staff", ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = s.BorrowBook("persuasion", patron, "", ctx)
+			if _, blocked := err.(*apperrors.BalanceExceededError); !blocked {
+				t.Fatalf("borrowing while owing %d: %v", fee, err)
+			}
+			_, err = s.SettleBalance(patron, models.PaymentPaid, fee+1, "", "staff", ctx)
+			if _, refused := err.(*apperrors.PaymentValidationError); !refused {
+				t.Errorf("paying more than the balance: %v", err)
+			}
+			if _, err := s.SettleBalance(patron, models.PaymentPaid, 60, "cash", "staff", ctx); err != nil {
+				t.Fatal(err)
+			}
+			balance, err := s.SettleBalance(patron, models.PaymentWaived, 40, "", "staff", ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := models.Balance{Username: patron, Charged: fee, Paid: 60, Waived: 40, Total: s.maxBalance}
+			if *balance != want {
+				t.Errorf("balance is %+v, want %+v", *balance, want)
+			}
+			if _, err := s.BorrowBook("persuasion", patron, "", ctx); err != nil {
+				t.Errorf("borrowing once the balance is settled: %v", err)
+			}
+			payments, err := s.GetPayments(patron, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(payments) != 2 || payments[0].Kind != models.PaymentPaid || payments[1].Kind != models.PaymentWaived {
+				t.Errorf("payments are %+v", payments)
+			}
+		})
+	}
+}
+
+func TestExpiredHoldDoesNotBlockRenewal(t *testing.T) {
+	ctx := context.Background()
+	for name, repos := range testbackends.Open(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newTestService(repos)
+			patrons := addPatrons(t, repos, "patron", 2)
+			if _, err := s.AddBook(models.Book{ID: "emma", Title: "Emma", Author: "Austen", Amount: 1}, ctx); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.BorrowBook("emma", patrons[0], "", ctx); err != nil {
+				t.Fatal(err)
+			}
+			hold, err := s.PlaceHold("emma", patrons[1], ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = s.RenewBook("emma", patrons[0], ctx)
+			if _, pending := err.(*apperrors.HoldsPendingError); !pending {
+				t.Fatalf("renewing a book another patron waits for: %v", err)
+			}
+
+			hold.ExpiresAt = time.Now().UTC().Add(-time.Minute)
+			if err := repos.Holds.Update(ctx, *hold); err != nil {
+				t.Fatal(err)
+			}
+			loan, err := s.RenewBook("emma", patrons[0], ctx)
+			if err != nil {
+				t.Fatalf("renewing once the hold ran out: %v", err)
+			}
+			if loan.Renewals != 1 {
+				t.Errorf("renewals = %d, want 1", loan.Renewals)
+			}
+			holds, err := repos.Holds.FindActive(ctx, "emma")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(holds) != 0 {
+				t.Errorf("the expired hold is still active: %+v", holds)
+			}
+		})
+	}
+}
+
+func TestPageTokenRoundTrip(t *testing.T) {
+	for _, offset := range []int{0, 1, 20, 12345} {
+		got, err := decodePageToken(encodePageToken(offset))
+		if err != nil || got != offset {
+			t.Errorf("offset %d came back as %d, %v", offset, got, err)
+		}
+	}
+}
+
+// invalidPageTokens are tokens no page ever handed out: not base64, base64
+// of something other than an offset, and a negative offset.
+var invalidPageTokens = []string{
+	"!!!",
+	encodePageToken(20) + "=",
+	base64.RawURLEncoding.EncodeToString([]byte("20; DROP TABLE books")),
+	base64.RawURLEncoding.EncodeToString([]byte("-20")),
+	base64.RawURLEncoding.EncodeToString([]byte("1e3")),
+}
+
+func TestInvalidPageTokensAreRejected(t *testing.T) {
+	ctx := context.Background()
+	repos := testbackends.Open(t)["memory"]
+	s := newTestService(repos)
+	patron := addPatrons(t, repos, "patron", 1)[0]
+	listings := map[string]func(token string) error{
+		"books": func(token string) error {
+			_, err := s.ListBooks(models.BookQuery{PageToken: token}, ctx)
+			return err
+		},
+		"search": func(token string) error {
+			_, err := s.SearchBooks("austen", models.BookQuery{PageToken: token}, ctx)
+			return err
+		},
+		"loans": func(token string) error {
+			_, err := s.GetUserLoans(patron, models.LoanQuery{PageToken: token}, ctx)
+			return err
+		},
+	}
+	for name, list := range listings {
+		for _, token := range invalidPageTokens {
+			if _, invalid := list(token).(*apperrors.InvalidQueryError); !invalid {
+				t.Errorf("%s accepted page token %q", name, token)
+			}
+		}
+	}
+}
+
+// pageThrough follows the page tokens of list, limit items at a time, and
+// returns the IDs of every page in turn.
+func pageThrough(t *testing.T, limit int, list func(limit int, token string) ([]string, string, error)) []string {
+	t.Helper()
+	var ids []string
+	token := ""
+	for range 100 {
+		page, next, err := list(limit, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) > limit {
+			t.Fatalf("a page of %d holds %d items", limit, len(page))
+		}
+		ids = append(ids, page...)
+		if next == "" {
+			return ids
+		}
+		token = next
+	}
+	t.Fatal("the pages never end")
+	return nil
+}
+
+func TestPagesKeepTheirOrder(t *testing.T) {
+	ctx := context.Background()
+	sorts := []string{"", models.SortByTitle, models.SortByAuthor, models.SortByAvailable}
+	listed := make(map[string]map[string][]string)
+	for name, repos := range testbackends.Open(t) {
+		listed[name] = make(map[string][]string)
+		t.Run(name, func(t *testing.T) {
+			s := newTestService(repos)
+			// Titles, authors and availability all repeat, so the order
+			// rests on how ties are broken.
+			for i := range 11 {
+				book := models.Book{
+					ID:     fmt.Sprintf("book-%02d", (i*7)%11),
+					Title:  []string{"Emma", "Persuasion", "Emma"}[i%3],
+					Author: []string{"Austen", "Brontë"}[i%2],
+					Amount: 1 + i%2,
+				}
+				if _, err := s.AddBook(book, ctx); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, sortBy := range sorts {
+				for _, order := range []string{models.OrderAscending, models.OrderDescending} {
+					list := func(limit int, token string) ([]string, string, error) {
+						page, err := s.ListBooks(models.BookQuery{SortBy: sortBy, Order: order, Limit: limit, PageToken: token}, ctx)
+						if err != nil {
+							return nil, "", err
+						}
+						var ids []string
+						for _, book := range page.Books {
+							ids = append(ids, book.ID)
+						}
+						return ids, page.NextPageToken, nil
+					}
+					all := pageThrough(t, maxPageSize, list)
+					if len(all) != 11 || len(sorted(all)) != len(slices.Compact(sorted(all))) {
+						t.Fatalf("sorted by %q %s: listed %q", sortBy, order, all)
+					}
+					for _, limit := range []int{1, 3, 5} {
+						if paged := pageThrough(t, limit, list); !slices.Equal(paged, all) {
+							t.Errorf("sorted by %q %s in pages of %d: %q, want %q", sortBy, order, limit, paged, all)
+						}
+					}
+					listed[name][sortBy+" "+order] = all
+				}
+			}
+
+			patron := addPatrons(t, repos, "patron", 1)[0]
+			for _, id := range []string{"book-01", "book-03", "book-05", "book-07", "book-09"} {
+				for range 2 {
+					if _, err := s.BorrowBook(id, patron, "", ctx); err != nil {
+						t.Fatal(err)
+					}
+					if _, err := s.ReleaseBook(id, patron, ctx); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			list := func(limit int, token string) ([]string, string, error) {
+				page, err := s.GetUserLoans(patron, models.LoanQuery{Limit: limit, PageToken: token}, ctx)
+				if err != nil {
+					return nil, "", err
+				}
+				var ids []string
+				for _, loan := range page.Loans {
+					ids = append(ids, loan.ID)
+				}
+				return ids, page.NextPageToken, nil
+			}
+			all := pageThrough(t, maxPageSize, list)
+			if len(all) != 10 {
+				t.Fatalf("listed %d loans, want 10", len(all))
+			}
+			for _, limit := range []int{1, 3, 4} {
+				if paged := pageThrough(t, limit, list); !slices.Equal(paged, all) {
+					t.Errorf("loans in pages of %d: %q, want %q", limit, paged, all)
+				}
+			}
+		})
+	}
+
+	// Ties are broken the same way whichever backend stores the books.
+	for name, orders := range listed {
+		for sort, ids := range orders {
+			if want := listed["memory"][sort]; !slices.Equal(ids, want) {
+				t.Errorf("%s sorted by %s: %q, but memory lists %q", name, sort, ids, want)
+			}
+		}
+	}
+}
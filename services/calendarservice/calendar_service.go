@@ -0,0 +1,51 @@
+package calendarservice
+
+import (
+	"context"
+	"library_management_system/calendar"
+	"library_management_system/models"
+	"library_management_system/repository"
+)
+
+// Service reads and replaces the library calendar.
+type Service struct {
+	calendars repository.CalendarRepository
+}
+
+// NewService returns a Service that stores the calendar in the given
+// repository.
+func NewService(calendars repository.CalendarRepository) *Service {
+	return &Service{calendars: calendars}
+}
+
+// GetCalendar returns the library calendar, or the default one of a library
+// open every day if none has been set.
+func (s *Service) GetCalendar(ctx context.Context) (*models.Calendar, error) {
+	saved, err := s.calendars.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if saved == nil {
+		defaults := calendar.Default()
+		return &defaults, nil
+	}
+	if saved.OpeningHours == nil {
+		saved.OpeningHours = []models.OpeningHours{}
+	}
+	if saved.Holidays == nil {
+		saved.Holidays = []models.Holiday{}
+	}
+	return saved, nil
+}
+
+// SetCalendar validates and saves a new calendar. It applies to loans made
+// or renewed from then on and to fines charged from then on.
+func (s *Service) SetCalendar(c models.Calendar, ctx context.Context) (*models.Calendar, error) {
+	if _, err := calendar.New(c); err != nil {
+		return nil, err
+	}
+	if err := s.calendars.Save(ctx, c); err != nil {
+		return nil, err
+	}
+	return s.GetCalendar(ctx)
+}
@@ -0,0 +1,342 @@
+// Package keyservice signs and verifies access tokens with asymmetric keys
+// kept in the database, rotates them and publishes their public halves as a
+// JSON Web Key Set, so that other services can verify tokens without sharing
+// a secret.
+package keyservice
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"library_management_system/apperrors"
+	"library_management_system/config/appconfig"
+	"library_management_system/config/jsonconfig"
+	"library_management_system/models"
+	"library_management_system/repository"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Signing algorithms, by their JWS names.
+const (
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+const (
+	rsaKeyBits = 2048
+	// cacheTTL is how long keys are used before they are read again, and so
+	// how long other servers may take to pick up a rotation.
+	cacheTTL = time.Minute
+	// missReloadInterval limits the reloads caused by tokens naming a key
+	// this server does not know, which anybody can send.
+	missReloadInterval = 5 * time.Second
+)
+
+// KeyIDHeader is the JWS header naming the key a token was signed with.
+const KeyIDHeader = "kid"
+
+var errNoSigningKey = errors.New("no signing key; keys are created when the server starts")
+
+// key is a parsed signing key.
+type key struct {
+	id        string
+	method    jwt.SigningMethod
+	private   crypto.Signer
+	createdAt time.Time
+}
+
+// Service signs tokens with the newest published key and accepts tokens
+// signed by any key that is still in use. A new key is created every
+// rotation interval and published in the key set at once, but only signs
+// once clients caching the key set have had time to fetch it; the key it
+// replaces keeps verifying tokens for the rotation window and is then
+// deleted. Private keys are stored encrypted with the key encryption
+// key.
+type Service struct {
+	keys             repository.SigningKeyRepository
+	sealer           *sealer
+	algorithm        string
+	rotationInterval time.Duration
+	rotationWindow   time.Duration
+
+	mu         sync.Mutex
+	cached     []key
+	loadedAt   time.Time
+	reloadedAt time.Time
+}
+
+// NewService returns a Service that stores keys in the given repository and
+// creates, encrypts and rotates them as described by config.
+func NewService(keys repository.SigningKeyRepository, config appconfig.AuthConfig) (*Service, error) {
+	keyEncryptionKey, err := config.DecodeKeyEncryptionKey()
+	if err != nil {
+		return nil, fmt.Errorf("key encryption key %w", err)
+	}
+	sealer, err := newSealer(keyEncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Service{
+		keys:             keys,
+		sealer:           sealer,
+		algorithm:        config.SigningAlgorithm,
+		rotationInterval: time.Duration(config.KeyRotationInterval),
+		rotationWindow:   time.Duration(config.KeyRotationWindow),
+	}, nil
+}
+
+// Sign returns claims as a token signed with the newest key that has been
+// published for jsonconfig.JWKSMaxAge, or with the oldest key in use when
+// none has, as when the first key was just created.
+func (s *Service) Sign(claims jwt.MapClaims, ctx context.Context) (string, error) {
+	keys, err := s.current(ctx, false)
+	if err != nil {
+		return "", err
+	}
+	if len(keys) == 0 {
+		return "", errNoSigningKey
+	}
+	signer := keys[0]
+	published := time.Now().Add(-jsonconfig.JWKSMaxAge)
+	for _, k := range keys[1:] {
+		if !k.createdAt.After(published) {
+			signer = k
+		}
+	}
+	token := jwt.NewWithClaims(signer.method, claims)
+	token.Header[KeyIDHeader] = signer.id
+	return token.SignedString(signer.private)
+}
+
+// Parse verifies tokenString and returns its claims. A token that is
+// malformed, expired or not signed by a key in use is an InvalidTokenError.
+func (s *Service) Parse(tokenString string, ctx context.Context) (jwt.MapClaims, error) {
+	var loadErr error
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		id, _ := token.Header[KeyIDHeader].(string)
+		verifier, err := s.find(ctx, id)
+		if err != nil {
+			loadErr = err
+			return nil, err
+		}
+		if verifier == nil || verifier.method.Alg() != token.Method.Alg() {
+			return nil, &apperrors.InvalidTokenError{}
+		}
+		return verifier.private.Public(), nil
+	})
+	if loadErr != nil {
+		return nil, loadErr
+	}
+	if err != nil || !token.Valid {
+		return nil, &apperrors.InvalidTokenError{}
+	}
+	return token.Claims.(jwt.MapClaims), nil
+}
+
+// JWKS returns the public keys of every key in use.
+func (s *Service) JWKS(ctx context.Context) (*models.JWKSet, error) {
+	keys, err := s.current(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	set := &models.JWKSet{Keys: make([]models.JWK, 0, len(keys))}
+	for _, k := range keys {
+		jwk := models.JWK{Use: "sig", Algorithm: k.method.Alg(), KeyID: k.id}
+		switch public := k.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set, nil
+}
+
+// Rotate creates a key when there is none, when the newest is older than
+// the rotation interval, when it does not use the configured algorithm or
+// when it was stored unencrypted. It deletes the keys whose rotation window
+// has passed and checks that the rest can be read. It returns the ID of the
+// new key, or "" when none was due, and the number of keys deleted.
+func (s *Service) Rotate(ctx context.Context) (string, int, error) {
+	stored, err := s.keys.FindAll(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	now := time.Now().UTC()
+
+	created := ""
+	if n := len(stored); n == 0 || !stored[n-1].CreatedAt.Add(s.rotationInterval).After(now) ||
+		stored[n-1].Algorithm != s.algorithm || !sealed(stored[n-1]) {
+		newKey, err := s.generate(now)
+		if err != nil {
+			return "", 0, err
+		}
+		if err := s.keys.Insert(ctx, newKey); err != nil {
+			return "", 0, err
+		}
+		stored = append(stored, newKey)
+		created = newKey.ID
+	}
+
+	deleted := 0
+	for i := range stored {
+		if !s.retired(stored, i, now) {
+			continue
+		}
+		if err := s.keys.Delete(ctx, stored[i].ID); err != nil {
+			return created, deleted, err
+		}
+		deleted++
+	}
+
+	// Reload the keys now, so that a key encryption key that cannot open
+	// them is reported here rather than by the next login.
+	s.mu.Lock()
+	s.loadedAt = time.Time{}
+	s.mu.Unlock()
+	if _, err := s.current(ctx, false); err != nil {
+		return created, deleted, err
+	}
+	return created, deleted, nil
+}
+
+// retired reports whether keys[i] of keys, oldest first, no longer verifies
+// tokens: the key after it was created more than the rotation window ago.
+func (s *Service) retired(keys []models.SigningKey, i int, now time.Time) bool {
+	return i < len(keys)-1 && !keys[i+1].CreatedAt.Add(s.rotationWindow).After(now)
+}
+
+// generate makes a new key for the configured algorithm.
+func (s *Service) generate(now time.Time) (models.SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	switch s.algorithm {
+	case RS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case EdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		err = fmt.Errorf("signing algorithm %q is not supported", s.algorithm)
+	}
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return models.SigningKey{}, err
+	}
+	id := hex.EncodeToString(random)
+	sealed, err := s.sealer.seal(id, der)
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+	return models.SigningKey{
+		ID:         id,
+		Algorithm:  s.algorithm,
+		PrivateKey: sealed,
+		CreatedAt:  now,
+	}, nil
+}
+
+// find returns the key in use with id, or nil when there is none. An unknown
+// id reloads the keys, as another server may have just created the key.
+func (s *Service) find(ctx context.Context, id string) (*key, error) {
+	keys, err := s.current(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	if k := lookup(keys, id); k != nil || id == "" {
+		return k, nil
+	}
+	keys, err = s.current(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	return lookup(keys, id), nil
+}
+
+func lookup(keys []key, id string) *key {
+	for i := range keys {
+		if keys[i].id == id {
+			return &keys[i]
+		}
+	}
+	return nil
+}
+
+// current returns the keys in use, oldest first, reading them again when the
+// cache is stale or, at most every missReloadInterval, when missed is set.
+func (s *Service) current(ctx context.Context, missed bool) ([]key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	stale := now.Sub(s.loadedAt) >= cacheTTL
+	if missed && now.Sub(s.reloadedAt) >= missReloadInterval {
+		stale = true
+	}
+	if !stale {
+		return s.cached, nil
+	}
+
+	stored, err := s.keys.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]key, 0, len(stored))
+	for i, k := range stored {
+		if s.retired(stored, i, now) {
+			continue
+		}
+		parsed, err := s.parseKey(k)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, parsed)
+	}
+	s.cached, s.loadedAt, s.reloadedAt = keys, now, now
+	return keys, nil
+}
+
+func (s *Service) parseKey(stored models.SigningKey) (key, error) {
+	der, err := s.sealer.open(stored)
+	if err != nil {
+		return key{}, err
+	}
+	private, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return key{}, fmt.Errorf("signing key %s: %w", stored.ID, err)
+	}
+	parsed := key{id: stored.ID, createdAt: stored.CreatedAt}
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		parsed.method, parsed.private = jwt.SigningMethodRS256, private
+	case ed25519.PrivateKey:
+		parsed.method, parsed.private = SigningMethodEdDSA, private
+	default:
+		return key{}, fmt.Errorf("signing key %s has unsupported type %T", stored.ID, private)
+	}
+	if parsed.method.Alg() != stored.Algorithm {
+		return key{}, fmt.Errorf("signing key %s is not an %s key", stored.ID, stored.Algorithm)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,189 @@
+package keyservice
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"library_management_system/config/appconfig"
+	"library_management_system/config/jsonconfig"
+	"library_management_system/models"
+	"library_management_system/repository/memrepo"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func testConfig(t *testing.T) appconfig.AuthConfig {
+	keyEncryptionKey := make([]byte, 32)
+	if _, err := rand.Read(keyEncryptionKey); err != nil {
+		t.Fatal(err)
+	}
+	config := appconfig.Default().Auth
+	config.SigningAlgorithm = EdDSA
+	config.KeyEncryptionKey = base64.StdEncoding.EncodeToString(keyEncryptionKey)
+	return config
+}
+
+func newTestService(t *testing.T, keys *memrepo.SigningKeyRepository, config appconfig.AuthConfig) *Service {
+	s, err := NewService(keys, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func sign(t *testing.T, s *Service) string {
+	token, err := s.Sign(jwt.MapClaims{"sub": "ann", "exp": time.Now().Add(time.Minute).Unix()}, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestPrivateKeysAreStoredEncrypted(t *testing.T) {
+	ctx := context.Background()
+	keys := memrepo.NewStore().SigningKeys()
+	config := testConfig(t)
+	s := newTestService(t, keys, config)
+	if _, _, err := s.Rotate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	token := sign(t, s)
+
+	stored, err := keys.FindAll(ctx)
+	if err != nil || len(stored) != 1 {
+		t.Fatal(stored, err)
+	}
+	if _, err := x509.ParsePKCS8PrivateKey(stored[0].PrivateKey); err == nil {
+		t.Fatal("the private key is stored in the clear")
+	}
+
+	if _, err := newTestService(t, keys, config).Parse(token, ctx); err != nil {
+		t.Errorf("another server with the same key encryption key: %v", err)
+	}
+	if _, err := newTestService(t, keys, testConfig(t)).Parse(token, ctx); err == nil {
+		t.Error("a server with another key encryption key decrypted the signing key")
+	}
+
+	moved := stored[0]
+	moved.ID = "moved"
+	if _, err := s.sealer.open(moved); err == nil {
+		t.Error("an encrypted key opened under another ID")
+	}
+}
+
+func TestUnencryptedKeyIsReplaced(t *testing.T) {
+	ctx := context.Background()
+	keys := memrepo.NewStore().SigningKeys()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := models.SigningKey{ID: "legacy", Algorithm: EdDSA, PrivateKey: der, CreatedAt: time.Now().UTC()}
+	if err := keys.Insert(ctx, legacy); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestService(t, keys, testConfig(t))
+	created, _, err := s.Rotate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created == "" {
+		t.Fatal("no encrypted key replaced the unencrypted one")
+	}
+	stored, err := keys.FindAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newest := stored[len(stored)-1]; newest.ID != created || bytes.Equal(newest.PrivateKey, der) || !sealed(newest) {
+		t.Fatalf("newest key %s is not the encrypted replacement", newest.ID)
+	}
+
+	if header := tokenHeader(t, sign(t, s)); header != legacy.ID {
+		t.Errorf("signed with %s before the replacement was published", header)
+	}
+	if _, err := s.Parse(signedWithKID(t, private, legacy.ID), ctx); err != nil {
+		t.Errorf("tokens of the unencrypted key stop verifying before its rotation window: %v", err)
+	}
+}
+
+func TestNewKeySignsOncePublished(t *testing.T) {
+	ctx := context.Background()
+	keys := memrepo.NewStore().SigningKeys()
+	config := testConfig(t)
+	s := newTestService(t, keys, config)
+	now := time.Now().UTC()
+	old, err := s.generate(now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := s.generate(now.Add(-jsonconfig.JWKSMaxAge / 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []models.SigningKey{old, fresh} {
+		if err := keys.Insert(ctx, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	set, err := s.JWKS(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Keys) != 2 || set.Keys[1].KeyID != fresh.ID {
+		t.Errorf("the new key is not published: %+v", set.Keys)
+	}
+	if header := tokenHeader(t, sign(t, s)); header != old.ID {
+		t.Errorf("signed with %s, want %s until the new key has been published for %v", header, old.ID, jsonconfig.JWKSMaxAge)
+	}
+
+	published, err := s.generate(now.Add(-jsonconfig.JWKSMaxAge))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Insert(ctx, published); err != nil {
+		t.Fatal(err)
+	}
+	if header := tokenHeader(t, sign(t, newTestService(t, keys, config))); header != published.ID {
+		t.Errorf("signed with %s, want the published key %s", header, published.ID)
+	}
+}
+
+func signedWithKID(t *testing.T, private ed25519.PrivateKey, kid string) string {
+	token := jwt.NewWithClaims(SigningMethodEdDSA, jwt.MapClaims{"sub": "ann", "exp": time.Now().Add(time.Minute).Unix()})
+	token.Header[KeyIDHeader] = kid
+	signed, err := token.SignedString(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func tokenHeader(t *testing.T, token string) string {
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, _ := parsed.Header[KeyIDHeader].(string)
+	return kid
+}
+
+func TestRotateRejectsWrongKeyEncryptionKey(t *testing.T) {
+	ctx := context.Background()
+	keys := memrepo.NewStore().SigningKeys()
+	if _, _, err := newTestService(t, keys, testConfig(t)).Rotate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := newTestService(t, keys, testConfig(t)).Rotate(ctx); err == nil {
+		t.Error("rotation with another key encryption key succeeded")
+	}
+}
@@ -0,0 +1,71 @@
+package keyservice
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"library_management_system/models"
+)
+
+// sealedKeyVersion starts every private key encrypted by this package. It is
+// followed by the AES-GCM nonce and the sealed PKCS #8 key. Keys stored
+// before encryption was added are bare PKCS #8, whose DER encoding always
+// starts with a SEQUENCE tag, 0x30, so the two cannot be confused.
+const sealedKeyVersion = 1
+
+// sealer encrypts private keys with the key encryption key. The ID of the
+// signing key is authenticated with it, so an encrypted key copied to
+// another record does not decrypt.
+type sealer struct {
+	aead cipher.AEAD
+}
+
+func newSealer(keyEncryptionKey []byte) (*sealer, error) {
+	block, err := aes.NewCipher(keyEncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &sealer{aead: aead}, nil
+}
+
+// seal returns the stored form of the PKCS #8 private key of the signing key
+// with id.
+func (s *sealer) seal(id string, der []byte) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := append([]byte{sealedKeyVersion}, nonce...)
+	return s.aead.Seal(sealed, nonce, der, []byte(id)), nil
+}
+
+// open returns the PKCS #8 private key of stored, which may predate
+// encryption.
+func (s *sealer) open(stored models.SigningKey) ([]byte, error) {
+	if !sealed(stored) {
+		return stored.PrivateKey, nil
+	}
+	rest := stored.PrivateKey[1:]
+	if len(rest) < s.aead.NonceSize() {
+		return nil, fmt.Errorf("signing key %s is truncated", stored.ID)
+	}
+	nonce, ciphertext := rest[:s.aead.NonceSize()], rest[s.aead.NonceSize():]
+	der, err := s.aead.Open(nil, nonce, ciphertext, []byte(stored.ID))
+	if err != nil {
+		return nil, fmt.Errorf("signing key %s: %w", stored.ID, errWrongKeyEncryptionKey)
+	}
+	return der, nil
+}
+
+var errWrongKeyEncryptionKey = errors.New("cannot decrypt; the key encryption key is not the one it was stored with")
+
+// sealed reports whether the private key of stored is encrypted.
+func sealed(stored models.SigningKey) bool {
+	return len(stored.PrivateKey) > 0 && stored.PrivateKey[0] == sealedKeyVersion
+}
@@ -0,0 +1,273 @@
+package sessionservice
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"library_management_system/apperrors"
+	"library_management_system/config/appconfig"
+	"library_management_system/models"
+	"library_management_system/repository"
+	"strings"
+	"time"
+)
+
+// refreshTokenSeparator splits a refresh token into the session ID and the
+// secret.
+const refreshTokenSeparator = "."
+
+// Grant is what a login or a refresh hands out: the user and session it is
+// for, the refresh token for the client to keep, and the ID and expiry the
+// new access token must carry.
+type Grant struct {
+	User                 *models.User
+	Session              models.Session
+	RefreshToken         string
+	AccessTokenID        string
+	AccessTokenExpiresAt time.Time
+}
+
+// Service manages login sessions. Each session has one refresh token, which
+// is replaced every time it is used, and one live access token, which is
+// revoked when the session is refreshed or ends.
+type Service struct {
+	sessions   repository.SessionRepository
+	users      repository.UserRepository
+	transactor repository.Transactor
+	accessTTL  time.Duration
+	sessionTTL time.Duration
+}
+
+// NewService returns a Service that stores sessions in the given repository
+// and times tokens as described by config.
+func NewService(sessions repository.SessionRepository, users repository.UserRepository,
+	transactor repository.Transactor, config appconfig.AuthConfig) *Service {
+	return &Service{
+		sessions:   sessions,
+		users:      users,
+		transactor: transactor,
+		accessTTL:  time.Duration(config.TokenTTL),
+		sessionTTL: time.Duration(config.RefreshTokenTTL),
+	}
+}
+
+// StartSession opens a session for username, who has just logged in.
+func (s *Service) StartSession(username string, ctx context.Context) (*Grant, error) {
+	user, err := s.users.FindByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	grant, rotation, err := s.newGrant(id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	grant.User = user
+	grant.Session = models.Session{
+		ID:        id,
+		Username:  username,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.sessionTTL),
+	}
+	repository.RotateSession(&grant.Session, rotation)
+	if err := s.sessions.Insert(ctx, &grant.Session); err != nil {
+		return nil, err
+	}
+	return grant, nil
+}
+
+// Refresh exchanges refreshToken for a new refresh token and access token,
+// revoking the access token issued before. A refresh token that was already
+// used ends its session: either the client is confused or the token was
+// stolen, and both the thief and the owner have to log in again.
+func (s *Service) Refresh(refreshToken string, ctx context.Context) (*Grant, error) {
+	id, secret, ok := strings.Cut(refreshToken, refreshTokenSeparator)
+	if !ok || id == "" || secret == "" {
+		return nil, &apperrors.InvalidRefreshTokenError{}
+	}
+	session, err := s.sessions.FindByID(ctx, id)
+	if _, missing := err.(*apperrors.SessionNotFoundError); missing {
+		return nil, &apperrors.InvalidRefreshTokenError{}
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if !repository.SessionActive(*session, now) {
+		return nil, &apperrors.InvalidRefreshTokenError{}
+	}
+	hash := hashSecret(secret)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(session.RefreshTokenHash)) != 1 {
+		if err := s.end(ctx, *session, now); err != nil {
+			return nil, err
+		}
+		return nil, &apperrors.InvalidRefreshTokenError{}
+	}
+
+	user, err := s.users.FindByUsername(ctx, session.Username)
+	if _, missing := err.(*apperrors.UserNotFoundError); missing {
+		return nil, &apperrors.InvalidRefreshTokenError{}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled {
+		if err := s.end(ctx, *session, now); err != nil {
+			return nil, err
+		}
+		return nil, &apperrors.InvalidRefreshTokenError{}
+	}
+	grant, rotation, err := s.newGrant(id)
+	if err != nil {
+		return nil, err
+	}
+	rotated := false
+	err = s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		rotated, err = s.sessions.Rotate(ctx, id, hash, rotation)
+		if err != nil || !rotated {
+			return err
+		}
+		return s.revokeAccessToken(ctx, *session, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		// Another request used the same refresh token first.
+		if err := s.end(ctx, *session, now); err != nil {
+			return nil, err
+		}
+		return nil, &apperrors.InvalidRefreshTokenError{}
+	}
+
+	grant.User = user
+	grant.Session = *session
+	repository.RotateSession(&grant.Session, rotation)
+	return grant, nil
+}
+
+// EndSession logs out of the session with id, revoking its refresh token and
+// its access token.
+func (s *Service) EndSession(id string, ctx context.Context) error {
+	session, err := s.sessions.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	return s.end(ctx, *session, time.Now().UTC())
+}
+
+// RevokeUserSessions ends every active session of username, so that they must
+// log in again everywhere. It returns the number of sessions ended.
+func (s *Service) RevokeUserSessions(username string, ctx context.Context) (int, error) {
+	if _, err := s.users.FindByUsername(ctx, username); err != nil {
+		return 0, err
+	}
+	return s.EndUserSessions(username, ctx)
+}
+
+// EndUserSessions ends every active session of username like
+// RevokeUserSessions, but also for users who no longer exist, such as one
+// who was just deleted.
+func (s *Service) EndUserSessions(username string, ctx context.Context) (int, error) {
+	revoked := 0
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		now := time.Now().UTC()
+		sessions, err := s.sessions.FindActiveByUser(ctx, username, now)
+		if err != nil {
+			return err
+		}
+		for _, session := range sessions {
+			if err := s.end(ctx, session, now); err != nil {
+				return err
+			}
+		}
+		revoked = len(sessions)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return revoked, nil
+}
+
+// TokenRevoked reports whether the access token with id has been revoked.
+func (s *Service) TokenRevoked(id string, ctx context.Context) (bool, error) {
+	return s.sessions.TokenRevoked(ctx, id)
+}
+
+// PurgeExpired deletes the sessions and revoked token records that have
+// expired and returns how many it deleted.
+func (s *Service) PurgeExpired(ctx context.Context) (int, error) {
+	return s.sessions.DeleteExpired(ctx, time.Now().UTC())
+}
+
+// end revokes session and its current access token.
+func (s *Service) end(ctx context.Context, session models.Session, now time.Time) error {
+	return s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.sessions.Revoke(ctx, session.ID, now); err != nil {
+			return err
+		}
+		return s.revokeAccessToken(ctx, session, now)
+	})
+}
+
+// revokeAccessToken revokes the current access token of session unless it
+// has expired anyway.
+func (s *Service) revokeAccessToken(ctx context.Context, session models.Session, now time.Time) error {
+	if !session.AccessTokenExpiresAt.After(now) {
+		return nil
+	}
+	return s.sessions.RevokeToken(ctx, models.RevokedToken{
+		ID:        session.AccessTokenID,
+		ExpiresAt: session.AccessTokenExpiresAt,
+	})
+}
+
+// newGrant makes a new refresh token for the session with id and picks the ID
+// and expiry of the next access token.
+func (s *Service) newGrant(id string) (*Grant, models.SessionRotation, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, models.SessionRotation{}, err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+	accessTokenID, err := newID()
+	if err != nil {
+		return nil, models.SessionRotation{}, err
+	}
+	grant := &Grant{
+		RefreshToken:         id + refreshTokenSeparator + encoded,
+		AccessTokenID:        accessTokenID,
+		AccessTokenExpiresAt: time.Now().UTC().Add(s.accessTTL),
+	}
+	rotation := models.SessionRotation{
+		RefreshTokenHash:     hashSecret(encoded),
+		AccessTokenID:        grant.AccessTokenID,
+		AccessTokenExpiresAt: grant.AccessTokenExpiresAt,
+	}
+	return grant, rotation, nil
+}
+
+func newID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// hashSecret is what is stored of a refresh token secret. The secret is
+// random, so a fast hash is enough.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -2,25 +2,88 @@ package userservice
 
 import (
 	"context"
+	"crypto/rand"
+	"fmt"
 	"library_management_system/apperrors"
-	"library_management_system/config/dbconfig"
-	"library_management_system/db"
+	"library_management_system/authz"
+	"library_management_system/config/appconfig"
 	"library_management_system/models"
+	"library_management_system/repository"
+	"library_management_system/services/sessionservice"
+	"math/big"
+	"net/mail"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
 
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// RegisterUser creates a new user in the database
-func RegisterUser(username, password, role string, ctx context.Context) (*models.User, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return nil, err
-	}
+// cardNumberDigits is the length of generated library card numbers, and
+// cardAttempts how many numbers are tried before giving up on clashes.
+const (
+	cardNumberDigits = 12
+	cardAttempts     = 5
+)
+
+// Phone numbers are at most 15 digits long including the country code, as
+// in E.164; shorter than 7 is a typo rather than a number.
+const (
+	minPhoneDigits = 7
+	maxPhoneDigits = 15
+)
+
+// Service implements account operations on top of a UserRepository. Holds
+// are only read, to keep users with active holds from being deleted.
+// Sessions are ended in the same transaction as the account changes that
+// invalidate them.
+type Service struct {
+	users      repository.UserRepository
+	holds      repository.HoldRepository
+	sessions   *sessionservice.Service
+	transactor repository.Transactor
+	policy     *authz.Policy
+	bcryptCost int
+}
 
-	err = checkIfUserExists(ctx, username)
+// NewService returns a Service that stores users in the given repository,
+// ends their sessions through sessions, accepts the roles policy defines and
+// hashes passwords with the configured bcrypt cost.
+func NewService(users repository.UserRepository, holds repository.HoldRepository, sessions *sessionservice.Service,
+	transactor repository.Transactor, policy *authz.Policy, config appconfig.AuthConfig) *Service {
+	return &Service{
+		users:      users,
+		holds:      holds,
+		sessions:   sessions,
+		transactor: transactor,
+		policy:     policy,
+		bcryptCost: config.BcryptCost,
+	}
+}
 
+// RegisterUser creates a new user with roles in the database and issues them
+// a library card.
+func (s *Service) RegisterUser(username, password string, roles []string, ctx context.Context) (*models.User, error) {
+	var errorMessages []string
+	if strings.TrimSpace(username) == "" {
+		errorMessages = append(errorMessages, "username is empty")
+	} else if strings.ContainsFunc(username, unicode.IsSpace) {
+		errorMessages = append(errorMessages, "username contains spaces")
+	}
+	if password == "" {
+		errorMessages = append(errorMessages, "password is empty")
+	}
+	roles, err := s.validRoles(roles)
+	if validation, ok := err.(*apperrors.UserValidationError); ok {
+		errorMessages = append(errorMessages, validation.ErrorMessages...)
+	} else if err != nil {
+		return nil, err
+	}
+	if len(errorMessages) > 0 {
+		return nil, &apperrors.UserValidationError{ErrorMessages: errorMessages}
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), s.bcryptCost)
 	if err != nil {
 		return nil, err
 	}
@@ -28,22 +91,83 @@ func RegisterUser(username, password, role string, ctx context.Context) (*models
 	user := models.User{
 		Username: username,
 		Password: string(hashedPassword),
-		Role:     role,
+		Roles:    roles,
 	}
 
-	result, err := db.UsersCollection.InsertOne(ctx, user)
+	err = withNewCardNumber(func(cardNumber string) error {
+		user.CardNumber = cardNumber
+		return s.users.Insert(ctx, &user)
+	})
 	if err != nil {
 		return nil, err
 	}
-	user.ID = result.InsertedID.(primitive.ObjectID).Hex()
 	return &user, nil
 }
 
+// validRoles checks that roles holds at least one role and only roles the
+// policy defines, and returns them sorted without duplicates.
+func (s *Service) validRoles(roles []string) ([]string, error) {
+	var errorMessages []string
+	if len(roles) == 0 {
+		errorMessages = append(errorMessages, "a user needs at least one role")
+	}
+	valid := make([]string, 0, len(roles))
+	for _, role := range roles {
+		if !s.policy.HasRole(role) {
+			errorMessages = append(errorMessages, fmt.Sprintf("role %q does not exist", role))
+		} else if !slices.Contains(valid, role) {
+			valid = append(valid, role)
+		}
+	}
+	if len(errorMessages) > 0 {
+		return nil, &apperrors.UserValidationError{ErrorMessages: errorMessages}
+	}
+	sort.Strings(valid)
+	return valid, nil
+}
+
+// IssueCard gives username a new library card, replacing a lost one or
+// issuing the first card of a user registered before cards existed.
+func (s *Service) IssueCard(username string, ctx context.Context) (*models.User, error) {
+	err := withNewCardNumber(func(cardNumber string) error {
+		return s.users.SetCardNumber(ctx, username, cardNumber)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.users.FindByUsername(ctx, username)
+}
+
+// withNewCardNumber calls store with random card numbers until one is not
+// already issued.
+func withNewCardNumber(store func(cardNumber string) error) error {
+	var err error
+	for attempt := 0; attempt < cardAttempts; attempt++ {
+		var cardNumber string
+		cardNumber, err = newCardNumber()
+		if err != nil {
+			return err
+		}
+		err = store(cardNumber)
+		if _, clash := err.(*apperrors.CardNumberAlreadyExistsError); !clash {
+			return err
+		}
+	}
+	return err
+}
+
+func newCardNumber() (string, error) {
+	limit := new(big.Int).Exp(big.NewInt(10), big.NewInt(cardNumberDigits), nil)
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", cardNumberDigits, n), nil
+}
+
 // AuthenticateUser verifies user credentials
-func AuthenticateUser(username, password string, ctx context.Context) (*models.User, error) {
-	var user models.User
-	filter := bson.M{dbconfig.Username: username}
-	err := db.UsersCollection.FindOne(ctx, filter).Decode(&user)
+func (s *Service) AuthenticateUser(username, password string, ctx context.Context) (*models.User, error) {
+	user, err := s.users.FindByUsername(ctx, username)
 	if err != nil {
 		return nil, err
 	}
@@ -52,60 +176,250 @@ func AuthenticateUser(username, password string, ctx context.Context) (*models.U
 	if err != nil {
 		return nil, err
 	}
+	if user.Disabled {
+		return nil, &apperrors.UserDisabledError{Username: username}
+	}
 
-	return &user, nil
+	return user, nil
 }
 
-func checkIfUserExists(ctx context.Context, username string) error {
-	_, error := findUserByUsername(ctx, bson.M{dbconfig.Username: username})
-	if error == nil {
-		return &apperrors.UsernameAlreadyExistsError{Username: username}
-	}
+func (s *Service) FindUser(ctx context.Context, username string) (*models.User, error) {
+	return s.users.FindByUsername(ctx, username)
+}
 
-	return nil
+func (s *Service) GetAllUsers(ctx context.Context) ([]models.User, error) {
+	return s.users.FindAll(ctx)
 }
 
-func FindUser(ctx context.Context, username string) (*models.User, error) {
-	user, error := findUserByUsername(ctx, bson.M{dbconfig.Username: username})
-	if error != nil {
-		return nil, error
+// SetLoanLimit overrides how many books username may have on loan at once.
+// A nil limit removes the override, so the limit of the user's role applies.
+// Books already on loan are not affected by a lower limit.
+func (s *Service) SetLoanLimit(username string, limit *int, ctx context.Context) (*models.User, error) {
+	if limit != nil && *limit < 0 {
+		return nil, &apperrors.UserValidationError{ErrorMessages: []string{"loan limit must not be negative"}}
 	}
+	if err := s.users.SetLoanLimit(ctx, username, limit); err != nil {
+		return nil, err
+	}
+	return s.users.FindByUsername(ctx, username)
+}
 
-	return user, nil
+// SetRoles replaces the roles of username on behalf of actor and ends their
+// sessions, so that the new roles apply from their next login. Actors cannot
+// take away their own permission to manage users, and the last enabled
+// administrator cannot lose the admin role.
+func (s *Service) SetRoles(username string, roles []string, actor string, ctx context.Context) (*models.User, error) {
+	roles, err := s.validRoles(roles)
+	if err != nil {
+		return nil, err
+	}
+	if username == actor && !s.policy.Allows(roles, authz.UsersManage) {
+		return nil, &apperrors.UserValidationError{ErrorMessages: []string{
+			fmt.Sprintf("you cannot give up your own %s permission", authz.UsersManage),
+		}}
+	}
+	err = s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		user, err := s.users.FindByUsername(ctx, username)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(roles, authz.AdminRole) {
+			if err := s.keepAdministrator(ctx, *user); err != nil {
+				return err
+			}
+		}
+		if err := s.users.SetRoles(ctx, username, roles); err != nil {
+			return err
+		}
+		_, err = s.sessions.EndUserSessions(username, ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.users.FindByUsername(ctx, username)
 }
 
-func UpdateUser(ctx context.Context, user *models.User) (bool, error) {
-	filter := bson.M{dbconfig.Username: user.Username}
-	update := bson.M{dbconfig.SetOperator: bson.M{dbconfig.BorrowedBookIDs: user.BorrowedBookIDs}}
-	_, err := db.UsersCollection.UpdateOne(ctx, filter, update)
+// DisableUser disables the account of username on behalf of actor and ends
+// their sessions, so that they are signed out and cannot log in again.
+// Actors cannot disable themselves, nor anybody the last enabled
+// administrator.
+func (s *Service) DisableUser(username, actor string, ctx context.Context) (*models.User, error) {
+	if username == actor {
+		return nil, &apperrors.UserValidationError{ErrorMessages: []string{"you cannot disable your own account"}}
+	}
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		user, err := s.users.FindByUsername(ctx, username)
+		if err != nil {
+			return err
+		}
+		if err := s.keepAdministrator(ctx, *user); err != nil {
+			return err
+		}
+		if err := s.users.SetDisabled(ctx, username, true); err != nil {
+			return err
+		}
+		_, err = s.sessions.EndUserSessions(username, ctx)
+		return err
+	})
 	if err != nil {
-		return false, err
-	} else {
-		return true, nil
+		return nil, err
 	}
+	return s.users.FindByUsername(ctx, username)
 }
 
-func findUserByUsername(ctx context.Context, filter interface{}) (*models.User, error) {
-	var user models.User
-	error := db.UsersCollection.FindOne(ctx, filter).Decode(&user)
+// EnableUser re-enables the disabled account of username.
+func (s *Service) EnableUser(username string, ctx context.Context) (*models.User, error) {
+	if err := s.users.SetDisabled(ctx, username, false); err != nil {
+		return nil, err
+	}
+	return s.users.FindByUsername(ctx, username)
+}
 
-	return &user, error
+// DeleteUser removes the account of username on behalf of actor and ends
+// their sessions. Users who
+// still have books on loan or holds in a queue cannot be deleted, and
+// neither can actors themselves or the last enabled administrator. Their
+// loan history is kept.
+func (s *Service) DeleteUser(username, actor string, ctx context.Context) error {
+	if username == actor {
+		return &apperrors.UserValidationError{ErrorMessages: []string{"you cannot delete your own account"}}
+	}
+	return s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		user, err := s.users.FindByUsername(ctx, username)
+		if err != nil {
+			return err
+		}
+		if len(user.BorrowedBookIDs) > 0 {
+			return &apperrors.UserHasBooksError{Username: username, Books: len(user.BorrowedBookIDs)}
+		}
+		holds, err := s.holds.FindActiveByUser(ctx, username)
+		if err != nil {
+			return err
+		}
+		if len(holds) > 0 {
+			return &apperrors.UserHasHoldsError{Username: username, Holds: len(holds)}
+		}
+		if err := s.keepAdministrator(ctx, *user); err != nil {
+			return err
+		}
+		if err := s.users.Delete(ctx, username); err != nil {
+			return err
+		}
+		_, err = s.sessions.EndUserSessions(username, ctx)
+		return err
+	})
 }
 
-func GetAllUsers(ctx context.Context) ([]models.User, error) {
-	var users []models.User
-	cursor, err := db.UsersCollection.Find(ctx, bson.D{{}})
+// ChangePassword replaces the password of username once currentPassword is
+// verified against the stored hash, and ends all of their sessions, signing
+// out anyone else using the old password. The new password, the ended
+// sessions and the new session returned for the caller are stored in one
+// transaction.
+func (s *Service) ChangePassword(username, currentPassword, newPassword string, ctx context.Context) (*sessionservice.Grant, error) {
+	user, err := s.users.FindByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(currentPassword))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return nil, &apperrors.IncorrectPasswordError{}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if newPassword == "" {
+		return nil, &apperrors.UserValidationError{ErrorMessages: []string{"password is empty"}}
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), s.bcryptCost)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(ctx)
 
-	for cursor.Next(ctx) {
-		var user models.User
-		if err := cursor.Decode(&user); err != nil {
-			return nil, err
+	var grant *sessionservice.Grant
+	err = s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.users.SetPassword(ctx, username, string(hashedPassword)); err != nil {
+			return err
+		}
+		if _, err := s.sessions.EndUserSessions(username, ctx); err != nil {
+			return err
+		}
+		grant, err = s.sessions.StartSession(username, ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return grant, nil
+}
+
+// UpdateContact changes the contact details of username. A nil email or
+// phone is left as it is and an empty one is removed.
+func (s *Service) UpdateContact(username string, email, phone *string, ctx context.Context) (*models.User, error) {
+	user, err := s.users.FindByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if email != nil {
+		user.Email = strings.TrimSpace(*email)
+	}
+	if phone != nil {
+		user.Phone = strings.TrimSpace(*phone)
+	}
+
+	var errorMessages []string
+	if user.Email != "" {
+		if address, err := mail.ParseAddress(user.Email); err != nil || address.Address != user.Email {
+			errorMessages = append(errorMessages, fmt.Sprintf("%q is not an email address", user.Email))
+		}
+	}
+	if user.Phone != "" && !validPhone(user.Phone) {
+		errorMessages = append(errorMessages, fmt.Sprintf("%q is not a phone number", user.Phone))
+	}
+	if len(errorMessages) > 0 {
+		return nil, &apperrors.UserValidationError{ErrorMessages: errorMessages}
+	}
+
+	if err := s.users.SetContact(ctx, username, user.Email, user.Phone); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// validPhone accepts numbers of minPhoneDigits to maxPhoneDigits digits,
+// written with an optional leading + and spaces, dashes, dots or
+// parentheses between the digits.
+func validPhone(phone string) bool {
+	digits := 0
+	for i, r := range phone {
+		switch {
+		case r >= '0' && r <= '9':
+			digits++
+		case r == '+' && i == 0:
+		case strings.ContainsRune(" -.()", r):
+		default:
+			return false
+		}
+	}
+	return digits >= minPhoneDigits && digits <= maxPhoneDigits
+}
+
+// keepAdministrator fails when user is the only enabled administrator, who
+// is about to be demoted, disabled or deleted.
+func (s *Service) keepAdministrator(ctx context.Context, user models.User) error {
+	if user.Disabled || !slices.Contains(user.Roles, authz.AdminRole) {
+		return nil
+	}
+	users, err := s.users.FindAll(ctx)
+	if err != nil {
+		return err
+	}
+	for _, other := range users {
+		if other.Username != user.Username && !other.Disabled && slices.Contains(other.Roles, authz.AdminRole) {
+			return nil
 		}
-		users = append(users, user)
 	}
-	return users, nil
+	return &apperrors.UserValidationError{ErrorMessages: []string{
+		fmt.Sprintf("%s is the last enabled administrator", user.Username),
+	}}
 }
@@ -0,0 +1,128 @@
+package userservice
+
+import (
+	"context"
+	"library_management_system/apperrors"
+	"library_management_system/authz"
+	"library_management_system/config/appconfig"
+	"library_management_system/db"
+	"library_management_system/internal/testbackends"
+	"library_management_system/services/sessionservice"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestServices returns the user service under test and the session
+// service it ends sessions through, with users ann, an administrator, and
+// bob registered.
+func newTestServices(t *testing.T, repos *db.Repositories) (*Service, *sessionservice.Service) {
+	config := appconfig.Default().Auth
+	config.BcryptCost = bcrypt.MinCost
+	policy, err := authz.NewPolicy(config.Roles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := sessionservice.NewService(repos.Sessions, repos.Users, repos.Transactor, config)
+	s := NewService(repos.Users, repos.Holds, sessions, repos.Transactor, policy, config)
+	ctx := context.Background()
+	if _, err := s.RegisterUser("ann", "secret", []string{authz.AdminRole}, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RegisterUser("bob", "secret", []string{"user"}, ctx); err != nil {
+		t.Fatal(err)
+	}
+	return s, sessions
+}
+
+// checkSessionsEnded fails unless the refresh token and access token of
+// grant were both revoked.
+func checkSessionsEnded(t *testing.T, sessions *sessionservice.Service, grant *sessionservice.Grant) {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := sessions.Refresh(grant.RefreshToken, ctx); err == nil {
+		t.Error("the session can still be refreshed")
+	}
+	if revoked, err := sessions.TokenRevoked(grant.AccessTokenID, ctx); err != nil || !revoked {
+		t.Errorf("the access token is not revoked: %v", err)
+	}
+}
+
+func TestAccountChangesEndSessions(t *testing.T) {
+	changes := map[string]func(s *Service, ctx context.Context) error{
+		"set roles": func(s *Service, ctx context.Context) error {
+			_, err := s.SetRoles("bob", []string{"librarian"}, "ann", ctx)
+			return err
+		},
+		"disable": func(s *Service, ctx context.Context) error {
+			_, err := s.DisableUser("bob", "ann", ctx)
+			return err
+		},
+		"delete": func(s *Service, ctx context.Context) error {
+			return s.DeleteUser("bob", "ann", ctx)
+		},
+	}
+	for change, apply := range changes {
+		for name, repos := range testbackends.Open(t) {
+			t.Run(change+"/"+name, func(t *testing.T) {
+				ctx := context.Background()
+				s, sessions := newTestServices(t, repos)
+				grant, err := sessions.StartSession("bob", ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := apply(s, ctx); err != nil {
+					t.Fatal(err)
+				}
+				checkSessionsEnded(t, sessions, grant)
+			})
+		}
+	}
+}
+
+func TestRefusedAccountChangeKeepsSessions(t *testing.T) {
+	for name, repos := range testbackends.Open(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s, sessions := newTestServices(t, repos)
+			grant, err := sessions.StartSession("ann", ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = s.SetRoles("ann", []string{"user"}, "bob", ctx)
+			if _, refused := err.(*apperrors.UserValidationError); !refused {
+				t.Fatalf("demoting the last administrator: %v", err)
+			}
+			if revoked, err := sessions.TokenRevoked(grant.AccessTokenID, ctx); err != nil || revoked {
+				t.Errorf("a refused change revoked the access token: %v", err)
+			}
+		})
+	}
+}
+
+func TestChangePasswordEndsOtherSessions(t *testing.T) {
+	for name, repos := range testbackends.Open(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s, sessions := newTestServices(t, repos)
+			old, err := sessions.StartSession("bob", ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.ChangePassword("bob", "wrong", "better", ctx); err == nil {
+				t.Fatal("changed the password without the current one")
+			}
+			grant, err := s.ChangePassword("bob", "secret", "better", ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSessionsEnded(t, sessions, old)
+			if _, err := sessions.Refresh(grant.RefreshToken, ctx); err != nil {
+				t.Errorf("the new session cannot be refreshed: %v", err)
+			}
+			if _, err := s.AuthenticateUser("bob", "better", ctx); err != nil {
+				t.Errorf("logging in with the new password: %v", err)
+			}
+		})
+	}
+}